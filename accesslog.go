@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Where requests for redirections are logged, one JSON object per line, or
+// "-" for standard output.
+var accessLogFile *string = flag.String("access-log", "", `file to log requests for redirections to as JSON lines, or "-" for standard output`)
+
+// An accessEntry is a line of the access log. Besides the request and the
+// response, it has what the request matched: the rule's source, its group
+// and campaign, and what was done, as in a Resolution, so log analytics can
+// aggregate by rule or campaign without matching paths again.
+type accessEntry struct {
+	Time        time.Time `json:"time"`
+	Client      string    `json:"client"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Query       string    `json:"query,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Referer     string    `json:"referer,omitempty"`
+	Status      int       `json:"status"`
+	Bytes       int64     `json:"bytes"`
+	Duration    float64   `json:"duration_ms"`
+	Rule        string    `json:"rule,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Campaign    string    `json:"campaign,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Action      string    `json:"action"`
+	Destination string    `json:"destination,omitempty"`
+	Cache       string    `json:"cache,omitempty"`
+	Bot         bool      `json:"bot,omitempty"`
+
+	// The time zone of the rule's schedule, which hits are counted in.
+	loc *time.Location
+}
+
+// An accessLog writes accessEntries. A nil accessLog logs nothing.
+type accessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func openAccessLog(path string) (*accessLog, error) {
+	if path == "-" {
+		return &accessLog{w: os.Stdout}, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{w: file}, nil
+}
+
+// Fill in the request that started at start and was answered through cw.
+func (entry *accessEntry) finish(req *http.Request, cw *countingWriter, start time.Time) {
+	entry.Time = start
+	entry.Client = realAddr(req)
+	entry.Method = req.Method
+	entry.Path = req.URL.Path
+	entry.Query = req.URL.RawQuery
+	entry.UserAgent = req.UserAgent()
+	entry.Referer = req.Referer()
+	entry.Status = cw.status
+	if entry.Status == 0 {
+		// Nothing was written, which net/http answers with a 200.
+		entry.Status = http.StatusOK
+	}
+	entry.Bytes = cw.bytes
+	entry.Duration = float64(time.Since(start).Microseconds()) / 1000
+}
+
+// Flush the log to disk, if it is a file.
+func (access *accessLog) Sync() {
+	if access == nil {
+		return
+	}
+	access.mu.Lock()
+	defer access.mu.Unlock()
+	if file, ok := access.w.(*os.File); ok && file != os.Stdout {
+		file.Sync()
+	}
+}
+
+// Record a finished entry. Bots are left out with -log-bots=false.
+func (access *accessLog) Record(entry *accessEntry) {
+	if access == nil || entry.Bot && !*logBots {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("access log:", err)
+		return
+	}
+	access.mu.Lock()
+	defer access.mu.Unlock()
+	if _, err = access.w.Write(append(line, '\n')); err != nil {
+		log.Println("access log:", err)
+	}
+}
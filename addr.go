@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Forwarding headers are only honored from these proxies.
+var trustedProxiesFlag *string = flag.String("trusted-proxies", "127.0.0.1/32,::1/128",
+	"comma-separated IPs or CIDRs of proxies trusted to send X-Real-Ip and X-Forwarded-For")
+
+// The parsed -trusted-proxies.
+var trustedProxies []netip.Prefix
+
+// Clients allowed to use the admin endpoints, in addition to those allowed by
+// the configuration.
+var adminAllowFlag *string = flag.String("admin-allow", "127.0.0.1/32,::1/128",
+	"comma-separated IPs or CIDRs allowed to use the admin endpoints")
+
+// The parsed -admin-allow.
+var adminAllow []netip.Prefix
+
+// The prefixes "localhost" stands for in lists of addresses.
+var localhostPrefixes = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+// Parse a comma-separated list of IPs and CIDRs. A bare IP is a prefix
+// matching only itself, and localhost is both IPv4 and IPv6 loopback.
+func parsePrefixes(list string) (prefixes []netip.Prefix, err error) {
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if strings.EqualFold(field, "localhost") {
+			prefixes = append(prefixes, localhostPrefixes...)
+			continue
+		}
+		var prefix netip.Prefix
+		if strings.Contains(field, "/") {
+			prefix, err = netip.ParsePrefix(field)
+		} else {
+			var addr netip.Addr
+			addr, err = parseAddr(field)
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return
+}
+
+// Parse an address that may be in brackets or have a port, as IPv6
+// addresses in headers and flags often are: 2001:db8::1, [2001:db8::1],
+// [2001:db8::1]:443 and 192.0.2.1:443 are all fine. IPv4-mapped IPv6
+// addresses are taken as IPv4.
+func parseAddr(s string) (netip.Addr, error) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	} else if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	addr, err := netip.ParseAddr(s)
+	return addr.Unmap(), err
+}
+
+// Whether addr is in any of the prefixes. IPv4-mapped IPv6 addresses match
+// IPv4 prefixes.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The address of the direct peer, without its port. Peers on a Unix domain
+// socket are local, so their address is the loopback address.
+func peerAddr(req *http.Request) (netip.Addr, error) {
+	if fromUnixSocket(req) {
+		return netip.MustParseAddr("127.0.0.1"), nil
+	}
+	return parseAddr(req.RemoteAddr)
+}
+
+// The client's address. When the direct peer is a trusted proxy, X-Real-Ip
+// is used if set; otherwise X-Forwarded-For is walked from the nearest hop
+// back, and the first address that is not a trusted proxy is the client.
+// Anything else uses the peer's own address.
+func clientAddr(req *http.Request) (netip.Addr, error) {
+	peer, err := peerAddr(req)
+	if err != nil || !containsAddr(trustedProxies, peer) {
+		return peer, err
+	}
+
+	if realIP := strings.TrimSpace(req.Header.Get("X-Real-Ip")); realIP != "" {
+		if addr, err := parseAddr(realIP); err == nil {
+			return addr, nil
+		}
+		return peer, nil
+	}
+
+	var hops []string
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A malformed chain can't be trusted past this point.
+			break
+		}
+		client = addr
+		if !containsAddr(trustedProxies, client) {
+			break
+		}
+	}
+	return client, nil
+}
+
+// The remote address as a string for logging and access control, falling
+// back to RemoteAddr as given if it can't be parsed.
+func realAddr(req *http.Request) string {
+	addr, err := clientAddr(req)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return addr.String()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The admin dashboard, a single page backed by /_config and /_stats.
+//
+//go:embed admin.html
+var adminPage []byte
+
+// The AdminHandler serves the admin dashboard.
+func (redir *Redirector) AdminHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+			w.Write(adminPage)
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hits on each redirection are attributed to where they came from: the host
+// of the Referer, and the campaign tag in ?src=, such as the billboard or ad
+// placement a link was printed on. They are counted in total, and in hourly
+// and daily buckets in the time zone of the redirection's group, for the
+// last -stats-hours hours and -stats-days days. Hits from bots are counted
+// in Hits and Bots, but not attributed, so they don't skew campaign numbers.
+type attribution struct {
+	Hits      int64            `json:"hits"`
+	Bots      int64            `json:"bots"`
+	Referrers map[string]int64 `json:"referrers"`
+	Campaigns map[string]int64 `json:"campaigns"`
+}
+
+// How long hits are kept in hourly buckets, and in daily ones. Buckets older
+// than that are forgotten as new ones start, and left out of reports.
+var statsHours *int = flag.Int("stats-hours", 48, "hours to keep hourly statistics for")
+var statsDays *int = flag.Int("stats-days", 31, "days to keep daily statistics for")
+
+// The keys of hourly and daily buckets, which sort by time.
+const (
+	hourLayout = "2006-01-02T15"
+	dayLayout  = "2006-01-02"
+)
+
+const (
+	// The query parameter with a link's campaign tag.
+	campaignParam = "src"
+
+	// The most referrers or campaigns counted in each bucket. Any more are
+	// counted together, so a flood of junk doesn't use up memory.
+	maxAttributed = 100
+)
+
+// Referrers for requests without a Referer, and referrers or campaigns past
+// maxAttributed.
+const (
+	directReferrer = "(direct)"
+	otherAttribute = "(other)"
+)
+
+// The attribution kept for a redirection.
+type ruleAttribution struct {
+	total       *attribution
+	hours, days map[string]*attribution
+	loc         *time.Location
+}
+
+func newAttribution() *attribution {
+	return &attribution{Referrers: make(map[string]int64), Campaigns: make(map[string]int64)}
+}
+
+func newRuleAttribution() *ruleAttribution {
+	return &ruleAttribution{total: newAttribution(), hours: make(map[string]*attribution), days: make(map[string]*attribution), loc: time.Local}
+}
+
+// Count a hit from referrer with the campaign tag, if any.
+func (a *attribution) count(referrer, campaign string, bot bool) {
+	a.Hits++
+	if bot {
+		a.Bots++
+		return
+	}
+	countAttribute(a.Referrers, referrer)
+	if campaign != "" {
+		countAttribute(a.Campaigns, campaign)
+	}
+}
+
+func countAttribute(counts map[string]int64, key string) {
+	if _, ok := counts[key]; !ok && len(counts) >= maxAttributed {
+		key = otherAttribute
+	}
+	counts[key]++
+}
+
+// Count a hit in total and in the buckets for its hour and day.
+func (ra *ruleAttribution) count(now time.Time, referrer, campaign string, bot bool) {
+	ra.total.count(referrer, campaign, bot)
+	ra.loc = now.Location()
+	for _, bucket := range []struct {
+		buckets map[string]*attribution
+		key     string
+	}{
+		{ra.hours, now.Format(hourLayout)},
+		{ra.days, now.Format(dayLayout)},
+	} {
+		a := bucket.buckets[bucket.key]
+		if a == nil {
+			a = newAttribution()
+			bucket.buckets[bucket.key] = a
+			ra.forget(now)
+		}
+		a.count(referrer, campaign, bot)
+	}
+}
+
+// Forget the buckets kept for too long at now.
+func (ra *ruleAttribution) forget(now time.Time) {
+	now = now.In(ra.loc)
+	forgetBefore(ra.hours, now.Add(-time.Duration(*statsHours)*time.Hour).Format(hourLayout))
+	forgetBefore(ra.days, now.AddDate(0, 0, -*statsDays).Format(dayLayout))
+}
+
+// Forget the buckets, whose keys sort by time, up to and including the one
+// for cutoff.
+func forgetBefore[T any](buckets map[string]T, cutoff string) {
+	for key := range buckets {
+		if key <= cutoff {
+			delete(buckets, key)
+		}
+	}
+}
+
+// The host a request was referred from.
+func referrerHost(req *http.Request) string {
+	referer := req.Referer()
+	if referer == "" {
+		return directReferrer
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return otherAttribute
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// A redirection's statistics, as reported by /_stats/{source}. Buckets are
+// oldest first.
+type RuleReport struct {
+	Source  string         `json:"source"`
+	Since   time.Time      `json:"since"`
+	Bytes   int64          `json:"bytes"`
+	Bucket  string         `json:"bucket"`
+	Buckets []BucketReport `json:"buckets"`
+	attribution
+}
+
+// The hits in an hour or a day.
+type BucketReport struct {
+	Time string `json:"time"`
+	attribution
+}
+
+// Statistics buckets.
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+)
+
+// RuleReport reports the statistics for source, bucketed by hour or day.
+func (stats *Stats) RuleReport(source, bucket string) *RuleReport {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	report := &RuleReport{Source: source, Since: stats.since, Bucket: bucket, Buckets: []BucketReport{}}
+	report.attribution = *newAttribution()
+	counters := stats.rules[source]
+	if counters == nil {
+		return report
+	}
+	report.Bytes = counters.Bytes
+	report.attribution = copyAttribution(counters.attribution.total)
+	counters.attribution.forget(stats.clock.Now())
+	buckets := counters.attribution.days
+	if bucket == BucketHour {
+		buckets = counters.attribution.hours
+	}
+	for key, a := range buckets {
+		report.Buckets = append(report.Buckets, BucketReport{key, copyAttribution(a)})
+	}
+	sort.Slice(report.Buckets, func(i, j int) bool { return report.Buckets[i].Time < report.Buckets[j].Time })
+	return report
+}
+
+func copyAttribution(a *attribution) attribution {
+	c := *newAttribution()
+	c.Hits, c.Bots = a.Hits, a.Bots
+	for key, n := range a.Referrers {
+		c.Referrers[key] = n
+	}
+	for key, n := range a.Campaigns {
+		c.Campaigns[key] = n
+	}
+	return c
+}
+
+// The RuleStatsHandler reports the hits on one redirection by referrer and
+// campaign, in total and by day or, with ?by=hour, by hour
+// (GET /_stats/{source}, such as /_stats/spring-sale for /spring-sale).
+func (redir *Redirector) RuleStatsHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			bucket := req.URL.Query().Get("by")
+			switch bucket {
+			case "":
+				bucket = BucketDay
+			case BucketDay, BucketHour:
+			default:
+				writeError(w, http.StatusBadRequest, "Bad bucket")
+				return
+			}
+			source := pathKey(strings.TrimPrefix(req.URL.Path, "/_stats"))
+			redir.mu.RLock()
+			_, ok := redir.Redirections.Get(source)
+			redir.mu.RUnlock()
+			report := redir.stats.RuleReport(source, bucket)
+			if !ok && report.Hits == 0 {
+				writeError(w, http.StatusNotFound, "No redirection for "+source)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+		})
+	}
+}
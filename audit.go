@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Where configuration changes are recorded.
+var auditLogFile *string = flag.String("audit-log", "", "append-only file to record configuration changes in")
+
+// An auditLog appends each change made through the API to a file as a line
+// of JSON: the event, with the client that made it and the old and new rules.
+// A nil auditLog records nothing.
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{path: path, file: file}, nil
+}
+
+// Record the event. Failing to record it is logged, but doesn't undo the
+// change.
+func (audit *auditLog) Record(event *Event) {
+	if audit == nil {
+		return
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Println("audit:", err)
+		return
+	}
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	if _, err = audit.file.Write(append(line, '\n')); err != nil {
+		log.Println("audit:", err)
+	}
+}
+
+// Flush the log to disk.
+func (audit *auditLog) Sync() {
+	if audit == nil {
+		return
+	}
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	audit.file.Sync()
+}
+
+// Query returns the recorded events for source (or every source, if empty)
+// between from and to (where zero times are unbounded), at most limit of
+// them, most recent first. It gives up if ctx is done before it finishes.
+func (audit *auditLog) Query(ctx context.Context, source string, from, to time.Time, limit int) ([]*Event, error) {
+	_, s := startSpan(ctx, "audit.query", spanInternal)
+	defer s.finish()
+	file, err := os.Open(audit.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []*Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		event := new(Event)
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			continue
+		}
+		if (source != "" && event.Source != source) ||
+			(!from.IsZero() && event.Time.Before(from)) || (!to.IsZero() && event.Time.After(to)) {
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// The AuditHandler supplies the audit log as JSON, filtered by the path,
+// from, and to (RFC 3339 times) query parameters, with at most limit (100 by
+// default) events.
+func (redir *Redirector) AuditHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			if redir.audit == nil {
+				writeError(w, http.StatusNotFound, "No -audit-log")
+				return
+			}
+
+			query := req.URL.Query()
+			var from, to time.Time
+			var err error
+			if value := query.Get("from"); value != "" {
+				if from, err = time.Parse(time.RFC3339, value); err != nil {
+					writeError(w, http.StatusBadRequest, "Bad from time", paramError("from", "must be an RFC 3339 time"))
+					return
+				}
+			}
+			if value := query.Get("to"); value != "" {
+				if to, err = time.Parse(time.RFC3339, value); err != nil {
+					writeError(w, http.StatusBadRequest, "Bad to time", paramError("to", "must be an RFC 3339 time"))
+					return
+				}
+			}
+			limit := 100
+			if value := query.Get("limit"); value != "" {
+				if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+					writeError(w, http.StatusBadRequest, "Bad limit", paramError("limit", "must be a positive integer"))
+					return
+				}
+			}
+
+			events, err := redir.audit.Query(req.Context(), query.Get("path"), from, to, limit)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.Println("audit:", err)
+				writeError(w, http.StatusInternalServerError, "Error reading audit log")
+				return
+			}
+			if events == nil {
+				events = []*Event{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(events)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	tr := newTestRedirector(t, tokensConfig)
+	tr.expectStatus(tr.do("GET", "/_audit", ""), http.StatusNotFound)
+
+	var err error
+	if tr.audit, err = openAuditLog(filepath.Join(t.TempDir(), "audit.log")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.audit.file.Close() })
+	tr.expectStatus(tr.do("PUT", "/promo/summer", "/summer"), http.StatusCreated)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "PUT", "/promo/summer", "/summer-sale", "Authorization", "Bearer secret"), http.StatusOK)
+	tr.expectStatus(tr.do("DELETE", "/other", ""), http.StatusNoContent)
+
+	w := tr.do("GET", "/_audit?path=/promo/summer", "")
+	tr.expectStatus(w, http.StatusOK)
+	var events []Event
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %s", len(events), w.Body.String())
+	}
+	// Most recent first.
+	if e := events[0]; e.Type != EventRuleUpdated || e.Client != "192.0.2.1" || e.Token != "marketing" || e.Rule.To != "/summer-sale" || e.Old.To != "/summer" {
+		t.Errorf("got %+v", e)
+	}
+	if e := events[1]; e.Type != EventRuleCreated || e.Client != "127.0.0.1" || e.Token != "" {
+		t.Errorf("got %+v", e)
+	}
+
+	tr.expectStatus(tr.do("GET", "/_audit?limit=1", ""), http.StatusOK)
+	tr.expectStatus(tr.do("GET", "/_audit?from=yesterday", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "GET", "/_audit", "", "Authorization", "Bearer reader"), http.StatusForbidden)
+}
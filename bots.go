@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Whether requests from bots are logged. Leaving them out keeps the access
+// log to the people following links.
+var logBots *bool = flag.Bool("log-bots", true, "log redirects for bots as well as people")
+
+// User-agent substrings of automated clients besides crawlers and link
+// preview fetchers: monitors, scrapers, and HTTP libraries.
+var botAgents = []string{
+	"bot",
+	"crawl",
+	"spider",
+	"scrape",
+	"slurp",
+	"headless",
+	"lighthouse",
+	"pingdom",
+	"uptime",
+	"monitor",
+	"curl/",
+	"wget/",
+	"python-requests",
+	"python-urllib",
+	"aiohttp",
+	"go-http-client",
+	"okhttp",
+	"java/",
+	"apache-httpclient",
+	"libwww-perl",
+	"node-fetch",
+	"axios/",
+	"httpie",
+	probeAgent,
+}
+
+// Whether a user agent is a bot: a known crawler or preview fetcher, one of
+// botAgents, one of the configuration's own bots, or no user agent at all,
+// which browsers always send.
+func (config *Config) isBot(ua string) bool {
+	if strings.TrimSpace(ua) == "" {
+		return true
+	}
+	return isCrawler(ua) || isPreviewAgent(ua) || matchAgent(ua, botAgents) || matchAgent(ua, config.bots)
+}
+
+// Lowercase the configuration's bots for matching.
+func (config *Config) compileBots() error {
+	config.bots = make([]string, 0, len(config.Bots))
+	for _, bot := range config.Bots {
+		if strings.TrimSpace(bot) == "" {
+			return fmt.Errorf("empty bot user agent")
+		}
+		config.bots = append(config.bots, strings.ToLower(bot))
+	}
+	return nil
+}
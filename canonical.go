@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Canonical settings redirect requests for redirections that don't arrive
+// at the canonical scheme and host, such as in front of a legacy site, to
+// the same path and query there:
+//
+//	"canonical": {"https": true, "www": "strip", "lowercase": true}
+//
+// HTTPS requires https, as seen by the server or told by a trusted proxy in
+// X-Forwarded-Proto. WWW is "add" or "strip" to require or forbid a www.
+// label on host names, Lowercase requires a lowercase host, and Host names
+// the one host to require instead. A change of scheme or host drops the
+// port. Code is the redirect's, 301 by default.
+//
+// The settings apply to the whole configuration, or to a rule, replacing
+// them for requests matching it. Normally a request that isn't canonical is
+// redirected to the canonical URL, whether or not a rule matches its path,
+// and the rule applies when the client follows. With BeforeLookup, a
+// matching rule's redirect is sent straight away instead, with a destination
+// on this server made absolute on the canonical host, saving a hop.
+type Canonical struct {
+	HTTPS        bool   `json:"https,omitempty"`
+	WWW          string `json:"www,omitempty"`
+	Lowercase    bool   `json:"lowercase,omitempty"`
+	Host         string `json:"host,omitempty"`
+	BeforeLookup bool   `json:"before_lookup,omitempty"`
+	Code         int    `json:"code,omitempty"`
+}
+
+// Canonical WWW settings.
+const (
+	WWWAdd   = "add"
+	WWWStrip = "strip"
+)
+
+// The action of a request redirected to its canonical URL.
+const ActionCanonical = "canonical"
+
+func (c *Canonical) check() error {
+	switch c.WWW {
+	case "", WWWAdd, WWWStrip:
+	default:
+		return fmt.Errorf("canonical www must be %q or %q, not %q", WWWAdd, WWWStrip, c.WWW)
+	}
+	if c.Host != "" && (c.Host != strings.ToLower(c.Host) || strings.ContainsAny(c.Host, "/:*")) {
+		return fmt.Errorf("canonical host %q is not a lowercase host name", c.Host)
+	}
+	if c.Code != 0 && (c.Code < 300 || c.Code > 399) {
+		return fmt.Errorf("canonical code %d is not a redirection code", c.Code)
+	}
+	return nil
+}
+
+func (c *Canonical) code() int {
+	if c.Code == 0 {
+		return http.StatusMovedPermanently
+	}
+	return c.Code
+}
+
+// The settings for a request matching rule, which may be nil.
+func (config *Config) canonicalFor(rule *Rule) *Canonical {
+	if rule != nil && rule.Canonical != nil {
+		return rule.Canonical
+	}
+	return config.Canonical
+}
+
+// The canonical scheme and host for req, as in https://example.com, or ""
+// if it is already there.
+func (c *Canonical) origin(req *http.Request) string {
+	if c == nil {
+		return ""
+	}
+	scheme := requestScheme(req)
+	name, port := strings.Trim(req.Host, "[]"), ""
+	if h, p, err := net.SplitHostPort(req.Host); err == nil {
+		name, port = h, p
+	}
+	want, wantScheme := name, scheme
+	if c.HTTPS {
+		wantScheme = "https"
+	}
+	if c.Host != "" {
+		want = c.Host
+	} else if c.Lowercase {
+		want = strings.ToLower(name)
+	}
+	if c.Host == "" && name != "" && strings.Contains(name, ".") && !isIP(name) {
+		hasWWW := strings.HasPrefix(strings.ToLower(want), "www.")
+		switch {
+		case c.WWW == WWWAdd && !hasWWW:
+			want = "www." + want
+		case c.WWW == WWWStrip && hasWWW:
+			want = want[len("www."):]
+		}
+	}
+	if want == name && wantScheme == scheme {
+		return ""
+	}
+	host := want
+	switch {
+	case port != "" && wantScheme == scheme && c.Host == "":
+		host = net.JoinHostPort(want, port)
+	case strings.Contains(want, ":"):
+		host = "[" + want + "]"
+	}
+	return wantScheme + "://" + host
+}
+
+func isIP(host string) bool {
+	_, err := netip.ParseAddr(host)
+	return err == nil
+}
+
+// The scheme a request was made with, as the server saw it, or as a trusted
+// proxy says in X-Forwarded-Proto.
+func requestScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		if peer, err := peerAddr(req); err == nil && containsAddr(trustedProxies, peer) {
+			proto, _, _ = strings.Cut(proto, ",")
+			return strings.ToLower(strings.TrimSpace(proto))
+		}
+	}
+	return "http"
+}
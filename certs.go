@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A directory of certificates for -tls-listen, so one server can terminate
+// TLS for several vanity domains. Each certificate is served for the names
+// it covers, chosen by SNI. The directory holds either a pair of files for
+// each certificate, name.crt and name.key, or a directory for each with
+// fullchain.pem and privkey.pem, as certbot's live directory does.
+var tlsCertDir *string = flag.String("tls-cert-dir", "", "directory of certificates for -tls-listen, chosen by the host name the client asks for")
+
+// Where an ACME client such as certbot puts its HTTP challenges, to be
+// served at /.well-known/acme-challenge/, so certificates for each host can
+// be issued and renewed without stopping the server.
+var acmeWebroot *string = flag.String("acme-webroot", "", "directory to serve ACME HTTP challenges from, as certbot --webroot writes them")
+
+// How often the certificates are reloaded, to pick up renewals.
+const certReload = 10 * time.Minute
+
+// A certStore has the certificates for the TLS listeners: the default one
+// from -tls-cert and -tls-key, if any, and those in -tls-cert-dir by the
+// names they cover.
+type certStore struct {
+	mu          sync.RWMutex
+	fallback    *tls.Certificate
+	byName      map[string]*tls.Certificate
+	loadedNames []string
+}
+
+// Load the certificates, or reload them, keeping the ones loaded before if
+// this fails.
+func (store *certStore) load() error {
+	var fallback *tls.Certificate
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return err
+		}
+		fallback = &cert
+	}
+	byName := make(map[string]*tls.Certificate)
+	if *tlsCertDir != "" {
+		pairs, err := certPairs(*tlsCertDir)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+			if err != nil {
+				return fmt.Errorf("%s: %v", pair[0], err)
+			}
+			if cert.Leaf == nil {
+				if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+					return fmt.Errorf("%s: %v", pair[0], err)
+				}
+			}
+			for _, name := range cert.Leaf.DNSNames {
+				name = strings.ToLower(name)
+				if other, ok := byName[name]; ok && other.Leaf.NotAfter.After(cert.Leaf.NotAfter) {
+					continue
+				}
+				byName[name] = &cert
+			}
+		}
+	}
+	if fallback == nil && len(byName) == 0 {
+		return errors.New("no certificates for -tls-listen")
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.fallback, store.byName, store.loadedNames = fallback, byName, names
+	return nil
+}
+
+// The certificate and key files in dir, as pairs.
+func certPairs(dir string) ([][2]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var pairs [][2]string
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		switch {
+		case strings.HasPrefix(name, "."):
+		case entry.IsDir() || entry.Type()&os.ModeSymlink != 0 && isDir(path):
+			chain := filepath.Join(path, "fullchain.pem")
+			if _, err := os.Stat(chain); err == nil {
+				pairs = append(pairs, [2]string{chain, filepath.Join(path, "privkey.pem")})
+			}
+		case strings.HasSuffix(name, ".crt"):
+			pairs = append(pairs, [2]string{path, strings.TrimSuffix(path, ".crt") + ".key"})
+		}
+	}
+	return pairs, nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// The certificate for the name the client asked for: one covering it
+// exactly, then one with a wildcard covering it, then the default one.
+func (store *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := store.byName[name]; ok {
+		return cert, nil
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := store.byName["*."+parent]; ok {
+			return cert, nil
+		}
+	}
+	if store.fallback != nil {
+		return store.fallback, nil
+	}
+	return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+// Reload the certificates every certReload, logging failures.
+func (store *certStore) reloadPeriodically() {
+	for range time.Tick(certReload) {
+		if err := store.load(); err != nil {
+			log.Println("certificates:", err)
+		}
+	}
+}
+
+// The TLS configuration for the TLS listeners, with the certificates loaded.
+func loadCertificates() (*tls.Config, error) {
+	store := &certStore{}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if len(store.loadedNames) > 0 {
+		log.Println("serving certificates for", strings.Join(store.loadedNames, ", "))
+	}
+	go store.reloadPeriodically()
+	return &tls.Config{GetCertificate: store.getCertificate}, nil
+}
+
+// Serve the ACME challenges in -acme-webroot. Only the files are served,
+// without directory listings.
+func acmeChallenges(webroot string) http.Handler {
+	files := http.FileServer(http.Dir(filepath.Join(webroot, ".well-known", "acme-challenge")))
+	return http.StripPrefix("/.well-known/acme-challenge/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "" || strings.Contains(req.URL.Path, "/") {
+			http.NotFound(w, req)
+			return
+		}
+		log.Println(realAddr(req), "ACME challenge", req.URL.Path)
+		files.ServeHTTP(w, req)
+	}))
+}
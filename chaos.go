@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault injection is only possible when the server is started for it.
+var chaosMode *bool = flag.Bool("chaos", false, "allow injecting faults through /_chaos (never in production)")
+
+// The subsystems faults can be injected into.
+var chaosSubsystems = map[string]string{
+	"lookup":  "redirect lookups fail with a 503",
+	"proxy":   "proxied requests fail with a 502",
+	"config":  "loading configuration fails",
+	"ready":   "the readiness check fails",
+	"webhook": "webhook deliveries fail",
+}
+
+// A Fault slows a subsystem down by Latency and then makes it fail with
+// probability ErrorRate, or always if Outage is set, until Until if given.
+type Fault struct {
+	Latency   string    `json:"latency,omitempty"`
+	ErrorRate float64   `json:"error_rate,omitempty"`
+	Outage    bool      `json:"outage,omitempty"`
+	Until     time.Time `json:"until,omitzero"`
+	latency   time.Duration
+}
+
+// The error returned by subsystems failing because of an injected fault.
+var errChaos = errors.New("injected fault")
+
+var faults = struct {
+	sync.Mutex
+	bySubsystem map[string]*Fault
+}{bySubsystem: make(map[string]*Fault)}
+
+// Apply any fault injected into the subsystem: wait out its latency, then
+// return errChaos if it fails. Without -chaos this does nothing.
+func injectFault(ctx context.Context, subsystem string) error {
+	if !*chaosMode {
+		return nil
+	}
+	faults.Lock()
+	fault := faults.bySubsystem[subsystem]
+	if fault != nil && !fault.Until.IsZero() && defaultClock.Now().After(fault.Until) {
+		delete(faults.bySubsystem, subsystem)
+		fault = nil
+	}
+	faults.Unlock()
+	if fault == nil {
+		return nil
+	}
+
+	if fault.latency > 0 {
+		timer := time.NewTimer(fault.latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if fault.Outage || randomFloat() < fault.ErrorRate {
+		return errChaos
+	}
+	return nil
+}
+
+// The ChaosHandler lists the injected faults (GET /_chaos), injects one into a
+// subsystem (PUT /_chaos/subsystem with a JSON Fault), and removes one
+// (DELETE /_chaos/subsystem) or all of them (DELETE /_chaos).
+func (redir *Redirector) ChaosHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			subsystem := strings.Trim(strings.TrimPrefix(req.URL.Path, "/_chaos"), "/")
+			if _, ok := chaosSubsystems[subsystem]; subsystem != "" && !ok {
+				writeError(w, http.StatusNotFound, "Unknown subsystem; one of: "+strings.Join(chaosSubsystemNames(), ", "))
+				return
+			}
+
+			faults.Lock()
+			defer faults.Unlock()
+
+			switch {
+			case req.Method == "GET":
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(faults.bySubsystem)
+			case req.Method == "PUT" && subsystem != "":
+				fault := new(Fault)
+				if err := json.NewDecoder(req.Body).Decode(fault); err != nil {
+					writeError(w, http.StatusBadRequest, "Error decoding JSON fault: "+err.Error())
+					return
+				}
+				if fault.Latency != "" {
+					var err error
+					if fault.latency, err = time.ParseDuration(fault.Latency); err != nil {
+						writeError(w, http.StatusBadRequest, "Bad latency: "+err.Error())
+						return
+					}
+				}
+				if fault.ErrorRate < 0 || fault.ErrorRate > 1 {
+					writeError(w, http.StatusBadRequest, "error_rate must be between 0 and 1")
+					return
+				}
+				faults.bySubsystem[subsystem] = fault
+				log.Println(realAddr(req), "injected fault into", subsystem)
+			case req.Method == "DELETE" && subsystem != "":
+				delete(faults.bySubsystem, subsystem)
+				log.Println(realAddr(req), "removed fault from", subsystem)
+			case req.Method == "DELETE":
+				faults.bySubsystem = make(map[string]*Fault)
+				log.Println(realAddr(req), "removed all faults")
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			}
+		})
+	}
+}
+
+func chaosSubsystemNames() (names []string) {
+	for name := range chaosSubsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/json"
+	"flag"
+	"log"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// For testing, the server can run on a clock of its own, starting at
+// -fake-time and moving only when /_clock moves it, and draw its random
+// numbers from -seed, so that runs are repeatable.
+var fakeTime *string = flag.String("fake-time", "", "run on a clock that starts at this RFC 3339 time and only moves through /_clock (for testing)")
+var seed *int64 = flag.Int64("seed", 0, "seed for slugs and injected faults, to make them the same on every run (for testing)")
+
+// A Clock tells the time for everything that depends on it: schedules,
+// statistics buckets, rate limits, alert windows, and the expiry of taps,
+// idempotency keys, and cached responses, each on its Redirector's clock, and
+// of injected faults, which are the whole server's, on the default one.
+// Durations, such as latencies, are always measured on the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// The Clock a Redirector runs on unless it is given one of its own, and the
+// one -fake-time sets.
+var defaultClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The Clock the Redirector runs on.
+func (redir *Redirector) Clock() Clock {
+	return redir.clock
+}
+
+// The Clock the configuration's schedules and caches run on: its
+// Redirector's, or the default one for a configuration that has none, such
+// as one being checked.
+func (config *Config) Clock() Clock {
+	if config.clock == nil {
+		return defaultClock
+	}
+	return config.clock
+}
+
+// A ManualClock stands still until it is set or advanced.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set the clock to now.
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance the clock by d, returning the new time.
+func (c *ManualClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Start the clock at -fake-time and seed the random numbers with -seed, if
+// they are set.
+func checkDeterminism() error {
+	if *fakeTime != "" {
+		start, err := time.Parse(time.RFC3339, *fakeTime)
+		if err != nil {
+			return err
+		}
+		defaultClock = NewManualClock(start)
+	}
+	if *seed != 0 {
+		seeded.Rand = rand.New(rand.NewSource(*seed))
+	}
+	return nil
+}
+
+// The random numbers from -seed, if it is set.
+var seeded struct {
+	sync.Mutex
+	*rand.Rand
+}
+
+// A random number from 0 up to n, for slugs: from crypto/rand, or from
+// -seed.
+func randomIntn(n int) (int, error) {
+	seeded.Lock()
+	defer seeded.Unlock()
+	if seeded.Rand != nil {
+		return seeded.Intn(n), nil
+	}
+	i, err := crand.Int(crand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// A random number from 0 up to 1, for injected faults.
+func randomFloat() float64 {
+	seeded.Lock()
+	defer seeded.Unlock()
+	if seeded.Rand != nil {
+		return seeded.Float64()
+	}
+	return rand.Float64()
+}
+
+// The ClockHandler reports the time on the Redirector's clock (GET /_clock)
+// and, if it is a ManualClock, as with -fake-time, sets it (POST /_clock with
+// "time", in RFC 3339) or moves it forward (POST /_clock with "advance", a
+// duration such as 90m).
+func (redir *Redirector) ClockHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		redir.onlyAdmin(w, req, func() {
+			manual, _ := redir.Clock().(*ManualClock)
+			switch {
+			case req.Method == "GET":
+			case req.Method == "POST" && manual != nil:
+				if value := req.FormValue("time"); value != "" {
+					now, err := time.Parse(time.RFC3339, value)
+					if err != nil {
+						writeError(w, http.StatusBadRequest, "Bad time: "+err.Error(), paramError("time", "must be an RFC 3339 time"))
+						return
+					}
+					manual.Set(now)
+				} else {
+					d, err := time.ParseDuration(req.FormValue("advance"))
+					if err != nil || d < 0 {
+						writeError(w, http.StatusBadRequest, "Bad advance; give a time or a duration to advance by")
+						return
+					}
+					manual.Advance(d)
+				}
+				log.Println(realAddr(req), "set the clock to", manual.Now().Format(time.RFC3339))
+			case req.Method == "POST":
+				writeError(w, http.StatusConflict, "The clock can only be set with -fake-time")
+				return
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"time": redir.Clock().Now(), "fake": manual != nil})
+		})
+	}
+}
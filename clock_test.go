@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Redirectors on clocks of their own don't see each other's time, and
+// /_clock moves only its own.
+func TestClocks(t *testing.T) {
+	t.Parallel()
+	config := `{"redirections": {"/sale": {"to": "/black-friday", "start": "2026-11-27T05:00:00Z"}}}`
+	before := newTestRedirectorWithClock(t, NewManualClock(time.Date(2026, 11, 26, 12, 0, 0, 0, time.UTC)), config)
+	during := newTestRedirectorWithClock(t, NewManualClock(time.Date(2026, 11, 28, 12, 0, 0, 0, time.UTC)), config)
+	before.expectNotFound("/sale")
+	during.expectRedirect("/sale", http.StatusFound, "/black-friday")
+
+	form := []string{"Content-Type", "application/x-www-form-urlencoded"}
+	before.expectStatus(before.do("POST", "/_clock", "advance=17h", form...), http.StatusOK)
+	before.expectRedirect("/sale", http.StatusFound, "/black-friday")
+	during.expectStatus(during.do("POST", "/_clock", "time=2026-11-26T12:00:00Z", form...), http.StatusOK)
+	during.expectNotFound("/sale")
+	before.expectRedirect("/sale", http.StatusFound, "/black-friday")
+
+	w := during.do("GET", "/_clock", "")
+	during.expectStatus(w, http.StatusOK)
+	var got struct {
+		Time time.Time
+		Fake bool
+	}
+	during.decode(w, &got)
+	if !got.Time.Equal(time.Date(2026, 11, 26, 12, 0, 0, 0, time.UTC)) || !got.Fake {
+		t.Errorf("got %+v", got)
+	}
+
+	// Without a ManualClock, the time can't be set.
+	tr := newTestRedirector(t, config)
+	tr.expectStatus(tr.do("POST", "/_clock", "advance=1h", form...), http.StatusConflict)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The binary runs the command given as its first argument, with flags before
+// or after it:
+//
+//	serve          serve the redirections (the default)
+//	validate       check the configuration (-validate)
+//	lint           report questionable rules (-lint)
+//	import FILE    check a configuration in any format and make it -config
+//	export [FILE]  write the configuration as FILE, by its extension, or as
+//	               JSON to standard output
+//	ctl METHOD PATH
+//	               send a request to the admin API of a running server, with
+//	               standard input as the body of a PUT or POST
+//	suggest LOG    suggest redirections for the 404s in an access log, or -
+//	               for standard input
+//	selfupdate     replace the binary with the latest release
+var commands = map[string]bool{"serve": true, "validate": true, "lint": true, "import": true, "export": true, "ctl": true, "suggest": true, "selfupdate": true}
+
+// Serve only the redirections from the configuration, changed with PUT and
+// DELETE and /_config, as the server first did, without the other admin
+// endpoints.
+var minimal *bool = flag.Bool("minimal", false, "serve only the redirections and /_config, without the other admin endpoints")
+
+// The server ctl talks to, by default the one -host and -port would listen on.
+var ctlURL *string = flag.String("ctl-url", "", "base URL of the server for ctl (default from -host and -port)")
+
+// Parse the flags and the command, and the flags among the arguments after
+// it, returning the command and the arguments left for it.
+func parseCommand() (string, []string, error) {
+	flag.Parse()
+	command := flag.Arg(0)
+	if command == "" {
+		command = "serve"
+	} else if !commands[command] {
+		return "", nil, fmt.Errorf("unknown command %q", command)
+	}
+	var args []string
+	for rest := flag.Args(); len(rest) > 1; rest = flag.Args() {
+		if err := flag.CommandLine.Parse(rest[1:]); err != nil {
+			return "", nil, err
+		}
+		if flag.NArg() > 0 {
+			args = append(args, flag.Arg(0))
+		}
+	}
+	switch {
+	case *validateOnly:
+		command = "validate"
+	case *lintOnly:
+		command = "lint"
+	}
+	return command, args, nil
+}
+
+// Run a command other than serve, returning its exit status.
+func runCommand(command string, args []string) int {
+	switch command {
+	case "validate":
+		return validateCommand()
+	case "lint":
+		return lintConfig()
+	case "import":
+		return importCommand(args)
+	case "export":
+		return exportCommand(args)
+	case "ctl":
+		return ctlCommand(args)
+	case "suggest":
+		return suggestCommand(args)
+	case "selfupdate":
+		if err := selfUpdate(); err != nil {
+			fmt.Fprintln(os.Stderr, "selfupdate:", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func validateCommand() int {
+	public, _ := NewRedirector().routes()
+	problems := validateConfig(*configFile, public)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if problems != nil {
+		return 1
+	}
+	fmt.Println(*configFile, "is valid")
+	return 0
+}
+
+// Check the configuration in a file, in the format its extension names, and
+// write it to -config in that file's format.
+func importCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import FILE")
+		return 2
+	}
+	data, err := os.ReadFile(args[0])
+	if err == nil {
+		format, _ := extensionFormat(args[0])
+		data, err = configToJSON(data, format)
+	}
+	redir := NewRedirector()
+	if err == nil {
+		err = redir.LoadConfig(data)
+	}
+	if err == nil {
+		data, err = redir.encodeConfig()
+	}
+	if err == nil {
+		data, err = configFromJSON(data, fileFormat(*configFile))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, args[0]+":", err)
+		return 1
+	}
+	if err = writeFileAtomically(*configFile, data); err != nil {
+		fmt.Fprintln(os.Stderr, "import:", err)
+		return 1
+	}
+	fmt.Println("imported", redir.Redirections.Len(), "redirections from", args[0], "to", *configFile)
+	return 0
+}
+
+// Write the configuration, merged if -config is a directory, to a file in the
+// format its extension names, or to standard output as JSON.
+func exportCommand(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: export [FILE]")
+		return 2
+	}
+	redir := NewRedirector()
+	err := redir.LoadConfigFile(*configFile)
+	var data []byte
+	if err == nil {
+		data, err = redir.encodeConfig()
+	}
+	if err == nil && len(args) == 1 {
+		format, _ := extensionFormat(args[0])
+		if data, err = configFromJSON(data, format); err == nil {
+			err = writeFileAtomically(args[0], data)
+		}
+	} else if err == nil {
+		_, err = os.Stdout.Write(data)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export:", err)
+		return 1
+	}
+	return 0
+}
+
+// Send a request to a running server's admin API and copy the response to
+// standard output. PUT and DELETE are sent with If-Match: *, replacing what is
+// there whatever it is.
+func ctlCommand(args []string) int {
+	if len(args) != 2 || !strings.HasPrefix(args[1], "/") {
+		fmt.Fprintln(os.Stderr, "usage: ctl METHOD /PATH")
+		return 2
+	}
+	method := strings.ToUpper(args[0])
+	base := *ctlURL
+	if base == "" {
+		h := strings.Trim(*host, "[]")
+		if h == "" {
+			// Listening on all interfaces, the server can be reached locally.
+			h = "localhost"
+		}
+		base = "http://" + net.JoinHostPort(h, strconv.Itoa(*port))
+	}
+	var body io.Reader
+	if method == "PUT" || method == "POST" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ctl:", err)
+			return 1
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+args[1], body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ctl:", err)
+		return 2
+	}
+	if body != nil {
+		format := *configFormat
+		if format == "" {
+			format = FormatJSON
+		}
+		req.Header.Set("Content-Type", formatContentType(format))
+	}
+	if method == "PUT" || method == "DELETE" {
+		req.Header.Set("If-Match", "*")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ctl:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode >= 400 {
+		fmt.Fprintln(os.Stderr, "ctl:", resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// The routes of -minimal: lookups and changes of redirections, and /_config.
+func (redir *Redirector) minimalRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", allowMethods(redir.ServeHTTP, "GET", "HEAD", "PUT", "DELETE"))
+	mux.HandleFunc("/_config", allowMethods(redir.ConfigHandler(), "GET", "PUT", "DELETE"))
+	return mux
+}
+
+// Replace the file at path with data at once, so a crash can't leave half of
+// it.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
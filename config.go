@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is the JSON configuration of a Redirector: a mapping of /source to
+// /destination redirections and the settings that go with them, along with
+// what is compiled from them when the configuration is loaded.
+type Config struct {
+	Redirections Rules `json:"redirections"`
+	wildcards    *wildcardSet
+
+	// Destinations are named base URLs that rules can refer to as @name.
+	Destinations map[string]string `json:"destinations,omitempty"`
+
+	// Tracking parameters are added to every redirect's destination.
+	Tracking map[string]string `json:"tracking,omitempty"`
+	tracking trackingParams
+
+	// Redirects are sent with the template in RedirectBody as their body,
+	// unless the rule's group has its own.
+	Groups       map[string]*Group `json:"groups,omitempty"`
+	RedirectBody string            `json:"redirect_body,omitempty"`
+	body         *template.Template
+
+	// Unmatched paths are sent to DefaultDestination with DefaultCode, if set.
+	// Otherwise, the template at NotFoundTemplate is rendered with a 404.
+	NotFoundTemplate   string `json:"not_found_template,omitempty"`
+	DefaultDestination string `json:"default_destination,omitempty"`
+	DefaultCode        int    `json:"default_code,omitempty"`
+	notFound           *template.Template
+
+	// Webhooks are notified of changes made through the API and of rules
+	// crossing their alert thresholds.
+	Webhooks []*Webhook `json:"webhooks,omitempty"`
+
+	// Profiles are named sets of redirections, such as for a sale or an
+	// incident. The redirections of the active Profile, if any, take
+	// precedence over the others.
+	Profiles         map[string]Rules `json:"profiles,omitempty"`
+	Profile          string           `json:"profile,omitempty"`
+	profileWildcards *wildcardSet
+
+	// Hosts are sets of redirections for requests to particular hosts, or
+	// to hosts matching a pattern such as *.go.example.com (see hostRules).
+	Hosts map[string]Rules `json:"hosts,omitempty"`
+	hosts []*hostRules
+
+	// Maintenance, if set, answers requests for the whole server, or for
+	// some prefixes, with a holding page instead of redirecting them.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+
+	// Upstream, if set, serves the requests without a redirection, instead
+	// of the default destination or a 404 (see Upstream).
+	Upstream *Upstream `json:"upstream,omitempty"`
+
+	// Canonical, if set, redirects requests that don't arrive at the
+	// canonical scheme and host there (see Canonical).
+	Canonical *Canonical `json:"canonical,omitempty"`
+
+	// Bots are user-agent substrings of clients to count as bots in the
+	// statistics, besides the ones that are known.
+	Bots []string `json:"bots,omitempty"`
+	bots []string
+
+	// Admin.Allow lists the IPs and CIDRs allowed to use the admin endpoints,
+	// and Admin.Tokens the tokens that may use them (see APIToken).
+	Admin      *AdminConfig `json:"admin,omitempty"`
+	adminAllow []netip.Prefix
+	tokens     map[[sha256.Size]byte]apiToken
+
+	// The Redirector's clock, or nil for the default one (see Clock).
+	clock Clock
+}
+
+// The admin section of the configuration.
+type AdminConfig struct {
+	Allow  []string             `json:"allow,omitempty"`
+	Tokens map[string]*APIToken `json:"tokens,omitempty"`
+}
+
+// Ways of applying a configuration to the current one.
+const (
+	ConfigMerge   = "merge"
+	ConfigReplace = "replace"
+)
+
+// ConfigChanges counts how applying a configuration changed the redirections,
+// and keeps what each change was.
+type ConfigChanges struct {
+	Mode      string `json:"mode,omitempty"`
+	Added     int    `json:"added"`
+	Updated   int    `json:"updated"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+	changed   []ruleChange
+}
+
+// A redirection's rule before and after a change; nil if there was none.
+type ruleChange struct {
+	source   string
+	old, new *Rule
+}
+
+// Compare the redirections before and after a change.
+func diffRedirections(before, after Rules) (changes ConfigChanges) {
+	after.Each(func(source string, rule *Rule) {
+		old, ok := before.Get(source)
+		switch {
+		case !ok:
+			changes.Added++
+		case old == rule || old.equal(rule):
+			changes.Unchanged++
+			return
+		default:
+			changes.Updated++
+		}
+		changes.changed = append(changes.changed, ruleChange{source, old, rule})
+	})
+	before.Each(func(source string, old *Rule) {
+		if _, ok := after.Get(source); !ok {
+			changes.Removed++
+			changes.changed = append(changes.changed, ruleChange{source, old, nil})
+		}
+	})
+	return
+}
+
+// Copy the configuration so that decoding JSON into the copy leaves the
+// original untouched. Rules and groups are shared: decoding replaces them
+// rather than modifying them.
+func (config *Config) clone() *Config {
+	clone := *config
+	clone.Redirections = config.Redirections.clone()
+	if config.Profiles != nil {
+		clone.Profiles = make(map[string]Rules, len(config.Profiles))
+		for name, redirections := range config.Profiles {
+			clone.Profiles[name] = redirections
+		}
+	}
+	if config.Hosts != nil {
+		clone.Hosts = make(map[string]Rules, len(config.Hosts))
+		for host, redirections := range config.Hosts {
+			clone.Hosts[host] = redirections
+		}
+	}
+	if config.Destinations != nil {
+		clone.Destinations = make(map[string]string, len(config.Destinations))
+		for name, to := range config.Destinations {
+			clone.Destinations[name] = to
+		}
+	}
+	if config.Tracking != nil {
+		clone.Tracking = make(map[string]string, len(config.Tracking))
+		for key, value := range config.Tracking {
+			clone.Tracking[key] = value
+		}
+	}
+	if config.Groups != nil {
+		clone.Groups = make(map[string]*Group, len(config.Groups))
+		for name, group := range config.Groups {
+			clone.Groups[name] = group
+		}
+	}
+	if config.Maintenance != nil {
+		maintenance := *config.Maintenance
+		maintenance.Prefixes = make(map[string]HoldingPage, len(config.Maintenance.Prefixes))
+		for prefix, page := range config.Maintenance.Prefixes {
+			maintenance.Prefixes[prefix] = page
+		}
+		clone.Maintenance = &maintenance
+	}
+	// Decoding a slice reuses its elements, so the webhooks and bots are
+	// copied too.
+	if config.Webhooks != nil {
+		clone.Webhooks = make([]*Webhook, len(config.Webhooks))
+		for i, hook := range config.Webhooks {
+			copied := *hook
+			copied.Events = slices.Clone(hook.Events)
+			clone.Webhooks[i] = &copied
+		}
+	}
+	clone.Bots = slices.Clone(config.Bots)
+	if config.Upstream != nil {
+		upstream := *config.Upstream
+		clone.Upstream = &upstream
+	}
+	if config.Canonical != nil {
+		canonical := *config.Canonical
+		clone.Canonical = &canonical
+	}
+	if config.Admin != nil {
+		admin := *config.Admin
+		admin.Allow = append([]string(nil), admin.Allow...)
+		admin.Tokens = maps.Clone(admin.Tokens)
+		clone.Admin = &admin
+	}
+	return &clone
+}
+
+// Check the rules against the groups and parse the configured templates.
+// Rules and groups shared with a live configuration are only compiled once,
+// so compiling a candidate configuration never modifies the live one.
+func (config *Config) compile() (err error) {
+	if config.Redirections, err = normalizeRules(config.Redirections); err != nil {
+		return
+	}
+	for name, redirections := range config.Profiles {
+		if config.Profiles[name], err = normalizeRules(redirections); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+	}
+	for host, redirections := range config.Hosts {
+		if config.Hosts[host], err = normalizeRules(redirections); err != nil {
+			return fmt.Errorf("host %s: %v", host, err)
+		}
+	}
+
+	if err = config.compileDestinations(); err != nil {
+		return
+	}
+	if err = config.compileBots(); err != nil {
+		return
+	}
+	config.tracking = nil
+	if config.Tracking != nil {
+		if config.tracking, err = compileTracking(config.Tracking); err != nil {
+			return
+		}
+	}
+	config.Redirections.Each(func(source string, rule *Rule) {
+		if err == nil {
+			err = config.compileRule(source, rule)
+		}
+	})
+	if err != nil {
+		return
+	}
+	for name, redirections := range config.Profiles {
+		redirections.Each(func(source string, rule *Rule) {
+			if err == nil {
+				err = config.compileRule(source, rule)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+	}
+	for host, redirections := range config.Hosts {
+		redirections.Each(func(source string, rule *Rule) {
+			if err == nil {
+				err = config.compileRule(source, rule)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("host %s: %v", host, err)
+		}
+	}
+	if _, ok := config.Profiles[config.Profile]; config.Profile != "" && !ok {
+		return fmt.Errorf("unknown profile %q", config.Profile)
+	}
+
+	if config.wildcards, err = compileWildcards(config.Redirections); err != nil {
+		return
+	}
+	if config.profileWildcards, err = compileWildcards(config.Profiles[config.Profile]); err != nil {
+		return fmt.Errorf("profile %s: %v", config.Profile, err)
+	}
+	if config.hosts, err = compileHosts(config.Hosts); err != nil {
+		return
+	}
+
+	config.body = nil
+	if config.RedirectBody != "" {
+		if config.body, err = template.New("redirect_body").Parse(config.RedirectBody); err != nil {
+			return
+		}
+	}
+	for name, group := range config.Groups {
+		if group.body == nil && group.RedirectBody != "" {
+			if group.body, err = template.New(name).Parse(group.RedirectBody); err != nil {
+				return fmt.Errorf("group %s: %v", name, err)
+			}
+		}
+		if group.location == nil && group.TimeZone != "" {
+			if group.location, err = time.LoadLocation(group.TimeZone); err != nil {
+				return fmt.Errorf("group %s: unknown time zone %q", name, group.TimeZone)
+			}
+		}
+	}
+
+	if config.Canonical != nil {
+		if err = config.Canonical.check(); err != nil {
+			return
+		}
+	}
+	if config.Upstream != nil {
+		if config.DefaultDestination != "" {
+			return fmt.Errorf("upstream and default_destination cannot both be set")
+		}
+		if err = config.Upstream.compile(); err != nil {
+			return
+		}
+	}
+
+	if config.DefaultDestination != "" {
+		if err = checkDestination(config.DefaultDestination); err != nil {
+			return fmt.Errorf("default_destination: %v", err)
+		}
+	}
+
+	for _, hook := range config.Webhooks {
+		if hook.URL == "" {
+			return fmt.Errorf("webhook without a url")
+		}
+	}
+
+	if config.Maintenance != nil {
+		if err = config.Maintenance.compile(); err != nil {
+			return
+		}
+	}
+
+	config.adminAllow, config.tokens = nil, nil
+	if config.Admin != nil {
+		if config.adminAllow, err = parsePrefixes(strings.Join(config.Admin.Allow, ",")); err != nil {
+			return fmt.Errorf("admin allow: %v", err)
+		}
+		if config.tokens, err = compileTokens(config.Admin.Tokens); err != nil {
+			return fmt.Errorf("admin %v", err)
+		}
+	}
+
+	config.notFound = nil
+	if config.NotFoundTemplate != "" {
+		config.notFound, err = template.ParseFiles(config.NotFoundTemplate)
+	}
+	return
+}
+
+// Check a rule and build its proxy, if it needs one and doesn't have it yet.
+func (config *Config) compileRule(source string, rule *Rule) (err error) {
+	if rule.Respond != nil {
+		if rule.To != "" || rule.Mode != "" {
+			return fmt.Errorf("redirection %s: rules that respond have no destination or mode", source)
+		}
+		if err = rule.Respond.check(); err != nil {
+			return fmt.Errorf("redirection %s: %v", source, err)
+		}
+	} else if rule.Script == "" || rule.To != "" {
+		if err = config.checkTo(rule.To); err != nil {
+			return fmt.Errorf("redirection %s: %v", source, err)
+		}
+	}
+	if rule.Script != "" && rule.script == nil {
+		if rule.Respond != nil || rule.Mode == ModeProxy {
+			return fmt.Errorf("redirection %s: rules that respond or proxy cannot have scripts", source)
+		}
+		if rule.script, err = compileScript(rule.Script); err != nil {
+			return fmt.Errorf("redirection %s: %v", source, err)
+		}
+	}
+	for _, tag := range rule.Tags {
+		if tag == "" || tag != strings.TrimSpace(tag) {
+			return fmt.Errorf("redirection %s: tag %q is empty or has surrounding spaces", source, tag)
+		}
+	}
+	if rule.Canonical != nil {
+		if err = rule.Canonical.check(); err != nil {
+			return fmt.Errorf("redirection %s: %v", source, err)
+		}
+	}
+	if _, ok := config.Groups[rule.Group]; rule.Group != "" && !ok {
+		return fmt.Errorf("redirection %s: unknown group %q", source, rule.Group)
+	}
+	if rule.Code != 0 && (rule.Code < 300 || rule.Code > 399) {
+		return fmt.Errorf("redirection %s: %d is not a redirection code", source, rule.Code)
+	}
+	switch rule.Crawlers {
+	case "", CrawlersRedirect, CrawlersBlock, CrawlersNotFound:
+	default:
+		return fmt.Errorf("redirection %s: unknown crawler policy %q", source, rule.Crawlers)
+	}
+	if rule.Tracking != nil && rule.tracking == nil {
+		if rule.tracking, err = compileTracking(rule.Tracking); err != nil {
+			return fmt.Errorf("redirection %s: %v", source, err)
+		}
+	}
+	if (rule.Start != "" || rule.End != "") && rule.schedule == nil {
+		if rule.schedule, err = compileSchedule(rule.Start, rule.End); err != nil {
+			return fmt.Errorf("redirection %s: %v", source, err)
+		}
+	}
+	if rule.Alert != nil && rule.Alert.window == 0 {
+		window, err := time.ParseDuration(rule.Alert.Window)
+		if err != nil || window <= 0 || rule.Alert.Hits < 1 {
+			return fmt.Errorf("redirection %s: alert needs hits and a window", source)
+		}
+		rule.Alert.window = window
+	}
+	switch rule.Mode {
+	case "", ModeRedirect:
+		if rule.Cache != nil {
+			return fmt.Errorf("redirection %s: only proxied redirections are cached", source)
+		}
+	case ModeProxy:
+		if strings.HasPrefix(rule.To, "@") {
+			return fmt.Errorf("redirection %s: proxied destinations cannot be named destinations", source)
+		}
+		if rule.proxy != nil {
+			break
+		}
+		if rule.proxy, err = newProxy(rule); err != nil {
+			return fmt.Errorf("redirection %s: %v", source, err)
+		}
+		if rule.Cache != nil {
+			if rule.cache, err = compileProxyCache(rule.Cache, config.Clock()); err != nil {
+				return fmt.Errorf("redirection %s: %v", source, err)
+			}
+		}
+	default:
+		return fmt.Errorf("redirection %s: unknown mode %q", source, rule.Mode)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Read a configuration file, or a directory of them, as JSON. If
+// configurations must be signed, so must each file.
+func readConfig(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return readConfigDir(path)
+	}
+	config, err := readSigned(path)
+	if err != nil {
+		return nil, err
+	}
+	return configToJSON(config, fileFormat(path))
+}
+
+// Read the configuration files in a directory, by their extensions, and
+// merge them in lexical order (see mergeConfigs).
+func readConfigDir(dir string) ([]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var parts []configPart
+	for _, entry := range entries {
+		format, ok := extensionFormat(entry.Name())
+		if !ok || entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := readSigned(path)
+		if err == nil {
+			data, err = configToJSON(data, format)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		parts = append(parts, configPart{entry.Name(), data})
+	}
+	return mergeConfigs(parts)
+}
+
+// A configPart is one of several configurations to merge, as JSON, and the
+// name of the file or key it came from.
+type configPart struct {
+	name string
+	data []byte
+}
+
+// Merge configurations in order. Later ones take precedence: a redirection,
+// group, profile, or setting in one replaces the same one in those before
+// it, which is logged as a conflict. Webhooks and bots from all of them are
+// kept.
+func mergeConfigs(parts []configPart) ([]byte, error) {
+	merged := &Config{Redirections: newRules(0)}
+	origins := make(map[string]string)
+	define := func(name, file string) {
+		if origin, ok := origins[name]; ok {
+			log.Printf("%s: %s is also in %s, which it replaces\n", file, name, origin)
+		}
+		origins[name] = file
+	}
+
+	for _, entry := range parts {
+		var part Config
+		if err := json.Unmarshal(entry.data, &part); err != nil {
+			return nil, fmt.Errorf("%s: %v", entry.name, err)
+		}
+
+		sources := make([]string, 0, part.Redirections.Len())
+		part.Redirections.Each(func(source string, rule *Rule) {
+			sources = append(sources, source)
+		})
+		sort.Strings(sources)
+		for _, source := range sources {
+			define("redirection "+source, entry.name)
+			rule, _ := part.Redirections.Get(source)
+			merged.Redirections.Set(source, rule)
+		}
+		for name, to := range part.Destinations {
+			define("destination "+name, entry.name)
+			if merged.Destinations == nil {
+				merged.Destinations = make(map[string]string)
+			}
+			merged.Destinations[name] = to
+		}
+		for key, value := range part.Tracking {
+			define("tracking "+key, entry.name)
+			if merged.Tracking == nil {
+				merged.Tracking = make(map[string]string)
+			}
+			merged.Tracking[key] = value
+		}
+		for name, group := range part.Groups {
+			define("group "+name, entry.name)
+			if merged.Groups == nil {
+				merged.Groups = make(map[string]*Group)
+			}
+			merged.Groups[name] = group
+		}
+		for name, redirections := range part.Profiles {
+			define("profile "+name, entry.name)
+			if merged.Profiles == nil {
+				merged.Profiles = make(map[string]Rules)
+			}
+			merged.Profiles[name] = redirections
+		}
+		for host, redirections := range part.Hosts {
+			define("host "+host, entry.name)
+			if merged.Hosts == nil {
+				merged.Hosts = make(map[string]Rules)
+			}
+			merged.Hosts[host] = redirections
+		}
+		if part.Profile != "" {
+			define("active profile", entry.name)
+			merged.Profile = part.Profile
+		}
+		if part.RedirectBody != "" {
+			define("redirect_body", entry.name)
+			merged.RedirectBody = part.RedirectBody
+		}
+		if part.NotFoundTemplate != "" {
+			define("not_found_template", entry.name)
+			merged.NotFoundTemplate = part.NotFoundTemplate
+		}
+		if part.DefaultDestination != "" {
+			define("default_destination", entry.name)
+			merged.DefaultDestination = part.DefaultDestination
+		}
+		if part.DefaultCode != 0 {
+			define("default_code", entry.name)
+			merged.DefaultCode = part.DefaultCode
+		}
+		if part.Maintenance != nil {
+			define("maintenance", entry.name)
+			merged.Maintenance = part.Maintenance
+		}
+		if part.Admin != nil {
+			define("admin", entry.name)
+			merged.Admin = part.Admin
+		}
+		merged.Webhooks = append(merged.Webhooks, part.Webhooks...)
+		merged.Bots = append(merged.Bots, part.Bots...)
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// The format of the configuration file, if its extension doesn't say.
+var configFormat *string = flag.String("config-format", "", "configuration file format: json, yaml, or toml (by default, from the file's extension)")
+
+// Configuration formats. Configurations in YAML and TOML are converted to
+// JSON, and back again for export. Comments are not kept.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// The format of a configuration file: the -config-format flag if set, or
+// else the one its extension names, or else JSON.
+func fileFormat(name string) string {
+	if *configFormat != "" {
+		return *configFormat
+	}
+	format, _ := extensionFormat(name)
+	return format
+}
+
+// The format a file's extension names, and whether it names one.
+func extensionFormat(name string) (string, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJSON, true
+	case ".yaml", ".yml":
+		return FormatYAML, true
+	case ".toml":
+		return FormatTOML, true
+	}
+	return FormatJSON, false
+}
+
+// The format of a request body, from its Content-Type.
+func contentFormat(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(strings.ToLower(mediaType)) {
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return FormatYAML
+	case "application/toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// Whether a Content-Type is one of a configuration format's.
+func configContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(strings.ToLower(mediaType)) {
+	case "application/json", "text/json", "application/yaml", "application/x-yaml", "text/yaml", "application/toml":
+		return true
+	}
+	return false
+}
+
+// The Content-Type for a format.
+func formatContentType(format string) string {
+	switch format {
+	case FormatYAML:
+		return "application/yaml"
+	case FormatTOML:
+		return "application/toml"
+	}
+	return "application/json"
+}
+
+// Convert a configuration in the given format to JSON.
+func configToJSON(config []byte, format string) ([]byte, error) {
+	var value any
+	var err error
+	switch format {
+	case FormatJSON:
+		return config, nil
+	case FormatYAML:
+		value, err = parseYAML(config)
+	case FormatTOML:
+		value, err = parseTOML(config)
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", format, err)
+	}
+	return json.Marshal(value)
+}
+
+// Convert a JSON configuration to the given format, keeping the order of its
+// fields.
+func configFromJSON(config []byte, format string) ([]byte, error) {
+	if format == FormatJSON {
+		return config, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.UseNumber()
+	value, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	switch format {
+	case FormatYAML:
+		encodeYAML(buf, value, 0)
+	case FormatTOML:
+		root, ok := value.(*orderedMap)
+		if !ok {
+			return nil, fmt.Errorf("toml: the configuration is not an object")
+		}
+		encodeTOML(buf, nil, root, false)
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// An orderedMap is a JSON object that remembers the order of its keys.
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+// Decode a JSON value, with objects as orderedMaps and numbers as
+// json.Numbers.
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		m := &orderedMap{values: make(map[string]any)}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			m.keys = append(m.keys, key.(string))
+			m.values[key.(string)] = value
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	}
+	return token, nil
+}
+
+// Quote a string with the escapes that JSON, YAML, and TOML have in common.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f || r == utf8.RuneError {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Check that a configuration in format reads as the JSON want, or fails to
+// read if want is empty.
+func expectConfigJSON(t *testing.T, format, config, want string) {
+	t.Helper()
+	got, err := configToJSON([]byte(config), format)
+	if want == "" {
+		if err == nil {
+			t.Errorf("%q: got %s, want an error", config, got)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%q: %v", config, err)
+		return
+	}
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("%q: %v", config, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("bad test %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("%q: got %s, want %s", config, got, want)
+	}
+}
+
+func TestYAML(t *testing.T) {
+	for _, tt := range []struct{ yaml, json string }{
+		{"redirections:\n  /a: /b\n  /c: /d\n", `{"redirections": {"/a": "/b", "/c": "/d"}}`},
+		{"---\n# A comment\nredirections:   # another\n  /a: '/b#c'\n", `{"redirections": {"/a": "/b#c"}}`},
+		{"a:\n  b:\n    c: 1\n  d: 2.5\ne: x\n", `{"a": {"b": {"c": 1}, "d": 2.5}, "e": "x"}`},
+		{"list:\n  - one\n  - 2\n  - k: v\n    l: w\n", `{"list": ["one", 2, {"k": "v", "l": "w"}]}`},
+		{"list:\n- one\n- two\n", `{"list": ["one", "two"]}`},
+		{"flow: [a, 'b, c', {x: 1, y: [2, 3]}]\n", `{"flow": ["a", "b, c", {"x": 1, "y": [2, 3]}]}`},
+		{"empty: {}\nnone: []\n", `{"empty": {}, "none": []}`},
+		{"a: null\nb: ~\nc:\nd: true\ne: False\nf: 010\ng: 1e3\n", `{"a": null, "b": null, "c": null, "d": true, "e": false, "f": "010", "g": 1e3}`},
+		{`s: "tab\there \"quoted\""` + "\n", `{"s": "tab\there \"quoted\""}`},
+		{"s: 'it''s'\n", `{"s": "it's"}`},
+		{"s: a # comment\nt: a#b\n", `{"s": "a", "t": "a#b"}`},
+		{"body: |\n  <p>Moved</p>\n\n  <p>Again</p>\nnext: 1\n", `{"body": "<p>Moved</p>\n\n<p>Again</p>\n", "next": 1}`},
+		{"body: |-\n  line\n", `{"body": "line"}`},
+		{"body: >\n  folded\n  text\n\n  para\n", `{"body": "folded text\npara\n"}`},
+		{"/p/{id}: /products/{id}\n", `{"/p/{id}": "/products/{id}"}`},
+		{"key with spaces: value: with colon\n", `{"key with spaces": "value: with colon"}`},
+
+		{"a: [1, 2\n", ""},
+		{"a: 'open\n", ""},
+		{"a: {b 1}\n", ""},
+		{"a:\n  b: 1\n c: 2\n", ""},
+		{"- a\nb: c\n", ""},
+	} {
+		expectConfigJSON(t, FormatYAML, tt.yaml, tt.json)
+	}
+}
+
+func TestTOML(t *testing.T) {
+	for _, tt := range []struct{ toml, json string }{
+		{"[redirections]\n\"/a\" = \"/b\"\n'/c' = \"/d\"\n", `{"redirections": {"/a": "/b", "/c": "/d"}}`},
+		{"# comment\ncode = 301 # trailing\n", `{"code": 301}`},
+		{"a.b.c = 1\na.d = 2\n", `{"a": {"b": {"c": 1}, "d": 2}}`},
+		{"[a]\nx = 1\n[a.b]\ny = 2\n", `{"a": {"x": 1, "b": {"y": 2}}}`},
+		{"[[webhooks]]\nurl = \"https://a\"\n[[webhooks]]\nurl = \"https://b\"\nevents = [\"rule.created\", \"rule.deleted\",]\n",
+			`{"webhooks": [{"url": "https://a"}, {"url": "https://b", "events": ["rule.created", "rule.deleted"]}]}`},
+		{"rule = {to = \"/b\", code = 308, tags = [\"x\"]}\n", `{"rule": {"to": "/b", "code": 308, "tags": ["x"]}}`},
+		{"n = 1_000\nf = -0.5\ne = 6.02e23\np = +3\nt = true\nu = false\n", `{"n": 1000, "f": -0.5, "e": 6.02e23, "p": 3, "t": true, "u": false}`},
+		{`s = "tab\t\"q\" \u00e9"` + "\n", `{"s": "tab\t\"q\" é"}`},
+		{`s = 'C:\path'` + "\n", `{"s": "C:\\path"}`},
+		{"s = \"\"\"\nline one\nline two\\\n   continued\"\"\"\n", `{"s": "line one\nline twocontinued"}`},
+		{"s = '''\nraw \\n text'''\n", `{"s": "raw \\n text"}`},
+		{"start = 2026-11-27T00:00:00Z\nday = 2026-11-27\nat = 2026-11-27 09:30:00\n", `{"start": "2026-11-27T00:00:00Z", "day": "2026-11-27", "at": "2026-11-27 09:30:00"}`},
+		{"array = [\n  1,\n  2, # two\n]\n", `{"array": [1, 2]}`},
+
+		{"a = 1\na = 2\n", ""},
+		{"a = \"open\n", ""},
+		{`a = "\q"` + "\n", ""},
+		{"a = nope\n", ""},
+		{"a = 1 b = 2\n", ""},
+		{"a = 1\n[a]\n", ""},
+		{"[a\n", ""},
+		{"a = [1 2]\n", ""},
+		{"= 1\n", ""},
+	} {
+		expectConfigJSON(t, FormatTOML, tt.toml, tt.json)
+	}
+}
+
+// A configuration written as YAML or TOML reads back the same.
+func TestConfigFormatsRoundTrip(t *testing.T) {
+	const config = `{
+		"redirections": {
+			"/a": "/b",
+			"/p/{id}": {"to": "https://example.com/products/{id}", "code": 301, "tags": ["catalog", "2026"]},
+			"/gone": {"respond": {"status": 410, "body": "<p>Gone.</p>\n<p>For good.</p>\n"}}
+		},
+		"profiles": {"sale": {"/": "/sale"}},
+		"webhooks": [{"url": "https://hooks.example.com/a", "events": ["rule.created"]}],
+		"redirect_body": "Moved to {{.Location}}: 'here' # not a comment"
+	}`
+	for _, format := range []string{FormatYAML, FormatTOML} {
+		encoded, err := configFromJSON([]byte(config), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		expectConfigJSON(t, format, string(encoded), config)
+		if t.Failed() {
+			t.Logf("%s:\n%s", format, strings.TrimSpace(string(encoded)))
+		}
+	}
+}
+
+// The configuration is served in the format asked for, with its own ETag.
+func TestGetConfigFormat(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/a": "/b", "/c": {"to": "/d", "code": 301}}}`)
+	etag := tr.do("GET", "/_config", "").Header().Get("ETag")
+	for format, contentType := range map[string]string{FormatYAML: "application/yaml", FormatTOML: "application/toml"} {
+		w := tr.do("GET", "/_config?format="+format, "")
+		tr.expectStatus(w, http.StatusOK)
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), contentType) {
+			t.Errorf("%s: got Content-Type %q", format, w.Header().Get("Content-Type"))
+		}
+		if w.Header().Get("ETag") == etag {
+			t.Errorf("%s: same ETag as JSON", format)
+		}
+		expectConfigJSON(t, format, w.Body.String(), `{"redirections": {"/a": "/b", "/c": {"to": "/d", "code": 301}}}`)
+
+		// Sent back as it came, with its ETag, it changes nothing.
+		w = tr.do("PUT", "/_config?mode=replace", w.Body.String(), "If-Match", w.Header().Get("ETag"), "Content-Type", contentType)
+		tr.expectStatus(w, http.StatusOK)
+		var changes ConfigChanges
+		tr.decode(w, &changes)
+		if changes.Added+changes.Updated+changes.Removed != 0 {
+			t.Errorf("%s: got %+v", format, changes)
+		}
+	}
+	tr.expectStatus(tr.do("GET", "/_config?format=xml", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.do("PUT", "/_config", "redirections: [", "If-Match", "*", "Content-Type", "application/yaml"), http.StatusBadRequest)
+	tr.expectStatus(tr.do("PUT", "/_config", "a = ", "If-Match", "*", "Content-Type", "application/toml"), http.StatusBadRequest)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// The format of the log: "text", as log prints it, or "json", a JSON object
+// for each line, as log collectors expect. By default, the log is JSON on
+// standard output when standard error isn't a terminal, as in a container,
+// and text on standard error when it is.
+var logFormat *string = flag.String("log-format", "", "format of the log, text or json (default json when standard error isn't a terminal)")
+
+// Log a summary of the settings once the server is listening.
+var printConfig *bool = flag.Bool("print-config", false, "log a summary of the settings at startup")
+
+// The log formats.
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// Use the port in PORT, as platforms such as Cloud Run and Heroku and many
+// Kubernetes charts set it, unless -port or FOFF_PORT is given. The server
+// then listens on all interfaces unless -host is given too, since the
+// platform's router connects from outside the container.
+func applyPort(flags *flag.FlagSet) error {
+	value := os.Getenv("PORT")
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if value == "" || given["port"] {
+		return nil
+	}
+	if err := flags.Set("port", value); err != nil {
+		return fmt.Errorf("PORT: %v", err)
+	}
+	if !given["host"] {
+		return flags.Set("host", "")
+	}
+	return nil
+}
+
+// Set up the log in the format -log-format asks for.
+func setupLogging() error {
+	format := *logFormat
+	if format == "" {
+		format = LogText
+		if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+			format = LogJSON
+		}
+	}
+	switch format {
+	case LogText:
+	case LogJSON:
+		// The log package writes through the default slog handler once
+		// there is one.
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+// A startupSummary is what -print-config logs: where the server listens, what
+// it serves, and the flags set on the command line or in the environment,
+// with secrets redacted. UID is -1 where there are no user IDs.
+type startupSummary struct {
+	Version      string            `json:"version"`
+	PID          int               `json:"pid"`
+	UID          int               `json:"uid"`
+	Listeners    []string          `json:"listeners"`
+	Config       string            `json:"config"`
+	Redirections int               `json:"redirections"`
+	Flags        map[string]string `json:"flags"`
+}
+
+// Log the startup summary.
+func (redir *Redirector) printStartupSummary(listeners []string) {
+	summary := &startupSummary{
+		Version:   version,
+		PID:       os.Getpid(),
+		UID:       os.Geteuid(),
+		Listeners: listeners,
+		Config:    *configFile,
+		Flags:     make(map[string]string),
+	}
+	if *kvStore != "" {
+		summary.Config = *kvStore + " " + *kvPrefix
+	}
+	redir.mu.RLock()
+	summary.Redirections = redir.Redirections.Len()
+	redir.mu.RUnlock()
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		if strings.Contains(f.Name, "secret") || strings.Contains(f.Name, "token") {
+			value = "REDACTED"
+		}
+		summary.Flags[f.Name] = value
+	})
+	line, _ := json.Marshal(summary)
+	log.Printf("settings: %s", line)
+}
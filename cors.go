@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Origins allowed to call the admin endpoints from a browser, such as an
+// internal app for managing the redirections. Browsers refuse those calls
+// unless the origin is listed here, or * allows any.
+var corsOrigins *string = flag.String("cors-origins", "", "comma-separated origins allowed to call the admin endpoints from browsers, or * for any")
+
+// The methods and request headers browsers may use from those origins.
+// Methods an endpoint doesn't take are refused anyway.
+var corsMethods *string = flag.String("cors-methods", "GET, HEAD, POST, PUT, PATCH, DELETE", "methods allowed from -cors-origins")
+var corsHeaders *string = flag.String("cors-headers", "Authorization, Content-Type, If-Match, Idempotency-Key, X-Config-Mode, X-Config-Signature, Traceparent",
+	"request headers allowed from -cors-origins")
+
+// Whether those calls may send cookies and HTTP authentication, for apps
+// behind a proxy that authenticates admins.
+var corsCredentials *bool = flag.Bool("cors-credentials", false, "allow credentials on requests from -cors-origins")
+
+// How long browsers may cache the answer to a preflight request.
+var corsMaxAge *time.Duration = flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache preflight responses")
+
+// Response headers the scripts may read, besides the ones that always can.
+const corsExposed = "ETag, Location, Retry-After, Idempotent-Replayed"
+
+// The parsed CORS flags.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	methods   map[string]bool
+}
+
+// The parsed CORS flags, if -cors-origins is set.
+var cors *corsPolicy
+
+// Parse the CORS flags.
+func parseCORS() (*corsPolicy, error) {
+	if strings.TrimSpace(*corsOrigins) == "" {
+		return nil, nil
+	}
+	policy := &corsPolicy{origins: make(map[string]bool), methods: make(map[string]bool)}
+	for _, origin := range strings.Split(*corsOrigins, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		switch {
+		case origin == "":
+		case origin == "*":
+			policy.anyOrigin = true
+		case !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://"), strings.Count(origin, "/") > 2:
+			return nil, errors.New("origin " + origin + " is not a scheme and host, such as https://admin.example.com")
+		default:
+			policy.origins[strings.ToLower(origin)] = true
+		}
+	}
+	for _, method := range strings.Split(*corsMethods, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			policy.methods[method] = true
+		}
+	}
+	return policy, nil
+}
+
+// Whether requests from origin are allowed.
+func (policy *corsPolicy) allows(origin string) bool {
+	return origin != "" && (policy.anyOrigin || policy.origins[strings.ToLower(origin)])
+}
+
+// Allow cross-origin requests to handler from the -cors-origins. Preflight
+// requests are answered by allowMethods, which knows each endpoint's
+// methods, with the headers set here.
+func allowCORS(handler http.Handler) http.Handler {
+	if cors == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		origin := req.Header.Get("Origin")
+		if !cors.allows(origin) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		if cors.anyOrigin && !*corsCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if *corsCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Headers", *corsHeaders)
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		} else {
+			header.Set("Access-Control-Expose-Headers", corsExposed)
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// The methods of an endpoint, in an Allow header, that browsers may use from
+// the allowed origins.
+func (policy *corsPolicy) allowedMethods(allow []string) string {
+	var methods []string
+	for _, method := range allow {
+		if method != "OPTIONS" && policy.methods[method] {
+			methods = append(methods, method)
+		}
+	}
+	return strings.Join(methods, ", ")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// User-agent substrings of search engine crawlers.
+var crawlerAgents = []string{
+	"googlebot",
+	"bingbot",
+	"slurp",
+	"duckduckbot",
+	"baiduspider",
+	"yandexbot",
+	"sogou",
+	"exabot",
+	"seznambot",
+	"petalbot",
+	"applebot",
+	"ahrefsbot",
+	"semrushbot",
+	"mj12bot",
+	"dotbot",
+}
+
+// User-agent substrings of the crawlers that fetch pages to build link
+// previews on social platforms and chat applications.
+var previewAgents = []string{
+	"facebookexternalhit",
+	"facebot",
+	"twitterbot",
+	"linkedinbot",
+	"slackbot",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"skypeuripreview",
+	"pinterest",
+	"redditbot",
+	"embedly",
+	"vkshare",
+	"applebot",
+}
+
+// Whether the user agent contains any of the agents.
+func matchAgent(ua string, agents []string) bool {
+	ua = strings.ToLower(ua)
+	for _, agent := range agents {
+		if strings.Contains(ua, agent) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the user agent is a known search engine crawler.
+func isCrawler(ua string) bool {
+	return matchAgent(ua, crawlerAgents)
+}
+
+// Whether the user agent is a known link preview crawler.
+func isPreviewAgent(ua string) bool {
+	return matchAgent(ua, previewAgents)
+}
+
+// Crawler policies. Rules redirect crawlers like everyone else unless they
+// say otherwise.
+const (
+	CrawlersRedirect = "redirect"
+	CrawlersBlock    = "block"
+	CrawlersNotFound = "404"
+)
+
+// How a rule treats the client given its user agent: one of the crawler
+// policies, or "preview" if it should be served the rule's preview.
+func (rule *Rule) agentPolicy(ua string) string {
+	if rule.Preview != nil && isPreviewAgent(ua) {
+		return "preview"
+	}
+	if rule.Crawlers != "" && isCrawler(ua) {
+		return rule.Crawlers
+	}
+	return CrawlersRedirect
+}
+
+// Serve a crawler a page it should neither index nor follow, instead of the
+// redirect.
+func serveBlocked(w http.ResponseWriter, req *http.Request) {
+	log.Println(realAddr(req), "blocked crawler for", req.URL.Path)
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, `<!DOCTYPE html>
+<html><head><meta name="robots" content="noindex, nofollow"></head><body></body></html>
+`)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check the destinations of the redirections now and then, so campaigns
+// don't send people to an outage unnoticed. Destinations that fail
+// checkFailures checks in a row are broken, and listed at /_stats/broken;
+// with -suppress-broken, requests that would be redirected to one get the
+// 404 page instead.
+var checkInterval *time.Duration = flag.Duration("check-destinations", 0, "how often to check that destinations are up (0 to never check)")
+var checkTimeout *time.Duration = flag.Duration("check-timeout", 5*time.Second, "how long a destination has to answer a check")
+var suppressBroken *bool = flag.Bool("suppress-broken", false, "send the 404 page instead of redirecting to a broken destination")
+
+// How many checks in a row a destination must fail to be broken, how many
+// destinations are checked at once, and how many of the sources redirecting
+// to one are listed.
+const (
+	checkFailures = 2
+	checkWorkers  = 8
+	checkSources  = 10
+)
+
+// The user agent checks are made with.
+const checkAgent = "fourohfourfound-check"
+
+// The latest check of a destination. Broken is when it became broken, if it
+// is. Sources are some of the redirections to it.
+type destinationCheck struct {
+	Destination string     `json:"destination"`
+	Status      int        `json:"status,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Failures    int        `json:"failures"`
+	Checked     time.Time  `json:"checked"`
+	Broken      *time.Time `json:"broken,omitempty"`
+	Sources     []string   `json:"sources"`
+}
+
+// A destinationChecker keeps the latest check of each destination.
+type destinationChecker struct {
+	client  *http.Client
+	clock   Clock
+	mu      sync.RWMutex
+	results map[string]*destinationCheck
+}
+
+func newDestinationChecker(clock Clock) *destinationChecker {
+	return &destinationChecker{
+		client: &http.Client{
+			Timeout: *checkTimeout,
+			// A destination that redirects is up.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		clock:   clock,
+		results: make(map[string]*destinationCheck),
+	}
+}
+
+// The destinations to check, with the sources redirecting to them. Only
+// absolute URLs without placeholders can be checked; paths on this server
+// are its own business. The caller must hold one of the Redirector's locks.
+func (config *Config) checkTargets() map[string][]string {
+	targets := make(map[string][]string)
+	add := func(prefix string) func(string, *Rule) {
+		return func(source string, rule *Rule) {
+			if rule.Respond != nil || rule.proxy != nil {
+				return
+			}
+			to, _ := config.expand(rule.To)
+			if !strings.HasPrefix(to, "http://") && !strings.HasPrefix(to, "https://") || strings.ContainsAny(to, "{*") {
+				return
+			}
+			targets[to] = append(targets[to], prefix+source)
+		}
+	}
+	config.Redirections.Each(add(""))
+	for name, redirections := range config.Profiles {
+		redirections.Each(add("profile " + name + " "))
+	}
+	for host, redirections := range config.Hosts {
+		redirections.Each(add(host))
+	}
+	return targets
+}
+
+// Check the destinations every interval.
+func (redir *Redirector) checkDestinations(interval time.Duration) {
+	for {
+		redir.mu.RLock()
+		targets := redir.checkTargets()
+		redir.mu.RUnlock()
+		redir.checks.run(targets)
+		time.Sleep(interval)
+	}
+}
+
+// Check each of the targets, checkWorkers at a time, and forget the
+// destinations that are no longer configured.
+func (checker *destinationChecker) run(targets map[string][]string) {
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < checkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for destination := range work {
+				status, err := checker.check(destination)
+				checker.record(destination, targets[destination], status, err)
+			}
+		}()
+	}
+	for destination := range targets {
+		work <- destination
+	}
+	close(work)
+	wg.Wait()
+
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	for destination := range checker.results {
+		if _, ok := targets[destination]; !ok {
+			delete(checker.results, destination)
+		}
+	}
+}
+
+// Check a destination with HEAD, or GET if it doesn't take HEAD. Statuses
+// of 400 and up fail.
+func (checker *destinationChecker) check(destination string) (int, error) {
+	var status int
+	for _, method := range []string{"HEAD", "GET"} {
+		ctx, cancel := context.WithTimeout(context.Background(), *checkTimeout)
+		req, err := http.NewRequestWithContext(ctx, method, destination, nil)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		req.Header.Set("User-Agent", checkAgent+"/"+version)
+		resp, err := checker.client.Do(req)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		resp.Body.Close()
+		cancel()
+		status = resp.StatusCode
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	if status >= 400 {
+		return status, errStatus(status)
+	}
+	return status, nil
+}
+
+type errStatus int
+
+func (status errStatus) Error() string {
+	return http.StatusText(int(status))
+}
+
+// Record a check, logging destinations that break or recover.
+func (checker *destinationChecker) record(destination string, sources []string, status int, err error) {
+	sort.Strings(sources)
+	if len(sources) > checkSources {
+		sources = sources[:checkSources]
+	}
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	result := checker.results[destination]
+	if result == nil {
+		result = &destinationCheck{Destination: destination}
+		checker.results[destination] = result
+	}
+	now := checker.clock.Now()
+	result.Status, result.Checked, result.Sources, result.Error = status, now, sources, ""
+	if err == nil {
+		if result.Broken != nil {
+			log.Println("destination", destination, "recovered")
+		}
+		result.Failures, result.Broken = 0, nil
+		return
+	}
+	result.Error = err.Error()
+	result.Failures++
+	if result.Failures == checkFailures {
+		result.Broken = &now
+		log.Println("destination", destination, "is broken:", err)
+	}
+}
+
+// Whether destination is broken.
+func (checker *destinationChecker) broken(destination string) bool {
+	if checker == nil {
+		return false
+	}
+	checker.mu.RLock()
+	defer checker.mu.RUnlock()
+	result := checker.results[destination]
+	return result != nil && result.Broken != nil
+}
+
+// The broken destinations, those broken longest first.
+func (checker *destinationChecker) report() []destinationCheck {
+	broken := []destinationCheck{}
+	if checker == nil {
+		return broken
+	}
+	checker.mu.RLock()
+	defer checker.mu.RUnlock()
+	for _, result := range checker.results {
+		if result.Broken != nil {
+			broken = append(broken, *result)
+		}
+	}
+	sort.Slice(broken, func(i, j int) bool {
+		if !broken[i].Broken.Equal(*broken[j].Broken) {
+			return broken[i].Broken.Before(*broken[j].Broken)
+		}
+		return broken[i].Destination < broken[j].Destination
+	})
+	return broken
+}
+
+// The BrokenHandler lists the broken destinations (GET /_stats/broken).
+func (redir *Redirector) BrokenHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			if redir.checks == nil {
+				writeError(w, http.StatusNotFound, "Destinations are not checked; start with -check-destinations")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(redir.checks.report())
+		})
+	}
+}
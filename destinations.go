@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A destination starting with @ refers to one of the configuration's named
+// destinations, optionally followed by a path, query, or fragment to add to
+// it, so that a base URL shared by many rules is only written once:
+//
+//	"destinations": {"shop": "https://shop.example.com"},
+//	"redirections": {"/sale": "@shop/sale"}
+//
+// Named destinations are filled in when a request is matched, so changing
+// one changes every rule that refers to it.
+func (config *Config) expand(to string) (string, bool) {
+	if !strings.HasPrefix(to, "@") {
+		return to, true
+	}
+	name, rest := splitNamed(to)
+	base, ok := config.Destinations[name]
+	if !ok {
+		return to, false
+	}
+	if strings.HasSuffix(base, "/") && strings.HasPrefix(rest, "/") {
+		rest = rest[1:]
+	}
+	return base + rest, true
+}
+
+// Split a reference to a named destination into the name and the rest.
+func splitNamed(to string) (name, rest string) {
+	name = strings.TrimPrefix(to, "@")
+	if i := strings.IndexAny(name, "/?#"); i >= 0 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// Check a rule's destination, with any named destination filled in.
+func (config *Config) checkTo(to string) error {
+	expanded, ok := config.expand(to)
+	if !ok {
+		name, _ := splitNamed(to)
+		return fmt.Errorf("unknown destination %q", name)
+	}
+	return checkDestination(expanded)
+}
+
+// Check the named destinations.
+func (config *Config) compileDestinations() error {
+	for name, to := range config.Destinations {
+		if name == "" || strings.ContainsAny(name, "/?#@") {
+			return fmt.Errorf("bad destination name %q", name)
+		}
+		if err := checkDestination(to); err != nil {
+			return fmt.Errorf("destination %s: %v", name, err)
+		}
+	}
+	return nil
+}
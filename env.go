@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The prefix of the environment variables that set flags.
+const envPrefix = "FOFF_"
+
+// The environment variable for a flag: FOFF_ followed by its name in upper
+// case, with dashes as underscores (e.g., FOFF_PORT, FOFF_ADMIN_ALLOW).
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Set the flags that weren't given on the command line from the environment,
+// so that flags take precedence over the environment, which takes precedence
+// over the defaults.
+func applyEnv(flags *flag.FlagSet) (err error) {
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %v", envName(f.Name), setErr)
+		}
+	})
+	return
+}
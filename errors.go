@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// An APIError is how the admin API reports an error, as JSON, so that
+// clients can tell errors apart by their code rather than their message:
+//
+//	{"error": {"status": 400, "code": "invalid_config", "message": "Bad configuration: ...",
+//	  "details": [{"field": "redirections./sale", "message": "end 2026-11-27 is not after start 2026-12-01"}]}}
+//
+// The code is the status's name, such as "not_found" or "conflict", unless
+// the error has a more specific one. Details name the fields at fault, where
+// they are known: query and form parameters, and the parts of a
+// configuration or rule.
+type APIError struct {
+	Status  int           `json:"status"`
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Details []ErrorDetail `json:"details,omitempty"`
+}
+
+// An ErrorDetail is what is wrong with one field, which is empty if the
+// problem is with the request as a whole, such as JSON that doesn't parse.
+type ErrorDetail struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error codes more specific than their statuses.
+const (
+	CodeInvalidConfig  = "invalid_config"
+	CodeInvalidRule    = "invalid_rule"
+	CodeTooManyChanges = "too_many_changes"
+)
+
+// Answer with an error with the code of its status.
+func writeError(w http.ResponseWriter, status int, message string, details ...ErrorDetail) {
+	(&APIError{status, statusCode(status), message, details}).write(w)
+}
+
+func (e *APIError) write(w http.ResponseWriter) {
+	h := w.Header()
+	// As http.Error does, drop what was set for the response that failed.
+	h.Del("Content-Length")
+	h.Del("ETag")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]*APIError{"error": e})
+}
+
+// The code for a status: its name in lowercase, with underscores, as in
+// "precondition_failed".
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
+
+// A detail naming the query or form parameter at fault.
+func paramError(name, message string) ErrorDetail {
+	return ErrorDetail{Field: name, Message: message}
+}
+
+// The sections of a configuration named by the prefixes of the errors
+// checking it, such as "redirection /sale: ".
+var errorSections = []struct{ prefix, field string }{
+	{"redirection ", "redirections"},
+	{"token ", "admin.tokens"},
+	{"profile ", "profiles"},
+	{"host ", "hosts"},
+	{"group ", "groups"},
+	{"destination ", "destinations"},
+	{"tracking parameter ", "tracking"},
+}
+
+// The field at fault in an error decoding or checking a configuration or a
+// rule, and what is wrong with it, as in redirections./sale, or nothing if
+// it can't be told.
+func configErrorDetails(err error) []ErrorDetail {
+	var syntax *json.SyntaxError
+	var mistyped *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntax):
+		return []ErrorDetail{{Message: fmt.Sprintf("%v, at byte %d", syntax, syntax.Offset)}}
+	case errors.As(err, &mistyped):
+		return []ErrorDetail{{Field: mistyped.Field, Message: fmt.Sprintf("must be %s, not %s", jsonKind(mistyped.Type), mistyped.Value)}}
+	}
+	var fields []string
+	message := err.Error()
+	for matched := true; matched; {
+		matched = false
+		for _, section := range errorSections {
+			rest, ok := strings.CutPrefix(message, section.prefix)
+			if !ok {
+				continue
+			}
+			name, what, ok := strings.Cut(rest, ": ")
+			if !ok || strings.HasPrefix(name, `"`) {
+				break
+			}
+			// A host's or profile's rules are directly under its name.
+			if section.field != "redirections" || fields == nil {
+				name = section.field + "." + name
+			}
+			fields, message, matched = append(fields, name), what, true
+			break
+		}
+	}
+	if fields == nil {
+		return nil
+	}
+	return []ErrorDetail{{Field: strings.Join(fields, "."), Message: message}}
+}
+
+// What JSON a Go type is decoded from, such as "an object".
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct, reflect.Pointer:
+		return "an object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "something else"
+}
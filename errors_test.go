@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Check that a request answers with status and an error with code, and
+// return the error.
+func expectError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) *APIError {
+	t.Helper()
+	var body struct{ Error *APIError }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == nil {
+		t.Fatalf("got %d, not an error: %s", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	if w.Code != status || body.Error.Status != status || body.Error.Code != code {
+		t.Fatalf("got %d with %+v, want %d with code %q", w.Code, body.Error, status, code)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	return body.Error
+}
+
+// Check that an error names field, and only field, as at fault.
+func expectField(t *testing.T, e *APIError, field string) {
+	t.Helper()
+	if len(e.Details) != 1 || e.Details[0].Field != field || e.Details[0].Message == "" {
+		t.Fatalf("got details %+v, want one for %q", e.Details, field)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/a": "/b"}}`)
+	put := func(config string) *httptest.ResponseRecorder {
+		return tr.do("PUT", "/_config", config, "If-Match", "*", "Content-Type", "application/json")
+	}
+
+	e := expectError(t, put(`{"redirections": `), http.StatusBadRequest, CodeInvalidConfig)
+	if len(e.Details) != 1 || e.Details[0].Field != "" {
+		t.Errorf("syntax error details %+v", e.Details)
+	}
+	e = expectError(t, put(`{"redirections": {"/sale": {"to": 3}}}`), http.StatusBadRequest, CodeInvalidConfig)
+	expectField(t, e, "to")
+	e = expectError(t, put(`{"redirections": {"/sale": {"to": "/deals", "code": 200}}}`), http.StatusBadRequest, CodeInvalidConfig)
+	expectField(t, e, "redirections./sale")
+	e = expectError(t, put(`{"hosts": {"go.example.com": {"/sale": {"to": "/deals", "code": 200}}}}`), http.StatusBadRequest, CodeInvalidConfig)
+	expectField(t, e, "hosts.go.example.com./sale")
+	tr.expectRedirect("/a", http.StatusFound, "/b")
+
+	expectError(t, tr.do("PUT", "/_config", `{"redirections": {}}`, "If-Match", `"stale"`), http.StatusPreconditionFailed, "precondition_failed")
+}
+
+func TestRuleErrors(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {}}`)
+	e := expectError(t, tr.do("PUT", "/sale", `{"to": "/deals", "code": 200}`, "Content-Type", "application/json"), http.StatusBadRequest, CodeInvalidRule)
+	expectField(t, e, "redirections./sale")
+	expectError(t, tr.do("PUT", "/sale", `{"to": `, "Content-Type", "application/json"), http.StatusBadRequest, CodeInvalidRule)
+	expectError(t, tr.do("DELETE", "/sale", ""), http.StatusNotFound, "not_found")
+
+	e = expectError(t, tr.do("GET", "/_config/rules?limit=none", ""), http.StatusBadRequest, "bad_request")
+	expectField(t, e, "limit")
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {}}`)
+	previous := *maxBodySize
+	*maxBodySize = 16
+	t.Cleanup(func() { *maxBodySize = previous })
+	expectError(t, tr.do("PUT", "/long", "/"+strings.Repeat("x", 32)), http.StatusRequestEntityTooLarge, "request_entity_too_large")
+	tr.expectNotFound("/long")
+}
+
+func TestTooManyChanges(t *testing.T) {
+	var rules []string
+	for i := 0; i < 20; i++ {
+		rules = append(rules, fmt.Sprintf(`"/%d": "/old"`, i))
+	}
+	tr := newTestRedirector(t, `{"redirections": {`+strings.Join(rules, ",")+`}}`)
+	previous := *maxChange
+	*maxChange = 50
+	t.Cleanup(func() { *maxChange = previous })
+
+	expectError(t, tr.do("PUT", "/_config?mode=replace", `{"redirections": {"/0": "/old"}}`, "If-Match", "*"), http.StatusConflict, CodeTooManyChanges)
+	tr.expectRedirect("/19", http.StatusFound, "/old")
+	tr.expectStatus(tr.do("PUT", "/_config?mode=replace&force=true", `{"redirections": {"/0": "/old"}}`, "If-Match", "*"), http.StatusOK)
+	tr.expectNotFound("/19")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// Requests no redirection could be for, such as scans for ../../etc/passwd,
+// are turned away before they are matched, so garbage traffic is cheap and
+// stays out of the 404 statistics and the access log.
+var filterRequestsFlag *bool = flag.Bool("filter", true, "reject requests with path traversal, null bytes, overlong URLs, or unknown methods before matching them")
+
+// The longest request URL accepted.
+var maxURLLength *int = flag.Int("max-url-length", 2048, "longest request URL accepted, in bytes")
+
+// Why requests are filtered.
+const (
+	filteredMethod    = "method"
+	filteredLength    = "length"
+	filteredNull      = "null_byte"
+	filteredTraversal = "traversal"
+)
+
+// The number of requests filtered, by reason.
+var filteredCounts = map[string]*atomic.Int64{
+	filteredMethod:    new(atomic.Int64),
+	filteredLength:    new(atomic.Int64),
+	filteredNull:      new(atomic.Int64),
+	filteredTraversal: new(atomic.Int64),
+}
+
+// The methods any endpoint takes.
+var knownMethods = func() map[string]bool {
+	known := make(map[string]bool)
+	for _, method := range methodOrder {
+		known[method] = true
+	}
+	return known
+}()
+
+// Reject requests handler should never see.
+func filterRequests(handler http.Handler) http.Handler {
+	if !*filterRequestsFlag {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reason, status := filterReason(req)
+		if reason == "" {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		filteredCounts[reason].Add(1)
+		uri := req.RequestURI
+		if len(uri) > 100 {
+			uri = uri[:100] + "..."
+		}
+		log.Printf("%s filtered %s %s %q", realAddr(req), reason, req.Method, uri)
+		if reason == filteredMethod {
+			w.Header().Set("Allow", strings.Join(methodOrder, ", "))
+		}
+		w.Header().Set("Connection", "close")
+		http.Error(w, http.StatusText(status), status)
+	})
+}
+
+// Why req should be rejected, and with what status, or "" if it shouldn't.
+// Paths are decoded twice to catch doubly encoded dots and slashes.
+func filterReason(req *http.Request) (reason string, status int) {
+	if !knownMethods[req.Method] {
+		return filteredMethod, http.StatusMethodNotAllowed
+	}
+	if *maxURLLength > 0 && len(req.RequestURI) > *maxURLLength {
+		return filteredLength, http.StatusRequestURITooLong
+	}
+	path, _, _ := strings.Cut(req.RequestURI, "?")
+	for i := 0; i < 2; i++ {
+		if decoded, err := url.PathUnescape(path); err == nil {
+			path = decoded
+		}
+	}
+	if strings.ContainsRune(path, 0) || strings.Contains(req.URL.RawQuery, "%00") {
+		return filteredNull, http.StatusBadRequest
+	}
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return filteredTraversal, http.StatusBadRequest
+		}
+	}
+	return "", 0
+}
+
+// The number of requests filtered, by reason.
+func filteredReport() map[string]int64 {
+	report := make(map[string]int64, len(filteredCounts))
+	for reason, count := range filteredCounts {
+		report[reason] = count.Load()
+	}
+	return report
+}
@@ -7,15 +7,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
-	"io"
-	"io/ioutil"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // The host to listen on.
@@ -24,8 +32,9 @@ var host *string = flag.String("host", "localhost", "listen host")
 // The port to listen on.
 var port *int = flag.Int("port", 4404, "listen port")
 
-// The location of a JSON configuration file specifying the redirections.
-var configFile *string = flag.String("config", "config.json", "configuration file")
+// The location of a JSON configuration file specifying the redirections, or
+// a directory of them.
+var configFile *string = flag.String("config", "config.json", "configuration file or directory")
 
 // Configuration file format:
 //
@@ -33,153 +42,793 @@ var configFile *string = flag.String("config", "config.json", "configuration fil
 //   "redirections": {
 //     "source":"destination",
 //      "another source":"another destination",
+//      "grouped source":{"to":"destination","group":"name"},
+//      "shared source":{"to":"destination","preview":{"title":"...","image":"..."}},
+//      "proxied source":{"to":"https://host/destination","mode":"proxy","headers":{"name":"value"}},
+//      "moved source":{"to":"destination","code":301,"crawlers":"redirect|block|404"},
+//      "retired source":{"respond":{"status":410,"body":"Gone","content_type":"text/plain"}},
+//      "scheduled source":{"to":"destination","group":"name","start":"2026-11-27","end":"2026-12-01"},
 //      ...
-//   }
+//   },
+//   "groups": {
+//     "name": {"campaign":"Spring Sale","redirect_body":"<a href=\"{{.Destination}}\">{{.Campaign}}</a>","time_zone":"America/New_York"}
+//   },
+//   "redirect_body": "<a href=\"{{.Destination}}\">Moved</a>",
+//   "not_found_template": "404.html",
+//   "default_destination": "/",
+//   "default_code": 302,
+//   "bots": ["MyUptimeChecker"],
+//   "admin": {"allow": ["10.1.0.0/16", "2001:db8::/32"]}
 // }
+//
+// Everything but redirections is optional.
 
 // The redirection code to send to clients.
 var redirectionCode *int = flag.Int("code", 302, "redirection code")
 
+// Limits on how long clients may take, so slow or stalled ones don't hold
+// connections and goroutines. The write timeout must allow for -proxy-timeout.
+var readHeaderTimeout *time.Duration = flag.Duration("read-header-timeout", 10*time.Second, "time allowed to read request headers")
+var readTimeout *time.Duration = flag.Duration("read-timeout", 30*time.Second, "time allowed to read a whole request")
+var writeTimeout *time.Duration = flag.Duration("write-timeout", 60*time.Second, "time allowed to write a response")
+var idleTimeout *time.Duration = flag.Duration("idle-timeout", 120*time.Second, "time idle keep-alive connections are kept")
+
 // The configuration for the handlers includes the redirection code (e.g., 301) and
-// a mapping of /source to /destination redirections.
+// the live Config.
+//
+// mu guards the Config, which is only modified while also holding update.
+// Changes that can fail are made to a copy of the Config while holding update
+// alone, and swapped in under mu once they are complete.
+//
+// Each change also publishes a copy of the Config to live, which is never
+// changed afterwards, so requests for redirections look them up without
+// taking mu, however many there are and however often they change.
 type Redirector struct {
-	code         int
-	mu           sync.RWMutex
-	Redirections map[string]string `json:"redirections"`
+	code   int
+	mu     sync.RWMutex
+	update sync.Mutex
+	Config
+	live atomic.Pointer[Config]
+
+	// The clock everything the Redirector does runs on. It is also set in
+	// each configuration it loads, for their schedules and caches.
+	clock Clock
+
+	// When a configuration was last loaded successfully, the error from the
+	// last attempt to load one, and when the configuration last changed.
+	loaded   time.Time
+	loadErr  error
+	modified time.Time
+
+	// When each redirection last changed, or was first loaded.
+	ruleModified map[string]time.Time
+
+	// The version of the configuration last loaded from the file or the
+	// key-value store; later ones were changed through the API, and are lost
+	// on shutdown.
+	fileVersion int
+
+	stats *Stats
+
+	// Limits on admin requests per client, and on redirect lookups per
+	// client and in total. Nil limiters allow everything.
+	adminLimit, lookupLimit, globalLimit *Limiter
+
+	// Responses to mutating admin requests with an Idempotency-Key.
+	idempotency *idempotencyCache
+
+	// Where webhook events are sent from, and the rules' alert windows.
+	sink   *webhookSink
+	alerts *alertWindows
+
+	// Where changes made through the API are recorded, if anywhere.
+	audit *auditLog
+
+	// Where requests for redirections are logged, if anywhere.
+	access *accessLog
+
+	// The recent versions of the configuration, for rollback.
+	versions *configVersions
+
+	// The redirections deleted recently, for restoring.
+	trash *trash
+
+	// Debug taps on paths.
+	taps *tapSet
+
+	// The live stream of hits.
+	hits *hitStream
+
+	// The results of probing the server's own paths, if it does.
+	probes *prober
+
+	// The peers changes are replicated to, if any.
+	peers *peerSet
+
+	// The latest checks of the destinations, if they are checked.
+	checks *destinationChecker
+
+	// The hooks requests for redirections go through, built-in ones first.
+	hooks []Hook
 }
 
 // Create a new Redirector with a default code of StatusFound (302) and an empty redirections map.
 func NewRedirector() *Redirector {
-	return &Redirector{code: http.StatusFound, Redirections: make(map[string]string)}
+	return NewRedirectorWithClock(defaultClock)
 }
 
-// The remote address is either the client's address or X-Real-Ip, if set.
-// X-Real-Ip must be sent by the forwarding server to us.
-func realAddr(req *http.Request) (addr string) {
-	if headerAddr := req.Header.Get("X-Real-Ip"); headerAddr != "" {
-		return headerAddr
+// Create a new Redirector as NewRedirector does, running on c: its
+// schedules, statistics, rate limits, alert windows, and the expiry of its
+// taps, trash, idempotency keys, and cached responses go by c alone.
+func NewRedirectorWithClock(c Clock) *Redirector {
+	redir := &Redirector{
+		code:     http.StatusFound,
+		Config:   Config{Redirections: newRules(0), clock: c},
+		clock:    c,
+		stats:    NewStats(c),
+		sink:     newWebhookSink(context.Background()),
+		alerts:   newAlertWindows(c),
+		versions: newConfigVersions(c),
+		trash:    newTrash(c),
+		taps:     newTapSet(c),
+		hits:     newHitStream(),
 	}
-	return req.RemoteAddr
+	redir.idempotency = newIdempotencyCache(c, func(req *http.Request) string {
+		token, _ := redir.tokenFor(req)
+		return token.client(req)
+	})
+	redir.initHooks()
+	redir.live.Store(redir.Config.clone())
+	return redir
 }
 
-// A handler wrapped with onlyLocal will return http.StatusUnauthorized if the client
-// is not localhost. The upstream server must send X-Real-Ip to work properly.
-func onlyLocal(w http.ResponseWriter, req *http.Request, fn func()) {
-	addr := strings.SplitN(realAddr(req), ":", 2)[0]
-	switch addr {
-	case "localhost", "127.0.0.1":
+// A handler wrapped with onlyAdmin will return http.StatusUnauthorized if the client
+// is not allowed by -admin-allow or the configuration's admin section, and has no
+// token, and http.StatusForbidden if its token isn't an admin token. Anyone not
+// listed is denied. The upstream server must be a trusted proxy and send X-Real-Ip
+// or X-Forwarded-For to work properly.
+func (redir *Redirector) onlyAdmin(w http.ResponseWriter, req *http.Request, fn func()) {
+	redir.onlyScoped(w, req, func(token *apiToken) {
+		if token != nil {
+			log.Println(realAddr(req), "denied", req.Method, req.URL.Path, "to token", token.name)
+			writeError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
 		fn()
+	})
+}
+
+// Get will redirect the client if the path is found in the redirections map,
+// or matches a wildcard in it. Otherwise, a 404 is returned.
+func (redir *Redirector) Get(w http.ResponseWriter, req *http.Request) {
+	if err := injectFault(req.Context(), "lookup"); err != nil {
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if req.Context().Err() != nil {
+		// The client has already gone away.
+		return
+	}
+	if redir.beforeLookup(w, req) {
+		return
+	}
+	start := time.Now()
+	cw := &countingWriter{ResponseWriter: w}
+	w = cw
+
+	_, lookupSpan := startSpan(req.Context(), "lookup", spanInternal)
+	config := redir.live.Load()
+	rule, source, destination, ok := config.lookup(requestHost(req), req.URL.Path)
+	lookupSpan.set("foff.store", *storeFlag)
+	lookupSpan.set("foff.rule", source)
+	lookupSpan.finish()
+	policy := CrawlersRedirect
+	entry := &accessEntry{Rule: source, Bot: config.isBot(req.UserAgent())}
+	if ok {
+		if rule.script != nil {
+			destination = config.scriptDestination(source, rule, req, destination)
+		}
+		match := &Match{Source: source, Rule: rule, Destination: destination}
+		redir.afterMatch(w, req, match)
+		destination = match.Destination
+		policy, entry.loc = rule.agentPolicy(req.UserAgent()), config.location(rule)
+		entry.Group, entry.Owner = rule.Group, rule.Owner
+		if group := config.Groups[rule.Group]; group != nil {
+			entry.Campaign = group.Campaign
+		}
+		if rule.Alert != nil && redir.alerts.hit(source, rule.Alert) {
+			log.Println(source, "reached", rule.Alert.Hits, "hits within", rule.Alert.Window)
+			redir.sink.Send(config.Webhooks, &Event{Type: EventThreshold, Time: redir.Clock().Now(), Source: source,
+				Rule: rule, Hits: rule.Alert.Hits, Window: rule.Alert.Window})
+		}
+	}
+	capture := redir.taps.capture(req, req.URL.Path, func() []string { return config.trace(requestHost(req), req.URL.Path, req.UserAgent()) })
+	defer func() {
+		entry.finish(req, cw, start)
+		redir.afterResponse(req, entry)
+		if s := spanFrom(req.Context()); s != nil {
+			s.set("foff.rule", source)
+			s.set("foff.action", entry.Action)
+			s.set("foff.destination", entry.Destination)
+		}
+		redir.taps.finish(capture, cw.status, entry)
+	}()
+
+	hp := config.Maintenance.holding(req.URL.Path)
+	canonical := config.canonicalFor(rule)
+	origin := canonical.origin(req)
+	switch {
+	case hp != nil:
+		entry.Action = ActionMaintenance
+		serveHolding(w, req, hp)
+	case origin != "" && !(ok && canonical.BeforeLookup && rule.proxy == nil && rule.Respond == nil && destination != "" && policy == CrawlersRedirect):
+		entry.Action, entry.Destination = ActionCanonical, origin+req.URL.RequestURI()
+		log.Println(realAddr(req), "redirected", req.Host+req.URL.Path, "to canonical", entry.Destination)
+		http.Redirect(w, req, entry.Destination, canonical.code())
+	case ok && rule.proxy != nil && policy == CrawlersRedirect:
+		entry.Action, entry.Destination = ActionProxy, destination
+		entry.Cache = serveProxy(w, req, rule)
+	case !ok && redir.onNotFound(w, req):
+		entry.Action = ActionHook
+	case !ok && config.Upstream != nil:
+		entry.Action, entry.Destination = ActionUpstream, config.Upstream.URL
+		config.Upstream.serve(w, req)
+	case !ok:
+		entry.Action = ActionNotFound
+		if config.DefaultDestination != "" {
+			entry.Action, entry.Destination = ActionRedirect, config.DefaultDestination
+		}
+		config.NotFound(w, req, redir.code)
+	case rule.Respond != nil:
+		entry.Action = ActionRespond
+		serveRespond(w, req, rule.Respond)
+	case destination == "":
+		// A script computed no destination, and the rule has no other.
+		entry.Action = ActionNotFound
+		config.notFoundPage(w, req)
+	case policy == "preview":
+		entry.Action = ActionPreview
+		servePreview(w, req, rule.Preview, destination)
+	case policy == CrawlersBlock:
+		entry.Action = ActionBlock
+		serveBlocked(w, req)
+	case policy == CrawlersNotFound:
+		entry.Action = ActionNotFound
+		config.notFoundPage(w, req)
+	case *suppressBroken && redir.checks.broken(destination):
+		entry.Action = ActionNotFound
+		log.Println(realAddr(req), "did not redirect", req.URL.Path, "to broken", destination)
+		config.notFoundPage(w, req)
 	default:
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		code := rule.Code
+		if code == 0 {
+			code = redir.code
+		}
+		destination = config.addTracking(destination, req.URL.Path, source, rule)
+		if origin != "" && strings.HasPrefix(destination, "/") {
+			// Sent before the canonical redirect, so make it canonical.
+			destination = origin + destination
+		}
+		entry.Action, entry.Destination = ActionRedirect, destination
+		if !entry.Bot || *logBots {
+			log.Println(realAddr(req), "redirected from", req.URL.Path, "to", destination)
+		}
+		config.redirect(w, req, destination, code, config.Groups[rule.Group])
 	}
 }
 
-// Get will redirect the client if the path is found in the redirections map.
-// Otherwise, a 404 is returned.
-func (redir *Redirector) Get(w http.ResponseWriter, req *http.Request) {
-	redir.mu.RLock()
-	defer redir.mu.RUnlock()
+// NotFound handles a path without a redirection. If a default destination is
+// configured, the client is redirected there with its code, or else code.
+// Otherwise, the custom 404 template is rendered, falling back to a plain 404.
+func (config *Config) NotFound(w http.ResponseWriter, req *http.Request, code int) {
+	if config.DefaultDestination != "" {
+		if config.DefaultCode != 0 {
+			code = config.DefaultCode
+		}
+		log.Println(realAddr(req), "redirected unmatched", req.URL.Path, "to", config.DefaultDestination)
+		config.redirect(w, req, config.DefaultDestination, code, nil)
+		return
+	}
+	config.notFoundPage(w, req)
+}
 
-	if destination, ok := redir.Redirections[req.URL.Path]; ok {
-		log.Println(realAddr(req), "redirected from", req.URL.Path, "to", destination)
-		http.Redirect(w, req, destination, redir.code)
-	} else {
-		log.Println(realAddr(req), "sent 404 for", req.URL.Path)
+// Send a 404, rendering the custom 404 template if there is one.
+func (config *Config) notFoundPage(w http.ResponseWriter, req *http.Request) {
+	log.Println(realAddr(req), "sent 404 for", req.URL.Path)
+	if config.notFound == nil {
 		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if err := config.notFound.Execute(w, req.URL); err != nil {
+		log.Println("NotFound template:", err)
 	}
 }
 
-// Put will add a redirection from the PUT path to the path specified in the
-// request's data.
+// Send the client to destination. The body is rendered from the group's
+// redirect template, or the default one, when configured.
+func (config *Config) redirect(w http.ResponseWriter, req *http.Request, destination string, code int, group *Group) {
+	body, data := config.body, redirectData{Path: req.URL.Path, Destination: destination, Code: code}
+	if group != nil {
+		data.Campaign = group.Campaign
+		if group.body != nil {
+			body = group.body
+		}
+	}
+	if body == nil || req.Method != "GET" {
+		http.Redirect(w, req, destination, code)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if err := body.Execute(buf, data); err != nil {
+		log.Println("Redirect template:", err)
+		http.Redirect(w, req, destination, code)
+		return
+	}
+	w.Header().Set("Location", destination)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	buf.WriteTo(w)
+}
+
+// Put adds or replaces the redirection from the PUT path.
 func (redir *Redirector) Put(w http.ResponseWriter, req *http.Request) {
+	redir.putRule(w, req, req.URL.Path)
+}
+
+// Add or replace the redirection from source with the rule in the request's
+// body: JSON with a Content-Type of application/json, such as
+// {"to": "/new", "code": 301} or just "/new", or else the destination as
+// plain text, or as curl -d sends it. The stored rule is sent back, with 201
+// if it is new.
+func (redir *Redirector) putRule(w http.ResponseWriter, req *http.Request, source string) {
+	mediaType, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	switch mediaType {
+	case "", "application/json", "text/plain", "application/x-www-form-urlencoded":
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, "Unsupported rule type "+strconv.Quote(mediaType)+"; send JSON or plain text")
+		return
+	}
+	body, ok := readBody(w, req)
+	if !ok {
+		return
+	}
+	rule := &Rule{To: string(body)}
+	if mediaType == "application/json" {
+		rule = new(Rule)
+		if err := json.Unmarshal(body, rule); err != nil {
+			(&APIError{http.StatusBadRequest, CodeInvalidRule, "Error decoding rule: " + err.Error(), configErrorDetails(err)}).write(w)
+			return
+		}
+	}
+
+	token, _ := redir.tokenFor(req)
+	if err := token.allowsRule(rule); err != nil {
+		log.Println(realAddr(req), "denied", req.Method, source+":", err)
+		writeError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+		return
+	}
+	old, err := redir.SetRule(source, rule, token.client(req))
+	if err != nil {
+		(&APIError{http.StatusBadRequest, CodeInvalidRule, "Bad rule: " + err.Error(), configErrorDetails(err)}).write(w)
+		return
+	}
+	source = pathKey(source)
+	log.Println(realAddr(req), "set redirection from", source, "to", rule.To)
+	redir.notify(redir.ruleEvent(req, source, old, rule))
+
+	status := http.StatusOK
+	if old == nil {
+		status = http.StatusCreated
+	}
+	redir.writeRule(w, source, status)
+}
+
+// SetRule adds or replaces the redirection from source, after compiling the
+// rule against the live configuration, returning the rule it replaced, if
+// any. A rule without a creator is stamped as created by client now, or when
+// the rule it replaces was.
+func (redir *Redirector) SetRule(source string, rule *Rule, client string) (old *Rule, err error) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
 	redir.mu.Lock()
 	defer redir.mu.Unlock()
 
-	// TODO: Require authorization to change redirections
-	buf := new(bytes.Buffer)
-	io.Copy(buf, req.Body)
-	destination := buf.String()
+	source = pathKey(source)
+	if err = redir.compileRule(source, rule); err != nil {
+		return nil, err
+	}
+	old, _ = redir.Redirections.Get(source)
+	if rule.CreatedBy == "" && rule.CreatedAt.IsZero() {
+		rule.CreatedBy, rule.CreatedAt = client, redir.Clock().Now().UTC().Truncate(time.Second)
+		if old != nil {
+			rule.CreatedBy, rule.CreatedAt = old.CreatedBy, old.CreatedAt
+		}
+	}
+	redir.Redirections.Set(source, rule)
+	if isWildcard(source) {
+		wildcards, err := compileWildcards(redir.Redirections)
+		if err != nil {
+			if old != nil {
+				redir.Redirections.Set(source, old)
+			} else {
+				redir.Redirections.Delete(source)
+			}
+			return nil, err
+		}
+		redir.wildcards = wildcards
+	}
+	redir.changed("set " + source)
+	redir.peers.replicate(source, rule)
+	return old, nil
+}
 
-	redir.Redirections[req.URL.Path] = destination
-	log.Println(realAddr(req), "added redirection from", req.URL.Path, "to", destination)
+// Send the redirection from source as /_config/rules lists it.
+func (redir *Redirector) writeRule(w http.ResponseWriter, source string, status int) {
+	redir.mu.RLock()
+	rule, ok := redir.Redirections.Get(source)
+	modified := redir.ruleModified[source]
+	redir.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No redirection for "+source)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(ruleListing{source, rule, redir.stats.ruleHits(source), modified})
 }
 
-// Delete removes the redirection at the specified path.
+// AddRedirection adds or replaces the redirection from source, returning the
+// rule it replaced, if any. The rule is not compiled, so it may only have a
+// destination.
+func (redir *Redirector) AddRedirection(source string, rule *Rule) (old *Rule) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+
+	source = pathKey(source)
+	old, _ = redir.Redirections.Get(source)
+	redir.Redirections.Set(source, rule)
+	if isWildcard(source) {
+		// Only proxied rules can fail to compile as wildcards, and those
+		// are never added this way.
+		redir.wildcards, _ = compileWildcards(redir.Redirections)
+	}
+	redir.changed("set " + source)
+	redir.peers.replicate(source, rule)
+	return
+}
+
+// Delete removes the redirection at the DELETE path.
 func (redir *Redirector) Delete(w http.ResponseWriter, req *http.Request) {
+	redir.deleteRule(w, req, req.URL.Path)
+}
+
+// Remove the redirection from source, answering 204, or 404 if there is
+// none. The redirection goes to the trash, from which it can be restored.
+func (redir *Redirector) deleteRule(w http.ResponseWriter, req *http.Request, source string) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
 	redir.mu.Lock()
 	defer redir.mu.Unlock()
 
-	// TODO: Require authorization to delete redirections
-	delete(redir.Redirections, req.URL.Path)
-	log.Println(realAddr(req), "removed redirection for", req.URL.Path)
+	source = pathKey(source)
+	old, ok := redir.Redirections.Get(source)
+	redir.Redirections.Delete(source)
+	if ok && isWildcard(source) {
+		redir.wildcards, _ = compileWildcards(redir.Redirections)
+	}
+	if ok {
+		token, _ := redir.tokenFor(req)
+		redir.trash.add(source, old, token.client(req))
+		log.Println(realAddr(req), "removed redirection for", source)
+		redir.changed("delete " + source)
+		redir.emit(redir.ruleEvent(req, source, old, nil))
+	}
+	// Replicated even if this node didn't have it, as a peer may.
+	redir.peers.replicate(source, nil)
+	if !ok {
+		writeError(w, http.StatusNotFound, "No redirection for "+source)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (redir *Redirector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
-	case "GET":
-		redir.Get(w, req)
+	case "GET", "HEAD":
+		if allowRequest(w, req, redir.globalLimit, redir.lookupLimit) {
+			redir.Get(w, req)
+		}
 	case "PUT":
-		onlyLocal(w, req, func() { redir.Put(w, req) })
+		if allowRequest(w, req, nil, redir.adminLimit) {
+			redir.onlyRule(w, req, req.URL.Path, func() { redir.idempotency.serve(w, req, redir.Put) })
+		}
 	case "DELETE":
-		onlyLocal(w, req, func() { redir.Delete(w, req) })
+		if allowRequest(w, req, nil, redir.adminLimit) {
+			redir.onlyRule(w, req, req.URL.Path, func() { redir.idempotency.serve(w, req, redir.Delete) })
+		}
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-// Use the specified JSON configuration to configure the Redirector.
+// Use the specified JSON configuration to configure the Redirector, merging it
+// into the current configuration.
 func (redir *Redirector) LoadConfig(config []byte) (err error) {
+	_, err = redir.ApplyConfig(config, false, "", true)
+	return
+}
+
+// Apply the specified JSON configuration, either merging it into the current
+// configuration or replacing it entirely, and report how the redirections
+// changed. The configuration is decoded, checked, and compiled on a copy of
+// the live one, which is only replaced if all of that succeeds. If ifMatch is
+// not empty, the live configuration must match it (see configMatches).
+// Unless forced, a configuration that would update or remove more of the
+// redirections than -max-change allows is refused (see checkChangeRate).
+//
+// The changes aren't sent to the peers, which load their own configuration
+// files; configurations PUT through the API are (see SetConfig).
+func (redir *Redirector) ApplyConfig(config []byte, replace bool, ifMatch string, force bool) (changes ConfigChanges, err error) {
+	return redir.applyConfig(config, replace, ifMatch, force, false)
+}
+
+// Apply a configuration as ApplyConfig does, sending the redirections it
+// changed to the peers if replicate is set.
+func (redir *Redirector) applyConfig(config []byte, replace bool, ifMatch string, force, replicate bool) (changes ConfigChanges, err error) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+
+	if ifMatch != "" && !redir.configMatches(ifMatch) {
+		return changes, errPreconditionFailed
+	}
+
+	candidate := &Config{Redirections: newRules(0), clock: redir.clock}
+	if !replace {
+		candidate = redir.Config.clone()
+	}
+	if err = injectFault(context.Background(), "config"); err == nil {
+		err = json.Unmarshal(config, candidate)
+	}
+	if err == nil {
+		err = candidate.compile()
+	}
+
 	redir.mu.Lock()
 	defer redir.mu.Unlock()
 
-	err = json.Unmarshal(config, redir)
-	log.Printf("%d redirections loaded\n", len(redir.Redirections))
+	redir.loadErr = err
+	if err != nil {
+		return
+	}
+	changes = diffRedirections(redir.Redirections, candidate.Redirections)
+	if err = checkChangeRate(changes, redir.Redirections.Len(), force); err != nil {
+		return
+	}
+	redir.Config = *candidate
+	redir.loaded = redir.Clock().Now()
+	if replace {
+		redir.changed("replace config")
+	} else {
+		redir.changed("merge config")
+	}
+	if replicate {
+		redir.replicateChanges(changes)
+	}
+	log.Printf("%d redirections loaded\n", redir.Redirections.Len())
 	return
 }
 
-// Read the JSON configuration from a file to configure the Redirector.
+// Read the configuration from a file, in JSON, YAML, or TOML, or from a
+// directory of them, to configure the Redirector.
 func (redir *Redirector) LoadConfigFile(config string) (err error) {
-	bytes, err := ioutil.ReadFile(config)
+	_, s := startSpan(context.Background(), "config.load", spanInternal)
+	s.set("foff.config", config)
+	defer func() {
+		if err != nil {
+			s.fail(err.Error())
+		}
+		s.finish()
+	}()
+	bytes, err := readConfig(config)
 	if err != nil {
 		return
 	}
-	err = redir.LoadConfig(bytes)
+	if err = redir.LoadConfig(bytes); err == nil {
+		redir.markLoaded()
+	}
 	return
 }
 
+// Note that the live configuration is the one in the file or the key-value
+// store, without changes made through the API.
+func (redir *Redirector) markLoaded() {
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+	redir.fileVersion = redir.versions.latest()
+}
+
+// The live configuration as JSON. The caller must hold one of the locks.
+func (redir *Redirector) encodeConfig() ([]byte, error) {
+	// Templates in the configuration are HTML, so leave it unescaped.
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(redir)
+	return buf.Bytes(), err
+}
+
+// The entity tag of the configuration as encoded by encodeConfig.
+func configETag(config []byte) string {
+	sum := sha256.Sum256(config)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+var errPreconditionFailed = errors.New("configuration has changed")
+
+// Report whether the live configuration matches an If-Match header: "*", or
+// a list of entity tags, one of which is the configuration's. The caller must
+// hold one of the locks.
+func (redir *Redirector) configMatches(ifMatch string) bool {
+	if strings.TrimSpace(ifMatch) == "*" {
+		return true
+	}
+	config, err := redir.encodeConfig()
+	if err != nil {
+		return false
+	}
+	etag := strings.Trim(configETag(config), `"`)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag, _, _ = strings.Cut(strings.Trim(strings.TrimSpace(tag), `"`), "-")
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // GETting the config supplies the client with a JSON formatted configuration
-// suitable for storing as the configuration file.
+// suitable for storing as the configuration file, or a YAML or TOML one with
+// the format query parameter. It carries an ETag to send back in If-Match
+// when PUTting a modified configuration, and a Last-Modified for conditional
+// GETs.
 func (redir *Redirector) GetConfig(w http.ResponseWriter, req *http.Request) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = FormatJSON
+	}
+
 	redir.mu.RLock()
-	defer redir.mu.RUnlock()
+	config, err := redir.encodeConfig()
+	modified := redir.modified
+	redir.mu.RUnlock()
 
-	jsonConfig, err := json.MarshalIndent(redir, "", "  ")
 	if err != nil {
-		http.Error(w, "Error encoding JSON config", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Error encoding JSON config")
+		return
+	}
+	etag := configETag(config)
+	if config, err = configFromJSON(config, format); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	io.WriteString(w, string(jsonConfig))
+	if format != FormatJSON {
+		// Each format is its own representation, with its own tag.
+		etag = strings.TrimSuffix(etag, `"`) + "-" + format + `"`
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", formatContentType(format))
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, req, "config."+format, modified, bytes.NewReader(config))
 }
 
 // Set the Redirector configuration from the JSON supplied in the PUT
-// request's data.
+// request's data. The mode query parameter or X-Config-Mode header selects
+// whether the configuration is merged into the current one ("merge", the
+// default) or replaces it ("replace"). The response reports how many
+// redirections were added, updated, and removed.
+//
+// The configuration may be sent as YAML or TOML with a Content-Type of
+// application/yaml or application/toml.
+//
+// The request must have an If-Match header with the ETag of the configuration
+// it was based on, or "*", so that concurrent edits don't clobber each other.
+//
+// The redirections it changes are sent to the peers; its other settings
+// aren't.
 func (redir *Redirector) SetConfig(w http.ResponseWriter, req *http.Request) {
-	buf := new(bytes.Buffer)
-	io.Copy(buf, req.Body)
-	err := redir.LoadConfig(buf.Bytes())
+	ifMatch := req.Header.Get("If-Match")
+	if ifMatch == "" {
+		writeError(w, http.StatusPreconditionRequired, "If-Match required")
+		return
+	}
+	mode := req.URL.Query().Get("mode")
+	if mode == "" {
+		mode = req.Header.Get("X-Config-Mode")
+	}
+	if mode == "" {
+		mode = ConfigMerge
+	}
+	if mode != ConfigMerge && mode != ConfigReplace {
+		writeError(w, http.StatusBadRequest, "Unknown config mode "+strconv.Quote(mode))
+		return
+	}
+
+	if contentType := req.Header.Get("Content-Type"); contentType != "" && !configContentType(contentType) {
+		writeError(w, http.StatusUnsupportedMediaType, "Unsupported configuration type "+strconv.Quote(contentType)+"; send JSON, YAML, or TOML")
+		return
+	}
+	body, ok := readBody(w, req)
+	if !ok {
+		return
+	}
+	if err := verifyConfig(body, req.Header.Get(signatureHeader)); err != nil {
+		log.Println(realAddr(req), "rejected config:", err)
+		writeError(w, http.StatusForbidden, "Configuration must be signed: "+err.Error())
+		return
+	}
+	config, err := configToJSON(body, contentFormat(req.Header.Get("Content-Type")))
 	if err != nil {
-		http.Error(w, "Error decoding JSON config", http.StatusInternalServerError)
+		(&APIError{http.StatusBadRequest, CodeInvalidConfig, "Error decoding config: " + err.Error(), configErrorDetails(err)}).write(w)
+		return
+	}
+	changes, err := redir.applyConfig(config, mode == ConfigReplace, ifMatch, forced(req), true)
+	if err == errPreconditionFailed {
+		writeError(w, http.StatusPreconditionFailed, "Configuration has changed")
+		return
+	}
+	if _, ok := err.(*tooManyChanges); ok {
+		refuseChange(w, err)
 		return
 	}
-	io.WriteString(w, "Configuration successfully loaded.\n")
+	if err != nil {
+		(&APIError{http.StatusBadRequest, CodeInvalidConfig, "Bad configuration: " + err.Error(), configErrorDetails(err)}).write(w)
+		return
+	}
+	changes.Mode = mode
+	log.Println(realAddr(req), mode, "config:", changes.Added, "added,", changes.Updated, "updated,", changes.Removed, "removed")
+	redir.notify(&Event{Type: EventConfigApplied, Time: redir.Clock().Now(), Client: realAddr(req), Changes: &changes})
+	for _, change := range changes.changed {
+		redir.audit.Record(redir.ruleEvent(req, change.source, change.old, change.new))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&changes)
 }
 
-// When deleted, the Redirector configuration is emptied.
+// When deleted, the Redirector configuration is emptied. An If-Match header
+// is honored if present.
 func (redir *Redirector) DeleteConfig(w http.ResponseWriter, req *http.Request) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
 	redir.mu.Lock()
 	defer redir.mu.Unlock()
 
-	redir.Redirections = make(map[string]string)
+	if ifMatch := req.Header.Get("If-Match"); ifMatch != "" && !redir.configMatches(ifMatch) {
+		writeError(w, http.StatusPreconditionFailed, "Configuration has changed")
+		return
+	}
+
+	changes := diffRedirections(redir.Redirections, Rules{})
+	if err := checkChangeRate(changes, redir.Redirections.Len(), forced(req)); err != nil {
+		refuseChange(w, err)
+		return
+	}
+	redir.Redirections = newRules(0)
+	redir.wildcards = nil
+	redir.changed("clear config")
+	redir.replicateChanges(changes)
+	redir.emit(&Event{Type: EventConfigCleared, Time: redir.Clock().Now(), Client: realAddr(req), Changes: &changes})
+	for _, change := range changes.changed {
+		redir.audit.Record(redir.ruleEvent(req, change.source, change.old, nil))
+	}
 }
 
 // The ConfigHandler handles retrieving the Redirector configuration (GET) and
@@ -187,38 +836,273 @@ func (redir *Redirector) DeleteConfig(w http.ResponseWriter, req *http.Request)
 func (redir *Redirector) ConfigHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		log.Println(realAddr(req), req.Method, req.URL.Path)
-		onlyLocal(w, req,
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req,
 			func() {
 				switch req.Method {
-				case "GET":
+				case "GET", "HEAD":
 					redir.GetConfig(w, req)
 				case "PUT":
-					redir.SetConfig(w, req)
+					redir.idempotency.serve(w, req, redir.SetConfig)
 				case "DELETE":
-					redir.DeleteConfig(w, req)
+					redir.idempotency.serve(w, req, redir.DeleteConfig)
 				default:
-					http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+					writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 				}
 			})
 	}
 }
 
+// The handlers for the public listeners, and for the admin listeners, which
+// are the same unless -admin-listen is set.
+func (redir *Redirector) routes() (public, admin *http.ServeMux) {
+	if *minimal {
+		public = redir.minimalRoutes()
+		return public, public
+	}
+	public = http.NewServeMux()
+	admin = public
+	if *adminListen != "" {
+		admin = http.NewServeMux()
+		admin.HandleFunc("/_health", allowMethods(redir.HealthHandler(), "GET"))
+		admin.HandleFunc("/_ready", allowMethods(redir.ReadyHandler(), "GET"))
+		// Only lookups, not changes to the redirections, but anything for
+		// the upstream.
+		public.HandleFunc("/", redir.passThrough(allowMethods(redir.ServeHTTP, "GET", "HEAD")))
+	}
+	admin.HandleFunc("/", allowMethods(redir.ServeHTTP, "GET", "HEAD", "PUT", "DELETE"))
+	admin.HandleFunc("/_config", allowMethods(redir.ConfigHandler(), "GET", "PUT", "DELETE"))
+	admin.HandleFunc("/_config/", allowMethods(redir.VersionsHandler(), "GET", "POST"))
+	admin.HandleFunc("/_config/rules", allowMethods(redir.RulesHandler(), "GET"))
+	admin.HandleFunc("/_config/rules/", allowMethods(redir.RuleHandler(), "GET", "PUT", "DELETE"))
+	admin.HandleFunc("/_config/trash", allowMethods(redir.TrashHandler(), "GET", "DELETE"))
+	admin.HandleFunc("/_config/trash/", allowMethods(redir.TrashHandler(), "POST", "DELETE"))
+	admin.HandleFunc("/_stats", allowMethods(redir.StatsHandler(), "GET"))
+	admin.HandleFunc("/_stats/", allowMethods(redir.RuleStatsHandler(), "GET"))
+	admin.HandleFunc("/_stats/404s", allowMethods(redir.MissesHandler(), "GET", "POST"))
+	admin.HandleFunc("/_stats/stream", allowMethods(redir.StreamHandler(), "GET"))
+	admin.HandleFunc("/_stats/404s/proposals", allowMethods(redir.ProposalsHandler(), "GET"))
+	admin.HandleFunc("/_stats/broken", allowMethods(redir.BrokenHandler(), "GET"))
+	admin.HandleFunc("/_stats/export", allowMethods(redir.StatsExportHandler(), "GET"))
+	admin.HandleFunc("/_admin", allowMethods(redir.AdminHandler(), "GET"))
+	admin.HandleFunc("/_audit", allowMethods(redir.AuditHandler(), "GET"))
+	admin.HandleFunc("/_owners", allowMethods(redir.OwnersHandler(), "GET"))
+	admin.HandleFunc("/_profile", allowMethods(redir.ProfileHandler(), "GET", "PUT", "DELETE"))
+	admin.HandleFunc("/_owners/", allowMethods(redir.OwnersHandler(), "POST"))
+	admin.HandleFunc("/_status", allowMethods(redir.StatusHandler(), "GET"))
+	admin.HandleFunc("/_resolve", allowMethods(redir.ResolveHandler(), "GET"))
+	admin.HandleFunc("/_rewrite", allowMethods(redir.RewriteHandler(), "POST"))
+	admin.HandleFunc("/_suggest", allowMethods(redir.SuggestHandler(), "POST"))
+	admin.HandleFunc("/_shorten", allowMethods(redir.ShortenHandler(), "POST"))
+	admin.HandleFunc("/_qr", allowMethods(redir.QRHandler(), "GET"))
+	admin.HandleFunc("/_tap", allowMethods(redir.TapHandler(), "GET", "POST", "DELETE"))
+	admin.HandleFunc("/_clock", allowMethods(redir.ClockHandler(), "GET", "POST"))
+	admin.HandleFunc("/_lint", allowMethods(redir.LintHandler(), "GET"))
+	admin.HandleFunc("/_peer", allowMethods(redir.PeerHandler(), "POST"))
+	admin.HandleFunc("/_maintenance", allowMethods(redir.MaintenanceHandler(), "GET", "PUT", "DELETE"))
+	public.HandleFunc("/_health", allowMethods(redir.HealthHandler(), "GET"))
+	public.HandleFunc("/_ready", allowMethods(redir.ReadyHandler(), "GET"))
+	public.HandleFunc("/_sitemap.xml", allowMethods(redir.SitemapHandler(), "GET", "HEAD"))
+	public.HandleFunc("/_sitemap.txt", allowMethods(redir.SitemapHandler(), "GET", "HEAD"))
+	if *acmeWebroot != "" {
+		public.HandleFunc("/.well-known/acme-challenge/", allowMethods(acmeChallenges(*acmeWebroot).ServeHTTP, "GET"))
+	}
+	if *chaosMode {
+		admin.HandleFunc("/_chaos", allowMethods(redir.ChaosHandler(), "GET", "DELETE"))
+		admin.HandleFunc("/_chaos/", allowMethods(redir.ChaosHandler(), "PUT", "DELETE"))
+	}
+	if *updateCheck {
+		admin.HandleFunc("/_update", allowMethods(redir.UpdateHandler(), "GET"))
+	}
+	if *pprofEnabled {
+		redir.handlePprof(admin)
+	}
+	return
+}
+
 func main() {
-	flag.Parse()
-	addr := *host + ":" + strconv.Itoa(*port)
+	command, args, err := parseCommand()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := applyEnv(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if err := applyPort(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	addrs, err := endpoints(net.JoinHostPort(strings.Trim(*host, "[]"), strconv.Itoa(*port)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	trustedProxies, err = parsePrefixes(*trustedProxiesFlag)
+	if err != nil {
+		log.Fatal("trusted-proxies: ", err)
+	}
+	adminAllow, err = parsePrefixes(*adminAllowFlag)
+	if err != nil {
+		log.Fatal("admin-allow: ", err)
+	}
+	if cors, err = parseCORS(); err != nil {
+		log.Fatal("cors-origins: ", err)
+	}
+
+	if err = checkStore(*storeFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err = checkSlugs(*slugLength, *slugAlphabet); err != nil {
+		log.Fatal(err)
+	}
+	if err = checkDeterminism(); err != nil {
+		log.Fatal("fake-time: ", err)
+	}
+	signingKeys, err = parseConfigKeys(*configKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if command != "serve" {
+		os.Exit(runCommand(command, args))
+	}
+	if err = setupLogging(); err != nil {
+		log.Fatal("log-format: ", err)
+	}
+	if os.Geteuid() == 0 {
+		log.Println("running as root; in a container, run as an unprivileged user instead")
+	}
+
+	inFlight = newGate(*maxRequests)
+	background = newGate(*maxBackground)
+	startTracing()
 
 	redirector := NewRedirector()
 	redirector.code = *redirectionCode
+	redirector.adminLimit = NewLimiter(*adminRate, *adminBurst, redirector.Clock())
+	if redirector.peers, err = newPeerSet(redirector.Clock()); err != nil {
+		log.Fatal("peers: ", err)
+	}
+	redirector.lookupLimit = NewLimiter(*lookupRate, *lookupBurst, redirector.Clock())
+	redirector.globalLimit = NewLimiter(*globalRate, *globalBurst, redirector.Clock())
+	if *auditLogFile != "" {
+		if redirector.audit, err = openAuditLog(*auditLogFile); err != nil {
+			log.Fatal("audit-log: ", err)
+		}
+	}
+	if *accessLogFile != "" {
+		if redirector.access, err = openAccessLog(*accessLogFile); err != nil {
+			log.Fatal("access-log: ", err)
+		}
+	}
 
-	err := redirector.LoadConfigFile(*configFile)
-	if err != nil {
-		log.Fatal("LoadConfigFile: ", err)
+	if *kvStore != "" {
+		kv, err := newKVBackend()
+		if err != nil {
+			log.Fatal("kv-store: ", err)
+		}
+		index, digest, err := redirector.loadKV(kv)
+		if err != nil {
+			log.Fatal("kv-store: ", err)
+		}
+		go redirector.watchKV(kv, index, digest)
+	} else {
+		err = redirector.LoadConfigFile(*configFile)
+		if err != nil {
+			log.Fatal("LoadConfigFile: ", err)
+		}
+		reloadOnHangup(redirector, *configFile)
+		if *watchConfig {
+			go redirector.watch(*configFile, *watchInterval)
+		}
+	}
+	if *warmup {
+		paths, err := readWarmupPaths(*warmupPaths)
+		if err != nil {
+			log.Fatal("warmup-paths: ", err)
+		}
+		warming.Store(true)
+		go func() {
+			redirector.Warmup(paths)
+			warming.Store(false)
+		}()
+	}
+
+	if *checkInterval > 0 {
+		redirector.checks = newDestinationChecker(redirector.Clock())
+		go redirector.checkDestinations(*checkInterval)
 	}
 
-	http.Handle("/", redirector)
-	http.HandleFunc("/_config", redirector.ConfigHandler())
-	err = http.ListenAndServe(addr, nil)
+	public, admin := redirector.routes()
+	if *chaosMode {
+		log.Println("fault injection is enabled at /_chaos")
+	}
+
+	listeners, err := listen(endpointAddrs(addrs))
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Fatal("Listen: ", err)
+	}
+	if err = writePidFile(); err != nil {
+		log.Fatal("pidfile: ", err)
+	}
+	var tlsConfig *tls.Config
+	if *tlsListen != "" {
+		if tlsConfig, err = loadCertificates(); err != nil {
+			log.Fatal("certificates: ", err)
+		}
+	}
+	servers := make([]*http.Server, len(listeners))
+	for i := range servers {
+		handler := http.Handler(public)
+		if addrs[i].admin || admin == public {
+			handler = allowCORS(admin)
+		}
+		servers[i] = &http.Server{
+			Handler:           filterRequests(traceRequests(limitInFlight(limitBodies(handler)))),
+			ReadHeaderTimeout: *readHeaderTimeout,
+			ReadTimeout:       *readTimeout,
+			WriteTimeout:      *writeTimeout,
+			IdleTimeout:       *idleTimeout,
+		}
+		if addrs[i].tls {
+			servers[i].TLSConfig = tlsConfig
+		}
+	}
+	if *printConfig {
+		names := make([]string, len(listeners))
+		for i, listener := range listeners {
+			names[i] = listener.Addr().String()
+		}
+		redirector.printStartupSummary(names)
+	}
+	restarted := restartOnSignal(servers, listeners)
+	stopped := stopOnSignal(servers)
+	errs := make(chan error, len(servers))
+	for i, server := range servers {
+		listener, e := listeners[i], addrs[i]
+		log.Println("fourohfourfound", version, "listening on", listener.Addr(), e.kind())
+		go func() {
+			if e.tls {
+				errs <- server.ServeTLS(listener, "", "")
+			} else {
+				errs <- server.Serve(listener)
+			}
+		}()
+	}
+	if *probePaths != "" {
+		redirector.probes = newProber()
+		go redirector.probe(probeTargets(addrs, listeners), parseProbePaths(*probePaths), *probeInterval)
+	}
+	for range servers {
+		if err := <-errs; err != http.ErrServerClosed {
+			log.Fatal("Serve: ", err)
+		}
+	}
+	select {
+	case <-restarted:
+		redirector.shutdown("restart")
+	case sig := <-stopped:
+		redirector.shutdown(sig)
 	}
 }
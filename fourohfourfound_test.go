@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {
+		"/source": "/destination",
+		"/moved": {"to": "https://example.com/moved", "code": 301},
+		"/item/{id}": "/items/{id}",
+		"/item/{id}/edit": "/edit/{id}"
+	}}`)
+	tr.expectRedirect("/source", http.StatusFound, "/destination")
+	tr.expectRedirect("/moved", http.StatusMovedPermanently, "https://example.com/moved")
+	tr.expectRedirect("/item/42", http.StatusFound, "/items/42")
+	tr.expectRedirect("/item/42/edit", http.StatusFound, "/edit/42")
+	tr.expectNotFound("/elsewhere")
+
+	w := tr.do("HEAD", "/source", "")
+	tr.expectStatus(w, http.StatusFound)
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD answered with a body: %q", w.Body.String())
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tr := newTestRedirector(t, `{
+		"redirections": {"/spring": "/sale", "/docs/{page}": "https://docs.example.com/{page}"},
+		"hosts": {"go.example.com": {"/spring": "/go-sale"}}
+	}`)
+	tr.expectResolves("", "/spring", "/spring", "/sale")
+	tr.expectResolves("go.example.com", "/spring", "go.example.com/spring", "/go-sale")
+	tr.expectResolves("", "/docs/intro", "/docs/{page}", "https://docs.example.com/intro")
+	tr.expectResolves("", "/summer", "", "")
+}
+
+func TestResolveHandler(t *testing.T) {
+	tr := newTestRedirector(t, `{
+		"redirections": {"/spring": {"to": "/sale", "crawlers": "block"}},
+		"hosts": {"go.example.com": {"/spring": "/go-sale"}}
+	}`)
+	resolve := func(query string) Resolution {
+		t.Helper()
+		w := tr.do("GET", "/_resolve?"+query, "", "User-Agent", "Mozilla/5.0")
+		tr.expectStatus(w, http.StatusOK)
+		var res Resolution
+		tr.decode(w, &res)
+		return res
+	}
+	if res := resolve("path=/spring"); res.Source != "/spring" || res.Destination != "/sale" || res.Code != http.StatusFound {
+		t.Errorf("got %+v", res)
+	}
+	if res := resolve("path=/spring&host=GO.example.com"); res.Source != "go.example.com/spring" || res.Destination != "/go-sale" {
+		t.Errorf("got %+v", res)
+	}
+	if res := resolve("path=/spring&ua=Googlebot"); res.Action != "block" || res.Code != http.StatusOK {
+		t.Errorf("got %+v", res)
+	}
+	if res := resolve("path=/summer"); res.Source != "" || res.Code != http.StatusNotFound {
+		t.Errorf("got %+v", res)
+	}
+	tr.expectStatus(tr.do("GET", "/_resolve", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "GET", "/_resolve?path=/spring", ""), http.StatusUnauthorized)
+}
+
+func TestPutAndDeleteRule(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {}}`)
+	tr.expectStatus(tr.do("PUT", "/new", "/destination"), http.StatusCreated)
+	tr.expectRedirect("/new", http.StatusFound, "/destination")
+
+	w := tr.do("PUT", "/new", `{"to": "/elsewhere", "code": 308}`, "Content-Type", "application/json")
+	tr.expectStatus(w, http.StatusOK)
+	var listing ruleListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if listing.Source != "/new" || listing.Rule.To != "/elsewhere" || listing.Rule.CreatedBy != "127.0.0.1" {
+		t.Errorf("got %+v", listing)
+	}
+	tr.expectRedirect("/new", http.StatusPermanentRedirect, "/elsewhere")
+
+	tr.expectStatus(tr.do("PUT", "/bad", `{"to": "/x", "code": 200}`, "Content-Type", "application/json"), http.StatusBadRequest)
+	tr.expectStatus(tr.do("PUT", "/bad", "/x", "Content-Type", "image/png"), http.StatusUnsupportedMediaType)
+
+	tr.expectStatus(tr.do("DELETE", "/new", ""), http.StatusNoContent)
+	tr.expectNotFound("/new")
+	tr.expectStatus(tr.do("DELETE", "/new", ""), http.StatusNotFound)
+}
+
+func TestAdminOnly(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/a": "/b"}}`)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "PUT", "/a", "/c"), http.StatusUnauthorized)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "DELETE", "/a", ""), http.StatusUnauthorized)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "GET", "/_config", ""), http.StatusUnauthorized)
+	// Anyone may follow a redirection.
+	tr.expectStatus(tr.doFrom("192.0.2.1", "GET", "/a", ""), http.StatusFound)
+
+	tr = newTestRedirector(t, `{"redirections": {"/a": "/b"}, "admin": {"allow": ["192.0.2.0/24"]}}`)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "PUT", "/a", "/c"), http.StatusOK)
+}
+
+func TestConfig(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/a": "/b"}}`)
+	w := tr.do("GET", "/_config", "")
+	tr.expectStatus(w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	tr.expectStatus(tr.do("PUT", "/_config", `{"redirections": {"/c": "/d"}}`), http.StatusPreconditionRequired)
+	tr.expectStatus(tr.do("PUT", "/_config", `{"redirections": {"/c": "/d"}}`, "If-Match", `"stale"`), http.StatusPreconditionFailed)
+
+	tr.expectStatus(tr.do("PUT", "/_config", `{"redirections": {"/c": "/d"}}`, "If-Match", etag), http.StatusOK)
+	tr.expectRedirect("/a", http.StatusFound, "/b")
+	tr.expectRedirect("/c", http.StatusFound, "/d")
+
+	tr.expectStatus(tr.do("PUT", "/_config?mode=replace", `{"redirections": {"/e": "/f"}}`, "If-Match", "*"), http.StatusOK)
+	tr.expectNotFound("/a")
+	tr.expectRedirect("/e", http.StatusFound, "/f")
+
+	w = tr.do("PUT", "/_config", "redirections:\n  /g: /h\n", "If-Match", "*", "Content-Type", "application/yaml")
+	tr.expectStatus(w, http.StatusOK)
+	tr.expectRedirect("/g", http.StatusFound, "/h")
+}
+
+// A merge that is rejected leaves the live configuration as it was.
+func TestRejectedMerge(t *testing.T) {
+	tr := newTestRedirector(t, `{
+		"redirections": {"/a": "/b"},
+		"webhooks": [{"url": "https://hooks.example.com/a", "secret": "shh", "events": ["rule.created"]}],
+		"bots": ["crawler"],
+		"canonical": {"www": "strip"}
+	}`)
+	// Each is refused for its default destination, which isn't a path.
+	for _, merge := range []string{
+		`{"webhooks": [{"url": "https://evil.example.com/", "events": ["rule.deleted"]}], "bots": ["other"], "default_destination": "nowhere"}`,
+		`{"canonical": {"www": "add", "https": true}, "default_destination": "nowhere"}`,
+	} {
+		tr.expectStatus(tr.do("PUT", "/_config", merge, "If-Match", "*"), http.StatusBadRequest)
+	}
+	if hook := tr.Webhooks[0]; hook.URL != "https://hooks.example.com/a" || hook.Secret != "shh" || hook.Events[0] != "rule.created" {
+		t.Errorf("webhook changed to %+v", hook)
+	}
+	if tr.Bots[0] != "crawler" {
+		t.Errorf("bots changed to %q", tr.Bots)
+	}
+	if c := *tr.Canonical; c != (Canonical{WWW: WWWStrip}) {
+		t.Errorf("canonical settings changed to %+v", c)
+	}
+}
+
+// Lookups see either the old or the new redirection, never neither, while
+// others change them.
+func TestConcurrentChanges(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/stable": "/v0"}}`)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				w := tr.do("PUT", "/stable", fmt.Sprintf("/v%d", j))
+				if w.Code != http.StatusOK {
+					t.Errorf("PUT: got %d", w.Code)
+					return
+				}
+				tr.do("PUT", fmt.Sprintf("/churn/%d/%d", i, j), "/x")
+				tr.do("DELETE", fmt.Sprintf("/churn/%d/%d", i, j), "")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				w := tr.do("GET", "/stable", "", "User-Agent", "Mozilla/5.0")
+				if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), "/v") {
+					t.Errorf("GET: got %d to %q", w.Code, w.Header().Get("Location"))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// The largest share of the redirections, in percent, that one change may
+// update or remove without being forced, so that a bug in automation can't
+// wipe out the redirections. Changes to fewer than -max-change-min
+// redirections are always allowed.
+var maxChange *int = flag.Int("max-change", 0, "percentage of the redirections one change may update or remove without ?force=true (0 for no limit)")
+var maxChangeMin *int = flag.Int("max-change-min", 10, "redirections a change may update or remove regardless of -max-change")
+
+// A change that would update or remove too many of the redirections.
+type tooManyChanges struct {
+	altered, total int
+}
+
+func (err *tooManyChanges) Error() string {
+	return fmt.Sprintf("change would update or remove %d of %d redirections, more than %d%%", err.altered, err.total, *maxChange)
+}
+
+// Check that changes to the total redirections there were before stay within
+// -max-change, unless forced.
+func checkChangeRate(changes ConfigChanges, total int, force bool) error {
+	altered := changes.Updated + changes.Removed
+	if force || *maxChange <= 0 || total == 0 || altered < *maxChangeMin {
+		return nil
+	}
+	if altered*100 > *maxChange*total {
+		return &tooManyChanges{altered, total}
+	}
+	return nil
+}
+
+// Whether the request asks for a change to go ahead even if it is larger
+// than -max-change allows.
+func forced(req *http.Request) bool {
+	force, _ := strconv.ParseBool(req.URL.Query().Get("force"))
+	return force
+}
+
+// Refuse a change that checkChangeRate rejected.
+func refuseChange(w http.ResponseWriter, err error) {
+	(&APIError{http.StatusConflict, CodeTooManyChanges, "Refusing change: " + err.Error() + "; add ?force=true to apply it anyway", nil}).write(w)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The tests run with the flags' defaults, as the server would without any,
+// and quietly unless -test.v is given.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	var err error
+	if adminAllow, err = parsePrefixes(*adminAllowFlag); err != nil {
+		log.Fatal(err)
+	}
+	if cors, err = parseCORS(); err != nil {
+		log.Fatal(err)
+	}
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// A testRedirector is a Redirector with a configuration, in memory, serving
+// requests through the same routes and middleware as a server listening on
+// one address, for both redirections and the admin API. Each runs on a clock
+// of its own, but test redirectors share the flags with each other and with
+// everything else in the package, so a test that changes one can't run in
+// parallel.
+type testRedirector struct {
+	*Redirector
+	t       testing.TB
+	handler http.Handler
+}
+
+// A Redirector loaded with config, a JSON configuration.
+func newTestRedirector(t testing.TB, config string) *testRedirector {
+	t.Helper()
+	return newTestRedirectorWithClock(t, defaultClock, config)
+}
+
+// A Redirector loaded with config, running on clock, such as a ManualClock to
+// step through schedules and expiry with.
+func newTestRedirectorWithClock(t testing.TB, clock Clock, config string) *testRedirector {
+	t.Helper()
+	redir := NewRedirectorWithClock(clock)
+	if err := redir.LoadConfig([]byte(config)); err != nil {
+		t.Fatalf("loading configuration: %v", err)
+	}
+	_, admin := redir.routes()
+	return &testRedirector{redir, t, filterRequests(limitBodies(allowCORS(admin)))}
+}
+
+// Serve a request from localhost, which may use the admin API, with body,
+// if any, and headers given as pairs of names and values.
+func (tr *testRedirector) do(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	tr.t.Helper()
+	return tr.doFrom("127.0.0.1", method, target, body, headers...)
+}
+
+// Serve a request as do does, from a client at addr, which isn't allowed to
+// use the admin API unless the configuration says so.
+func (tr *testRedirector) doFrom(addr, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	tr.t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.RemoteAddr = addr + ":40404"
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	tr.handler.ServeHTTP(w, req)
+	return w
+}
+
+// Check that a request answers with status.
+func (tr *testRedirector) expectStatus(w *httptest.ResponseRecorder, status int) {
+	tr.t.Helper()
+	if w.Code != status {
+		tr.t.Fatalf("got %d, want %d: %s", w.Code, status, strings.TrimSpace(w.Body.String()))
+	}
+}
+
+// Check that a GET for path redirects to location with code.
+func (tr *testRedirector) expectRedirect(path string, code int, location string) {
+	tr.t.Helper()
+	w := tr.do("GET", path, "", "User-Agent", "Mozilla/5.0")
+	if w.Code != code || w.Header().Get("Location") != location {
+		tr.t.Fatalf("GET %s: got %d to %q, want %d to %q", path, w.Code, w.Header().Get("Location"), code, location)
+	}
+}
+
+// Check that a GET for path isn't redirected.
+func (tr *testRedirector) expectNotFound(path string) {
+	tr.t.Helper()
+	w := tr.do("GET", path, "", "User-Agent", "Mozilla/5.0")
+	if w.Code != http.StatusNotFound {
+		tr.t.Fatalf("GET %s: got %d to %q, want 404", path, w.Code, w.Header().Get("Location"))
+	}
+}
+
+// Check that a request for path on host resolves to the rule from source,
+// sending clients to destination. An empty source means no rule matches.
+func (tr *testRedirector) expectResolves(host, path, source, destination string) {
+	tr.t.Helper()
+	res := tr.Resolve(host, path, "Mozilla/5.0")
+	if res.Source != source || res.Destination != destination {
+		tr.t.Fatalf("%s%s: resolved to %q to %q, want %q to %q", host, path, res.Source, res.Destination, source, destination)
+	}
+}
+
+// Decode a JSON response into v.
+func (tr *testRedirector) decode(w *httptest.ResponseRecorder, v any) {
+	tr.t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		tr.t.Fatalf("decoding %s: %v", strings.TrimSpace(w.Body.String()), err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// When the process started, for reporting uptime.
+var started = time.Now()
+
+// The JSON body of the health and readiness endpoints.
+type healthStatus struct {
+	Status        string  `json:"status"`
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Redirections  int     `json:"redirections,omitempty"`
+	ConfigLoaded  string  `json:"config_loaded,omitempty"`
+	LastError     string  `json:"last_error,omitempty"`
+}
+
+func newHealthStatus(status string) *healthStatus {
+	uptime := time.Since(started)
+	return &healthStatus{Status: status, Uptime: uptime.Round(time.Second).String(), UptimeSeconds: uptime.Seconds()}
+}
+
+func writeHealth(w http.ResponseWriter, req *http.Request, code int, status *healthStatus) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if req.Method == "GET" {
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// HealthHandler reports liveness: if it answers at all, the process is up.
+func (redir *Redirector) HealthHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeHealth(w, req, http.StatusOK, newHealthStatus("ok"))
+	}
+}
+
+// ReadyHandler reports readiness: a configuration has been loaded without
+// error, the Redirector has finished warming up, if it does, and the server
+// isn't draining before it shuts down. A later configuration that fails to
+// load is reported, but the Redirector remains ready with the configuration
+// it has.
+func (redir *Redirector) ReadyHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.mu.RLock()
+		loaded, loadErr, n := redir.loaded, redir.loadErr, redir.Redirections.Len()
+		redir.mu.RUnlock()
+
+		code, status := http.StatusOK, newHealthStatus("ready")
+		if err := injectFault(req.Context(), "ready"); err != nil {
+			code, status = http.StatusServiceUnavailable, newHealthStatus("not ready")
+			status.LastError = err.Error()
+		} else if loaded.IsZero() {
+			code, status = http.StatusServiceUnavailable, newHealthStatus("not ready")
+		} else if warming.Load() {
+			code, status = http.StatusServiceUnavailable, newHealthStatus("warming up")
+		} else if draining.Load() {
+			code, status = http.StatusServiceUnavailable, newHealthStatus("draining")
+		} else {
+			status.ConfigLoaded = loaded.Format(time.RFC3339)
+		}
+		status.Redirections = n
+		if loadErr != nil && status.LastError == "" {
+			status.LastError = loadErr.Error()
+		}
+		writeHealth(w, req, code, status)
+	}
+}
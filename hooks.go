@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Hooks let code built into the server take part in requests for
+// redirections without changing the handler, such as to match paths in its
+// own way, add headers, or log somewhere else. A hook implements any of
+// BeforeLookupHook, AfterMatchHook, NotFoundHook, and AfterResponseHook, and
+// is registered with RegisterHook from the init function of a file added to
+// the build:
+//
+//	type campaignHeader struct{}
+//
+//	func (campaignHeader) Name() string { return "campaign-header" }
+//
+//	func (campaignHeader) AfterMatch(w http.ResponseWriter, req *http.Request, match *Match) {
+//		w.Header().Set("X-Campaign", match.Rule.Group)
+//	}
+//
+//	func init() { RegisterHook(campaignHeader{}) }
+//
+// Hooks run in the order they were registered, after the built-in ones,
+// which record the statistics and write the access log.
+type Hook interface {
+	Name() string
+}
+
+// A BeforeLookupHook sees a request before its redirection is looked up. It
+// may change the request, such as its path, or answer it itself by returning
+// true, in which case nothing else is done with the request.
+type BeforeLookupHook interface {
+	Hook
+	BeforeLookup(w http.ResponseWriter, req *http.Request) (handled bool)
+}
+
+// An AfterMatchHook sees the redirection found for a request, and may change
+// where it goes or add headers to the response.
+type AfterMatchHook interface {
+	Hook
+	AfterMatch(w http.ResponseWriter, req *http.Request, match *Match)
+}
+
+// A NotFoundHook sees a request without a redirection before the default
+// destination or the 404 page, and may answer it itself by returning true.
+type NotFoundHook interface {
+	Hook
+	NotFound(w http.ResponseWriter, req *http.Request) (handled bool)
+}
+
+// An AfterResponseHook sees what a request got once it has been answered.
+type AfterResponseHook interface {
+	Hook
+	AfterResponse(req *http.Request, entry *accessEntry)
+}
+
+// A Match is the redirection found for a request: the source it matched,
+// which may be a wildcard, its rule, and the destination for this request.
+type Match struct {
+	Source      string
+	Rule        *Rule
+	Destination string
+}
+
+// The action of a request a hook answered.
+const ActionHook = "hook"
+
+// The hooks registered so far.
+var registeredHooks []Hook
+
+// RegisterHook adds a hook to the Redirectors created after it, so it must be
+// called before the server starts, as from an init function.
+func RegisterHook(hook Hook) {
+	registeredHooks = append(registeredHooks, hook)
+}
+
+// The built-in hooks, then the registered ones.
+func (redir *Redirector) initHooks() {
+	redir.hooks = append([]Hook{statsHook{redir}, accessLogHook{redir}}, registeredHooks...)
+}
+
+// The names of the hooks, in order, for /_status.
+func (redir *Redirector) hookNames() []string {
+	names := make([]string, len(redir.hooks))
+	for i, hook := range redir.hooks {
+		names[i] = hook.Name()
+	}
+	return names
+}
+
+func (redir *Redirector) beforeLookup(w http.ResponseWriter, req *http.Request) bool {
+	for _, hook := range redir.hooks {
+		if h, ok := hook.(BeforeLookupHook); ok && h.BeforeLookup(w, req) {
+			return true
+		}
+	}
+	return false
+}
+
+func (redir *Redirector) afterMatch(w http.ResponseWriter, req *http.Request, match *Match) {
+	for _, hook := range redir.hooks {
+		if h, ok := hook.(AfterMatchHook); ok {
+			h.AfterMatch(w, req, match)
+		}
+	}
+}
+
+func (redir *Redirector) onNotFound(w http.ResponseWriter, req *http.Request) bool {
+	for _, hook := range redir.hooks {
+		if h, ok := hook.(NotFoundHook); ok && h.NotFound(w, req) {
+			return true
+		}
+	}
+	return false
+}
+
+func (redir *Redirector) afterResponse(req *http.Request, entry *accessEntry) {
+	for _, hook := range redir.hooks {
+		if h, ok := hook.(AfterResponseHook); ok {
+			h.AfterResponse(req, entry)
+		}
+	}
+}
+
+// The statsHook counts hits and misses in the statistics. Requests the
+// upstream answered are only counted if it didn't find them either.
+type statsHook struct{ redir *Redirector }
+
+func (statsHook) Name() string { return "stats" }
+
+func (hook statsHook) AfterResponse(req *http.Request, entry *accessEntry) {
+	if entry.Action == ActionUpstream && entry.Status != http.StatusNotFound {
+		// The upstream had the page, so it wasn't a miss.
+		return
+	}
+	loc := entry.loc
+	if loc == nil {
+		loc = time.Local
+	}
+	hook.redir.stats.Record(entry.Rule, req, entry.Bytes, loc, entry.Bot)
+	if entry.Cache != "" {
+		hook.redir.stats.countCache(entry.Rule, entry.Cache)
+	}
+}
+
+// The accessLogHook writes the access log, if there is one, and publishes
+// the hit to the live stream.
+type accessLogHook struct{ redir *Redirector }
+
+func (accessLogHook) Name() string { return "access-log" }
+
+func (hook accessLogHook) AfterResponse(req *http.Request, entry *accessEntry) {
+	hook.redir.access.Record(entry)
+	hook.redir.hits.publish(entry)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Hosts are sets of redirections for requests to particular hosts, such as
+// vanity domains, which are tried before the active profile's and the
+// others. A host may be a pattern whose first label is *, such as
+// *.go.example.com, matching one more label, which fills in {subdomain} in
+// its destinations, so one rule can serve a fleet of per-customer
+// subdomains:
+//
+//	"hosts": {
+//	  "go.brand-a.com": {"/": "https://brand-a.com/"},
+//	  "*.go.example.com": {"/": "https://example.com/customers/{subdomain}/"}
+//	}
+type hostRules struct {
+	host      string
+	pattern   bool
+	rules     Rules
+	wildcards *wildcardSet
+}
+
+// The placeholder for the label a host pattern matched.
+const subdomainPlaceholder = "{subdomain}"
+
+// Collect the hosts' redirections, exact hosts first, then patterns with
+// the most labels.
+func compileHosts(hosts map[string]Rules) ([]*hostRules, error) {
+	var compiled []*hostRules
+	for host, rules := range hosts {
+		pattern := strings.HasPrefix(host, "*.")
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || host != strings.ToLower(host) || strings.ContainsAny(name, "*:/") || strings.HasSuffix(name, ".") {
+			return nil, fmt.Errorf("host %q is not a lowercase host name or *. followed by one", host)
+		}
+		// Requests name international hosts in Punycode.
+		host = hostToASCII(host)
+		wildcards, err := compileWildcards(rules)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %v", host, err)
+		}
+		compiled = append(compiled, &hostRules{host: host, pattern: pattern, rules: rules, wildcards: wildcards})
+	}
+	sort.Slice(compiled, func(i, j int) bool {
+		a, b := compiled[i], compiled[j]
+		if a.pattern != b.pattern {
+			return !a.pattern
+		}
+		if la, lb := strings.Count(a.host, "."), strings.Count(b.host, "."); la != lb {
+			return la > lb
+		}
+		return a.host < b.host
+	})
+	return compiled, nil
+}
+
+// The redirections for host, and the label a pattern matched, if any.
+func (config *Config) forHost(host string) (hr *hostRules, subdomain string) {
+	for _, hr := range config.hosts {
+		if !hr.pattern {
+			if host == hr.host {
+				return hr, ""
+			}
+			continue
+		}
+		label, ok := strings.CutSuffix(host, hr.host[1:])
+		if ok && label != "" && !strings.Contains(label, ".") {
+			return hr, label
+		}
+	}
+	return nil, ""
+}
+
+// The host a request is for, in lowercase, without a port or trailing dot.
+func requestHost(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		// An IPv6 address without a port.
+		host = host[1 : len(host)-1]
+	}
+	return hostToASCII(strings.ToLower(strings.TrimSuffix(host, ".")))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long responses to requests with an Idempotency-Key are remembered.
+var idempotencyTTL *time.Duration = flag.Duration("idempotency-ttl", 10*time.Minute, "how long Idempotency-Key responses are kept")
+
+// An idempotencyCache remembers the successful responses to mutating admin
+// requests sent with an Idempotency-Key header, so a retried request gets the
+// original response instead of being applied twice. Keys are scoped to the
+// client that sent them, as named by client, so one client can't replay
+// another's responses.
+type idempotencyCache struct {
+	client  func(req *http.Request) string
+	clock   Clock
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+}
+
+// A remembered response, and the request it answered. done is closed once
+// the response is complete, or has been forgotten, leaving code 0.
+type idempotentResponse struct {
+	method  string
+	path    string
+	sum     [sha256.Size]byte
+	expires time.Time
+	done    chan struct{}
+
+	code   int
+	header http.Header
+	body   []byte
+}
+
+func newIdempotencyCache(clock Clock, client func(req *http.Request) string) *idempotencyCache {
+	return &idempotencyCache{client: client, clock: clock, entries: make(map[string]*idempotentResponse)}
+}
+
+// Serve the request with fn, unless a request with the same Idempotency-Key
+// has already been served successfully, in which case its response is
+// replayed. A key reused for a different request is rejected, as is one whose
+// request is still in progress. Errors aren't remembered, so the request can
+// be retried with the same key. Requests without a key are simply served.
+func (cache *idempotencyCache) serve(w http.ResponseWriter, req *http.Request, fn func(http.ResponseWriter, *http.Request)) {
+	key := req.Header.Get("Idempotency-Key")
+	if key == "" {
+		fn(w, req)
+		return
+	}
+
+	body, ok := readBody(w, req)
+	if !ok {
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	scoped := cache.client(req) + " " + key
+
+	cache.mu.Lock()
+	now := cache.clock.Now()
+	for k, entry := range cache.entries {
+		if entry.expires.Before(now) {
+			delete(cache.entries, k)
+		}
+	}
+	entry, ok := cache.entries[scoped]
+	if !ok {
+		entry = &idempotentResponse{method: req.Method, path: req.URL.Path, sum: sum,
+			expires: now.Add(*idempotencyTTL), done: make(chan struct{})}
+		cache.entries[scoped] = entry
+	}
+	cache.mu.Unlock()
+
+	if ok {
+		switch {
+		case entry.method != req.Method || entry.path != req.URL.Path || entry.sum != sum:
+			writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was used for a different request")
+		case !isClosed(entry.done) || entry.code == 0:
+			writeError(w, http.StatusConflict, "A request with this Idempotency-Key is in progress")
+		default:
+			log.Println(realAddr(req), "replayed", req.Method, req.URL.Path, "for Idempotency-Key", key)
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.code)
+			w.Write(entry.body)
+		}
+		return
+	}
+
+	rw := &recordingWriter{ResponseWriter: w, code: http.StatusOK}
+	completed := false
+	defer func() {
+		if completed && rw.code >= 200 && rw.code <= 299 {
+			entry.code, entry.header, entry.body = rw.code, w.Header().Clone(), rw.body.Bytes()
+		} else {
+			cache.mu.Lock()
+			if cache.entries[scoped] == entry {
+				delete(cache.entries, scoped)
+			}
+			cache.mu.Unlock()
+		}
+		close(entry.done)
+	}()
+	fn(rw, req)
+	completed = true
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// A recordingWriter keeps a copy of the response it writes.
+type recordingWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	rw.code = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
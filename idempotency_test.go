@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {}, "admin": {"allow": ["192.0.2.0/24"]}}`)
+	key := []string{"Idempotency-Key", "first"}
+
+	tr.expectStatus(tr.do("PUT", "/a", "/b", key...), http.StatusCreated)
+	w := tr.do("PUT", "/a", "/b", key...)
+	tr.expectStatus(w, http.StatusCreated)
+	if w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("not replayed")
+	}
+	tr.expectStatus(tr.do("PUT", "/a", "/c", key...), http.StatusUnprocessableEntity)
+
+	// Another client's key is its own.
+	w = tr.doFrom("192.0.2.1", "PUT", "/a", "/b", key...)
+	tr.expectStatus(w, http.StatusOK)
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("replayed another client's response")
+	}
+
+	// Errors aren't kept.
+	key = []string{"Idempotency-Key", "second"}
+	tr.expectStatus(tr.do("DELETE", "/missing", "", key...), http.StatusNotFound)
+	tr.expectStatus(tr.do("PUT", "/missing", "/found"), http.StatusCreated)
+	tr.expectStatus(tr.do("DELETE", "/missing", "", key...), http.StatusNoContent)
+}
+
+func TestIdempotencyPanic(t *testing.T) {
+	cache := newIdempotencyCache(defaultClock, func(req *http.Request) string { return "client" })
+	serve := func(fn func(http.ResponseWriter, *http.Request)) (w *httptest.ResponseRecorder, panicked bool) {
+		req := httptest.NewRequest("POST", "/_rewrite", nil)
+		req.Header.Set("Idempotency-Key", "key")
+		w = httptest.NewRecorder()
+		defer func() { panicked = recover() != nil }()
+		cache.serve(w, req, fn)
+		return w, false
+	}
+	if _, panicked := serve(func(http.ResponseWriter, *http.Request) { panic("failed") }); !panicked {
+		t.Fatal("didn't panic")
+	}
+	// The key is free for a retry, rather than in progress until it expires.
+	w, _ := serve(func(w http.ResponseWriter, req *http.Request) { w.WriteHeader(http.StatusOK) })
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("got %d, replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
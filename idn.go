@@ -0,0 +1,454 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Paths and hosts are matched in one form however clients encode them:
+// paths are NFC-normalized, so /über matches whether its ü is one character
+// or a u and a combining diaeresis, sources are percent-decoded as request
+// paths are, so /%C3%BCber in the configuration is /über, and host names are
+// matched in their ASCII, Punycode form, so münchen.de is xn--mnchen-3ya.de.
+//
+// The standard library has neither normalization nor Punycode. The tables
+// below cover the precomposed Latin, Greek, and Cyrillic letters, where
+// paths and vanity hosts use them; other scripts are matched as they are.
+
+// A source as written in the configuration, decoded as the paths of
+// requests are. Sources that aren't valid encodings, such as /100%, or that
+// would decode to a NUL, are left as they are.
+func decodeSource(source string) string {
+	if !strings.Contains(source, "%") {
+		return source
+	}
+	if decoded, err := url.PathUnescape(source); err == nil && !strings.ContainsRune(decoded, 0) {
+		return decoded
+	}
+	return source
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// The canonical decompositions of the precomposed characters.
+var decompositions = invertCompositions(compositions)
+
+func invertCompositions(compositions map[[2]rune]rune) map[rune][2]rune {
+	inverted := make(map[rune][2]rune, len(compositions))
+	for pair, composed := range compositions {
+		inverted[composed] = pair
+	}
+	return inverted
+}
+
+// Append the canonical decomposition of r to runes.
+func decompose(runes []rune, r rune) []rune {
+	if pair, ok := decompositions[r]; ok {
+		return append(decompose(runes, pair[0]), pair[1])
+	}
+	if decomposed, ok := excludedDecompositions[r]; ok {
+		for _, d := range decomposed {
+			runes = decompose(runes, d)
+		}
+		return runes
+	}
+	return append(runes, r)
+}
+
+// s in Normalization Form C: decomposed, with its combining marks in
+// canonical order, and composed again.
+func nfc(s string) string {
+	if isASCII(s) || !utf8.ValidString(s) {
+		return s
+	}
+	runes := make([]rune, 0, len(s))
+	for _, r := range s {
+		runes = decompose(runes, r)
+	}
+	for i := 0; i < len(runes); i++ {
+		j := i
+		for j < len(runes) && combiningClasses[runes[j]] != 0 {
+			j++
+		}
+		if j-i > 1 {
+			marks := runes[i:j]
+			sort.SliceStable(marks, func(a, b int) bool { return combiningClasses[marks[a]] < combiningClasses[marks[b]] })
+		}
+		i = j
+	}
+
+	// A character composes with the last starter unless another character
+	// of the same or a higher class, or a starter, comes between them.
+	composed := runes[:0]
+	starter, last := -1, -1
+	for _, r := range runes {
+		class := int(combiningClasses[r])
+		if starter >= 0 && last < class {
+			if c, ok := compositions[[2]rune{composed[starter], r}]; ok {
+				composed[starter] = c
+				continue
+			}
+		}
+		if class == 0 {
+			starter, last = len(composed), -1
+		} else {
+			last = class
+		}
+		composed = append(composed, r)
+	}
+	return string(composed)
+}
+
+// The ASCII form of a host name: labels that aren't ASCII are lowercased,
+// normalized, and encoded with Punycode, without the rest of IDNA's
+// mapping.
+func hostToASCII(host string) string {
+	if isASCII(host) {
+		return host
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if !isASCII(label) {
+			labels[i] = "xn--" + punycode(nfc(strings.ToLower(label)))
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// Punycode parameters (RFC 3492).
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// Encode a label with Punycode (RFC 3492).
+func punycode(label string) string {
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for handled := basic; handled < len(runes); {
+		next := int(utf8.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < next {
+				next = int(r)
+			}
+		}
+		delta += (next - n) * (handled + 1)
+		n = next
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := min(max(k-bias, punyTMin), punyTMax)
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (punyBase-punyTMin)*punyTMax/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// The canonical compositions of pairs of characters into the precomposed
+// Latin, Greek, and Cyrillic letters, from the Unicode 14.0.0 character
+// database, without the compositions it excludes.
+var compositions = map[[2]rune]rune{
+	{0x41, 0x300}: 0xC0, {0x41, 0x301}: 0xC1, {0x41, 0x302}: 0xC2, {0x41, 0x303}: 0xC3,
+	{0x41, 0x308}: 0xC4, {0x41, 0x30A}: 0xC5, {0x43, 0x327}: 0xC7, {0x45, 0x300}: 0xC8,
+	{0x45, 0x301}: 0xC9, {0x45, 0x302}: 0xCA, {0x45, 0x308}: 0xCB, {0x49, 0x300}: 0xCC,
+	{0x49, 0x301}: 0xCD, {0x49, 0x302}: 0xCE, {0x49, 0x308}: 0xCF, {0x4E, 0x303}: 0xD1,
+	{0x4F, 0x300}: 0xD2, {0x4F, 0x301}: 0xD3, {0x4F, 0x302}: 0xD4, {0x4F, 0x303}: 0xD5,
+	{0x4F, 0x308}: 0xD6, {0x55, 0x300}: 0xD9, {0x55, 0x301}: 0xDA, {0x55, 0x302}: 0xDB,
+	{0x55, 0x308}: 0xDC, {0x59, 0x301}: 0xDD, {0x61, 0x300}: 0xE0, {0x61, 0x301}: 0xE1,
+	{0x61, 0x302}: 0xE2, {0x61, 0x303}: 0xE3, {0x61, 0x308}: 0xE4, {0x61, 0x30A}: 0xE5,
+	{0x63, 0x327}: 0xE7, {0x65, 0x300}: 0xE8, {0x65, 0x301}: 0xE9, {0x65, 0x302}: 0xEA,
+	{0x65, 0x308}: 0xEB, {0x69, 0x300}: 0xEC, {0x69, 0x301}: 0xED, {0x69, 0x302}: 0xEE,
+	{0x69, 0x308}: 0xEF, {0x6E, 0x303}: 0xF1, {0x6F, 0x300}: 0xF2, {0x6F, 0x301}: 0xF3,
+	{0x6F, 0x302}: 0xF4, {0x6F, 0x303}: 0xF5, {0x6F, 0x308}: 0xF6, {0x75, 0x300}: 0xF9,
+	{0x75, 0x301}: 0xFA, {0x75, 0x302}: 0xFB, {0x75, 0x308}: 0xFC, {0x79, 0x301}: 0xFD,
+	{0x79, 0x308}: 0xFF, {0x41, 0x304}: 0x100, {0x61, 0x304}: 0x101, {0x41, 0x306}: 0x102,
+	{0x61, 0x306}: 0x103, {0x41, 0x328}: 0x104, {0x61, 0x328}: 0x105, {0x43, 0x301}: 0x106,
+	{0x63, 0x301}: 0x107, {0x43, 0x302}: 0x108, {0x63, 0x302}: 0x109, {0x43, 0x307}: 0x10A,
+	{0x63, 0x307}: 0x10B, {0x43, 0x30C}: 0x10C, {0x63, 0x30C}: 0x10D, {0x44, 0x30C}: 0x10E,
+	{0x64, 0x30C}: 0x10F, {0x45, 0x304}: 0x112, {0x65, 0x304}: 0x113, {0x45, 0x306}: 0x114,
+	{0x65, 0x306}: 0x115, {0x45, 0x307}: 0x116, {0x65, 0x307}: 0x117, {0x45, 0x328}: 0x118,
+	{0x65, 0x328}: 0x119, {0x45, 0x30C}: 0x11A, {0x65, 0x30C}: 0x11B, {0x47, 0x302}: 0x11C,
+	{0x67, 0x302}: 0x11D, {0x47, 0x306}: 0x11E, {0x67, 0x306}: 0x11F, {0x47, 0x307}: 0x120,
+	{0x67, 0x307}: 0x121, {0x47, 0x327}: 0x122, {0x67, 0x327}: 0x123, {0x48, 0x302}: 0x124,
+	{0x68, 0x302}: 0x125, {0x49, 0x303}: 0x128, {0x69, 0x303}: 0x129, {0x49, 0x304}: 0x12A,
+	{0x69, 0x304}: 0x12B, {0x49, 0x306}: 0x12C, {0x69, 0x306}: 0x12D, {0x49, 0x328}: 0x12E,
+	{0x69, 0x328}: 0x12F, {0x49, 0x307}: 0x130, {0x4A, 0x302}: 0x134, {0x6A, 0x302}: 0x135,
+	{0x4B, 0x327}: 0x136, {0x6B, 0x327}: 0x137, {0x4C, 0x301}: 0x139, {0x6C, 0x301}: 0x13A,
+	{0x4C, 0x327}: 0x13B, {0x6C, 0x327}: 0x13C, {0x4C, 0x30C}: 0x13D, {0x6C, 0x30C}: 0x13E,
+	{0x4E, 0x301}: 0x143, {0x6E, 0x301}: 0x144, {0x4E, 0x327}: 0x145, {0x6E, 0x327}: 0x146,
+	{0x4E, 0x30C}: 0x147, {0x6E, 0x30C}: 0x148, {0x4F, 0x304}: 0x14C, {0x6F, 0x304}: 0x14D,
+	{0x4F, 0x306}: 0x14E, {0x6F, 0x306}: 0x14F, {0x4F, 0x30B}: 0x150, {0x6F, 0x30B}: 0x151,
+	{0x52, 0x301}: 0x154, {0x72, 0x301}: 0x155, {0x52, 0x327}: 0x156, {0x72, 0x327}: 0x157,
+	{0x52, 0x30C}: 0x158, {0x72, 0x30C}: 0x159, {0x53, 0x301}: 0x15A, {0x73, 0x301}: 0x15B,
+	{0x53, 0x302}: 0x15C, {0x73, 0x302}: 0x15D, {0x53, 0x327}: 0x15E, {0x73, 0x327}: 0x15F,
+	{0x53, 0x30C}: 0x160, {0x73, 0x30C}: 0x161, {0x54, 0x327}: 0x162, {0x74, 0x327}: 0x163,
+	{0x54, 0x30C}: 0x164, {0x74, 0x30C}: 0x165, {0x55, 0x303}: 0x168, {0x75, 0x303}: 0x169,
+	{0x55, 0x304}: 0x16A, {0x75, 0x304}: 0x16B, {0x55, 0x306}: 0x16C, {0x75, 0x306}: 0x16D,
+	{0x55, 0x30A}: 0x16E, {0x75, 0x30A}: 0x16F, {0x55, 0x30B}: 0x170, {0x75, 0x30B}: 0x171,
+	{0x55, 0x328}: 0x172, {0x75, 0x328}: 0x173, {0x57, 0x302}: 0x174, {0x77, 0x302}: 0x175,
+	{0x59, 0x302}: 0x176, {0x79, 0x302}: 0x177, {0x59, 0x308}: 0x178, {0x5A, 0x301}: 0x179,
+	{0x7A, 0x301}: 0x17A, {0x5A, 0x307}: 0x17B, {0x7A, 0x307}: 0x17C, {0x5A, 0x30C}: 0x17D,
+	{0x7A, 0x30C}: 0x17E, {0x4F, 0x31B}: 0x1A0, {0x6F, 0x31B}: 0x1A1, {0x55, 0x31B}: 0x1AF,
+	{0x75, 0x31B}: 0x1B0, {0x41, 0x30C}: 0x1CD, {0x61, 0x30C}: 0x1CE, {0x49, 0x30C}: 0x1CF,
+	{0x69, 0x30C}: 0x1D0, {0x4F, 0x30C}: 0x1D1, {0x6F, 0x30C}: 0x1D2, {0x55, 0x30C}: 0x1D3,
+	{0x75, 0x30C}: 0x1D4, {0xDC, 0x304}: 0x1D5, {0xFC, 0x304}: 0x1D6, {0xDC, 0x301}: 0x1D7,
+	{0xFC, 0x301}: 0x1D8, {0xDC, 0x30C}: 0x1D9, {0xFC, 0x30C}: 0x1DA, {0xDC, 0x300}: 0x1DB,
+	{0xFC, 0x300}: 0x1DC, {0xC4, 0x304}: 0x1DE, {0xE4, 0x304}: 0x1DF, {0x226, 0x304}: 0x1E0,
+	{0x227, 0x304}: 0x1E1, {0xC6, 0x304}: 0x1E2, {0xE6, 0x304}: 0x1E3, {0x47, 0x30C}: 0x1E6,
+	{0x67, 0x30C}: 0x1E7, {0x4B, 0x30C}: 0x1E8, {0x6B, 0x30C}: 0x1E9, {0x4F, 0x328}: 0x1EA,
+	{0x6F, 0x328}: 0x1EB, {0x1EA, 0x304}: 0x1EC, {0x1EB, 0x304}: 0x1ED, {0x1B7, 0x30C}: 0x1EE,
+	{0x292, 0x30C}: 0x1EF, {0x6A, 0x30C}: 0x1F0, {0x47, 0x301}: 0x1F4, {0x67, 0x301}: 0x1F5,
+	{0x4E, 0x300}: 0x1F8, {0x6E, 0x300}: 0x1F9, {0xC5, 0x301}: 0x1FA, {0xE5, 0x301}: 0x1FB,
+	{0xC6, 0x301}: 0x1FC, {0xE6, 0x301}: 0x1FD, {0xD8, 0x301}: 0x1FE, {0xF8, 0x301}: 0x1FF,
+	{0x41, 0x30F}: 0x200, {0x61, 0x30F}: 0x201, {0x41, 0x311}: 0x202, {0x61, 0x311}: 0x203,
+	{0x45, 0x30F}: 0x204, {0x65, 0x30F}: 0x205, {0x45, 0x311}: 0x206, {0x65, 0x311}: 0x207,
+	{0x49, 0x30F}: 0x208, {0x69, 0x30F}: 0x209, {0x49, 0x311}: 0x20A, {0x69, 0x311}: 0x20B,
+	{0x4F, 0x30F}: 0x20C, {0x6F, 0x30F}: 0x20D, {0x4F, 0x311}: 0x20E, {0x6F, 0x311}: 0x20F,
+	{0x52, 0x30F}: 0x210, {0x72, 0x30F}: 0x211, {0x52, 0x311}: 0x212, {0x72, 0x311}: 0x213,
+	{0x55, 0x30F}: 0x214, {0x75, 0x30F}: 0x215, {0x55, 0x311}: 0x216, {0x75, 0x311}: 0x217,
+	{0x53, 0x326}: 0x218, {0x73, 0x326}: 0x219, {0x54, 0x326}: 0x21A, {0x74, 0x326}: 0x21B,
+	{0x48, 0x30C}: 0x21E, {0x68, 0x30C}: 0x21F, {0x41, 0x307}: 0x226, {0x61, 0x307}: 0x227,
+	{0x45, 0x327}: 0x228, {0x65, 0x327}: 0x229, {0xD6, 0x304}: 0x22A, {0xF6, 0x304}: 0x22B,
+	{0xD5, 0x304}: 0x22C, {0xF5, 0x304}: 0x22D, {0x4F, 0x307}: 0x22E, {0x6F, 0x307}: 0x22F,
+	{0x22E, 0x304}: 0x230, {0x22F, 0x304}: 0x231, {0x59, 0x304}: 0x232, {0x79, 0x304}: 0x233,
+	{0xA8, 0x301}: 0x385, {0x391, 0x301}: 0x386, {0x395, 0x301}: 0x388, {0x397, 0x301}: 0x389,
+	{0x399, 0x301}: 0x38A, {0x39F, 0x301}: 0x38C, {0x3A5, 0x301}: 0x38E, {0x3A9, 0x301}: 0x38F,
+	{0x3CA, 0x301}: 0x390, {0x399, 0x308}: 0x3AA, {0x3A5, 0x308}: 0x3AB, {0x3B1, 0x301}: 0x3AC,
+	{0x3B5, 0x301}: 0x3AD, {0x3B7, 0x301}: 0x3AE, {0x3B9, 0x301}: 0x3AF, {0x3CB, 0x301}: 0x3B0,
+	{0x3B9, 0x308}: 0x3CA, {0x3C5, 0x308}: 0x3CB, {0x3BF, 0x301}: 0x3CC, {0x3C5, 0x301}: 0x3CD,
+	{0x3C9, 0x301}: 0x3CE, {0x3D2, 0x301}: 0x3D3, {0x3D2, 0x308}: 0x3D4, {0x415, 0x300}: 0x400,
+	{0x415, 0x308}: 0x401, {0x413, 0x301}: 0x403, {0x406, 0x308}: 0x407, {0x41A, 0x301}: 0x40C,
+	{0x418, 0x300}: 0x40D, {0x423, 0x306}: 0x40E, {0x418, 0x306}: 0x419, {0x438, 0x306}: 0x439,
+	{0x435, 0x300}: 0x450, {0x435, 0x308}: 0x451, {0x433, 0x301}: 0x453, {0x456, 0x308}: 0x457,
+	{0x43A, 0x301}: 0x45C, {0x438, 0x300}: 0x45D, {0x443, 0x306}: 0x45E, {0x474, 0x30F}: 0x476,
+	{0x475, 0x30F}: 0x477, {0x416, 0x306}: 0x4C1, {0x436, 0x306}: 0x4C2, {0x410, 0x306}: 0x4D0,
+	{0x430, 0x306}: 0x4D1, {0x410, 0x308}: 0x4D2, {0x430, 0x308}: 0x4D3, {0x415, 0x306}: 0x4D6,
+	{0x435, 0x306}: 0x4D7, {0x4D8, 0x308}: 0x4DA, {0x4D9, 0x308}: 0x4DB, {0x416, 0x308}: 0x4DC,
+	{0x436, 0x308}: 0x4DD, {0x417, 0x308}: 0x4DE, {0x437, 0x308}: 0x4DF, {0x418, 0x304}: 0x4E2,
+	{0x438, 0x304}: 0x4E3, {0x418, 0x308}: 0x4E4, {0x438, 0x308}: 0x4E5, {0x41E, 0x308}: 0x4E6,
+	{0x43E, 0x308}: 0x4E7, {0x4E8, 0x308}: 0x4EA, {0x4E9, 0x308}: 0x4EB, {0x42D, 0x308}: 0x4EC,
+	{0x44D, 0x308}: 0x4ED, {0x423, 0x304}: 0x4EE, {0x443, 0x304}: 0x4EF, {0x423, 0x308}: 0x4F0,
+	{0x443, 0x308}: 0x4F1, {0x423, 0x30B}: 0x4F2, {0x443, 0x30B}: 0x4F3, {0x427, 0x308}: 0x4F4,
+	{0x447, 0x308}: 0x4F5, {0x42B, 0x308}: 0x4F8, {0x44B, 0x308}: 0x4F9, {0x41, 0x325}: 0x1E00,
+	{0x61, 0x325}: 0x1E01, {0x42, 0x307}: 0x1E02, {0x62, 0x307}: 0x1E03, {0x42, 0x323}: 0x1E04,
+	{0x62, 0x323}: 0x1E05, {0x42, 0x331}: 0x1E06, {0x62, 0x331}: 0x1E07, {0xC7, 0x301}: 0x1E08,
+	{0xE7, 0x301}: 0x1E09, {0x44, 0x307}: 0x1E0A, {0x64, 0x307}: 0x1E0B, {0x44, 0x323}: 0x1E0C,
+	{0x64, 0x323}: 0x1E0D, {0x44, 0x331}: 0x1E0E, {0x64, 0x331}: 0x1E0F, {0x44, 0x327}: 0x1E10,
+	{0x64, 0x327}: 0x1E11, {0x44, 0x32D}: 0x1E12, {0x64, 0x32D}: 0x1E13, {0x112, 0x300}: 0x1E14,
+	{0x113, 0x300}: 0x1E15, {0x112, 0x301}: 0x1E16, {0x113, 0x301}: 0x1E17, {0x45, 0x32D}: 0x1E18,
+	{0x65, 0x32D}: 0x1E19, {0x45, 0x330}: 0x1E1A, {0x65, 0x330}: 0x1E1B, {0x228, 0x306}: 0x1E1C,
+	{0x229, 0x306}: 0x1E1D, {0x46, 0x307}: 0x1E1E, {0x66, 0x307}: 0x1E1F, {0x47, 0x304}: 0x1E20,
+	{0x67, 0x304}: 0x1E21, {0x48, 0x307}: 0x1E22, {0x68, 0x307}: 0x1E23, {0x48, 0x323}: 0x1E24,
+	{0x68, 0x323}: 0x1E25, {0x48, 0x308}: 0x1E26, {0x68, 0x308}: 0x1E27, {0x48, 0x327}: 0x1E28,
+	{0x68, 0x327}: 0x1E29, {0x48, 0x32E}: 0x1E2A, {0x68, 0x32E}: 0x1E2B, {0x49, 0x330}: 0x1E2C,
+	{0x69, 0x330}: 0x1E2D, {0xCF, 0x301}: 0x1E2E, {0xEF, 0x301}: 0x1E2F, {0x4B, 0x301}: 0x1E30,
+	{0x6B, 0x301}: 0x1E31, {0x4B, 0x323}: 0x1E32, {0x6B, 0x323}: 0x1E33, {0x4B, 0x331}: 0x1E34,
+	{0x6B, 0x331}: 0x1E35, {0x4C, 0x323}: 0x1E36, {0x6C, 0x323}: 0x1E37, {0x1E36, 0x304}: 0x1E38,
+	{0x1E37, 0x304}: 0x1E39, {0x4C, 0x331}: 0x1E3A, {0x6C, 0x331}: 0x1E3B, {0x4C, 0x32D}: 0x1E3C,
+	{0x6C, 0x32D}: 0x1E3D, {0x4D, 0x301}: 0x1E3E, {0x6D, 0x301}: 0x1E3F, {0x4D, 0x307}: 0x1E40,
+	{0x6D, 0x307}: 0x1E41, {0x4D, 0x323}: 0x1E42, {0x6D, 0x323}: 0x1E43, {0x4E, 0x307}: 0x1E44,
+	{0x6E, 0x307}: 0x1E45, {0x4E, 0x323}: 0x1E46, {0x6E, 0x323}: 0x1E47, {0x4E, 0x331}: 0x1E48,
+	{0x6E, 0x331}: 0x1E49, {0x4E, 0x32D}: 0x1E4A, {0x6E, 0x32D}: 0x1E4B, {0xD5, 0x301}: 0x1E4C,
+	{0xF5, 0x301}: 0x1E4D, {0xD5, 0x308}: 0x1E4E, {0xF5, 0x308}: 0x1E4F, {0x14C, 0x300}: 0x1E50,
+	{0x14D, 0x300}: 0x1E51, {0x14C, 0x301}: 0x1E52, {0x14D, 0x301}: 0x1E53, {0x50, 0x301}: 0x1E54,
+	{0x70, 0x301}: 0x1E55, {0x50, 0x307}: 0x1E56, {0x70, 0x307}: 0x1E57, {0x52, 0x307}: 0x1E58,
+	{0x72, 0x307}: 0x1E59, {0x52, 0x323}: 0x1E5A, {0x72, 0x323}: 0x1E5B, {0x1E5A, 0x304}: 0x1E5C,
+	{0x1E5B, 0x304}: 0x1E5D, {0x52, 0x331}: 0x1E5E, {0x72, 0x331}: 0x1E5F, {0x53, 0x307}: 0x1E60,
+	{0x73, 0x307}: 0x1E61, {0x53, 0x323}: 0x1E62, {0x73, 0x323}: 0x1E63, {0x15A, 0x307}: 0x1E64,
+	{0x15B, 0x307}: 0x1E65, {0x160, 0x307}: 0x1E66, {0x161, 0x307}: 0x1E67, {0x1E62, 0x307}: 0x1E68,
+	{0x1E63, 0x307}: 0x1E69, {0x54, 0x307}: 0x1E6A, {0x74, 0x307}: 0x1E6B, {0x54, 0x323}: 0x1E6C,
+	{0x74, 0x323}: 0x1E6D, {0x54, 0x331}: 0x1E6E, {0x74, 0x331}: 0x1E6F, {0x54, 0x32D}: 0x1E70,
+	{0x74, 0x32D}: 0x1E71, {0x55, 0x324}: 0x1E72, {0x75, 0x324}: 0x1E73, {0x55, 0x330}: 0x1E74,
+	{0x75, 0x330}: 0x1E75, {0x55, 0x32D}: 0x1E76, {0x75, 0x32D}: 0x1E77, {0x168, 0x301}: 0x1E78,
+	{0x169, 0x301}: 0x1E79, {0x16A, 0x308}: 0x1E7A, {0x16B, 0x308}: 0x1E7B, {0x56, 0x303}: 0x1E7C,
+	{0x76, 0x303}: 0x1E7D, {0x56, 0x323}: 0x1E7E, {0x76, 0x323}: 0x1E7F, {0x57, 0x300}: 0x1E80,
+	{0x77, 0x300}: 0x1E81, {0x57, 0x301}: 0x1E82, {0x77, 0x301}: 0x1E83, {0x57, 0x308}: 0x1E84,
+	{0x77, 0x308}: 0x1E85, {0x57, 0x307}: 0x1E86, {0x77, 0x307}: 0x1E87, {0x57, 0x323}: 0x1E88,
+	{0x77, 0x323}: 0x1E89, {0x58, 0x307}: 0x1E8A, {0x78, 0x307}: 0x1E8B, {0x58, 0x308}: 0x1E8C,
+	{0x78, 0x308}: 0x1E8D, {0x59, 0x307}: 0x1E8E, {0x79, 0x307}: 0x1E8F, {0x5A, 0x302}: 0x1E90,
+	{0x7A, 0x302}: 0x1E91, {0x5A, 0x323}: 0x1E92, {0x7A, 0x323}: 0x1E93, {0x5A, 0x331}: 0x1E94,
+	{0x7A, 0x331}: 0x1E95, {0x68, 0x331}: 0x1E96, {0x74, 0x308}: 0x1E97, {0x77, 0x30A}: 0x1E98,
+	{0x79, 0x30A}: 0x1E99, {0x17F, 0x307}: 0x1E9B, {0x41, 0x323}: 0x1EA0, {0x61, 0x323}: 0x1EA1,
+	{0x41, 0x309}: 0x1EA2, {0x61, 0x309}: 0x1EA3, {0xC2, 0x301}: 0x1EA4, {0xE2, 0x301}: 0x1EA5,
+	{0xC2, 0x300}: 0x1EA6, {0xE2, 0x300}: 0x1EA7, {0xC2, 0x309}: 0x1EA8, {0xE2, 0x309}: 0x1EA9,
+	{0xC2, 0x303}: 0x1EAA, {0xE2, 0x303}: 0x1EAB, {0x1EA0, 0x302}: 0x1EAC, {0x1EA1, 0x302}: 0x1EAD,
+	{0x102, 0x301}: 0x1EAE, {0x103, 0x301}: 0x1EAF, {0x102, 0x300}: 0x1EB0, {0x103, 0x300}: 0x1EB1,
+	{0x102, 0x309}: 0x1EB2, {0x103, 0x309}: 0x1EB3, {0x102, 0x303}: 0x1EB4, {0x103, 0x303}: 0x1EB5,
+	{0x1EA0, 0x306}: 0x1EB6, {0x1EA1, 0x306}: 0x1EB7, {0x45, 0x323}: 0x1EB8, {0x65, 0x323}: 0x1EB9,
+	{0x45, 0x309}: 0x1EBA, {0x65, 0x309}: 0x1EBB, {0x45, 0x303}: 0x1EBC, {0x65, 0x303}: 0x1EBD,
+	{0xCA, 0x301}: 0x1EBE, {0xEA, 0x301}: 0x1EBF, {0xCA, 0x300}: 0x1EC0, {0xEA, 0x300}: 0x1EC1,
+	{0xCA, 0x309}: 0x1EC2, {0xEA, 0x309}: 0x1EC3, {0xCA, 0x303}: 0x1EC4, {0xEA, 0x303}: 0x1EC5,
+	{0x1EB8, 0x302}: 0x1EC6, {0x1EB9, 0x302}: 0x1EC7, {0x49, 0x309}: 0x1EC8, {0x69, 0x309}: 0x1EC9,
+	{0x49, 0x323}: 0x1ECA, {0x69, 0x323}: 0x1ECB, {0x4F, 0x323}: 0x1ECC, {0x6F, 0x323}: 0x1ECD,
+	{0x4F, 0x309}: 0x1ECE, {0x6F, 0x309}: 0x1ECF, {0xD4, 0x301}: 0x1ED0, {0xF4, 0x301}: 0x1ED1,
+	{0xD4, 0x300}: 0x1ED2, {0xF4, 0x300}: 0x1ED3, {0xD4, 0x309}: 0x1ED4, {0xF4, 0x309}: 0x1ED5,
+	{0xD4, 0x303}: 0x1ED6, {0xF4, 0x303}: 0x1ED7, {0x1ECC, 0x302}: 0x1ED8, {0x1ECD, 0x302}: 0x1ED9,
+	{0x1A0, 0x301}: 0x1EDA, {0x1A1, 0x301}: 0x1EDB, {0x1A0, 0x300}: 0x1EDC, {0x1A1, 0x300}: 0x1EDD,
+	{0x1A0, 0x309}: 0x1EDE, {0x1A1, 0x309}: 0x1EDF, {0x1A0, 0x303}: 0x1EE0, {0x1A1, 0x303}: 0x1EE1,
+	{0x1A0, 0x323}: 0x1EE2, {0x1A1, 0x323}: 0x1EE3, {0x55, 0x323}: 0x1EE4, {0x75, 0x323}: 0x1EE5,
+	{0x55, 0x309}: 0x1EE6, {0x75, 0x309}: 0x1EE7, {0x1AF, 0x301}: 0x1EE8, {0x1B0, 0x301}: 0x1EE9,
+	{0x1AF, 0x300}: 0x1EEA, {0x1B0, 0x300}: 0x1EEB, {0x1AF, 0x309}: 0x1EEC, {0x1B0, 0x309}: 0x1EED,
+	{0x1AF, 0x303}: 0x1EEE, {0x1B0, 0x303}: 0x1EEF, {0x1AF, 0x323}: 0x1EF0, {0x1B0, 0x323}: 0x1EF1,
+	{0x59, 0x300}: 0x1EF2, {0x79, 0x300}: 0x1EF3, {0x59, 0x323}: 0x1EF4, {0x79, 0x323}: 0x1EF5,
+	{0x59, 0x309}: 0x1EF6, {0x79, 0x309}: 0x1EF7, {0x59, 0x303}: 0x1EF8, {0x79, 0x303}: 0x1EF9,
+	{0x3B1, 0x313}: 0x1F00, {0x3B1, 0x314}: 0x1F01, {0x1F00, 0x300}: 0x1F02, {0x1F01, 0x300}: 0x1F03,
+	{0x1F00, 0x301}: 0x1F04, {0x1F01, 0x301}: 0x1F05, {0x1F00, 0x342}: 0x1F06, {0x1F01, 0x342}: 0x1F07,
+	{0x391, 0x313}: 0x1F08, {0x391, 0x314}: 0x1F09, {0x1F08, 0x300}: 0x1F0A, {0x1F09, 0x300}: 0x1F0B,
+	{0x1F08, 0x301}: 0x1F0C, {0x1F09, 0x301}: 0x1F0D, {0x1F08, 0x342}: 0x1F0E, {0x1F09, 0x342}: 0x1F0F,
+	{0x3B5, 0x313}: 0x1F10, {0x3B5, 0x314}: 0x1F11, {0x1F10, 0x300}: 0x1F12, {0x1F11, 0x300}: 0x1F13,
+	{0x1F10, 0x301}: 0x1F14, {0x1F11, 0x301}: 0x1F15, {0x395, 0x313}: 0x1F18, {0x395, 0x314}: 0x1F19,
+	{0x1F18, 0x300}: 0x1F1A, {0x1F19, 0x300}: 0x1F1B, {0x1F18, 0x301}: 0x1F1C, {0x1F19, 0x301}: 0x1F1D,
+	{0x3B7, 0x313}: 0x1F20, {0x3B7, 0x314}: 0x1F21, {0x1F20, 0x300}: 0x1F22, {0x1F21, 0x300}: 0x1F23,
+	{0x1F20, 0x301}: 0x1F24, {0x1F21, 0x301}: 0x1F25, {0x1F20, 0x342}: 0x1F26, {0x1F21, 0x342}: 0x1F27,
+	{0x397, 0x313}: 0x1F28, {0x397, 0x314}: 0x1F29, {0x1F28, 0x300}: 0x1F2A, {0x1F29, 0x300}: 0x1F2B,
+	{0x1F28, 0x301}: 0x1F2C, {0x1F29, 0x301}: 0x1F2D, {0x1F28, 0x342}: 0x1F2E, {0x1F29, 0x342}: 0x1F2F,
+	{0x3B9, 0x313}: 0x1F30, {0x3B9, 0x314}: 0x1F31, {0x1F30, 0x300}: 0x1F32, {0x1F31, 0x300}: 0x1F33,
+	{0x1F30, 0x301}: 0x1F34, {0x1F31, 0x301}: 0x1F35, {0x1F30, 0x342}: 0x1F36, {0x1F31, 0x342}: 0x1F37,
+	{0x399, 0x313}: 0x1F38, {0x399, 0x314}: 0x1F39, {0x1F38, 0x300}: 0x1F3A, {0x1F39, 0x300}: 0x1F3B,
+	{0x1F38, 0x301}: 0x1F3C, {0x1F39, 0x301}: 0x1F3D, {0x1F38, 0x342}: 0x1F3E, {0x1F39, 0x342}: 0x1F3F,
+	{0x3BF, 0x313}: 0x1F40, {0x3BF, 0x314}: 0x1F41, {0x1F40, 0x300}: 0x1F42, {0x1F41, 0x300}: 0x1F43,
+	{0x1F40, 0x301}: 0x1F44, {0x1F41, 0x301}: 0x1F45, {0x39F, 0x313}: 0x1F48, {0x39F, 0x314}: 0x1F49,
+	{0x1F48, 0x300}: 0x1F4A, {0x1F49, 0x300}: 0x1F4B, {0x1F48, 0x301}: 0x1F4C, {0x1F49, 0x301}: 0x1F4D,
+	{0x3C5, 0x313}: 0x1F50, {0x3C5, 0x314}: 0x1F51, {0x1F50, 0x300}: 0x1F52, {0x1F51, 0x300}: 0x1F53,
+	{0x1F50, 0x301}: 0x1F54, {0x1F51, 0x301}: 0x1F55, {0x1F50, 0x342}: 0x1F56, {0x1F51, 0x342}: 0x1F57,
+	{0x3A5, 0x314}: 0x1F59, {0x1F59, 0x300}: 0x1F5B, {0x1F59, 0x301}: 0x1F5D, {0x1F59, 0x342}: 0x1F5F,
+	{0x3C9, 0x313}: 0x1F60, {0x3C9, 0x314}: 0x1F61, {0x1F60, 0x300}: 0x1F62, {0x1F61, 0x300}: 0x1F63,
+	{0x1F60, 0x301}: 0x1F64, {0x1F61, 0x301}: 0x1F65, {0x1F60, 0x342}: 0x1F66, {0x1F61, 0x342}: 0x1F67,
+	{0x3A9, 0x313}: 0x1F68, {0x3A9, 0x314}: 0x1F69, {0x1F68, 0x300}: 0x1F6A, {0x1F69, 0x300}: 0x1F6B,
+	{0x1F68, 0x301}: 0x1F6C, {0x1F69, 0x301}: 0x1F6D, {0x1F68, 0x342}: 0x1F6E, {0x1F69, 0x342}: 0x1F6F,
+	{0x3B1, 0x300}: 0x1F70, {0x3B5, 0x300}: 0x1F72, {0x3B7, 0x300}: 0x1F74, {0x3B9, 0x300}: 0x1F76,
+	{0x3BF, 0x300}: 0x1F78, {0x3C5, 0x300}: 0x1F7A, {0x3C9, 0x300}: 0x1F7C, {0x1F00, 0x345}: 0x1F80,
+	{0x1F01, 0x345}: 0x1F81, {0x1F02, 0x345}: 0x1F82, {0x1F03, 0x345}: 0x1F83, {0x1F04, 0x345}: 0x1F84,
+	{0x1F05, 0x345}: 0x1F85, {0x1F06, 0x345}: 0x1F86, {0x1F07, 0x345}: 0x1F87, {0x1F08, 0x345}: 0x1F88,
+	{0x1F09, 0x345}: 0x1F89, {0x1F0A, 0x345}: 0x1F8A, {0x1F0B, 0x345}: 0x1F8B, {0x1F0C, 0x345}: 0x1F8C,
+	{0x1F0D, 0x345}: 0x1F8D, {0x1F0E, 0x345}: 0x1F8E, {0x1F0F, 0x345}: 0x1F8F, {0x1F20, 0x345}: 0x1F90,
+	{0x1F21, 0x345}: 0x1F91, {0x1F22, 0x345}: 0x1F92, {0x1F23, 0x345}: 0x1F93, {0x1F24, 0x345}: 0x1F94,
+	{0x1F25, 0x345}: 0x1F95, {0x1F26, 0x345}: 0x1F96, {0x1F27, 0x345}: 0x1F97, {0x1F28, 0x345}: 0x1F98,
+	{0x1F29, 0x345}: 0x1F99, {0x1F2A, 0x345}: 0x1F9A, {0x1F2B, 0x345}: 0x1F9B, {0x1F2C, 0x345}: 0x1F9C,
+	{0x1F2D, 0x345}: 0x1F9D, {0x1F2E, 0x345}: 0x1F9E, {0x1F2F, 0x345}: 0x1F9F, {0x1F60, 0x345}: 0x1FA0,
+	{0x1F61, 0x345}: 0x1FA1, {0x1F62, 0x345}: 0x1FA2, {0x1F63, 0x345}: 0x1FA3, {0x1F64, 0x345}: 0x1FA4,
+	{0x1F65, 0x345}: 0x1FA5, {0x1F66, 0x345}: 0x1FA6, {0x1F67, 0x345}: 0x1FA7, {0x1F68, 0x345}: 0x1FA8,
+	{0x1F69, 0x345}: 0x1FA9, {0x1F6A, 0x345}: 0x1FAA, {0x1F6B, 0x345}: 0x1FAB, {0x1F6C, 0x345}: 0x1FAC,
+	{0x1F6D, 0x345}: 0x1FAD, {0x1F6E, 0x345}: 0x1FAE, {0x1F6F, 0x345}: 0x1FAF, {0x3B1, 0x306}: 0x1FB0,
+	{0x3B1, 0x304}: 0x1FB1, {0x1F70, 0x345}: 0x1FB2, {0x3B1, 0x345}: 0x1FB3, {0x3AC, 0x345}: 0x1FB4,
+	{0x3B1, 0x342}: 0x1FB6, {0x1FB6, 0x345}: 0x1FB7, {0x391, 0x306}: 0x1FB8, {0x391, 0x304}: 0x1FB9,
+	{0x391, 0x300}: 0x1FBA, {0x391, 0x345}: 0x1FBC, {0xA8, 0x342}: 0x1FC1, {0x1F74, 0x345}: 0x1FC2,
+	{0x3B7, 0x345}: 0x1FC3, {0x3AE, 0x345}: 0x1FC4, {0x3B7, 0x342}: 0x1FC6, {0x1FC6, 0x345}: 0x1FC7,
+	{0x395, 0x300}: 0x1FC8, {0x397, 0x300}: 0x1FCA, {0x397, 0x345}: 0x1FCC, {0x1FBF, 0x300}: 0x1FCD,
+	{0x1FBF, 0x301}: 0x1FCE, {0x1FBF, 0x342}: 0x1FCF, {0x3B9, 0x306}: 0x1FD0, {0x3B9, 0x304}: 0x1FD1,
+	{0x3CA, 0x300}: 0x1FD2, {0x3B9, 0x342}: 0x1FD6, {0x3CA, 0x342}: 0x1FD7, {0x399, 0x306}: 0x1FD8,
+	{0x399, 0x304}: 0x1FD9, {0x399, 0x300}: 0x1FDA, {0x1FFE, 0x300}: 0x1FDD, {0x1FFE, 0x301}: 0x1FDE,
+	{0x1FFE, 0x342}: 0x1FDF, {0x3C5, 0x306}: 0x1FE0, {0x3C5, 0x304}: 0x1FE1, {0x3CB, 0x300}: 0x1FE2,
+	{0x3C1, 0x313}: 0x1FE4, {0x3C1, 0x314}: 0x1FE5, {0x3C5, 0x342}: 0x1FE6, {0x3CB, 0x342}: 0x1FE7,
+	{0x3A5, 0x306}: 0x1FE8, {0x3A5, 0x304}: 0x1FE9, {0x3A5, 0x300}: 0x1FEA, {0x3A1, 0x314}: 0x1FEC,
+	{0xA8, 0x300}: 0x1FED, {0x1F7C, 0x345}: 0x1FF2, {0x3C9, 0x345}: 0x1FF3, {0x3CE, 0x345}: 0x1FF4,
+	{0x3C9, 0x342}: 0x1FF6, {0x1FF6, 0x345}: 0x1FF7, {0x39F, 0x300}: 0x1FF8, {0x3A9, 0x300}: 0x1FFA,
+	{0x3A9, 0x345}: 0x1FFC,
+}
+
+// The canonical combining classes of the combining marks, from the same
+// database. Other characters are starters, of class 0.
+var combiningClasses = map[rune]uint8{
+	0x300: 230, 0x301: 230, 0x302: 230, 0x303: 230, 0x304: 230, 0x305: 230, 0x306: 230, 0x307: 230,
+	0x308: 230, 0x309: 230, 0x30A: 230, 0x30B: 230, 0x30C: 230, 0x30D: 230, 0x30E: 230, 0x30F: 230,
+	0x310: 230, 0x311: 230, 0x312: 230, 0x313: 230, 0x314: 230, 0x315: 232, 0x316: 220, 0x317: 220,
+	0x318: 220, 0x319: 220, 0x31A: 232, 0x31B: 216, 0x31C: 220, 0x31D: 220, 0x31E: 220, 0x31F: 220,
+	0x320: 220, 0x321: 202, 0x322: 202, 0x323: 220, 0x324: 220, 0x325: 220, 0x326: 220, 0x327: 202,
+	0x328: 202, 0x329: 220, 0x32A: 220, 0x32B: 220, 0x32C: 220, 0x32D: 220, 0x32E: 220, 0x32F: 220,
+	0x330: 220, 0x331: 220, 0x332: 220, 0x333: 220, 0x334: 1, 0x335: 1, 0x336: 1, 0x337: 1,
+	0x338: 1, 0x339: 220, 0x33A: 220, 0x33B: 220, 0x33C: 220, 0x33D: 230, 0x33E: 230, 0x33F: 230,
+	0x340: 230, 0x341: 230, 0x342: 230, 0x343: 230, 0x344: 230, 0x345: 240, 0x346: 230, 0x347: 220,
+	0x348: 220, 0x349: 220, 0x34A: 230, 0x34B: 230, 0x34C: 230, 0x34D: 220, 0x34E: 220, 0x350: 230,
+	0x351: 230, 0x352: 230, 0x353: 220, 0x354: 220, 0x355: 220, 0x356: 220, 0x357: 230, 0x358: 232,
+	0x359: 220, 0x35A: 220, 0x35B: 230, 0x35C: 233, 0x35D: 234, 0x35E: 234, 0x35F: 233, 0x360: 234,
+	0x361: 234, 0x362: 233, 0x363: 230, 0x364: 230, 0x365: 230, 0x366: 230, 0x367: 230, 0x368: 230,
+	0x369: 230, 0x36A: 230, 0x36B: 230, 0x36C: 230, 0x36D: 230, 0x36E: 230, 0x36F: 230, 0x483: 230,
+	0x484: 230, 0x485: 230, 0x486: 230, 0x487: 230, 0x1DC0: 230, 0x1DC1: 230, 0x1DC2: 220, 0x1DC3: 230,
+	0x1DC4: 230, 0x1DC5: 230, 0x1DC6: 230, 0x1DC7: 230, 0x1DC8: 230, 0x1DC9: 230, 0x1DCA: 220, 0x1DCB: 230,
+	0x1DCC: 230, 0x1DCD: 234, 0x1DCE: 214, 0x1DCF: 220, 0x1DD0: 202, 0x1DD1: 230, 0x1DD2: 230, 0x1DD3: 230,
+	0x1DD4: 230, 0x1DD5: 230, 0x1DD6: 230, 0x1DD7: 230, 0x1DD8: 230, 0x1DD9: 230, 0x1DDA: 230, 0x1DDB: 230,
+	0x1DDC: 230, 0x1DDD: 230, 0x1DDE: 230, 0x1DDF: 230, 0x1DE0: 230, 0x1DE1: 230, 0x1DE2: 230, 0x1DE3: 230,
+	0x1DE4: 230, 0x1DE5: 230, 0x1DE6: 230, 0x1DE7: 230, 0x1DE8: 230, 0x1DE9: 230, 0x1DEA: 230, 0x1DEB: 230,
+	0x1DEC: 230, 0x1DED: 230, 0x1DEE: 230, 0x1DEF: 230, 0x1DF0: 230, 0x1DF1: 230, 0x1DF2: 230, 0x1DF3: 230,
+	0x1DF4: 230, 0x1DF5: 230, 0x1DF6: 232, 0x1DF7: 228, 0x1DF8: 228, 0x1DF9: 220, 0x1DFA: 218, 0x1DFB: 230,
+	0x1DFC: 233, 0x1DFD: 220, 0x1DFE: 230, 0x1DFF: 220, 0x20D0: 230, 0x20D1: 230, 0x20D2: 1, 0x20D3: 1,
+	0x20D4: 230, 0x20D5: 230, 0x20D6: 230, 0x20D7: 230, 0x20D8: 1, 0x20D9: 1, 0x20DA: 1, 0x20DB: 230,
+	0x20DC: 230, 0x20E1: 230, 0x20E5: 1, 0x20E6: 1, 0x20E7: 230, 0x20E8: 220, 0x20E9: 230, 0x20EA: 1,
+	0x20EB: 1, 0x20EC: 220, 0x20ED: 220, 0x20EE: 220, 0x20EF: 220, 0x20F0: 230,
+}
+
+// The canonical decompositions of the characters that are never composed
+// again, such as U+0340 COMBINING GRAVE TONE MARK, which is U+0300 COMBINING
+// GRAVE ACCENT, and U+212B ANGSTROM SIGN, which is U+00C5, from the same
+// database.
+var excludedDecompositions = map[rune][]rune{
+	0x340: {0x300}, 0x341: {0x301}, 0x343: {0x313}, 0x344: {0x308, 0x301}, 0x374: {0x2B9},
+	0x37E: {0x3B}, 0x387: {0xB7}, 0x1F71: {0x3AC}, 0x1F73: {0x3AD}, 0x1F75: {0x3AE},
+	0x1F77: {0x3AF}, 0x1F79: {0x3CC}, 0x1F7B: {0x3CD}, 0x1F7D: {0x3CE}, 0x1FBB: {0x386},
+	0x1FBE: {0x3B9}, 0x1FC9: {0x388}, 0x1FCB: {0x389}, 0x1FD3: {0x390}, 0x1FDB: {0x38A},
+	0x1FE3: {0x3B0}, 0x1FEB: {0x38E}, 0x1FEE: {0x385}, 0x1FEF: {0x60}, 0x1FF9: {0x38C},
+	0x1FFB: {0x38F}, 0x1FFD: {0xB4}, 0x2126: {0x3A9}, 0x212A: {0x4B}, 0x212B: {0xC5},
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNFC(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"café", "café"},
+		{"café", "café"},
+		{"Å", "Å"},
+		// A singleton decomposition: the angstrom sign becomes Å.
+		{"Å", "Å"},
+		// Marks are put in canonical order before they are composed.
+		{"ậ", "ậ"},
+		{"ậ", "ậ"},
+		{"ḍ̇", "ḍ̇"},
+		{"/plain/ascii", "/plain/ascii"},
+	} {
+		if got := nfc(tt.in); got != tt.want {
+			t.Errorf("nfc(%+q) = %+q, want %+q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHostToASCII(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"правительство.рф", "xn--80aealotwbjpid2k.xn--p1ai"},
+		{"example.com", "example.com"},
+		{"*.münchen.de", "*.xn--mnchen-3ya.de"},
+	} {
+		if got := hostToASCII(tt.in); got != tt.want {
+			t.Errorf("hostToASCII(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// However a client encodes a path or host, it finds the same redirection.
+func TestUnicodeMatching(t *testing.T) {
+	tr := newTestRedirector(t, `{
+		"redirections": {"/café": "/coffee", "/%C3%BCber": "/about"},
+		"hosts": {"münchen.de": {"/": "/munich"}}
+	}`)
+	tr.expectRedirect("/caf%C3%A9", http.StatusFound, "/coffee")
+	tr.expectRedirect("/cafe%CC%81", http.StatusFound, "/coffee")
+	tr.expectRedirect("/%C3%BCber", http.StatusFound, "/about")
+	tr.expectRedirect("/u%CC%88ber", http.StatusFound, "/about")
+	tr.expectResolves("xn--mnchen-3ya.de", "/", "xn--mnchen-3ya.de/", "/munich")
+}
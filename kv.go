@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Read the configuration from a key-value store instead of -config, and
+// watch it, so a fleet of servers converges on the same configuration
+// within seconds of a change. Each key under -kv-prefix with a .json, .yaml,
+// or .toml extension is a configuration, merged with the others in the
+// order of their keys as the files of a configuration directory are, and
+// signed by the key with .sig added, if configurations must be signed.
+var kvStore *string = flag.String("kv-store", "", `key-value store to read the configuration from instead of -config: "consul" or "etcd"`)
+var kvAddr *string = flag.String("kv-addr", "", "URL of the key-value store's HTTP API (by default, http://127.0.0.1:8500 for Consul and http://127.0.0.1:2379 for etcd)")
+var kvPrefix *string = flag.String("kv-prefix", "fourohfourfound/", "prefix of the configuration's keys in the key-value store")
+
+// A Consul ACL token, or an etcd auth token. CONSUL_HTTP_TOKEN is used for
+// Consul if this isn't set.
+var kvToken *string = flag.String("kv-token", "", "token for the key-value store")
+
+// Where the last configuration read from the store is kept, so the server
+// can start with it while the store is unreachable. The keys are kept as
+// they were read, with their signatures, and checked again when the cache
+// is used, so the cache can't be used to load an unsigned configuration.
+var kvCache *string = flag.String("kv-cache", "", "file to keep the last good configuration from the key-value store in")
+
+// How long a watch waits for a change before asking again, and the longest
+// wait between attempts after failures.
+const (
+	kvWait       = 5 * time.Minute
+	kvMaxBackoff = 30 * time.Second
+)
+
+// A kvBackend reads the keys under a prefix. Given the index returned by an
+// earlier read, it waits until they may have changed since, or ctx is done,
+// before reading them again.
+type kvBackend interface {
+	read(ctx context.Context, index uint64) (kvs map[string][]byte, next uint64, err error)
+}
+
+// The backend for -kv-store.
+func newKVBackend() (kvBackend, error) {
+	client := &http.Client{Timeout: kvWait + 30*time.Second}
+	prefix := strings.TrimPrefix(*kvPrefix, "/")
+	switch *kvStore {
+	case "consul":
+		addr, token := *kvAddr, *kvToken
+		if addr == "" {
+			addr = "http://127.0.0.1:8500"
+		}
+		if token == "" {
+			token = os.Getenv("CONSUL_HTTP_TOKEN")
+		}
+		return &consulKV{strings.TrimSuffix(addr, "/"), prefix, token, client}, nil
+	case "etcd":
+		addr := *kvAddr
+		if addr == "" {
+			addr = "http://127.0.0.1:2379"
+		}
+		return &etcdKV{strings.TrimSuffix(addr, "/"), prefix, *kvToken, client}, nil
+	}
+	return nil, fmt.Errorf("unknown key-value store %q", *kvStore)
+}
+
+// Consul's KV store, read with blocking queries.
+type consulKV struct {
+	addr, prefix, token string
+	client              *http.Client
+}
+
+func (c *consulKV) read(ctx context.Context, index uint64) (map[string][]byte, uint64, error) {
+	query := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", kvWait.String())
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.addr+"/v1/kv/"+c.prefix+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	kvs := make(map[string][]byte)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// There are no keys under the prefix.
+		return kvs, next, nil
+	default:
+		return nil, 0, fmt.Errorf("consul: %s", resp.Status)
+	}
+	var entries []struct {
+		Key   string
+		Value []byte
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("consul: %v", err)
+	}
+	for _, entry := range entries {
+		kvs[entry.Key] = entry.Value
+	}
+	// An index that goes backwards means Consul's was reset; start again.
+	if next < index {
+		next = 0
+	}
+	return kvs, next, nil
+}
+
+// etcd's v3 KV store, through its JSON gateway.
+type etcdKV struct {
+	addr, prefix, token string
+	client              *http.Client
+}
+
+// The end of the range of keys with the prefix, as etcd clients compute it.
+func (e *etcdKV) rangeEnd() []byte {
+	end := []byte(e.prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+func (e *etcdKV) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+	resp, err := e.client.Do(req)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("etcd: %s", resp.Status)
+	}
+	return resp, err
+}
+
+func (e *etcdKV) read(ctx context.Context, index uint64) (map[string][]byte, uint64, error) {
+	if index > 0 {
+		if err := e.wait(ctx, index); err != nil {
+			return nil, 0, err
+		}
+	}
+	resp, err := e.post(ctx, "/v3/kv/range", map[string][]byte{"key": []byte(e.prefix), "range_end": e.rangeEnd()})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		KVs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, fmt.Errorf("etcd: %v", err)
+	}
+	kvs := make(map[string][]byte, len(result.KVs))
+	for _, kv := range result.KVs {
+		kvs[string(kv.Key)] = kv.Value
+	}
+	revision, _ := strconv.ParseUint(result.Header.Revision, 10, 64)
+	return kvs, revision, nil
+}
+
+// Wait for a change to the keys after revision, or for kvWait.
+func (e *etcdKV) wait(ctx context.Context, revision uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, kvWait)
+	defer cancel()
+	resp, err := e.post(ctx, "/v3/watch", map[string]any{"create_request": map[string]any{
+		"key":            []byte(e.prefix),
+		"range_end":      e.rangeEnd(),
+		"start_revision": strconv.FormatUint(revision+1, 10),
+	}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The watch streams a JSON object for each batch of changes.
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var message struct {
+			Result struct {
+				Events          []json.RawMessage `json:"events"`
+				Canceled        bool              `json:"canceled"`
+				CompactRevision string            `json:"compact_revision"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&message); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				// The watch ended, or waited for kvWait; read again.
+				return nil
+			}
+			return err
+		}
+		if len(message.Result.Events) > 0 || message.Result.Canceled || message.Result.CompactRevision != "" {
+			return nil
+		}
+	}
+}
+
+// The configuration in the keys, merged, as JSON.
+func kvConfig(kvs map[string][]byte, prefix string) ([]byte, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	keys := make([]string, 0, len(kvs))
+	for key := range kvs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []configPart
+	for _, key := range keys {
+		format, ok := extensionFormat(key)
+		if !ok || strings.HasSuffix(key, "/") {
+			continue
+		}
+		data := kvs[key]
+		if len(signingKeys) > 0 {
+			signature, signed := kvs[key+signatureSuffix]
+			if !signed {
+				return nil, fmt.Errorf("%s: %v", key, errUnsigned)
+			}
+			if err := verifyConfig(data, string(signature)); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		data, err := configToJSON(data, format)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		parts = append(parts, configPart{strings.TrimPrefix(key, prefix), data})
+	}
+	if parts == nil {
+		return nil, fmt.Errorf("no configuration under %s", prefix)
+	}
+	return mergeConfigs(parts)
+}
+
+// Load the configuration from the key-value store, or, if it can't be read,
+// the last good one from -kv-cache. It returns the index to watch from, and
+// the configuration's digest.
+func (redir *Redirector) loadKV(kv kvBackend) (uint64, string, error) {
+	kvs, index, err := kv.read(context.Background(), 0)
+	var config []byte
+	if err == nil {
+		config, err = kvConfig(kvs, *kvPrefix)
+	}
+	if err == nil {
+		if err = redir.LoadConfig(config); err == nil {
+			redir.markLoaded()
+			writeKVCache(kvs)
+			return index, configETag(config), nil
+		}
+	}
+	if *kvCache == "" {
+		return 0, "", err
+	}
+	log.Printf("key-value store: %v; starting with the configuration cached in %s\n", err, *kvCache)
+	cached, cacheErr := readKVCache()
+	if cacheErr == nil {
+		cacheErr = redir.LoadConfig(cached)
+	}
+	if cacheErr != nil {
+		return 0, "", fmt.Errorf("%v, and the cache can't be used: %v", err, cacheErr)
+	}
+	redir.markLoaded()
+	return 0, configETag(cached), nil
+}
+
+// Watch the key-value store, replacing the live configuration whenever the
+// store's changes. While the store is unreachable, or its configuration is
+// invalid, the live configuration is kept, and the error is reported by
+// /_ready. Index and digest are loadKV's.
+func (redir *Redirector) watchKV(kv kvBackend, index uint64, last string) {
+	backoff := time.Second
+	for {
+		kvs, next, err := kv.read(context.Background(), index)
+		var config []byte
+		if err == nil {
+			config, err = kvConfig(kvs, *kvPrefix)
+		}
+		if err != nil {
+			log.Println("key-value store:", err)
+			redir.mu.Lock()
+			redir.loadErr = err
+			redir.mu.Unlock()
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > kvMaxBackoff {
+				backoff = kvMaxBackoff
+			}
+			continue
+		}
+		backoff, index = time.Second, next
+		if digest := configETag(config); digest != last {
+			last = digest
+			redir.applyKV(kvs, config)
+		}
+	}
+}
+
+// Replace the live configuration with one from the keys of the key-value
+// store.
+func (redir *Redirector) applyKV(kvs map[string][]byte, config []byte) {
+	changes, err := redir.ApplyConfig(config, true, "", false)
+	if err != nil {
+		log.Println("configuration from the key-value store not applied, keeping the live one:", err)
+		return
+	}
+	redir.markLoaded()
+	writeKVCache(kvs)
+	if changes.Added+changes.Updated+changes.Removed > 0 {
+		log.Printf("applied the configuration from the key-value store: %d added, %d updated, %d removed\n", changes.Added, changes.Updated, changes.Removed)
+		redir.notify(&Event{Type: EventConfigApplied, Time: redir.Clock().Now(), Changes: &changes})
+	}
+}
+
+// Keep the keys of a configuration from the key-value store in -kv-cache,
+// replacing the file at once so a crash can't leave half of one.
+func writeKVCache(kvs map[string][]byte) {
+	if *kvCache == "" {
+		return
+	}
+	data, err := json.Marshal(kvs)
+	if err == nil {
+		err = writeFileAtomically(*kvCache, data)
+	}
+	if err != nil {
+		log.Println("kv-cache:", err)
+	}
+}
+
+// The configuration in the keys kept in -kv-cache, merged and checked against
+// their signatures as if they had just been read from the store.
+func readKVCache() ([]byte, error) {
+	data, err := os.ReadFile(*kvCache)
+	if err != nil {
+		return nil, err
+	}
+	var kvs map[string][]byte
+	if err = json.Unmarshal(data, &kvs); err != nil {
+		return nil, fmt.Errorf("%s: %v", *kvCache, err)
+	}
+	return kvConfig(kvs, *kvPrefix)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A key-value store that can't be reached.
+type downKV struct{}
+
+func (downKV) read(ctx context.Context, index uint64) (map[string][]byte, uint64, error) {
+	return nil, 0, errors.New("connection refused")
+}
+
+// A key-value store with the keys in kvs.
+type fixedKV map[string][]byte
+
+func (kv fixedKV) read(ctx context.Context, index uint64) (map[string][]byte, uint64, error) {
+	return kv, 1, nil
+}
+
+// The cache is checked against the signatures, as the store is.
+func TestKVCacheSigned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := signingKeys
+	signingKeys = []ed25519.PublicKey{public}
+	t.Cleanup(func() { signingKeys = previous })
+	*kvCache = filepath.Join(t.TempDir(), "cache.json")
+	t.Cleanup(func() { *kvCache = "" })
+
+	config := []byte(`{"redirections": {"/a": "/b"}}`)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(private, config))
+	store := fixedKV{*kvPrefix + "10-a.json": config, *kvPrefix + "10-a.json.sig": []byte(signature)}
+	if _, _, err := newTestRedirector(t, `{}`).loadKV(store); err != nil {
+		t.Fatal(err)
+	}
+
+	// With the store down, the cache is used.
+	tr := newTestRedirector(t, `{}`)
+	if _, _, err := tr.loadKV(downKV{}); err != nil {
+		t.Fatal(err)
+	}
+	tr.expectRedirect("/a", http.StatusFound, "/b")
+
+	// But not once it is changed or unsigned, or in another form.
+	evil := []byte(`{"redirections": {"/a": "/evil"}}`)
+	for _, cache := range []any{
+		map[string][]byte{*kvPrefix + "10-a.json": evil, *kvPrefix + "10-a.json.sig": []byte(signature)},
+		map[string][]byte{*kvPrefix + "10-a.json": evil},
+		json.RawMessage(evil),
+	} {
+		data, _ := json.Marshal(cache)
+		if err := os.WriteFile(*kvCache, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := newTestRedirector(t, `{}`).loadKV(downKV{}); err == nil {
+			t.Errorf("loaded the cache %s", data)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Caps that keep the process stable under attack or overload.
+var maxRequests *int = flag.Int("max-requests", 1000, "requests handled at once before the rest get a 503 (0 for no limit)")
+var maxBackground *int = flag.Int("max-background", 16, "background goroutines, such as webhook deliveries, run at once (0 for no limit)")
+
+// Limits on request bodies, so no client can make the server read more into
+// memory than it needs. Configurations may be much larger than anything else.
+var maxBodySize *int64 = flag.Int64("max-body-size", 1<<20, "largest request body taken by the admin endpoints, in bytes")
+var maxConfigSize *int64 = flag.Int64("max-config-size", 64<<20, "largest configuration taken by PUT /_config, in bytes")
+
+// A gate counts the work in progress and turns work away beyond max, if max
+// is positive.
+type gate struct {
+	max      int64
+	current  atomic.Int64
+	rejected atomic.Int64
+}
+
+func newGate(max int) *gate {
+	return &gate{max: int64(max)}
+}
+
+// Enter the gate, reporting whether there was room. Callers that enter must
+// leave.
+func (g *gate) enter() bool {
+	if n := g.current.Add(1); g.max > 0 && n > g.max {
+		g.current.Add(-1)
+		g.rejected.Add(1)
+		return false
+	}
+	return true
+}
+
+func (g *gate) leave() {
+	g.current.Add(-1)
+}
+
+// Run fn in a new goroutine if there is room, otherwise in this one.
+func (g *gate) goOrRun(fn func()) {
+	if !g.enter() {
+		fn()
+		return
+	}
+	go func() {
+		defer g.leave()
+		fn()
+	}()
+}
+
+// The gates for requests in flight and background goroutines.
+var (
+	inFlight   = newGate(0)
+	background = newGate(0)
+)
+
+// Paths that are answered however busy the server is, so that probes and
+// operators can still see what is going on. The live stream of hits has its
+// own limit, and would otherwise hold a place for as long as it is open.
+var ungatedPaths = map[string]bool{"/_health": true, "/_ready": true, "/_status": true, "/_stats/stream": true}
+
+// Limit the requests handler serves at once. Requests beyond the limit are
+// refused with http.StatusServiceUnavailable and a Retry-After.
+func limitInFlight(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ungatedPaths[req.URL.Path] {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		if !inFlight.enter() {
+			log.Println(realAddr(req), "overloaded", req.Method, req.URL.Path)
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "Service unavailable")
+			return
+		}
+		defer inFlight.leave()
+		handler.ServeHTTP(w, req)
+	})
+}
+
+type gateStatus struct {
+	Current  int64 `json:"current"`
+	Max      int64 `json:"max"`
+	Rejected int64 `json:"rejected"`
+}
+
+func (g *gate) status() gateStatus {
+	return gateStatus{Current: g.current.Load(), Max: g.max, Rejected: g.rejected.Load()}
+}
+
+// StatusHandler reports the load on the process: requests in flight,
+// background goroutines, and the webhook queue, along with how much memory
+// sharing rules and destinations saves, the requests filtered as garbage, and
+// the latest probes of its own paths, replication to its peers, and the hooks
+// requests go through.
+func (redir *Redirector) StatusHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			status := struct {
+				Uptime     string     `json:"uptime"`
+				Requests   gateStatus `json:"requests"`
+				Background gateStatus `json:"background"`
+				Goroutines int        `json:"goroutines"`
+				Webhooks   struct {
+					Queued  int   `json:"queued"`
+					Dropped int64 `json:"dropped"`
+					Failed  int64 `json:"failed"`
+				} `json:"webhooks"`
+				Filtered  map[string]int64 `json:"filtered"`
+				Interning InternStats      `json:"interning"`
+				Probes    []probeResult    `json:"probes,omitempty"`
+				Peers     []peerStatus     `json:"peers,omitempty"`
+				Hooks     []string         `json:"hooks"`
+			}{
+				Uptime:     time.Since(started).Round(time.Second).String(),
+				Requests:   inFlight.status(),
+				Background: background.status(),
+				Goroutines: runtime.NumGoroutine(),
+				Filtered:   filteredReport(),
+				Probes:     redir.probes.report(),
+				Peers:      redir.peers.status(),
+				Hooks:      redir.hookNames(),
+			}
+			status.Webhooks.Queued = len(redir.sink.queue)
+			redir.sink.mu.Lock()
+			status.Webhooks.Dropped, status.Webhooks.Failed = redir.sink.dropped, redir.sink.failed
+			redir.sink.mu.Unlock()
+			redir.mu.RLock()
+			status.Interning = redir.Redirections.internStats()
+			redir.mu.RUnlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(&status)
+		})
+	}
+}
+
+// Limit the body of each request to -max-body-size, or -max-config-size for
+// /_config and the access logs POSTed to /_suggest. Bodies declared larger
+// are refused before they are read.
+func limitBodies(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		limit := *maxBodySize
+		if req.URL.Path == "/_config" || req.URL.Path == "/_suggest" {
+			limit = *maxConfigSize
+		}
+		if req.ContentLength > limit {
+			log.Println(realAddr(req), "refused", req.Method, req.URL.Path, "with a body of", req.ContentLength, "bytes")
+			writeError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, limit)
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// Read a request's body. If it can't be read, or is over the limit, the
+// client is told so, and ok is false.
+func readBody(w http.ResponseWriter, req *http.Request) (body []byte, ok bool) {
+	body, err := io.ReadAll(req.Body)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		log.Println(realAddr(req), "refused", req.Method, req.URL.Path, "with a body over", tooLarge.Limit, "bytes")
+		writeError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return nil, false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "Error reading request")
+		return nil, false
+	}
+	return body, true
+}
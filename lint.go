@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Lint the configuration and exit, instead of serving it.
+var lintOnly *bool = flag.Bool("lint", false, "report questionable but valid rules in the configuration and exit, with a nonzero status if there are warnings")
+var lintFormat *string = flag.String("lint-format", "text", `how -lint reports: "text" or "json"`)
+
+// A LintIssue is something in a configuration that works, but probably
+// isn't what was meant, or won't age well.
+type LintIssue struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Source   string `json:"source,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Lint severities, most severe first. Errors are rules that can't work as
+// meant, such as redirect loops; warnings are likely mistakes; notices are
+// good practice.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNotice  = "notice"
+)
+
+var severities = []string{SeverityError, SeverityWarning, SeverityNotice}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Lint checks.
+const (
+	LintStaleTemporary = "stale-temporary"
+	LintInsecure       = "insecure-destination"
+	LintChain          = "chain"
+	LintLoop           = "loop"
+	LintNoOwner        = "no-owner"
+	LintNoNotes        = "no-notes"
+	LintBroadWildcard  = "broad-wildcard"
+)
+
+// How long a temporary redirect can go unchanged before it is probably
+// permanent.
+const staleTemporary = 365 * 24 * time.Hour
+
+// The hops followed looking for a chain's end.
+const maxChainHops = 10
+
+// Lint the redirections and each profile's. A temporary redirect's age is
+// from when it was last changed, according to changed, or else from its
+// created_at, its start, or when it was modified, according to modified,
+// which only knows about changes since the server started. changed and
+// modified only cover the redirections outside profiles. code is the
+// redirection code for rules without one. The caller must hold one of the
+// Redirector's locks.
+func (config *Config) lint(code int, changed, modified map[string]time.Time, now time.Time) []LintIssue {
+	var issues []LintIssue
+	check := func(profile string, rules Rules) {
+		rules.Each(func(source string, rule *Rule) {
+			report := func(severity, check, format string, args ...any) {
+				issues = append(issues, LintIssue{severity, check, source, profile, fmt.Sprintf(format, args...)})
+			}
+			if rule.Owner == "" {
+				report(SeverityNotice, LintNoOwner, "no owner to ask about it")
+			}
+			if rule.Description == "" && rule.Ticket == "" {
+				report(SeverityNotice, LintNoNotes, "no description or ticket saying what it is for")
+			}
+			if isWildcard(source) && broadWildcard(source) {
+				report(SeverityWarning, LintBroadWildcard, "matches almost any path")
+			}
+			if rule.Respond != nil || rule.proxy != nil {
+				return
+			}
+			to, _ := config.expand(rule.To)
+			if strings.HasPrefix(strings.ToLower(to), "http://") {
+				report(SeverityWarning, LintInsecure, "redirects to %s over plain HTTP", to)
+			}
+			ruleCode := rule.Code
+			if ruleCode == 0 {
+				ruleCode = code
+			}
+			if temporary(ruleCode) {
+				var since time.Time
+				var ok bool
+				if profile == "" {
+					since, ok = changed[source]
+				}
+				if !ok && !rule.CreatedAt.IsZero() {
+					since, ok = rule.CreatedAt, true
+				}
+				if !ok && rule.schedule != nil {
+					since, ok = rule.schedule.start.in(config.location(rule)), !rule.schedule.start.IsZero()
+				}
+				if !ok && profile == "" {
+					since, ok = modified[source]
+				}
+				if age := now.Sub(since); ok && age > staleTemporary {
+					report(SeverityWarning, LintStaleTemporary, "temporary %d redirect unchanged for %d days; make it permanent with 301 or 308",
+						ruleCode, int(age.Hours()/24))
+				}
+			}
+			if hops, loop := config.chain(source, to); loop {
+				report(SeverityError, LintLoop, "redirects in a loop: %s", strings.Join(hops, " -> "))
+			} else if len(hops) > 2 {
+				report(SeverityWarning, LintChain, "takes %d redirects: %s", len(hops)-1, strings.Join(hops, " -> "))
+			}
+		})
+	}
+	check("", config.Redirections)
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		check(name, config.Profiles[name])
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		if a.Severity != b.Severity {
+			return severityRank(a.Severity) < severityRank(b.Severity)
+		}
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		return a.Source < b.Source
+	})
+	return issues
+}
+
+// Whether a redirection code is temporary.
+func temporary(code int) bool {
+	return code == http.StatusFound || code == http.StatusSeeOther || code == http.StatusTemporaryRedirect
+}
+
+// Whether a wildcard source has no fixed segments, so it matches nearly
+// every path of its length.
+func broadWildcard(source string) bool {
+	for _, segment := range strings.Split(source, "/")[1:] {
+		if segment != "*" && !isPlaceholder(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+// Follow the redirects from source to its destination, to, through the
+// redirections that destination matches in turn, returning the paths it
+// goes through and whether it comes back to one of them. Destinations with
+// placeholders depend on the request, so they aren't followed.
+func (config *Config) chain(source, to string) (hops []string, loop bool) {
+	hops = []string{source}
+	seen := map[string]bool{source: true}
+	for len(hops) <= maxChainHops {
+		if !strings.HasPrefix(to, "/") || strings.HasPrefix(to, "//") || strings.Contains(to, "{") {
+			return append(hops, to), false
+		}
+		path := to
+		if i := strings.IndexAny(path, "?#"); i >= 0 {
+			path = path[:i]
+		}
+		// Decoded, as the path of the request the client makes.
+		path = decodeSource(path)
+		hops = append(hops, to)
+		if seen[pathKey(path)] {
+			return hops, true
+		}
+		seen[pathKey(path)] = true
+		rule, _, destination, ok := config.lookup("", path)
+		if !ok || rule.Respond != nil || rule.proxy != nil {
+			return hops, false
+		}
+		to = destination
+	}
+	return hops, false
+}
+
+// When each rule was last changed, according to the audit log, if there is
+// one.
+func (audit *auditLog) lastChanged(ctx context.Context) (map[string]time.Time, error) {
+	changed := make(map[string]time.Time)
+	if audit == nil {
+		return changed, nil
+	}
+	events, err := audit.Query(ctx, "", time.Time{}, time.Time{}, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if _, ok := changed[event.Source]; !ok && event.Source != "" {
+			changed[event.Source] = event.Time
+		}
+	}
+	return changed, nil
+}
+
+// Lint the configuration.
+func (redir *Redirector) Lint(ctx context.Context) ([]LintIssue, error) {
+	changed, err := redir.audit.lastChanged(ctx)
+	if err != nil {
+		return nil, err
+	}
+	redir.mu.RLock()
+	defer redir.mu.RUnlock()
+	return redir.lint(redir.code, changed, redir.ruleModified, redir.Clock().Now()), nil
+}
+
+// Write issues in format, reporting whether there were any errors or
+// warnings.
+func writeLint(w io.Writer, issues []LintIssue, format string) (bool, error) {
+	failed := false
+	for _, issue := range issues {
+		failed = failed || issue.Severity != SeverityNotice
+	}
+	if format == "json" {
+		if issues == nil {
+			issues = []LintIssue{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return failed, enc.Encode(issues)
+	}
+	for _, issue := range issues {
+		where := issue.Source
+		if issue.Profile != "" {
+			where = "profile " + issue.Profile + " " + where
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s: %s (%s)\n", issue.Severity, where, issue.Message, issue.Check); err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// The LintHandler lints the configuration (GET /_lint), reporting issues of
+// the given severity or worse (with ?severity=, every issue by default).
+func (redir *Redirector) LintHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			worst := SeverityNotice
+			if value := req.URL.Query().Get("severity"); value != "" {
+				if severityRank(value) < 0 {
+					writeError(w, http.StatusBadRequest, "Bad severity; one of: "+strings.Join(severities, ", "))
+					return
+				}
+				worst = value
+			}
+			issues, err := redir.Lint(req.Context())
+			if err != nil {
+				log.Println("lint:", err)
+				writeError(w, http.StatusInternalServerError, "Error reading audit log")
+				return
+			}
+			filtered := []LintIssue{}
+			for _, issue := range issues {
+				if severityRank(issue.Severity) <= severityRank(worst) {
+					filtered = append(filtered, issue)
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetEscapeHTML(false)
+			enc.Encode(filtered)
+		})
+	}
+}
+
+// Lint the configuration file for -lint, returning the exit status.
+func lintConfig() int {
+	if *lintFormat != "text" && *lintFormat != "json" {
+		fmt.Fprintln(os.Stderr, `-lint-format must be "text" or "json"`)
+		return 2
+	}
+	redir := NewRedirector()
+	redir.code = *redirectionCode
+	if err := redir.LoadConfigFile(*configFile); err != nil {
+		fmt.Fprintln(os.Stderr, *configFile+":", err)
+		return 1
+	}
+	if *auditLogFile != "" {
+		redir.audit = &auditLog{path: *auditLogFile}
+	}
+	issues, err := redir.Lint(context.Background())
+	if err == nil {
+		var failed bool
+		if failed, err = writeLint(os.Stdout, issues, *lintFormat); err == nil && failed {
+			return 1
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "lint:", err)
+		return 1
+	}
+	return 0
+}
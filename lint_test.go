@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The checks lint reports for source, outside profiles.
+func lintChecks(t *testing.T, tr *testRedirector, source string) map[string]bool {
+	t.Helper()
+	issues, err := tr.Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := make(map[string]bool)
+	for _, issue := range issues {
+		if issue.Source == source && issue.Profile == "" {
+			checks[issue.Check] = true
+		}
+	}
+	return checks
+}
+
+func TestLintStaleTemporary(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	manual := NewManualClock(start)
+	tr := newTestRedirectorWithClock(t, manual, `{"redirections": {
+		"/old": {"to": "/new", "created_at": "2024-01-01T00:00:00Z"},
+		"/recent": {"to": "/new", "created_at": "2025-12-01T00:00:00Z"},
+		"/moved": {"to": "/new", "code": 301, "created_at": "2024-01-01T00:00:00Z"},
+		"/undated": "/new"
+	}}`)
+	if !lintChecks(t, tr, "/old")[LintStaleTemporary] {
+		t.Error("/old: not stale by its created_at")
+	}
+	for _, source := range []string{"/recent", "/moved", "/undated"} {
+		if lintChecks(t, tr, source)[LintStaleTemporary] {
+			t.Errorf("%s: stale", source)
+		}
+	}
+
+	// Without a created_at, the age is from when it was loaded.
+	manual.Advance(400 * 24 * time.Hour)
+	if !lintChecks(t, tr, "/undated")[LintStaleTemporary] {
+		t.Error("/undated: not stale a year after it was loaded")
+	}
+	tr.expectStatus(tr.do("PUT", "/undated", "/newer"), http.StatusOK)
+	if lintChecks(t, tr, "/undated")[LintStaleTemporary] {
+		t.Error("/undated: stale just after it changed")
+	}
+}
+
+func TestLintNotes(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {
+		"/bare": "/new",
+		"/described": {"to": "/new", "description": "Spring sale landing page"},
+		"/ticketed": {"to": "/new", "ticket": "MKT-42", "owner": "growth"}
+	}}`)
+	for source, want := range map[string]bool{"/bare": true, "/described": false, "/ticketed": false} {
+		if got := lintChecks(t, tr, source)[LintNoNotes]; got != want {
+			t.Errorf("%s: no-notes %v, want %v", source, got, want)
+		}
+	}
+	if lintChecks(t, tr, "/ticketed")[LintNoOwner] || !lintChecks(t, tr, "/described")[LintNoOwner] {
+		t.Error("wrong no-owner notices")
+	}
+}
+
+func TestLintHandler(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/bare": "/new", "/plain": "http://example.com/"}}`)
+	issues := func(target string) map[string]string {
+		t.Helper()
+		w := tr.do("GET", target, "")
+		tr.expectStatus(w, http.StatusOK)
+		var list []LintIssue
+		tr.decode(w, &list)
+		severities := make(map[string]string)
+		for _, issue := range list {
+			severities[issue.Source+" "+issue.Check] = issue.Severity
+		}
+		return severities
+	}
+	all := issues("/_lint")
+	if all["/bare "+LintNoNotes] != SeverityNotice {
+		t.Errorf("no notice for /bare: %v", all)
+	}
+	warnings := issues("/_lint?severity=" + SeverityWarning)
+	if warnings["/plain "+LintInsecure] != SeverityWarning {
+		t.Errorf("no warning for /plain: %v", warnings)
+	}
+	for check, severity := range warnings {
+		if severity == SeverityNotice {
+			t.Errorf("%s: got a notice asking for warnings", check)
+		}
+	}
+	tr.expectStatus(tr.do("GET", "/_lint?severity=dire", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "GET", "/_lint", ""), http.StatusUnauthorized)
+}
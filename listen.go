@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Where to listen, instead of -host and -port: host:port, or unix: followed
+// by the path of a Unix domain socket. Several addresses are separated by
+// commas.
+var listenFlag *string = flag.String("listen", "", "comma-separated addresses to listen on, as host:port or unix:/path/to/socket (overrides -host and -port)")
+
+// Where to listen for HTTPS, with the certificate and key below.
+var tlsListen *string = flag.String("tls-listen", "", "comma-separated addresses to listen on for HTTPS")
+
+// The certificate and key files for -tls-listen, in PEM. With -tls-cert-dir,
+// this is the certificate for clients asking for names it has none for.
+var tlsCert *string = flag.String("tls-cert", "", "TLS certificate file for -tls-listen")
+var tlsKey *string = flag.String("tls-key", "", "TLS key file for -tls-listen")
+
+// Where to serve the admin and configuration endpoints, if not alongside the
+// redirections. When this is set, the other listeners only serve lookups and
+// health checks, so the admin surface is never exposed on them.
+var adminListen *string = flag.String("admin-listen", "", "comma-separated addresses to serve the admin endpoints on, instead of the other listeners")
+
+// An address to listen on, and how to serve it.
+type endpoint struct {
+	addr  string
+	tls   bool
+	admin bool
+}
+
+// The endpoints to listen on, given the default address from -host and -port.
+func endpoints(addr string) ([]endpoint, error) {
+	if *listenFlag != "" {
+		addr = *listenFlag
+	}
+	var list []endpoint
+	add := func(addrs string, tls, admin bool) {
+		for _, addr := range strings.Split(addrs, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				list = append(list, endpoint{addr, tls, admin})
+			}
+		}
+	}
+	add(addr, false, false)
+	add(*tlsListen, true, false)
+	add(*adminListen, false, true)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return nil, errors.New("-tls-cert and -tls-key go together")
+	}
+	if *tlsListen != "" && *tlsCert == "" && *tlsCertDir == "" {
+		return nil, errors.New("-tls-listen needs -tls-cert and -tls-key, or -tls-cert-dir")
+	}
+	return list, nil
+}
+
+// What the endpoint serves, for logging.
+func (e endpoint) kind() string {
+	switch {
+	case e.admin:
+		return "(admin)"
+	case e.tls:
+		return "(https)"
+	}
+	return "(http)"
+}
+
+// The addresses of endpoints, in order.
+func endpointAddrs(list []endpoint) []string {
+	addrs := make([]string, len(list))
+	for i, e := range list {
+		addrs[i] = e.addr
+	}
+	return addrs
+}
+
+// Listen on each of addrs, closing them all if any fails.
+func listenAll(addrs []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		listener, err := listenOn(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// The permissions of a Unix domain socket, so that only the proxy in front of
+// the server can connect to it.
+var socketMode *string = flag.String("socket-mode", "0660", "permissions of the Unix domain socket")
+
+// Listen on addr, which may name a Unix domain socket. A stale socket left
+// behind by a server that is no longer running is replaced.
+func listenOn(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", strings.TrimPrefix(addr, "tcp:"))
+	}
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		return nil, errors.New("bad -socket-mode " + strconv.Quote(*socketMode))
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, errors.New(path + " is in use")
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(path)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, os.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Whether the request came in over a Unix domain socket. Who may connect to
+// the socket is up to its permissions, so its peers count as local.
+func fromUnixSocket(req *http.Request) bool {
+	addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// Keep a Unix domain socket's file when its listener is closed, because a
+// restarted server has taken it over.
+func keepSocket(listener net.Listener) {
+	if unix, ok := listener.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(false)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+// A Redirector with n exact redirections, /r/0 to /r/n-1, and n/10
+// wildcards, /w/0/{id} to /w/n/10-1/{id}, each with a more specific
+// /w/i/{id}/edit beside it.
+func benchRedirector(b *testing.B, n int) *Redirector {
+	b.Helper()
+	redir := NewRedirector()
+	candidate := redir.Config.clone()
+	for i := 0; i < n; i++ {
+		candidate.Redirections.Set(fmt.Sprintf("/r/%d", i), &Rule{To: fmt.Sprintf("https://example.com/%d", i)})
+	}
+	for i := 0; i < n/10; i++ {
+		candidate.Redirections.Set(fmt.Sprintf("/w/%d/{id}", i), &Rule{To: "https://example.com/item/{id}"})
+		candidate.Redirections.Set(fmt.Sprintf("/w/%d/{id}/edit", i), &Rule{To: "https://example.com/edit/{id}"})
+	}
+	if err := candidate.compile(); err != nil {
+		b.Fatal(err)
+	}
+	redir.update.Lock()
+	redir.mu.Lock()
+	redir.Config = *candidate
+	redir.changed("benchmark")
+	redir.mu.Unlock()
+	redir.update.Unlock()
+	return redir
+}
+
+var benchSizes = []int{1000, 100000, 500000}
+
+func BenchmarkLookupExact(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			config := benchRedirector(b, n).live.Load()
+			paths := benchPaths("/r/%d", n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, _, ok := config.lookup("", paths[i%len(paths)]); !ok {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLookupWildcard(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			config := benchRedirector(b, n).live.Load()
+			paths := benchPaths("/w/%d/42/edit", n/10)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, _, ok := config.lookup("", paths[i%len(paths)]); !ok {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+// The wildcard scan the tree replaced, trying each wildcard in turn, for
+// comparison with BenchmarkLookupWildcard.
+func BenchmarkLookupWildcardLinear(b *testing.B) {
+	for _, n := range benchSizes[:2] {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			wildcards := benchRedirector(b, n).live.Load().wildcards.all()
+			paths := benchPaths("/w/%d/42/edit", n/10)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				path, found := paths[i%len(paths)], false
+				for _, w := range wildcards {
+					if _, found = w.match(path); found {
+						break
+					}
+				}
+				if !found {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+// Lookups from many goroutines while redirections are added, which they
+// don't wait for.
+func BenchmarkLookupDuringChanges(b *testing.B) {
+	redir := benchRedirector(b, 100000)
+	paths := benchPaths("/r/%d", 100000)
+	var stop atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; !stop.Load(); i++ {
+			redir.AddRedirection(fmt.Sprintf("/added/%d", i), &Rule{To: "https://example.com/added"})
+		}
+	}()
+	b.ReportAllocs()
+	b.ResetTimer()
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			path := paths[int(next.Add(1))%len(paths)]
+			if _, _, _, ok := redir.live.Load().lookup("", path); !ok {
+				b.Error("no match")
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	stop.Store(true)
+	<-done
+}
+
+// A whole request for a redirection, as the handler serves it.
+func BenchmarkGet(b *testing.B) {
+	redir := benchRedirector(b, 100000)
+	req := httptest.NewRequest("GET", "/r/4242", nil)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		redir.Get(httptest.NewRecorder(), req)
+	}
+}
+
+func benchPaths(format string, n int) []string {
+	paths := make([]string, 1024)
+	for i := range paths {
+		paths[i] = fmt.Sprintf(format, i*7919%n)
+	}
+	return paths
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maintenance makes the whole server, or only the paths under its
+// Prefixes, answer with a 503 and a holding page instead of redirecting,
+// such as during a migration when the destinations aren't live yet. Each
+// prefix may have its own page and Retry-After, and otherwise has the
+// defaults':
+//
+//	"maintenance": {
+//	  "retry_after": "30m",
+//	  "page": "<h1>Back soon</h1>",
+//	  "prefixes": {"/shop/": {"page": "<h1>The shop is moving</h1>", "retry_after": "2h"}, "/blog/": {}}
+//	}
+type Maintenance struct {
+	HoldingPage
+	Prefixes map[string]HoldingPage `json:"prefixes,omitempty"`
+	prefixes []string
+	pages    map[string]*HoldingPage
+}
+
+// A HoldingPage is an HTML template, rendered with the request's URL, and
+// how long clients are told to wait, as a duration such as "30m".
+type HoldingPage struct {
+	Page       string `json:"page,omitempty"`
+	RetryAfter string `json:"retry_after,omitempty"`
+	page       *template.Template
+	retryAfter time.Duration
+}
+
+// The holding page and Retry-After unless the configuration has its own.
+const (
+	defaultHoldingPage = "<!DOCTYPE html>\n<title>Down for maintenance</title>\n<h1>Down for maintenance</h1>\n<p>This page will be back shortly.</p>\n"
+	defaultRetryAfter  = 5 * time.Minute
+)
+
+// Check and parse the holding pages.
+func (m *Maintenance) compile() error {
+	if err := m.HoldingPage.compile("maintenance", nil); err != nil {
+		return err
+	}
+	m.prefixes, m.pages = nil, make(map[string]*HoldingPage, len(m.Prefixes))
+	for prefix, page := range m.Prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("maintenance prefix %q does not start with /", prefix)
+		}
+		if err := page.compile("maintenance prefix "+prefix, &m.HoldingPage); err != nil {
+			return err
+		}
+		m.prefixes = append(m.prefixes, prefix)
+		m.pages[prefix] = &page
+	}
+	// The longest prefix covering a path decides its page.
+	sort.Slice(m.prefixes, func(i, j int) bool { return len(m.prefixes[i]) > len(m.prefixes[j]) })
+	return nil
+}
+
+// Parse a holding page, taking what it leaves out from defaults, if any.
+func (hp *HoldingPage) compile(name string, defaults *HoldingPage) (err error) {
+	hp.page, hp.retryAfter = nil, defaultRetryAfter
+	if defaults != nil {
+		hp.page, hp.retryAfter = defaults.page, defaults.retryAfter
+	}
+	if hp.Page != "" {
+		if hp.page, err = template.New(name).Parse(hp.Page); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	} else if hp.page == nil {
+		hp.page = template.Must(template.New(name).Parse(defaultHoldingPage))
+	}
+	if hp.RetryAfter != "" {
+		if hp.retryAfter, err = time.ParseDuration(hp.RetryAfter); err != nil || hp.retryAfter < 0 {
+			return fmt.Errorf("%s: retry_after %q is not a duration", name, hp.RetryAfter)
+		}
+	}
+	return nil
+}
+
+// The holding page for path, or nil if it isn't under maintenance. A prefix
+// covers the paths that start with it, after a slash if it doesn't end in
+// one, so /shop covers /shop and /shop/cart but not /shopping.
+func (m *Maintenance) holding(path string) *HoldingPage {
+	if m == nil {
+		return nil
+	}
+	if len(m.Prefixes) == 0 {
+		return &m.HoldingPage
+	}
+	for _, prefix := range m.prefixes {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return m.pages[prefix]
+		}
+	}
+	return nil
+}
+
+// Send the holding page with a 503.
+func serveHolding(w http.ResponseWriter, req *http.Request, hp *HoldingPage) {
+	log.Println(realAddr(req), "sent holding page for", req.URL.Path)
+	buf := new(bytes.Buffer)
+	if err := hp.page.Execute(buf, req.URL); err != nil {
+		log.Println("Maintenance template:", err)
+		buf.Reset()
+		buf.WriteString(defaultHoldingPage)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(int(hp.retryAfter.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if req.Method != "HEAD" {
+		buf.WriteTo(w)
+	}
+}
+
+// SetMaintenance starts maintenance, or changes what is under it, or, if m
+// is nil, ends it.
+func (redir *Redirector) SetMaintenance(m *Maintenance) error {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+
+	candidate := redir.Config.clone()
+	candidate.Maintenance = m
+	if err := candidate.compile(); err != nil {
+		return err
+	}
+
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+
+	redir.Config = *candidate
+	if m == nil {
+		redir.changed("end maintenance")
+	} else {
+		redir.changed("start maintenance")
+	}
+	return nil
+}
+
+// The MaintenanceHandler shows what is under maintenance (GET), puts the
+// whole server, or the prefixes in the body, under maintenance (PUT), and
+// ends it (DELETE).
+func (redir *Redirector) MaintenanceHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			switch req.Method {
+			case "GET":
+				redir.getMaintenance(w, req)
+			case "PUT", "DELETE":
+				redir.idempotency.serve(w, req, redir.setMaintenance)
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			}
+		})
+	}
+}
+
+func (redir *Redirector) getMaintenance(w http.ResponseWriter, req *http.Request) {
+	redir.mu.RLock()
+	m := redir.Maintenance
+	redir.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]any{"active": m != nil, "maintenance": m})
+}
+
+func (redir *Redirector) setMaintenance(w http.ResponseWriter, req *http.Request) {
+	var m *Maintenance
+	if req.Method == "PUT" {
+		body, ok := readBody(w, req)
+		if !ok {
+			return
+		}
+		m = new(Maintenance)
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, m); err != nil {
+				writeError(w, http.StatusBadRequest, "Bad maintenance: "+err.Error())
+				return
+			}
+		}
+	}
+	if err := redir.SetMaintenance(m); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad maintenance: "+err.Error())
+		return
+	}
+	event := &Event{Type: EventMaintenanceStarted, Time: redir.Clock().Now(), Client: realAddr(req), Maintenance: m}
+	if m == nil {
+		event.Type = EventMaintenanceEnded
+		log.Println(realAddr(req), "ended maintenance")
+	} else {
+		log.Println(realAddr(req), "started maintenance")
+	}
+	redir.notify(event)
+	redir.getMaintenance(w, req)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/shop/cart": "/cart", "/shopping": "/mall", "/blog": "/news"}}`)
+	active := func() bool {
+		t.Helper()
+		w := tr.do("GET", "/_maintenance", "")
+		tr.expectStatus(w, http.StatusOK)
+		var state struct{ Active bool }
+		tr.decode(w, &state)
+		return state.Active
+	}
+	if active() {
+		t.Fatal("under maintenance from the start")
+	}
+
+	// The whole server.
+	tr.expectStatus(tr.do("PUT", "/_maintenance", ""), http.StatusOK)
+	w := tr.do("GET", "/blog", "")
+	tr.expectStatus(w, http.StatusServiceUnavailable)
+	if w.Header().Get("Retry-After") != "300" || !strings.Contains(w.Body.String(), "Down for maintenance") {
+		t.Errorf("got Retry-After %q: %s", w.Header().Get("Retry-After"), w.Body.String())
+	}
+	if !active() {
+		t.Error("not under maintenance")
+	}
+	tr.expectStatus(tr.do("GET", "/_health", ""), http.StatusOK)
+
+	// Only some prefixes, each with its own page.
+	tr.expectStatus(tr.do("PUT", "/_maintenance", `{"retry_after": "30m", "prefixes": {"/shop/": {"page": "<h1>{{.Path}} is moving</h1>"}}}`), http.StatusOK)
+	w = tr.do("GET", "/shop/cart", "")
+	tr.expectStatus(w, http.StatusServiceUnavailable)
+	if w.Header().Get("Retry-After") != "1800" || w.Body.String() != "<h1>/shop/cart is moving</h1>" {
+		t.Errorf("got Retry-After %q: %s", w.Header().Get("Retry-After"), w.Body.String())
+	}
+	tr.expectRedirect("/shopping", http.StatusFound, "/mall")
+	tr.expectRedirect("/blog", http.StatusFound, "/news")
+
+	tr.expectStatus(tr.do("PUT", "/_maintenance", `{"prefixes": {"shop/": {}}}`), http.StatusBadRequest)
+	tr.expectStatus(tr.do("PUT", "/_maintenance", `{"retry_after": "soon"}`), http.StatusBadRequest)
+	tr.expectStatus(tr.do("PUT", "/_maintenance", `{"page": "{{.Path"}`), http.StatusBadRequest)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "DELETE", "/_maintenance", ""), http.StatusUnauthorized)
+
+	tr.expectStatus(tr.do("DELETE", "/_maintenance", ""), http.StatusOK)
+	tr.expectRedirect("/shop/cart", http.StatusFound, "/cart")
+	if active() {
+		t.Error("still under maintenance")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The order methods are listed in Allow headers.
+var methodOrder = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Serve handler only for methods, answering OPTIONS with the methods in an
+// Allow header, and refusing any others with a 405 and the same header. HEAD
+// is allowed wherever GET is: unless handler takes HEAD itself, it gets a
+// GET, and net/http leaves out the body. Preflight requests from origins
+// allowCORS let through are told which of the methods they may use.
+func allowMethods(handler func(http.ResponseWriter, *http.Request), methods ...string) func(http.ResponseWriter, *http.Request) {
+	allowed := map[string]bool{"OPTIONS": true}
+	for _, method := range methods {
+		allowed[method] = true
+	}
+	getForHead := allowed["GET"] && !allowed["HEAD"]
+	allowed["HEAD"] = allowed["HEAD"] || allowed["GET"]
+	var list []string
+	for _, method := range methodOrder {
+		if allowed[method] {
+			list = append(list, method)
+		}
+	}
+	allow := strings.Join(list, ", ")
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == "OPTIONS":
+			w.Header().Set("Allow", allow)
+			if cors != nil && w.Header().Get("Access-Control-Allow-Origin") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", cors.allowedMethods(list))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case !allowed[req.Method]:
+			w.Header().Set("Allow", allow)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		case req.Method == "HEAD" && getForHead:
+			get := *req
+			get.Method = "GET"
+			handler(w, &get)
+		default:
+			handler(w, req)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The number of redirections each owner has. Redirections without an owner
+// are counted under "".
+func (redir *Redirector) Owners() map[string]int {
+	redir.mu.RLock()
+	defer redir.mu.RUnlock()
+
+	owners := make(map[string]int)
+	redir.Redirections.Each(func(source string, rule *Rule) {
+		owners[rule.Owner]++
+	})
+	return owners
+}
+
+// TransferOwnership gives the redirections that match to a new owner,
+// returning what changed, in order of source.
+func (redir *Redirector) TransferOwnership(match func(source string, rule *Rule) bool, owner string) []ruleChange {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+
+	var changes []ruleChange
+	redir.Redirections.Each(func(source string, old *Rule) {
+		if old.Owner == owner || !match(source, old) {
+			return
+		}
+		// Live rules are never modified, so the new owner goes on a copy.
+		rule := *old
+		rule.Owner = owner
+		changes = append(changes, ruleChange{source, old, &rule})
+	})
+	sort.Slice(changes, func(i, j int) bool { return changes[i].source < changes[j].source })
+	for _, change := range changes {
+		redir.Redirections.Set(change.source, change.new)
+		redir.peers.replicate(change.source, change.new)
+	}
+	if len(changes) > 0 {
+		redir.changed("transfer to " + owner)
+		redir.wildcards, _ = compileWildcards(redir.Redirections)
+	}
+	return changes
+}
+
+// The OwnersHandler counts the redirections each owner has (GET /_owners),
+// and transfers redirections to a new owner (POST /_owners/transfer). A
+// transfer gives the redirections to "to", choosing them by any of:
+// "source", which may be repeated; "prefix", for sources starting with it;
+// and "from", for those of an owner ("from=" for those without one). When
+// several are given, a redirection must match all of them.
+func (redir *Redirector) OwnersHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			switch {
+			case req.URL.Path == "/_owners" && req.Method == "GET":
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(redir.Owners())
+			case req.URL.Path == "/_owners/transfer" && req.Method == "POST":
+				redir.idempotency.serve(w, req, redir.transfer)
+			case req.URL.Path == "/_owners", req.URL.Path == "/_owners/transfer":
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			default:
+				http.NotFound(w, req)
+			}
+		})
+	}
+}
+
+func (redir *Redirector) transfer(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad form")
+		return
+	}
+	owner := req.PostForm.Get("to")
+	sources, prefix, from := req.PostForm["source"], req.PostForm["prefix"], req.PostForm["from"]
+	if owner == "" || sources == nil && prefix == nil && from == nil {
+		writeError(w, http.StatusBadRequest, "to and one of source, prefix, or from are required")
+		return
+	}
+	chosen := make(map[string]bool)
+	for _, source := range sources {
+		chosen[source] = true
+	}
+
+	changes := redir.TransferOwnership(func(source string, rule *Rule) bool {
+		return (sources == nil || chosen[source]) &&
+			(prefix == nil || strings.HasPrefix(source, prefix[0])) &&
+			(from == nil || rule.Owner == from[0])
+	}, owner)
+
+	transferred := []string{}
+	for _, change := range changes {
+		event := redir.ruleEvent(req, change.source, change.old, change.new)
+		event.Type = EventRuleTransferred
+		redir.notify(event)
+		transferred = append(transferred, change.source)
+	}
+	log.Println(realAddr(req), "transferred", len(transferred), "redirections to", owner)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"owner": owner, "transferred": transferred})
+}
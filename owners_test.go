@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestOwners(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {
+		"/a": {"to": "/x", "owner": "growth"},
+		"/spring/b": {"to": "/x", "owner": "growth"},
+		"/spring/c": "/x",
+		"/d": {"to": "/x", "owner": "web"}
+	}}`)
+	form := []string{"Content-Type", "application/x-www-form-urlencoded"}
+	expectOwners := func(want map[string]int) {
+		t.Helper()
+		w := tr.do("GET", "/_owners", "")
+		tr.expectStatus(w, http.StatusOK)
+		var owners map[string]int
+		tr.decode(w, &owners)
+		if !reflect.DeepEqual(owners, want) {
+			t.Errorf("got owners %v, want %v", owners, want)
+		}
+	}
+	expectTransfer := func(body string, want ...string) {
+		t.Helper()
+		w := tr.do("POST", "/_owners/transfer", body, form...)
+		tr.expectStatus(w, http.StatusOK)
+		var result struct {
+			Owner       string   `json:"owner"`
+			Transferred []string `json:"transferred"`
+		}
+		tr.decode(w, &result)
+		if !slices.Equal(result.Transferred, want) {
+			t.Errorf("%s: transferred %q, want %q", body, result.Transferred, want)
+		}
+	}
+	expectOwners(map[string]int{"": 1, "growth": 2, "web": 1})
+
+	// A redirection must match all that are given.
+	expectTransfer("to=marketing&from=growth&prefix=/spring/", "/spring/b")
+	expectTransfer("to=web&from=", "/spring/c")
+	expectTransfer("to=ops&source=/a&source=/d", "/a", "/d")
+	expectTransfer("to=ops&source=/a&from=web")
+	expectOwners(map[string]int{"marketing": 1, "ops": 2, "web": 1})
+	tr.expectRedirect("/spring/b", http.StatusFound, "/x")
+
+	tr.expectStatus(tr.do("POST", "/_owners/transfer", "to=ops", form...), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_owners/transfer", "from=web", form...), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_owners/other", "to=ops&from=web", form...), http.StatusNotFound)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "POST", "/_owners/transfer", "to=ops&from=web", form...), http.StatusUnauthorized)
+}
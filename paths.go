@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// How paths are normalized before they are matched, besides their Unicode
+// normalization (see nfc). Sources in the configuration are normalized the
+// same way when it is loaded, so /About/ and /about can be made to hit the
+// same rule.
+var caseInsensitive *bool = flag.Bool("case-insensitive", false, "match paths regardless of case")
+var ignoreTrailingSlash *bool = flag.Bool("ignore-trailing-slash", false, "match paths with or without a trailing slash")
+var cleanPaths *bool = flag.Bool("clean-paths", false, "collapse duplicate slashes and resolve . and .. segments before matching paths")
+
+// Clean a path as -clean-paths and -ignore-trailing-slash say, keeping its
+// case, and normalize its Unicode.
+func cleanPath(p string) string {
+	p = nfc(p)
+	if *cleanPaths && p != "" {
+		trailing := strings.HasSuffix(p, "/")
+		p = path.Clean(p)
+		if trailing && p != "/" {
+			p += "/"
+		}
+	}
+	if *ignoreTrailingSlash && len(p) > 1 && strings.HasSuffix(p, "/") {
+		if p = strings.TrimRight(p, "/"); p == "" {
+			p = "/"
+		}
+	}
+	return p
+}
+
+// The key a path is stored and looked up under: cleaned, and in lower case
+// with -case-insensitive. Placeholders keep their case, since the
+// destination refers to them by name.
+func pathKey(p string) string {
+	p = cleanPath(p)
+	if !*caseInsensitive {
+		return p
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		if !isPlaceholder(segment) {
+			segments[i] = strings.ToLower(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Whether a segment of a request path matches a fixed segment of a source.
+func segmentMatches(source, segment string) bool {
+	if *caseInsensitive {
+		return strings.EqualFold(source, segment)
+	}
+	return source == segment
+}
+
+// The key a source in the configuration is stored under: decoded, like the
+// paths of requests, and normalized.
+func sourceKey(source string) string {
+	return pathKey(decodeSource(source))
+}
+
+// Store the rules under their normalized sources. Sources that become the
+// same path are an error unless their rules are the same.
+func normalizeRules(rules Rules) (Rules, error) {
+	changed := false
+	rules.Each(func(source string, rule *Rule) {
+		changed = changed || sourceKey(source) != source
+	})
+	if !changed {
+		return rules, nil
+	}
+
+	var err error
+	var sources []string
+	var list []*Rule
+	first := make(map[string]string)
+	rules.Each(func(source string, rule *Rule) {
+		key := sourceKey(source)
+		if other, ok := first[key]; ok {
+			if existing, _ := rules.Get(other); err == nil && !existing.equal(rule) {
+				err = fmt.Errorf("redirections %s and %s are both %s", other, source, key)
+			}
+			return
+		}
+		first[key] = source
+		sources = append(sources, key)
+		list = append(list, rule)
+	})
+	if err != nil {
+		return rules, err
+	}
+	normalized := newRules(len(sources))
+	normalized.store.merge(sources, list)
+	return normalized, nil
+}
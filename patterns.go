@@ -0,0 +1,274 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// A MissPattern is a group of missed paths that differ only in some of their
+// segments, such as /product/{id} or /2019/*/old-post. Segments that vary
+// between numeric or hex identifiers are shown as {id}, and others as *.
+type MissPattern struct {
+	Pattern  string    `json:"pattern"`
+	Count    int64     `json:"count"`
+	Paths    int       `json:"paths"`
+	Examples []string  `json:"examples"`
+	Last     time.Time `json:"last"`
+}
+
+// How many distinct values a segment needs before the paths are grouped, and
+// how many example paths are kept for each pattern.
+const (
+	minPatternValues = 3
+	patternExamples  = 3
+)
+
+// A cluster of paths with the same number of segments. A nil entry in
+// values means the segment is fixed, as segments[i].
+type pathCluster struct {
+	segments []string
+	values   []map[string]bool
+	paths    []MissCount
+}
+
+// The key for the cluster with segment i wildcarded.
+func (cluster *pathCluster) keyWithout(i int) string {
+	key := make([]string, len(cluster.segments))
+	for j, segment := range cluster.segments {
+		if j != i && cluster.values[j] == nil {
+			key[j] = "=" + segment
+		}
+	}
+	return strings.Join(key, "/")
+}
+
+// Merge other into cluster, wildcarding segment i. The clusters have the
+// same key without i, so every other segment is either fixed in both, with
+// the same value, or a wildcard in both.
+func (cluster *pathCluster) merge(other *pathCluster, i int) {
+	for j := range cluster.values {
+		if j != i && cluster.values[j] == nil {
+			continue
+		}
+		if cluster.values[j] == nil {
+			cluster.values[j] = map[string]bool{cluster.segments[j]: true}
+		}
+		if other.values[j] == nil {
+			cluster.values[j][other.segments[j]] = true
+		}
+		for value := range other.values[j] {
+			cluster.values[j][value] = true
+		}
+	}
+	cluster.paths = append(cluster.paths, other.paths...)
+}
+
+// Group the missed paths by pattern, largest groups first. Paths that fit no
+// pattern are their own.
+func clusterMisses(misses []MissCount) []MissPattern {
+	bySize := make(map[int][]*pathCluster)
+	for _, miss := range misses {
+		segments := strings.Split(strings.TrimPrefix(miss.Path, "/"), "/")
+		bySize[len(segments)] = append(bySize[len(segments)], &pathCluster{
+			segments: segments,
+			values:   make([]map[string]bool, len(segments)),
+			paths:    []MissCount{miss},
+		})
+	}
+
+	var patterns []MissPattern
+	for size, clusters := range bySize {
+		for _, i := range segmentsByVariety(clusters, size) {
+			clusters = mergeAt(clusters, i)
+		}
+		for _, cluster := range clusters {
+			patterns = append(patterns, cluster.pattern())
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].Count != patterns[j].Count {
+			return patterns[i].Count > patterns[j].Count
+		}
+		return patterns[i].Pattern < patterns[j].Pattern
+	})
+	return patterns
+}
+
+// The segment positions, those with the most distinct values first, so that
+// identifiers are grouped before the words around them.
+func segmentsByVariety(clusters []*pathCluster, size int) []int {
+	variety := make([]int, size)
+	for i := range variety {
+		seen := make(map[string]bool)
+		for _, cluster := range clusters {
+			seen[cluster.segments[i]] = true
+		}
+		variety[i] = len(seen)
+	}
+	order := make([]int, size)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return variety[order[a]] > variety[order[b]] })
+	return order
+}
+
+// Merge the clusters that differ only at segment i, if there are enough of
+// them.
+func mergeAt(clusters []*pathCluster, i int) []*pathCluster {
+	buckets := make(map[string][]*pathCluster)
+	var keys []string
+	for _, cluster := range clusters {
+		key := cluster.keyWithout(i)
+		if buckets[key] == nil {
+			keys = append(keys, key)
+		}
+		buckets[key] = append(buckets[key], cluster)
+	}
+	merged := clusters[:0]
+	for _, key := range keys {
+		bucket := buckets[key]
+		values := make(map[string]bool)
+		for _, cluster := range bucket {
+			if cluster.values[i] == nil {
+				values[cluster.segments[i]] = true
+			}
+			for value := range cluster.values[i] {
+				values[value] = true
+			}
+		}
+		if len(values) < minPatternValues {
+			merged = append(merged, bucket...)
+			continue
+		}
+		for _, other := range bucket[1:] {
+			bucket[0].merge(other, i)
+		}
+		merged = append(merged, bucket[0])
+	}
+	return merged
+}
+
+func (cluster *pathCluster) pattern() MissPattern {
+	segments := make([]string, len(cluster.segments))
+	for i, segment := range cluster.segments {
+		switch {
+		case cluster.values[i] == nil:
+			segments[i] = segment
+		case allIdentifiers(cluster.values[i]):
+			segments[i] = "{id}"
+		default:
+			segments[i] = "*"
+		}
+	}
+	pattern := MissPattern{Pattern: "/" + strings.Join(segments, "/"), Paths: len(cluster.paths)}
+	sort.Slice(cluster.paths, func(i, j int) bool {
+		if cluster.paths[i].Count != cluster.paths[j].Count {
+			return cluster.paths[i].Count > cluster.paths[j].Count
+		}
+		return cluster.paths[i].Path < cluster.paths[j].Path
+	})
+	for _, miss := range cluster.paths {
+		pattern.Count += miss.Count
+		if miss.Last.After(pattern.Last) {
+			pattern.Last = miss.Last
+		}
+		if len(pattern.Examples) < patternExamples {
+			pattern.Examples = append(pattern.Examples, miss.Path)
+		}
+	}
+	return pattern
+}
+
+// Report whether every value looks like an identifier: a number, or a long
+// hex string such as a hash or UUID.
+func allIdentifiers(values map[string]bool) bool {
+	for value := range values {
+		if !isIdentifier(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentifier(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	digits, hex := true, 0
+	for _, c := range segment {
+		switch {
+		case c >= '0' && c <= '9':
+			hex++
+		case c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F':
+			digits = false
+			hex++
+		case c == '-':
+			digits = false
+		default:
+			return false
+		}
+	}
+	return digits || hex >= 8
+}
+
+// MissPatterns returns up to n of the patterns of missed paths that have
+// missed most often, most often first.
+func (stats *Stats) MissPatterns(n int) []MissPattern {
+	stats.mu.Lock()
+	misses := make([]MissCount, 0, len(stats.missPaths))
+	for _, count := range stats.missPaths {
+		misses = append(misses, *count)
+	}
+	stats.mu.Unlock()
+
+	patterns := clusterMisses(misses)
+	if len(patterns) > n {
+		patterns = patterns[:n]
+	}
+	return patterns
+}
+
+// A RuleProposal is a wildcard redirection that would cover a pattern of
+// missed paths, with the share of all counted misses it accounts for.
+type RuleProposal struct {
+	Source   string    `json:"source"`
+	Count    int64     `json:"count"`
+	Paths    int       `json:"paths"`
+	Share    float64   `json:"share"`
+	Examples []string  `json:"examples"`
+	Last     time.Time `json:"last"`
+}
+
+// Propose up to n wildcard redirections for the patterns of missed paths
+// that have missed most often, skipping those for which exists is true.
+func (stats *Stats) ProposeRules(n int, exists func(source string) bool) []RuleProposal {
+	stats.mu.Lock()
+	misses := make([]MissCount, 0, len(stats.missPaths))
+	var total int64
+	for _, count := range stats.missPaths {
+		misses = append(misses, *count)
+		total += count.Count
+	}
+	stats.mu.Unlock()
+
+	proposals := []RuleProposal{}
+	for _, pattern := range clusterMisses(misses) {
+		if len(proposals) == n {
+			break
+		}
+		if !isWildcard(pattern.Pattern) || exists(pattern.Pattern) {
+			continue
+		}
+		proposals = append(proposals, RuleProposal{
+			Source:   pattern.Pattern,
+			Count:    pattern.Count,
+			Paths:    pattern.Paths,
+			Share:    float64(pattern.Count) / float64(total),
+			Examples: pattern.Examples,
+			Last:     pattern.Last,
+		})
+	}
+	return proposals
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peers replicate the redirections set and removed through the API to each
+// other, so the API can be used on any of them, without a key-value store.
+// Each change is stamped with the time it was made and the node it was made
+// on, and the latest change to a redirection wins everywhere. Changes are
+// sent to each peer's admin listener, signed with the shared -peer-secret.
+var peersFlag *string = flag.String("peers", "", "comma-separated admin URLs of peers to replicate changes to, such as http://10.0.0.2:4404")
+var peerSecret *string = flag.String("peer-secret", "", "secret shared by the peers, to sign and check the changes they send each other")
+var nodeID *string = flag.String("node-id", "", "this server's name among its peers (by default, the host name and port)")
+
+// How far from this server's clock a change's stamp may be. Changes are
+// remembered only until the server restarts, so older ones are refused
+// rather than risk a captured change being sent again to roll a
+// redirection back.
+var peerMaxAge *time.Duration = flag.Duration("peer-max-age", 15*time.Minute, "how old a change from a peer may be before it is refused")
+
+// How many changes may wait to be sent to a peer, and how long to wait
+// between attempts to send them.
+const (
+	peerQueue    = 10000
+	peerRetry    = time.Second
+	peerMaxRetry = time.Minute
+)
+
+// The header changes are signed in, as "sha256=<hex>" like webhooks', and
+// the largest change taken.
+const (
+	peerSignatureHdr = "X-Foff-Peer-Signature"
+	maxMutationSize  = 1 << 20
+)
+
+// A mutation is a redirection set or removed (with a nil rule) on a node,
+// at a time in Unix nanoseconds.
+type mutation struct {
+	Source string `json:"source"`
+	Rule   *Rule  `json:"rule"`
+	Time   int64  `json:"time"`
+	Node   string `json:"node"`
+}
+
+// Whether m happened after the change stamped with time on node. Ties
+// between nodes are broken by their names, so every node agrees.
+func (m *mutation) after(stamp peerStamp) bool {
+	return m.Time > stamp.time || m.Time == stamp.time && m.Node > stamp.node
+}
+
+type peerStamp struct {
+	time int64
+	node string
+}
+
+// A peerSet sends this node's changes to its peers, and keeps the stamp of
+// the latest change to each redirection, removals included, to resolve
+// conflicts.
+type peerSet struct {
+	node   string
+	secret []byte
+	peers  []*peer
+	clock  Clock
+
+	mu     sync.Mutex
+	stamps map[string]peerStamp
+	last   int64
+}
+
+// A peer, and the changes waiting to be sent to it.
+type peer struct {
+	url     string
+	client  *http.Client
+	queue   chan []byte
+	mu      sync.Mutex
+	sent    int64
+	dropped int64
+	lastErr string
+}
+
+// The state of replication to a peer, for /_status.
+type peerStatus struct {
+	URL       string `json:"url"`
+	Queued    int    `json:"queued"`
+	Sent      int64  `json:"sent"`
+	Dropped   int64  `json:"dropped"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Start replicating to the -peers, if any.
+func newPeerSet(clock Clock) (*peerSet, error) {
+	if *peersFlag == "" {
+		return nil, nil
+	}
+	if *peerSecret == "" {
+		return nil, errors.New("-peers needs -peer-secret")
+	}
+	node := *nodeID
+	if node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		node = fmt.Sprintf("%s:%d", hostname, *port)
+	}
+	set := &peerSet{node: node, secret: []byte(*peerSecret), clock: clock, stamps: make(map[string]peerStamp)}
+	for _, url := range strings.Split(*peersFlag, ",") {
+		url = strings.TrimSuffix(strings.TrimSpace(url), "/")
+		if url == "" {
+			continue
+		}
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("peer %s is not an http:// or https:// URL", url)
+		}
+		p := &peer{url: url, client: &http.Client{Timeout: 10 * time.Second}, queue: make(chan []byte, peerQueue)}
+		set.peers = append(set.peers, p)
+		go p.run(set)
+	}
+	log.Println("replicating changes to", len(set.peers), "peers as", node)
+	return set, nil
+}
+
+// Stamp a change made on this node to the redirection from source, and send
+// it to the peers. A nil rule removes the redirection. The caller must hold
+// the Redirector's update lock, so changes are stamped in order.
+func (set *peerSet) replicate(source string, rule *Rule) {
+	if set == nil {
+		return
+	}
+	set.mu.Lock()
+	now := set.clock.Now().UnixNano()
+	if now <= set.last {
+		now = set.last + 1
+	}
+	set.last = now
+	m := &mutation{Source: source, Rule: rule, Time: now, Node: set.node}
+	set.stamps[source] = peerStamp{now, set.node}
+	set.mu.Unlock()
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		log.Println("peers:", err)
+		return
+	}
+	for _, p := range set.peers {
+		select {
+		case p.queue <- body:
+		default:
+			p.mu.Lock()
+			p.dropped++
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Send each redirection a configuration change added, updated, or removed to
+// the peers. The caller must hold the update lock.
+func (redir *Redirector) replicateChanges(changes ConfigChanges) {
+	for _, change := range changes.changed {
+		redir.peers.replicate(change.source, change.new)
+	}
+}
+
+// Take a change from a peer if it is later than the last one to its
+// redirection, reporting whether it was.
+func (set *peerSet) accept(m *mutation) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if !m.after(set.stamps[m.Source]) {
+		return false
+	}
+	set.stamps[m.Source] = peerStamp{m.Time, m.Node}
+	if m.Time > set.last {
+		// Keep this node's stamps ahead of the changes it has seen.
+		set.last = m.Time
+	}
+	return true
+}
+
+// The signature of a body sent between peers.
+func (set *peerSet) sign(body []byte) string {
+	mac := hmac.New(sha256.New, set.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// A change a peer refused, which sending again won't change.
+type peerRefusal struct {
+	status string
+}
+
+func (err *peerRefusal) Error() string {
+	return "refused: " + err.status
+}
+
+// Send the queued changes to the peer in order, retrying each until the
+// peer takes it or refuses it for good, as it does changes that are too old.
+func (p *peer) run(set *peerSet) {
+	for body := range p.queue {
+		wait := peerRetry
+		for {
+			err := p.send(set, body)
+			_, refused := err.(*peerRefusal)
+			p.mu.Lock()
+			switch {
+			case err == nil:
+				p.sent++
+				p.lastErr = ""
+			case refused:
+				p.dropped++
+				p.lastErr = err.Error()
+			default:
+				p.lastErr = err.Error()
+			}
+			p.mu.Unlock()
+			if err == nil {
+				break
+			}
+			if refused {
+				log.Println("peer", p.url, "dropped a change:", err)
+				break
+			}
+			log.Println("peer", p.url, "failed:", err)
+			time.Sleep(wait)
+			if wait *= 2; wait > peerMaxRetry {
+				wait = peerMaxRetry
+			}
+		}
+	}
+}
+
+func (p *peer) send(set *peerSet, body []byte) error {
+	req, err := http.NewRequest("POST", p.url+"/_peer", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(peerSignatureHdr, set.sign(body))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		// A bad or stale change.
+		return &peerRefusal{resp.Status}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// The state of replication to each peer.
+func (set *peerSet) status() []peerStatus {
+	if set == nil {
+		return nil
+	}
+	statuses := make([]peerStatus, len(set.peers))
+	for i, p := range set.peers {
+		p.mu.Lock()
+		statuses[i] = peerStatus{URL: p.url, Queued: len(p.queue), Sent: p.sent, Dropped: p.dropped, LastError: p.lastErr}
+		p.mu.Unlock()
+	}
+	return statuses
+}
+
+// Apply a change from a peer, unless a later one to the same redirection
+// has been made already, returning the rule it replaced, if any, and
+// whether it changed anything.
+func (redir *Redirector) applyMutation(m *mutation) (old *Rule, applied bool, err error) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+
+	source := pathKey(m.Source)
+	m.Source = source
+	if m.Rule != nil {
+		if err = redir.compileRule(source, m.Rule); err != nil {
+			return
+		}
+	}
+	if !redir.peers.accept(m) {
+		return
+	}
+	old, ok := redir.Redirections.Get(source)
+	if m.Rule == nil {
+		if !ok {
+			return
+		}
+		redir.Redirections.Delete(source)
+		redir.changed("delete " + source + " from " + m.Node)
+		log.Println("peer", m.Node, "removed redirection for", source)
+	} else {
+		redir.Redirections.Set(source, m.Rule)
+		redir.changed("set " + source + " from " + m.Node)
+		log.Println("peer", m.Node, "set redirection from", source, "to", m.Rule.To)
+	}
+	if isWildcard(source) {
+		redir.wildcards, _ = compileWildcards(redir.Redirections)
+	}
+	return old, true, nil
+}
+
+// The PeerHandler takes the changes peers send (POST /_peer), which must be
+// signed with -peer-secret. Peers are allowed by their signatures rather
+// than by -admin-allow.
+func (redir *Redirector) PeerHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if redir.peers == nil {
+			http.NotFound(w, req)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxMutationSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !hmac.Equal([]byte(req.Header.Get(peerSignatureHdr)), []byte(redir.peers.sign(body))) {
+			log.Println(realAddr(req), "denied", req.Method, req.URL.Path, "(bad signature)")
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		var m mutation
+		if err = json.Unmarshal(body, &m); err != nil || m.Source == "" || m.Node == "" {
+			writeError(w, http.StatusBadRequest, "Bad change")
+			return
+		}
+		if age := redir.Clock().Now().Sub(time.Unix(0, m.Time)); age > *peerMaxAge || age < -*peerMaxAge {
+			log.Println(realAddr(req), "refused a change to", m.Source, "from", m.Node, "made", age, "ago")
+			writeError(w, http.StatusBadRequest, "Stale change")
+			return
+		}
+		old, applied, err := redir.applyMutation(&m)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if applied {
+			event := redir.ruleEvent(req, m.Source, old, m.Rule)
+			event.Client = "peer " + m.Node
+			redir.notify(event)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Two redirectors, the first replicating its changes to the second, which
+// it reaches over HTTP.
+func newTestPeers(t *testing.T, config string) (from, to *testRedirector) {
+	t.Helper()
+	from, to = newTestRedirector(t, config), newTestRedirector(t, config)
+	server := httptest.NewServer(to.handler)
+	t.Cleanup(server.Close)
+
+	secret := []byte("shared")
+	to.peers = &peerSet{node: "to", secret: secret, clock: to.Clock(), stamps: make(map[string]peerStamp)}
+	p := &peer{url: server.URL, client: server.Client(), queue: make(chan []byte, peerQueue)}
+	from.peers = &peerSet{node: "from", secret: secret, peers: []*peer{p}, clock: from.Clock(), stamps: make(map[string]peerStamp)}
+	go p.run(from.peers)
+	t.Cleanup(func() { close(p.queue) })
+	return from, to
+}
+
+// Wait for the redirection from source to go to destination, or, if
+// destination is empty, to be removed.
+func (tr *testRedirector) awaitRule(source, destination, owner string) {
+	tr.t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		tr.mu.RLock()
+		rule, ok := tr.Redirections.Get(source)
+		tr.mu.RUnlock()
+		if !ok && destination == "" || ok && rule.To == destination && rule.Owner == owner {
+			return
+		}
+	}
+	tr.t.Fatalf("%s never went to %q, owned by %q", source, destination, owner)
+}
+
+func TestPeerReplication(t *testing.T) {
+	from, to := newTestPeers(t, `{"redirections": {"/a": "http://old.example.com/a", "/b": "/b2"}}`)
+	form := []string{"Content-Type", "application/x-www-form-urlencoded"}
+
+	from.expectStatus(from.do("PUT", "/c", "/c2"), http.StatusCreated)
+	to.awaitRule("/c", "/c2", "")
+
+	from.expectStatus(from.do("POST", "/_rewrite", "find=http://old.example.com&replace=https://new.example.com", form...), http.StatusOK)
+	to.awaitRule("/a", "https://new.example.com/a", "")
+
+	from.expectStatus(from.do("POST", "/_owners/transfer", "source=/b&to=growth", form...), http.StatusOK)
+	to.awaitRule("/b", "/b2", "growth")
+
+	// Whole configurations change the redirections on the peers too.
+	from.expectStatus(from.do("PUT", "/_config", `{"redirections": {"/d": "/d2", "/c": "/c3"}}`, "If-Match", "*"), http.StatusOK)
+	to.awaitRule("/d", "/d2", "")
+	to.awaitRule("/c", "/c3", "")
+
+	version := from.versions.latest()
+	from.expectStatus(from.do("PUT", "/_config?mode=replace", `{"redirections": {"/e": "/e2"}}`, "If-Match", "*"), http.StatusOK)
+	to.awaitRule("/e", "/e2", "")
+	to.awaitRule("/a", "", "")
+
+	from.expectStatus(from.do("POST", fmt.Sprintf("/_config/rollback/%d", version), ""), http.StatusOK)
+	to.awaitRule("/a", "https://new.example.com/a", "")
+	to.awaitRule("/e", "", "")
+
+	from.expectStatus(from.do("DELETE", "/_config", ""), http.StatusOK)
+	to.awaitRule("/d", "", "")
+	to.awaitRule("/b", "", "")
+}
+
+func TestStalePeerChange(t *testing.T) {
+	_, to := newTestPeers(t, `{"redirections": {"/a": "/new"}}`)
+	post := func(m mutation) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(m)
+		return to.do("POST", "/_peer", string(body), peerSignatureHdr, to.peers.sign(body))
+	}
+	rule := &Rule{To: "/old"}
+
+	to.expectStatus(post(mutation{"/a", rule, time.Now().Add(-time.Hour).UnixNano(), "from"}), http.StatusBadRequest)
+	to.expectStatus(post(mutation{"/a", rule, time.Now().Add(time.Hour).UnixNano(), "from"}), http.StatusBadRequest)
+	to.expectRedirect("/a", http.StatusFound, "/new")
+
+	to.expectStatus(post(mutation{"/a", rule, time.Now().UnixNano(), "from"}), http.StatusNoContent)
+	to.expectRedirect("/a", http.StatusFound, "/old")
+
+	w := to.do("POST", "/_peer", `{"source": "/a", "rule": null, "time": 1, "node": "x"}`, peerSignatureHdr, "sha256="+strings.Repeat("0", 64))
+	to.expectStatus(w, http.StatusUnauthorized)
+}
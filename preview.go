@@ -0,0 +1,53 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// A Preview holds the Open Graph and Twitter Card metadata served to link
+// preview crawlers in place of the redirect. Humans are redirected as usual.
+type Preview struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.Destination}}">
+{{with .Title}}<meta property="og:title" content="{{.}}">
+<meta name="twitter:title" content="{{.}}">
+{{end}}{{with .Description}}<meta property="og:description" content="{{.}}">
+<meta name="twitter:description" content="{{.}}">
+{{end}}{{with .Image}}<meta property="og:image" content="{{.}}">
+<meta name="twitter:image" content="{{.}}">
+<meta name="twitter:card" content="summary_large_image">
+{{else}}<meta name="twitter:card" content="summary">
+{{end}}{{with .SiteName}}<meta property="og:site_name" content="{{.}}">
+{{end}}<meta http-equiv="refresh" content="0; url={{.Destination}}">
+</head>
+<body>
+<a href="{{.Destination}}">{{or .Title .Destination}}</a>
+</body>
+</html>
+`))
+
+// Serve the preview page for destination.
+func servePreview(w http.ResponseWriter, req *http.Request, preview *Preview, destination string) {
+	log.Println(realAddr(req), "sent preview for", req.URL.Path)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := previewPage.Execute(w, struct {
+		*Preview
+		Destination string
+	}{preview, destination})
+	if err != nil {
+		log.Println("Preview template:", err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Public paths the server requests from itself, through each of its public
+// listeners, to catch a broken listener, certificate, or rule even when
+// nothing outside is watching.
+var probePaths *string = flag.String("probe-paths", "", "comma-separated public paths to request from the server itself")
+var probeInterval *time.Duration = flag.Duration("probe-interval", time.Minute, "how often to request -probe-paths")
+
+// The user agent of probes, which counts as a bot in the statistics.
+const probeAgent = "fourohfourfound-probe"
+
+// How long a probe may take.
+const probeTimeout = 10 * time.Second
+
+// A probeResult is the latest probe of a path through a listener. A probe
+// succeeds when it gets the response /_resolve says it should: the same
+// status and, for redirects, the same destination.
+type probeResult struct {
+	Target    string    `json:"target"`
+	Path      string    `json:"path"`
+	OK        bool      `json:"ok"`
+	Status    int       `json:"status,omitempty"`
+	Expected  int       `json:"expected,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS float64   `json:"latency_ms"`
+	Time      time.Time `json:"time"`
+	LastOK    time.Time `json:"last_ok,omitempty"`
+	Successes int64     `json:"successes"`
+	Failures  int64     `json:"failures"`
+	CertDays  int       `json:"cert_days_left,omitempty"`
+}
+
+// A probeTarget is a public listener to probe through.
+type probeTarget struct {
+	name   string
+	base   string
+	client *http.Client
+}
+
+// A prober keeps the latest results of the probes. A nil prober has none.
+type prober struct {
+	mu      sync.Mutex
+	results map[string]*probeResult
+}
+
+// Probe targets for the listeners that serve the public routes. Wildcard
+// addresses are probed on the loopback address of their family, and Unix domain sockets
+// through the socket.
+func probeTargets(addrs []endpoint, listeners []net.Listener) []*probeTarget {
+	var targets []*probeTarget
+	for i, e := range addrs {
+		if e.admin {
+			continue
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// A probe checks that the handshake works, not that the address it
+		// reaches the listener by is one the certificate names.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		transport.DisableKeepAlives = true
+		addr := listeners[i].Addr()
+		host := addr.String()
+		if addr.Network() == "unix" {
+			path := host
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			}
+			host = "localhost"
+		} else if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP.IsUnspecified() {
+			// The loopback address of the listener's own family, since an
+			// IPv6 listener may not take IPv4 connections, or the reverse.
+			loopback := "127.0.0.1"
+			if tcp.IP.To4() == nil {
+				loopback = "::1"
+			}
+			host = net.JoinHostPort(loopback, strconv.Itoa(tcp.Port))
+		}
+		scheme := "http"
+		if e.tls {
+			scheme = "https"
+		}
+		targets = append(targets, &probeTarget{
+			name: e.addr + " " + e.kind(),
+			base: scheme + "://" + host,
+			client: &http.Client{
+				Transport: transport,
+				Timeout:   probeTimeout,
+				CheckRedirect: func(*http.Request, []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			},
+		})
+	}
+	return targets
+}
+
+func newProber() *prober {
+	return &prober{results: make(map[string]*probeResult)}
+}
+
+// Probe paths through targets every interval, until the process exits.
+func (redir *Redirector) probe(targets []*probeTarget, paths []string, interval time.Duration) {
+	for {
+		for _, target := range targets {
+			for _, path := range paths {
+				redir.probeOnce(target, path)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Probe path through target once.
+func (redir *Redirector) probeOnce(target *probeTarget, path string) {
+	want := redir.Resolve("", path, probeAgent)
+	req, err := http.NewRequest("GET", target.base+path, nil)
+	if err != nil {
+		redir.probes.record(target, path, &probeResult{Error: err.Error()})
+		return
+	}
+	req.Header.Set("User-Agent", probeAgent+"/"+version)
+
+	start := time.Now()
+	resp, err := target.client.Do(req)
+	result := &probeResult{Expected: want.Code, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Error = err.Error()
+		redir.probes.record(target, path, result)
+		return
+	}
+	resp.Body.Close()
+	result.Status = resp.StatusCode
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		result.CertDays = int(time.Until(resp.TLS.PeerCertificates[0].NotAfter).Hours() / 24)
+	}
+	switch {
+	case want.Action == ActionProxy:
+		// Proxied content is up to the destination.
+		result.OK = resp.StatusCode < 500
+	case resp.StatusCode != want.Code:
+	case want.Action == ActionRedirect && resp.Header.Get("Location") != want.Destination:
+		result.Error = "redirected to " + resp.Header.Get("Location") + " instead of " + want.Destination
+	default:
+		result.OK = true
+	}
+	redir.probes.record(target, path, result)
+}
+
+// Record a probe's result, logging when a probe starts or stops failing.
+func (probes *prober) record(target *probeTarget, path string, result *probeResult) {
+	probes.mu.Lock()
+	defer probes.mu.Unlock()
+
+	key := target.name + " " + path
+	last := probes.results[key]
+	result.Target, result.Path, result.Time = target.name, path, time.Now()
+	if last != nil {
+		result.LastOK, result.Successes, result.Failures = last.LastOK, last.Successes, last.Failures
+	}
+	if result.OK {
+		result.LastOK = result.Time
+		result.Successes++
+		if last != nil && !last.OK {
+			log.Println("probe of", path, "through", target.name, "recovered")
+		}
+	} else {
+		result.Failures++
+		if last == nil || last.OK {
+			log.Println("probe of", path, "through", target.name, "failed:", result.describe())
+		}
+	}
+	probes.results[key] = result
+}
+
+// What went wrong with a failed probe.
+func (result *probeResult) describe() string {
+	if result.Error != "" {
+		return result.Error
+	}
+	return http.StatusText(result.Status) + " instead of " + http.StatusText(result.Expected)
+}
+
+// The latest results, in order of target and path.
+func (probes *prober) report() []probeResult {
+	if probes == nil {
+		return nil
+	}
+	probes.mu.Lock()
+	defer probes.mu.Unlock()
+
+	report := make([]probeResult, 0, len(probes.results))
+	for _, result := range probes.results {
+		report = append(report, *result)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Target != report[j].Target {
+			return report[i].Target < report[j].Target
+		}
+		return report[i].Path < report[j].Path
+	})
+	return report
+}
+
+// The paths in -probe-paths.
+func parseProbePaths(value string) []string {
+	var paths []string
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNoProfile = errors.New("no such profile")
+
+// ActivateProfile makes the named profile's redirections take precedence,
+// or, if name is empty, goes back to the redirections alone.
+func (redir *Redirector) ActivateProfile(name string) error {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+
+	if _, ok := redir.Profiles[name]; name != "" && !ok {
+		return errNoProfile
+	}
+	candidate := redir.Config.clone()
+	candidate.Profile = name
+	if err := candidate.compile(); err != nil {
+		return err
+	}
+
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+
+	redir.Config = *candidate
+	if name == "" {
+		redir.changed("deactivate profile")
+	} else {
+		redir.changed("activate profile " + name)
+	}
+	return nil
+}
+
+// The ProfileHandler shows the active profile and the others (GET), and
+// activates the profile named in the body (PUT), or none (DELETE).
+func (redir *Redirector) ProfileHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			switch req.Method {
+			case "GET":
+				redir.getProfile(w, req)
+			case "PUT", "DELETE":
+				redir.idempotency.serve(w, req, redir.setProfile)
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			}
+		})
+	}
+}
+
+func (redir *Redirector) getProfile(w http.ResponseWriter, req *http.Request) {
+	redir.mu.RLock()
+	active := redir.Profile
+	profiles := make([]string, 0, len(redir.Profiles))
+	for name := range redir.Profiles {
+		profiles = append(profiles, name)
+	}
+	redir.mu.RUnlock()
+
+	sort.Strings(profiles)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"active": active, "profiles": profiles})
+}
+
+func (redir *Redirector) setProfile(w http.ResponseWriter, req *http.Request) {
+	var name string
+	if req.Method == "PUT" {
+		body, ok := readBody(w, req)
+		if !ok {
+			return
+		}
+		if name = strings.TrimSpace(string(body)); name == "" {
+			writeError(w, http.StatusBadRequest, "Profile name required")
+			return
+		}
+	}
+	err := redir.ActivateProfile(name)
+	if err == errNoProfile {
+		writeError(w, http.StatusNotFound, "No such profile")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error activating profile: "+err.Error())
+		return
+	}
+	log.Println(realAddr(req), "activated profile", strconv.Quote(name))
+	redir.notify(&Event{Type: EventProfileActivated, Time: redir.Clock().Now(), Client: realAddr(req), Profile: &name})
+	redir.getProfile(w, req)
+}
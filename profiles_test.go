@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	tr := newTestRedirector(t, `{
+		"redirections": {"/sale": "/sales/current", "/status": "/"},
+		"profiles": {
+			"black-friday": {"/sale": "/sales/black-friday", "/deals/*": "/sales/black-friday"},
+			"incident": {"/status": "https://status.example.com/"}
+		}
+	}`)
+	type profiles struct {
+		Active   string
+		Profiles []string
+	}
+	expectProfile := func(w *httptest.ResponseRecorder, active string) {
+		t.Helper()
+		tr.expectStatus(w, http.StatusOK)
+		var got profiles
+		tr.decode(w, &got)
+		if want := (profiles{active, []string{"black-friday", "incident"}}); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	expectProfile(tr.do("GET", "/_profile", ""), "")
+
+	expectProfile(tr.do("PUT", "/_profile", "black-friday"), "black-friday")
+	tr.expectRedirect("/sale", http.StatusFound, "/sales/black-friday")
+	tr.expectRedirect("/deals/tv", http.StatusFound, "/sales/black-friday")
+	tr.expectRedirect("/status", http.StatusFound, "/")
+
+	expectProfile(tr.do("PUT", "/_profile", "incident"), "incident")
+	tr.expectRedirect("/sale", http.StatusFound, "/sales/current")
+	tr.expectRedirect("/status", http.StatusFound, "https://status.example.com/")
+
+	tr.expectStatus(tr.do("PUT", "/_profile", "cyber-monday"), http.StatusNotFound)
+	tr.expectStatus(tr.do("PUT", "/_profile", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "PUT", "/_profile", "black-friday"), http.StatusUnauthorized)
+
+	expectProfile(tr.do("DELETE", "/_profile", ""), "")
+	tr.expectRedirect("/status", http.StatusFound, "/")
+	tr.expectNotFound("/deals/tv")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// How long a proxied request may take, including reading the response.
+var proxyTimeout *time.Duration = flag.Duration("proxy-timeout", 30*time.Second, "timeout for proxied requests")
+
+// The transport shared by all proxy rules.
+var proxyTransport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// Create the reverse proxy for a rule in proxy mode. The destination must be an
+// absolute URL; requests are sent to it as-is, with the client's query string
+// appended and the rule's headers set on the outgoing request.
+func newProxy(rule *Rule) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(rule.To)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("proxy destination %q is not an absolute URL", rule.To)
+	}
+
+	rewrite := func(r *httputil.ProxyRequest) {
+		r.Out.URL.Scheme = target.Scheme
+		r.Out.URL.Host = target.Host
+		r.Out.URL.Path = target.Path
+		r.Out.URL.RawPath = target.RawPath
+		switch {
+		case target.RawQuery == "":
+			r.Out.URL.RawQuery = r.In.URL.RawQuery
+		case r.In.URL.RawQuery != "":
+			r.Out.URL.RawQuery = target.RawQuery + "&" + r.In.URL.RawQuery
+		default:
+			r.Out.URL.RawQuery = target.RawQuery
+		}
+		r.Out.Host = target.Host
+		r.SetXForwarded()
+		for name, value := range rule.Headers {
+			r.Out.Header.Set(name, value)
+		}
+	}
+	return &httputil.ReverseProxy{Rewrite: rewrite, Transport: proxyTransport, ErrorHandler: proxyErrorHandler(rule.To)}, nil
+}
+
+// Answer errors proxying to the destination to: timeouts with a 504, and
+// others with a 502.
+func proxyErrorHandler(to string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, req *http.Request, err error) {
+		if errors.Is(err, context.Canceled) {
+			// The client went away; there's no one to answer.
+			log.Println(realAddr(req), "canceled proxying to", to)
+			return
+		}
+		log.Println(realAddr(req), "proxy error for", to+":", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+			return
+		}
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+	}
+}
+
+// Serve the request from the rule's destination, giving up after the proxy
+// timeout, or from its cache if it has one, and say how the cache served it.
+func serveProxy(w http.ResponseWriter, req *http.Request, rule *Rule) string {
+	if rule.cache == nil {
+		proxyTo(w, req, rule.proxy, rule.To)
+		return ""
+	}
+	return rule.cache.serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+		proxyTo(w, req, rule.proxy, rule.To)
+	})
+}
+
+func proxyTo(w http.ResponseWriter, req *http.Request, proxy *httputil.ReverseProxy, to string) {
+	ctx, cancel := context.WithTimeout(req.Context(), *proxyTimeout)
+	defer cancel()
+
+	if err := injectFault(ctx, "proxy"); err != nil {
+		proxy.ErrorHandler(w, req, err)
+		return
+	}
+	log.Println(realAddr(req), "proxied", req.URL.Path, "to", to)
+	ctx, s := startSpan(ctx, "proxy", spanClient)
+	defer s.finish()
+	req = req.WithContext(ctx)
+	if s != nil {
+		s.set("url.full", to)
+		// Continue the trace at the destination.
+		req.Header = req.Header.Clone()
+		req.Header.Set("Traceparent", s.traceparent())
+	}
+	proxy.ServeHTTP(w, req)
+}
+
+// An Upstream is the origin server requests without a redirection are passed
+// to, such as the legacy site the redirections were taken from, so that the
+// server can sit in front of it for good and only answer the paths it knows.
+// URL is its base URL, which the request's path and query are added to. With
+// PreserveHost, requests keep their own Host, for origins serving several
+// sites.
+type Upstream struct {
+	URL          string `json:"url"`
+	PreserveHost bool   `json:"preserve_host,omitempty"`
+	proxy        *httputil.ReverseProxy
+	proxyURL     string
+	proxyHost    bool
+}
+
+// The action of a request passed to the upstream.
+const ActionUpstream = "upstream"
+
+// Make the proxy, unless the Upstream was copied from one that already has a
+// proxy for the same URL and PreserveHost.
+func (up *Upstream) compile() error {
+	if up.proxy != nil && up.proxyURL == up.URL && up.proxyHost == up.PreserveHost {
+		return nil
+	}
+	target, err := url.Parse(up.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("upstream %q is not an http or https URL", up.URL)
+	}
+	preserveHost := up.PreserveHost
+	rewrite := func(r *httputil.ProxyRequest) {
+		r.SetURL(target)
+		if preserveHost {
+			r.Out.Host = r.In.Host
+		}
+		r.SetXForwarded()
+	}
+	up.proxy = &httputil.ReverseProxy{Rewrite: rewrite, Transport: proxyTransport, ErrorHandler: proxyErrorHandler(up.URL)}
+	up.proxyURL, up.proxyHost = up.URL, up.PreserveHost
+	return nil
+}
+
+// Serve the request from the upstream.
+func (up *Upstream) serve(w http.ResponseWriter, req *http.Request) {
+	proxyTo(w, req, up.proxy, up.URL)
+}
+
+// Pass requests with methods other than GET and HEAD to the upstream, if
+// there is one, rather than to handler, for listeners that don't serve the
+// admin API.
+func (redir *Redirector) passThrough(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if up := redir.live.Load().Upstream; up != nil && req.Method != "GET" && req.Method != "HEAD" {
+			log.Println(realAddr(req), req.Method, req.URL.Path)
+			up.serve(w, req)
+			return
+		}
+		handler(w, req)
+	}
+}
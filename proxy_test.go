@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	var requests atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		if req.URL.Query().Get("private") != "" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		fmt.Fprintf(w, "report for %s", req.URL.Path)
+	}))
+	defer upstream.Close()
+
+	tr := newTestRedirector(t, fmt.Sprintf(`{"redirections": {
+		"/report": {"to": "%s/annual", "mode": "proxy"},
+		"/cached": {"to": "%s/cached", "mode": "proxy", "cache": {"ttl": "5m"}}
+	}}`, upstream.URL, upstream.URL))
+
+	w := tr.do("GET", "/report", "", "User-Agent", "Mozilla/5.0")
+	tr.expectStatus(w, http.StatusOK)
+	if body, _ := io.ReadAll(w.Body); string(body) != "report for /annual" {
+		t.Errorf("got %q", body)
+	}
+
+	requests.Store(0)
+	for i := 0; i < 3; i++ {
+		tr.expectStatus(tr.do("GET", "/cached", "", "User-Agent", "Mozilla/5.0"), http.StatusOK)
+	}
+	tr.expectStatus(tr.do("GET", "/cached", "", "User-Agent", "Mozilla/5.0", "Cookie", "session=1"), http.StatusOK)
+	tr.expectStatus(tr.do("GET", "/cached?private=1", "", "User-Agent", "Mozilla/5.0"), http.StatusOK)
+	tr.expectStatus(tr.do("GET", "/cached?private=1", "", "User-Agent", "Mozilla/5.0"), http.StatusOK)
+	if n := requests.Load(); n != 4 {
+		t.Errorf("upstream got %d requests, want 4", n)
+	}
+}
+
+func TestUnreachableProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+	tr := newTestRedirector(t, fmt.Sprintf(`{"redirections": {"/report": {"to": "%s/annual", "mode": "proxy"}}}`, upstream.URL))
+	tr.expectStatus(tr.do("GET", "/report", "", "User-Agent", "Mozilla/5.0"), http.StatusBadGateway)
+}
+
+// Merging a configuration changes the upstream only if it is accepted, and
+// then sends the requests to the new one.
+func TestUpstreamMerge(t *testing.T) {
+	origin := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(w, "%s %s", name, req.URL.Path)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	old, new := origin("old"), origin("new")
+	tr := newTestRedirector(t, fmt.Sprintf(`{"redirections": {}, "upstream": {"url": %q}}`, old.URL))
+	expectOrigin := func(want string) {
+		t.Helper()
+		w := tr.do("GET", "/page", "", "User-Agent", "Mozilla/5.0")
+		tr.expectStatus(w, http.StatusOK)
+		if w.Body.String() != want+" /page" {
+			t.Errorf("got %q from the upstream, want %s", w.Body.String(), want)
+		}
+	}
+
+	w := tr.do("PUT", "/_config", fmt.Sprintf(`{"upstream": {"url": %q}, "default_destination": "/"}`, new.URL), "If-Match", "*")
+	tr.expectStatus(w, http.StatusBadRequest)
+	if tr.Upstream.URL != old.URL {
+		t.Errorf("rejected merge changed the upstream to %s", tr.Upstream.URL)
+	}
+	expectOrigin("old")
+
+	tr.expectStatus(tr.do("PUT", "/_config", fmt.Sprintf(`{"upstream": {"url": %q}}`, new.URL), "If-Match", "*"), http.StatusOK)
+	expectOrigin("new")
+}
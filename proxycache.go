@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ProxyCache keeps the responses a proxy rule gets from its destination for
+// TTL (e.g., "5m"), and has identical requests made while one is on its way
+// wait for it rather than go to the destination too:
+//
+//	"/report": {"to": "https://reports.example.com/annual", "mode": "proxy", "cache": {"ttl": "5m"}}
+//
+// A rule's responses take at most MaxBytes, 16MiB by default, the least
+// recently used making room for new ones. Only GET and HEAD requests without
+// credentials are served from the cache, and only successful, permanent, or
+// missing responses that the destination doesn't mark private and that set
+// no cookies are kept. A max-age shorter than TTL is honored.
+type ProxyCache struct {
+	TTL      string `json:"ttl"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+}
+
+const (
+	// The default size of a rule's cache.
+	defaultProxyCacheBytes = 16 << 20
+
+	// The largest response kept.
+	maxCachedResponse = 1 << 20
+)
+
+// How a proxied request was served: from the cache, by going to the
+// destination, or by waiting for an identical request that did.
+const (
+	CacheHit       = "hit"
+	CacheMiss      = "miss"
+	CacheCoalesced = "coalesced"
+)
+
+// Statuses whose responses are kept.
+var cacheableStatus = map[int]bool{200: true, 203: true, 204: true, 300: true, 301: true, 308: true, 404: true, 410: true}
+
+// The cached responses of a rule, most recently used first.
+type responseCache struct {
+	ttl      time.Duration
+	maxBytes int64
+	clock    Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List
+	size    int64
+	flights map[string]*proxyFlight
+}
+
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// A request to the destination that identical ones are waiting for. resp is
+// nil if its response couldn't be kept.
+type proxyFlight struct {
+	done chan struct{}
+	resp *cachedResponse
+}
+
+func compileProxyCache(c *ProxyCache, clock Clock) (*responseCache, error) {
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("cache ttl %q is not a positive duration", c.TTL)
+	}
+	if c.MaxBytes < 0 {
+		return nil, fmt.Errorf("cache max_bytes %d is negative", c.MaxBytes)
+	}
+	maxBytes := c.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultProxyCacheBytes
+	}
+	return &responseCache{ttl: ttl, maxBytes: maxBytes, clock: clock, entries: make(map[string]*list.Element), flights: make(map[string]*proxyFlight)}, nil
+}
+
+// Serve the request from the cache or with serve, which goes to the
+// destination, and say how it was served, or "" if the cache was bypassed.
+func (c *responseCache) serve(w http.ResponseWriter, req *http.Request, serve func(http.ResponseWriter, *http.Request)) string {
+	if req.Method != "GET" && req.Method != "HEAD" || req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" {
+		serve(w, req)
+		return ""
+	}
+	key := req.Host + req.URL.RequestURI() + " " + req.Header.Get("Accept-Encoding")
+
+	c.mu.Lock()
+	if resp := c.get(key, c.clock.Now()); resp != nil {
+		c.mu.Unlock()
+		resp.write(w, req, c.clock.Now())
+		return CacheHit
+	}
+	if req.Method == "HEAD" {
+		// Without a body, the response can't be kept.
+		c.mu.Unlock()
+		serve(w, req)
+		return CacheMiss
+	}
+	if f := c.flights[key]; f != nil {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-req.Context().Done():
+			// The client went away; there's no one to answer.
+			return ""
+		}
+		if f.resp != nil {
+			f.resp.write(w, req, c.clock.Now())
+			return CacheCoalesced
+		}
+		serve(w, req)
+		return CacheMiss
+	}
+	f := &proxyFlight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	// Let the waiters go even if the proxy gives up on the response midway.
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		if f.resp != nil {
+			c.add(f.resp)
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	rec := &cacheRecorder{ResponseWriter: w, limit: min(c.maxBytes, maxCachedResponse)}
+	serve(rec, req)
+	if req.Context().Err() == nil {
+		f.resp = rec.response(key, c.ttl, c.clock.Now())
+	}
+	return CacheMiss
+}
+
+// The response for key, if it hasn't expired. The caller must hold the lock.
+func (c *responseCache) get(key string, now time.Time) *cachedResponse {
+	e := c.entries[key]
+	if e == nil {
+		return nil
+	}
+	resp := e.Value.(*cachedResponse)
+	if !now.Before(resp.expires) {
+		c.remove(e)
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return resp
+}
+
+// Keep resp, making room for it. The caller must hold the lock.
+func (c *responseCache) add(resp *cachedResponse) {
+	if e := c.entries[resp.key]; e != nil {
+		c.remove(e)
+	}
+	if resp.size() > c.maxBytes {
+		return
+	}
+	c.entries[resp.key] = c.lru.PushFront(resp)
+	c.size += resp.size()
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(e *list.Element) {
+	resp := c.lru.Remove(e).(*cachedResponse)
+	delete(c.entries, resp.key)
+	c.size -= resp.size()
+}
+
+// Roughly how much memory resp takes.
+func (resp *cachedResponse) size() int64 {
+	n := len(resp.key) + len(resp.body)
+	for name, values := range resp.header {
+		n += len(name)
+		for _, value := range values {
+			n += len(value)
+		}
+	}
+	return int64(n)
+}
+
+func (resp *cachedResponse) write(w http.ResponseWriter, req *http.Request, now time.Time) {
+	header := w.Header()
+	for name, values := range resp.header {
+		header[name] = values
+	}
+	header.Set("Age", strconv.Itoa(int(now.Sub(resp.stored)/time.Second)))
+	w.WriteHeader(resp.status)
+	if req.Method != "HEAD" {
+		w.Write(resp.body)
+	}
+}
+
+// A cacheRecorder passes a response on to the client, keeping a copy of it
+// unless it grows larger than limit.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (rec *cacheRecorder) WriteHeader(code int) {
+	if rec.status == 0 && code >= 200 {
+		rec.status = code
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *cacheRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if int64(rec.body.Len()+len(p)) > rec.limit {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *cacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// The response to keep for ttl under key, stored at now, or nil if it
+// mustn't be kept.
+func (rec *cacheRecorder) response(key string, ttl time.Duration, now time.Time) *cachedResponse {
+	if !cacheableStatus[rec.status] || rec.overflow || rec.header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	for _, vary := range rec.header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return nil
+			}
+		}
+	}
+	for _, directive := range strings.Split(rec.header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		name, value, _ := strings.Cut(directive, "=")
+		switch name {
+		case "no-store", "no-cache", "private":
+			return nil
+		case "max-age", "s-maxage":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return nil
+			}
+			ttl = min(ttl, time.Duration(seconds)*time.Second)
+		}
+	}
+	return &cachedResponse{key: key, status: rec.status, header: rec.header, body: bytes.Clone(rec.body.Bytes()), stored: now, expires: now.Add(ttl)}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// QR code images, in pixels across.
+const (
+	qrDefaultSize = 256
+	qrMaxSize     = 4096
+)
+
+// The light modules around a QR code that scanners need to find it.
+const qrQuietZone = 4
+
+// The QRHandler renders a QR code for the public URL of a redirection
+// (GET /_qr?path=/promo), for printing on posters and other physical ads.
+// "size" is the width in pixels (256 by default) and "format" is "png" (the
+// default) or "svg". The URL is under -public-url, or the host the request
+// was made to.
+func (redir *Redirector) QRHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			query := req.URL.Query()
+			path := query.Get("path")
+			if path == "" || path[0] != '/' {
+				writeError(w, http.StatusBadRequest, "Missing path")
+				return
+			}
+			size := qrDefaultSize
+			if query.Has("size") {
+				n, err := strconv.Atoi(query.Get("size"))
+				if err != nil || n < 1 || n > qrMaxSize {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("size must be from 1 to %d", qrMaxSize))
+					return
+				}
+				size = n
+			}
+			format := query.Get("format")
+			if format != "" && format != "png" && format != "svg" {
+				writeError(w, http.StatusBadRequest, "format must be png or svg")
+				return
+			}
+
+			// A code for a path that goes nowhere would be a costly typo
+			// once printed.
+			redir.mu.RLock()
+			_, _, _, ok := redir.lookup(requestHost(req), path)
+			redir.mu.RUnlock()
+			if !ok {
+				writeError(w, http.StatusNotFound, "No redirection for "+path)
+				return
+			}
+
+			qr, err := encodeQR([]byte(publicURL(req, path)))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if format == "svg" {
+				w.Header().Set("Content-Type", "image/svg+xml")
+				w.Write(qr.svg(size))
+				return
+			}
+			buf := new(bytes.Buffer)
+			if err := png.Encode(buf, qr.image(size)); err != nil {
+				log.Println("QR code:", err)
+				writeError(w, http.StatusInternalServerError, "Error rendering QR code")
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(buf.Bytes())
+		})
+	}
+}
+
+// Render the code with its quiet zone in a square image about size pixels
+// across, with each module a whole number of pixels.
+func (qr *qrCode) image(size int) image.Image {
+	modules := qr.size + 2*qrQuietZone
+	scale := max(size/modules, 1)
+	img := image.NewPaletted(image.Rect(0, 0, modules*scale, modules*scale), color.Palette{color.White, color.Black})
+	for y, row := range qr.modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex((x+qrQuietZone)*scale+px, (y+qrQuietZone)*scale+py, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// Render the code with its quiet zone as an SVG image size pixels across.
+func (qr *qrCode) svg(size int) []byte {
+	modules := qr.size + 2*qrQuietZone
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, modules, modules)
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, modules, modules)
+	for y, row := range qr.modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(buf, "M%d %dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>` + "\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestQRHandler(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/promo": "/sale", "/p/{id}": "/products/{id}"}}`)
+
+	w := tr.do("GET", "/_qr?path=/promo&size=512", "")
+	tr.expectStatus(w, http.StatusOK)
+	if w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("got %s, want a PNG", w.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Modules are whole pixels, so the image may be a little smaller.
+	if size := img.Bounds().Dx(); size > 512 || size < 400 || img.Bounds().Dy() != size {
+		t.Errorf("got a %v image, want about 512 pixels square", img.Bounds())
+	}
+
+	w = tr.do("GET", "/_qr?path=/p/42&format=svg", "")
+	tr.expectStatus(w, http.StatusOK)
+	if w.Header().Get("Content-Type") != "image/svg+xml" || !strings.HasPrefix(w.Body.String(), "<svg ") {
+		t.Errorf("got %s: %.40s", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	// A typo isn't printed.
+	tr.expectStatus(tr.do("GET", "/_qr?path=/prmo", ""), http.StatusNotFound)
+	tr.expectStatus(tr.do("GET", "/_qr", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.do("GET", "/_qr?path=/promo&size=0", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.do("GET", "/_qr?path=/promo&format=gif", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "GET", "/_qr?path=/promo", ""), http.StatusUnauthorized)
+}
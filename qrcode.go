@@ -0,0 +1,442 @@
+package main
+
+import (
+	"errors"
+)
+
+// A qrCode is a QR code symbol, encoded in byte mode at error correction
+// level M, which survives about 15% of the symbol being damaged, such as by
+// a fold in a poster. modules[y][x] is true for dark modules.
+type qrCode struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+var errTooLong = errors.New("too long for a QR code")
+
+// Error correction codewords per block, and the number of blocks, for each
+// version at level M. Index 0 is unused.
+var qrECCPerBlock = [41]int{
+	-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26,
+	26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+}
+var qrECCBlocks = [41]int{
+	-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16,
+	17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49,
+}
+
+// The format bits for level M.
+const qrLevelM = 0
+
+// Encode data in the smallest QR code that holds it, with the mask that
+// makes it easiest to scan.
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 1
+	for ; version <= 40; version++ {
+		countBits := 8
+		if version > 9 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= qrDataCodewords(version)*8 {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, errTooLong
+	}
+
+	// Byte mode, the length, the data, and a terminator, padded out to the
+	// capacity with alternating pad bytes.
+	var bits qrBits
+	bits.append(0x4, 4)
+	if version > 9 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := qrDataCodewords(version) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	qr := newQRCode(version)
+	qr.drawFunctionPatterns()
+	qr.drawCodewords(qr.addECC(codewords))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask)
+	}
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+	return qr, nil
+}
+
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	qr := &qrCode{version: version, size: size}
+	qr.modules = make([][]bool, size)
+	qr.function = make([][]bool, size)
+	for y := range qr.modules {
+		qr.modules[y] = make([]bool, size)
+		qr.function[y] = make([]bool, size)
+	}
+	return qr
+}
+
+// The number of modules for data and error correction in a version.
+func qrRawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// The number of data codewords a version holds at level M.
+func qrDataCodewords(version int) int {
+	return qrRawModules(version)/8 - qrECCPerBlock[version]*qrECCBlocks[version]
+}
+
+// A bit string, most significant bit first.
+type qrBits []bool
+
+func (bits *qrBits) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bits = append(*bits, value>>i&1 != 0)
+	}
+}
+
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+// Draw the finder, timing, and alignment patterns, and the version, with
+// room kept for the format bits.
+func (qr *qrCode) drawFunctionPatterns() {
+	for i := 0; i < qr.size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	for _, c := range [][2]int{{3, 3}, {qr.size - 4, 3}, {3, qr.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= qr.size || y < 0 || y >= qr.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				qr.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	positions := qr.alignmentPositions()
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// Alignment patterns don't overlap the finders.
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	qr.drawFormatBits(0)
+	if qr.version >= 7 {
+		rem := qr.version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := qr.version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 != 0
+			a, b := qr.size-11+i%3, i/3
+			qr.setFunction(a, b, dark)
+			qr.setFunction(b, a, dark)
+		}
+	}
+}
+
+// The centers of the alignment patterns, in each direction.
+func (qr *qrCode) alignmentPositions() []int {
+	if qr.version == 1 {
+		return nil
+	}
+	n := qr.version/7 + 2
+	step := (qr.version*8 + n*3 + 5) / (n*4 - 4) * 2
+	positions := make([]int, n)
+	positions[0] = 6
+	for i, pos := n-1, qr.size-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// Draw both copies of the format bits, which give the error correction level
+// and mask.
+func (qr *qrCode) drawFormatBits(mask int) {
+	data := qrLevelM<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.size-8, true)
+}
+
+// Split the data into blocks, add Reed-Solomon error correction to each, and
+// interleave them.
+func (qr *qrCode) addECC(data []byte) []byte {
+	numBlocks, eccLen := qrECCBlocks[qr.version], qrECCPerBlock[qr.version]
+	raw := qrRawModules(qr.version) / 8
+	numShort, shortLen := numBlocks-raw%numBlocks, raw/numBlocks
+	divisor := rsDivisor(eccLen)
+
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			// Short blocks are padded so the blocks line up; the padding is
+			// skipped when interleaving.
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, raw)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// The generator polynomial for Reed-Solomon codes of a degree, without its
+// leading term.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// The Reed-Solomon error correction codewords for data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// Multiply in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// Draw the codewords in the zigzag order, two columns at a time, from the
+// bottom right.
+func (qr *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern.
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if !qr.function[y][x] && i < len(data)*8 {
+					qr.modules[y][x] = data[i>>3]>>(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// Flip the data modules chosen by a mask. Applying it twice undoes it.
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !qr.function[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// How hard the symbol is to scan: long runs and blocks of one color,
+// patterns that look like finders, and an imbalance of dark and light are
+// penalized.
+func (qr *qrCode) penalty() int {
+	penalty := 0
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	line := make([]bool, qr.size)
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < qr.size; a++ {
+			for b := range line {
+				if vertical {
+					line[b] = qr.modules[b][a]
+				} else {
+					line[b] = qr.modules[a][b]
+				}
+			}
+			run := 1
+			for b := 1; b <= len(line); b++ {
+				if b < len(line) && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+			for b := 0; b+11 <= len(line); b++ {
+				for _, pattern := range finderLike {
+					if equalBools(line[b:b+11], pattern) {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < qr.size && y+1 < qr.size {
+				c := qr.modules[y][x]
+				if c == qr.modules[y][x+1] && c == qr.modules[y+1][x] && c == qr.modules[y+1][x+1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := qr.size * qr.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return penalty + k*10
+}
+
+func equalBools(a, b []bool) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
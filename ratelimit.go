@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Admin requests are strictly limited per client to slow brute forcing.
+var adminRate *float64 = flag.Float64("admin-rate", 1, "admin requests per second per client (0 for no limit)")
+var adminBurst *int = flag.Int("admin-burst", 10, "admin requests a client may make at once")
+
+// Redirect lookups may be limited per client and in total.
+var lookupRate *float64 = flag.Float64("rate", 0, "redirect lookups per second per client (0 for no limit)")
+var lookupBurst *int = flag.Int("burst", 20, "redirect lookups a client may make at once")
+var globalRate *float64 = flag.Float64("global-rate", 0, "redirect lookups per second in total (0 for no limit)")
+var globalBurst *int = flag.Int("global-burst", 200, "redirect lookups that may be made at once in total")
+
+// A Limiter is a set of token buckets, one per key, each refilling at rate
+// tokens per second up to burst tokens. A nil Limiter allows everything.
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	clock     Clock
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Create a Limiter running on clock, or nil if rate is not positive.
+func NewLimiter(rate float64, burst int, clock Clock) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), lastSweep: clock.Now(), clock: clock}
+}
+
+// Allow takes a token from the bucket for key. If there are none, it reports
+// how long until there will be.
+func (limiter *Limiter) Allow(key string) (ok bool, retry time.Duration) {
+	if limiter == nil {
+		return true, 0
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := limiter.clock.Now()
+	limiter.sweep(now)
+	b := limiter.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = b
+	}
+	b.tokens = math.Min(limiter.burst, b.tokens+now.Sub(b.last).Seconds()*limiter.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limiter.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Forget the buckets that have refilled, at most once a minute, so clients
+// that have gone away don't hold memory.
+func (limiter *Limiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < time.Minute {
+		return
+	}
+	limiter.lastSweep = now
+	full := time.Duration(limiter.burst / limiter.rate * float64(time.Second))
+	for key, b := range limiter.buckets {
+		if now.Sub(b.last) > full {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// Check the request against the limiters, keyed by the client's address for
+// the per-client ones. If any is exhausted, respond with
+// http.StatusTooManyRequests and a Retry-After, and return false.
+func allowRequest(w http.ResponseWriter, req *http.Request, global, perClient *Limiter) bool {
+	ok, retry := global.Allow("")
+	if ok {
+		ok, retry = perClient.Allow(realAddr(req))
+	}
+	if ok {
+		return true
+	}
+	log.Println(realAddr(req), "rate limited", req.Method, req.URL.Path)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "Too many requests")
+	return false
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdminRateLimit(t *testing.T) {
+	t.Parallel()
+	manual := NewManualClock(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC))
+	tr := newTestRedirectorWithClock(t, manual, `{"redirections": {"/a": "/b"}, "admin": {"allow": ["192.0.2.0/24"]}}`)
+	tr.adminLimit = NewLimiter(1, 2, manual)
+
+	tr.expectStatus(tr.do("GET", "/_owners", ""), http.StatusOK)
+	tr.expectStatus(tr.do("PUT", "/_profile", ""), http.StatusBadRequest)
+	w := tr.do("GET", "/_config", "")
+	tr.expectStatus(w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("got Retry-After %q, want 1", w.Header().Get("Retry-After"))
+	}
+	tr.expectStatus(tr.do("GET", "/_audit", ""), http.StatusTooManyRequests)
+
+	// Each client has its own limit, and redirections have none.
+	tr.expectStatus(tr.doFrom("192.0.2.1", "GET", "/_owners", ""), http.StatusOK)
+	tr.expectRedirect("/a", http.StatusFound, "/b")
+
+	manual.Advance(time.Second)
+	tr.expectStatus(tr.do("GET", "/_owners", ""), http.StatusOK)
+	tr.expectStatus(tr.do("GET", "/_owners", ""), http.StatusTooManyRequests)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A Resolution says what a request for a path would get, without making it.
+// Match is "exact" or "wildcard", Host names the host, or host pattern, whose
+// redirections the rule is one of, if any, and Profile names the active
+// profile if the rule is one of its redirections. Action is what is served: a
+// "redirect", a "proxy" of the destination, a link "preview", a "block" page
+// for crawlers, the rule's own response ("respond"), "not_found", a pass to
+// the "upstream", or a holding page while the path is under "maintenance".
+type Resolution struct {
+	Path        string `json:"path"`
+	Host        string `json:"host,omitempty"`
+	UserAgent   string `json:"user_agent,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Match       string `json:"match,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+	Rule        *Rule  `json:"rule,omitempty"`
+	Action      string `json:"action"`
+	Destination string `json:"destination,omitempty"`
+	Code        int    `json:"code"`
+}
+
+// Resolution actions.
+const (
+	ActionRedirect    = "redirect"
+	ActionProxy       = "proxy"
+	ActionPreview     = "preview"
+	ActionBlock       = "block"
+	ActionRespond     = "respond"
+	ActionNotFound    = "not_found"
+	ActionMaintenance = "maintenance"
+)
+
+// Resolve works out what Get would do for a request for path on host from a
+// client with the user agent ua.
+func (redir *Redirector) Resolve(host, path, ua string) *Resolution {
+	redir.mu.RLock()
+	defer redir.mu.RUnlock()
+
+	res := &Resolution{Path: path, UserAgent: ua}
+	if redir.Maintenance.holding(path) != nil {
+		res.Action, res.Code = ActionMaintenance, http.StatusServiceUnavailable
+		return res
+	}
+	rule, source, destination, ok := redir.lookup(host, path)
+	if !ok {
+		if redir.Upstream != nil {
+			res.Action, res.Destination, res.Code = ActionUpstream, redir.Upstream.URL, http.StatusOK
+		} else if redir.DefaultDestination != "" {
+			res.Action, res.Destination, res.Code = ActionRedirect, redir.DefaultDestination, redir.DefaultCode
+			if res.Code == 0 {
+				res.Code = redir.code
+			}
+		} else {
+			res.Action, res.Code = ActionNotFound, http.StatusNotFound
+		}
+		return res
+	}
+
+	if rule.script != nil {
+		// Scripts see a plain GET for the path, with only the host and user
+		// agent of the request, and no address or geo.
+		req := &http.Request{Method: "GET", URL: &url.URL{Path: path}, Host: host, Header: http.Header{}}
+		if ua != "" {
+			req.Header.Set("User-Agent", ua)
+		}
+		destination = redir.scriptDestination(source, rule, req, destination)
+	}
+	res.Source, res.Rule, res.Destination = source, rule, destination
+	res.Match = "exact"
+	if isWildcard(source) {
+		res.Match = "wildcard"
+	}
+	if hr, _ := redir.forHost(host); hr != nil && strings.HasPrefix(source, hr.host) {
+		res.Host = hr.host
+	} else if redir.Profile != "" {
+		if _, _, _, inProfile := findRule(redir.Profiles[redir.Profile], redir.profileWildcards, path); inProfile {
+			res.Profile = redir.Profile
+		}
+	}
+	switch policy := rule.agentPolicy(ua); {
+	case rule.Respond != nil:
+		res.Action, res.Code = ActionRespond, rule.Respond.Status
+	case destination == "":
+		res.Action, res.Code = ActionNotFound, http.StatusNotFound
+	case policy == "preview":
+		res.Action, res.Code = ActionPreview, http.StatusOK
+	case policy == CrawlersBlock:
+		res.Action, res.Code = ActionBlock, http.StatusOK
+	case policy == CrawlersNotFound:
+		res.Action, res.Code = ActionNotFound, http.StatusNotFound
+	case rule.proxy != nil:
+		res.Action, res.Code = ActionProxy, http.StatusOK
+	default:
+		res.Action, res.Code = ActionRedirect, rule.Code
+		res.Destination = redir.addTracking(destination, path, source, rule)
+		if res.Code == 0 {
+			res.Code = redir.code
+		}
+	}
+	return res
+}
+
+// Serve /_resolve?path=/foo&host=...&ua=..., which says which rule a request
+// would match and what it would get, for debugging the configuration. The
+// host and user agent default to the requester's own.
+func (redir *Redirector) ResolveHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			query := req.URL.Query()
+			path := query.Get("path")
+			if path == "" {
+				writeError(w, http.StatusBadRequest, "Missing path")
+				return
+			}
+			host := requestHost(req)
+			if query.Has("host") {
+				host = strings.ToLower(query.Get("host"))
+			}
+			ua := req.UserAgent()
+			if query.Has("ua") {
+				ua = query.Get("ua")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(redir.Resolve(host, path, ua))
+		})
+	}
+}
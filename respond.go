@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// A Respond answers requests itself instead of redirecting them, for retired
+// URLs that should be gone rather than moved:
+//
+//	"/old-promo": {"respond": {"status": 410, "body": "This promotion has ended."}}
+//	"/ping": {"respond": {"status": 204}}
+//
+// The body is sent as plain text unless ContentType says otherwise.
+type Respond struct {
+	Status      int    `json:"status"`
+	Body        string `json:"body,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Check a response. Redirection codes belong in a rule's destination and
+// code, and 1xx codes aren't final responses.
+func (respond *Respond) check() error {
+	if respond.Status < 200 || respond.Status > 599 || respond.Status >= 300 && respond.Status < 400 {
+		return fmt.Errorf("%d is not a status to respond with", respond.Status)
+	}
+	if respond.Body != "" && !bodyAllowed(respond.Status) {
+		return fmt.Errorf("a %d response has no body", respond.Status)
+	}
+	return nil
+}
+
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusResetContent
+}
+
+func serveRespond(w http.ResponseWriter, req *http.Request, respond *Respond) {
+	log.Println(realAddr(req), "responded", respond.Status, "for", req.URL.Path)
+	if respond.Body == "" {
+		w.WriteHeader(respond.Status)
+		return
+	}
+	contentType := respond.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(respond.Status)
+	if req.Method != "HEAD" {
+		io.WriteString(w, respond.Body)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+func listen(addrs []string) ([]net.Listener, error) {
+	return listenAll(addrs)
+}
+
+// Restarting without dropping connections needs Unix signals and inherited
+// file descriptors.
+func restartOnSignal(servers []*http.Server, listeners []net.Listener) <-chan struct{} {
+	return nil
+}
+
+// There is no SIGHUP to reload on; -watch still works.
+func reloadOnHangup(redir *Redirector, path string) {}
+
+func signalRestart(pid int) error {
+	return errors.New("restarting the server is not supported on this platform")
+}
@@ -0,0 +1,148 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// A restarted server finds its inherited listeners' file descriptors here,
+// separated by commas.
+const listenerEnv = "FOFF_LISTENER_FD"
+
+// The first file descriptor passed by systemd socket activation.
+const systemdListenFD = 3
+
+// Listen on each of addrs, or take over the listeners inherited from the
+// server that restarted into this one or passed by systemd socket
+// activation, which must be one for each address, in the same order.
+func listen(addrs []string) ([]net.Listener, error) {
+	var fds []int
+	if value := os.Getenv(listenerEnv); value != "" {
+		os.Unsetenv(listenerEnv)
+		for _, field := range strings.Split(value, ",") {
+			fd, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, err
+			}
+			fds = append(fds, fd)
+		}
+	} else if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) && os.Getenv("LISTEN_FDS") != "" {
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			os.Unsetenv(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			fds = append(fds, systemdListenFD+i)
+		}
+	}
+	if fds == nil {
+		return listenAll(addrs)
+	}
+	if len(fds) != len(addrs) {
+		return nil, fmt.Errorf("%d sockets were passed for %d addresses", len(fds), len(addrs))
+	}
+
+	listeners := make([]net.Listener, len(fds))
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "listener")
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners[i] = listener
+	}
+	return listeners, nil
+}
+
+// On SIGUSR2, start the executable again, handing it the listeners, and shut
+// these servers down once their requests are finished. The listeners stay
+// open throughout, so no connections are refused. This is how selfupdate
+// switches to a new binary. The returned channel is closed once the servers
+// have shut down.
+func restartOnSignal(servers []*http.Server, listeners []net.Listener) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for range signals {
+			if err := reexec(listeners); err != nil {
+				log.Println("restart:", err)
+				continue
+			}
+			log.Println("restarted; shutting down", os.Getpid())
+			for _, listener := range listeners {
+				keepSocket(listener)
+			}
+			for _, server := range servers {
+				server.Shutdown(context.Background())
+			}
+			close(done)
+			return
+		}
+	}()
+	return done
+}
+
+func reexec(listeners []net.Listener) error {
+	var files []*os.File
+	var fds []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, listener := range listeners {
+		filer, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return syscall.EINVAL
+		}
+		f, err := filer.File()
+		if err != nil {
+			return err
+		}
+		// ExtraFiles start at file descriptor 3.
+		fds = append(fds, strconv.Itoa(3+len(files)))
+		files = append(files, f)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), listenerEnv+"="+strings.Join(fds, ","))
+	cmd.ExtraFiles = files
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Start()
+}
+
+// On SIGHUP, reload the configuration from path.
+func reloadOnHangup(redir *Redirector, path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			redir.reload(path, "SIGHUP")
+		}
+	}()
+}
+
+// Tell the server with the process ID to restart.
+func signalRestart(pid int) error {
+	return syscall.Kill(pid, syscall.SIGUSR2)
+}
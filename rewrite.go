@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A destination rewritten: a rule's among the redirections, a profile's, or
+// a host's, or a named destination, whose old and new values are the
+// destinations of rules of their own.
+type rewriteChange struct {
+	ruleChange
+	profile, host, destination string
+}
+
+// A rewritten destination as /_rewrite shows it.
+type rewriteDiff struct {
+	Source      string `json:"source,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Old         *Rule  `json:"old"`
+	New         *Rule  `json:"new"`
+}
+
+// RewriteDestinations replaces the destinations of the redirections, those
+// of the profiles and hosts, and the named destinations with what replace
+// returns for them, if it reports a change, returning what changed. Unless
+// dryRun, the changes are applied, as long as the configuration they make is
+// valid and, unless forced, there are no more of them than -max-change
+// allows. Only the changes to the redirections are replicated to peers.
+func (redir *Redirector) RewriteDestinations(replace func(to string) (string, bool), dryRun, force bool) ([]rewriteChange, error) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+
+	// Nothing else changes the configuration while the update lock is held,
+	// so the changes are still good once it is locked for writing.
+	redir.mu.RLock()
+	candidate := redir.Config.clone()
+	redir.mu.RUnlock()
+	rewrites, total := candidate.rewrite(replace)
+	if len(rewrites) == 0 {
+		return rewrites, nil
+	}
+	if err := candidate.compile(); err != nil {
+		return rewrites, err
+	}
+	if dryRun {
+		return rewrites, nil
+	}
+	if err := checkChangeRate(ConfigChanges{Updated: len(rewrites)}, total, force); err != nil {
+		return rewrites, err
+	}
+
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+	redir.Config = *candidate
+	for _, rw := range rewrites {
+		if rw.profile == "" && rw.host == "" && rw.destination == "" {
+			redir.peers.replicate(rw.source, rw.new)
+		}
+	}
+	redir.changed("rewrite destinations")
+	return rewrites, nil
+}
+
+// Rewrite the destinations of the configuration, which must be a clone of
+// the live one, returning what changed, with the redirections' first, in
+// order of source, and how many destinations there are in all.
+func (config *Config) rewrite(replace func(to string) (string, bool)) (rewrites []rewriteChange, total int) {
+	// Live rules are never modified, so each new destination goes on a copy
+	// of its rule, with a proxy built for it afresh, in a copy of the rules.
+	rewriteRules := func(rules Rules, profile, host string) Rules {
+		var changed []rewriteChange
+		rules.Each(func(source string, old *Rule) {
+			total++
+			if old.Respond != nil {
+				return
+			}
+			if to, ok := replace(old.To); ok && to != old.To {
+				rule := *old
+				rule.To, rule.proxy = to, nil
+				changed = append(changed, rewriteChange{ruleChange{source, old, &rule}, profile, host, ""})
+			}
+		})
+		if changed == nil {
+			return rules
+		}
+		sort.Slice(changed, func(i, j int) bool { return changed[i].source < changed[j].source })
+		rules = rules.clone()
+		for _, rw := range changed {
+			rules.Set(rw.source, rw.new)
+		}
+		rewrites = append(rewrites, changed...)
+		return rules
+	}
+	config.Redirections = rewriteRules(config.Redirections, "", "")
+	for _, name := range slices.Sorted(maps.Keys(config.Profiles)) {
+		config.Profiles[name] = rewriteRules(config.Profiles[name], name, "")
+	}
+	for _, host := range slices.Sorted(maps.Keys(config.Hosts)) {
+		config.Hosts[host] = rewriteRules(config.Hosts[host], "", host)
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.Destinations)) {
+		total++
+		old := config.Destinations[name]
+		if to, ok := replace(old); ok && to != old {
+			config.Destinations[name] = to
+			rewrites = append(rewrites, rewriteChange{ruleChange{"", &Rule{To: old}, &Rule{To: to}}, "", "", name})
+		}
+	}
+	return rewrites, total
+}
+
+// The RewriteHandler finds and replaces text in the destinations of all of
+// the rules and the named destinations (POST /_rewrite), such as to move them
+// from http:// to https:// or from an old domain to a new one. "find" is replaced with
+// "replace", or with "regexp=true", "find" is a regular expression and
+// "replace" may refer to its groups as $1. With "dry_run=true", the changes
+// are shown without being applied.
+func (redir *Redirector) RewriteHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "POST" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			redir.idempotency.serve(w, req, redir.rewrite)
+		})
+	}
+}
+
+func (redir *Redirector) rewrite(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad form")
+		return
+	}
+	find, replacement := req.Form.Get("find"), req.Form.Get("replace")
+	if find == "" {
+		writeError(w, http.StatusBadRequest, "find is required", paramError("find", "is required"))
+		return
+	}
+	dryRun, _ := strconv.ParseBool(req.Form.Get("dry_run"))
+	replace := func(to string) (string, bool) {
+		return strings.ReplaceAll(to, find, replacement), strings.Contains(to, find)
+	}
+	if useRegexp, _ := strconv.ParseBool(req.Form.Get("regexp")); useRegexp {
+		pattern, err := regexp.Compile(find)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Bad regexp: "+err.Error())
+			return
+		}
+		replace = func(to string) (string, bool) {
+			return pattern.ReplaceAllString(to, replacement), pattern.MatchString(to)
+		}
+	}
+
+	changes, err := redir.RewriteDestinations(replace, dryRun, forced(req))
+	if _, ok := err.(*tooManyChanges); ok {
+		refuseChange(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad destination: "+err.Error(), configErrorDetails(err)...)
+		return
+	}
+	diff := []rewriteDiff{}
+	for _, rw := range changes {
+		diff = append(diff, rewriteDiff{rw.source, rw.profile, rw.host, rw.destination, rw.old, rw.new})
+		if !dryRun && rw.profile == "" && rw.host == "" && rw.destination == "" {
+			redir.notify(redir.ruleEvent(req, rw.source, rw.old, rw.new))
+		}
+	}
+	if !dryRun {
+		log.Println(realAddr(req), "rewrote", len(changes), "destinations")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"dry_run": dryRun, "changes": diff})
+}
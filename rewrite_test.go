@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	tr := newTestRedirector(t, `{
+		"redirections": {"/blog": "http://old.example.com/blog", "/docs": "@docs/start", "/home": "/"},
+		"destinations": {"docs": "http://old.example.com/docs"},
+		"profiles": {"sale": {"/blog": "http://old.example.com/sale"}},
+		"hosts": {"go.example.com": {"/blog": "http://old.example.com/go"}}
+	}`)
+	form := []string{"Content-Type", "application/x-www-form-urlencoded"}
+	rewrite := func(body string) []rewriteDiff {
+		t.Helper()
+		w := tr.do("POST", "/_rewrite", body, form...)
+		tr.expectStatus(w, http.StatusOK)
+		var result struct{ Changes []rewriteDiff }
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result.Changes
+	}
+
+	changes := rewrite("find=http://old.example.com&replace=https://new.example.com&dry_run=true")
+	want := []rewriteDiff{
+		{Source: "/blog", New: &Rule{To: "https://new.example.com/blog"}},
+		{Source: "/blog", Profile: "sale", New: &Rule{To: "https://new.example.com/sale"}},
+		{Source: "/blog", Host: "go.example.com", New: &Rule{To: "https://new.example.com/go"}},
+		{Destination: "docs", New: &Rule{To: "https://new.example.com/docs"}},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	for i, change := range changes {
+		if change.Source != want[i].Source || change.Profile != want[i].Profile || change.Host != want[i].Host ||
+			change.Destination != want[i].Destination || change.New.To != want[i].New.To {
+			t.Errorf("change %d: got %+v to %q, want %+v", i, change, change.New.To, want[i])
+		}
+	}
+	tr.expectRedirect("/blog", http.StatusFound, "http://old.example.com/blog")
+
+	rewrite("find=http://old.example.com&replace=https://new.example.com")
+	tr.expectRedirect("/blog", http.StatusFound, "https://new.example.com/blog")
+	tr.expectRedirect("/docs", http.StatusFound, "https://new.example.com/docs/start")
+	tr.expectResolves("go.example.com", "/blog", "go.example.com/blog", "https://new.example.com/go")
+	tr.expectStatus(tr.do("PUT", "/_profile", "sale"), http.StatusOK)
+	tr.expectRedirect("/blog", http.StatusFound, "https://new.example.com/sale")
+
+	// Nothing changes unless every new destination is valid.
+	tr.expectStatus(tr.do("POST", "/_rewrite", "find=https://new.example.com/go&replace=go", form...), http.StatusBadRequest)
+	tr.expectResolves("go.example.com", "/blog", "go.example.com/blog", "https://new.example.com/go")
+	if changes := rewrite("find=nowhere&replace=somewhere"); len(changes) != 0 {
+		t.Errorf("got %+v", changes)
+	}
+}
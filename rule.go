@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A Rule is a single redirection. In the configuration, a rule is either the
+// destination string or an object with the destination in "to":
+//
+//	"/source": "/destination"
+//	"/source": {"to": "/destination", "group": "spring-sale"}
+//
+// Code overrides the server's redirection code for this rule, so permanent
+// moves can be sent with a 301 while campaign links stay temporary.
+//
+// A rule with a preview serves link preview crawlers its Open Graph metadata
+// instead of redirecting them. Crawlers says what search engine crawlers get:
+// the redirect ("redirect", the default), a page they should not index
+// ("block"), or a 404 ("404").
+//
+// A rule with mode "proxy" serves the content at its destination, which must
+// be an absolute URL, with Headers set on the proxied request.
+//
+// A rule with an alert notifies the webhooks when its traffic crosses the
+// alert's threshold.
+//
+// Owner names the team or API key responsible for the rule. Tags, Ticket,
+// Description, CreatedBy, and CreatedAt are notes about it for the people
+// sharing the server, such as the campaign it is for and the ticket that
+// asked for it, which the server keeps but doesn't act on. Rules PUT through
+// the API are stamped with who created them and when, unless they say.
+//
+// Tracking adds parameters to the destination's query (see trackingParams).
+//
+// Start and End limit when the rule is active (see schedule).
+//
+// A rule with Respond answers with a status and body of its own instead of a
+// destination (see Respond).
+//
+// Canonical replaces the configuration's canonical settings for requests
+// matching the rule (see Canonical).
+//
+// A rule with a script computes its destination for each request, falling
+// back to To (see script).
+type Rule struct {
+	To          string            `json:"to,omitempty"`
+	Code        int               `json:"code,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Preview     *Preview          `json:"preview,omitempty"`
+	Crawlers    string            `json:"crawlers,omitempty"`
+	Mode        string            `json:"mode,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Cache       *ProxyCache       `json:"cache,omitempty"`
+	Alert       *Alert            `json:"alert,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Ticket      string            `json:"ticket,omitempty"`
+	Description string            `json:"description,omitempty"`
+	CreatedBy   string            `json:"created_by,omitempty"`
+	CreatedAt   time.Time         `json:"created_at,omitzero"`
+	Tracking    map[string]string `json:"tracking,omitempty"`
+	Start       string            `json:"start,omitempty"`
+	End         string            `json:"end,omitempty"`
+	Respond     *Respond          `json:"respond,omitempty"`
+	Script      string            `json:"script,omitempty"`
+	Canonical   *Canonical        `json:"canonical,omitempty"`
+	proxy       *httputil.ReverseProxy
+	cache       *responseCache
+	tracking    trackingParams
+	schedule    *schedule
+	script      *script
+}
+
+// Rule modes. Rules redirect unless they say otherwise.
+const (
+	ModeRedirect = "redirect"
+	ModeProxy    = "proxy"
+)
+
+// ruleFields has the same fields as Rule without its JSON methods.
+type ruleFields Rule
+
+// A rule is only written as an object when it has more than a destination.
+func (rule *Rule) simple() bool {
+	return rule.Code == 0 && rule.Group == "" && rule.Preview == nil && rule.Crawlers == "" &&
+		rule.Mode == "" && rule.Headers == nil && rule.Cache == nil && rule.Alert == nil && rule.Owner == "" &&
+		rule.Tags == nil && rule.Ticket == "" && rule.Description == "" && rule.CreatedBy == "" && rule.CreatedAt.IsZero() &&
+		rule.Tracking == nil && rule.Start == "" && rule.End == "" &&
+		rule.Respond == nil && rule.Script == "" && rule.Canonical == nil
+}
+
+// Whether two rules are configured the same way.
+func (rule *Rule) equal(other *Rule) bool {
+	a, errA := json.Marshal(rule)
+	b, errB := json.Marshal(other)
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+func (rule *Rule) UnmarshalJSON(data []byte) error {
+	var to string
+	if err := json.Unmarshal(data, &to); err == nil {
+		*rule = Rule{To: to}
+		return nil
+	}
+	return json.Unmarshal(data, (*ruleFields)(rule))
+}
+
+func (rule *Rule) MarshalJSON() ([]byte, error) {
+	if rule.simple() {
+		return json.Marshal(rule.To)
+	}
+	return json.Marshal((*ruleFields)(rule))
+}
+
+// A Group holds settings shared by the rules that name it, such as the body
+// sent along with redirects, the campaign it belongs to, and the time zone
+// its rules are scheduled and counted by day in.
+type Group struct {
+	Campaign     string `json:"campaign,omitempty"`
+	RedirectBody string `json:"redirect_body,omitempty"`
+	TimeZone     string `json:"time_zone,omitempty"`
+	body         *template.Template
+	location     *time.Location
+}
+
+// The data available to redirect body templates.
+type redirectData struct {
+	Path        string
+	Destination string
+	Code        int
+	Campaign    string
+}
+
+// The schemes destinations may have, so that a rule can't send clients to
+// javascript: or data: URLs.
+var destinationSchemes *string = flag.String("destination-schemes", "http,https", "comma-separated URL schemes destinations may have, besides paths on this server")
+
+// Whether destinations may have scheme.
+func allowedScheme(scheme string) bool {
+	for _, allowed := range strings.Split(*destinationSchemes, ",") {
+		if strings.EqualFold(strings.TrimSpace(allowed), scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that a destination is an absolute path or an absolute URL with an
+// allowed scheme, which is all a Location header can reliably hold.
+func checkDestination(to string) error {
+	if to == "" {
+		return errors.New("no destination")
+	}
+	if strings.ContainsAny(to, " \t\r\n") {
+		return fmt.Errorf("destination %q contains whitespace", to)
+	}
+	// Browsers take backslashes for slashes, so /\example.com leaves the site
+	// as //example.com does.
+	if strings.Contains(to, `\`) {
+		return fmt.Errorf("destination %q contains a backslash", to)
+	}
+	u, err := url.Parse(to)
+	if err != nil {
+		return fmt.Errorf("malformed destination %q", to)
+	}
+	switch {
+	case u.Scheme == "" && strings.HasPrefix(to, "//"):
+		return fmt.Errorf("destination %q needs a scheme", to)
+	case u.Scheme == "" && !strings.HasPrefix(to, "/"):
+		return fmt.Errorf("destination %q is not an absolute path or URL", to)
+	case u.Scheme != "" && !allowedScheme(u.Scheme):
+		return fmt.Errorf("destination %q has scheme %s, which -destination-schemes does not allow", to, u.Scheme)
+	case (u.Scheme == "http" || u.Scheme == "https") && u.Host == "":
+		return fmt.Errorf("destination %q has no host", to)
+	}
+	return nil
+}
+
+// Whether the rule has all of the tags.
+func (rule *Rule) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(rule.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCheckDestination(t *testing.T) {
+	for to, ok := range map[string]bool{
+		"/sale":                     true,
+		"/sale?from=spring#top":     true,
+		"https://example.com/sale":  true,
+		"mailto:sales@example.com":  false,
+		"":                          false,
+		"sale":                      false,
+		"/spring sale":              false,
+		"//example.com/sale":        false,
+		`/\example.com`:             false,
+		`\\example.com`:             false,
+		`/\/example.com`:            false,
+		`https:\\example.com`:       false,
+		`https://example.com\@shop`: false,
+		"https:///sale":             false,
+		"javascript:alert(1)":       false,
+		"/%5Cexample.com":           true,
+	} {
+		if err := checkDestination(to); (err == nil) != ok {
+			t.Errorf("%q: got %v", to, err)
+		}
+	}
+}
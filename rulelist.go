@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many redirections /_config/rules lists at once, unless asked for
+// fewer.
+const maxRulesPage = 1000
+
+// Note when the redirections that differ from those in previous changed. The
+// caller must hold both of the Redirector's locks.
+func (redir *Redirector) noteModified(previous *Config) {
+	now := redir.Clock().Now()
+	if redir.ruleModified == nil {
+		redir.ruleModified = make(map[string]time.Time)
+	}
+	redir.Redirections.Each(func(source string, rule *Rule) {
+		if _, known := redir.ruleModified[source]; known && previous != nil {
+			if old, ok := previous.Redirections.Get(source); ok && (old == rule || old.equal(rule)) {
+				return
+			}
+		}
+		redir.ruleModified[source] = now
+	})
+	for source := range redir.ruleModified {
+		if _, ok := redir.Redirections.Get(source); !ok {
+			delete(redir.ruleModified, source)
+		}
+	}
+}
+
+// A redirection as /_config/rules lists it, with its hits and when it last
+// changed.
+type ruleListing struct {
+	Source   string    `json:"source"`
+	Rule     *Rule     `json:"rule"`
+	Hits     int64     `json:"hits"`
+	Modified time.Time `json:"modified"`
+}
+
+// A page of redirections. Next is the offset of the next page, if there is
+// one.
+type rulePage struct {
+	Total  int           `json:"total"`
+	Offset int           `json:"offset"`
+	Next   int           `json:"next,omitempty"`
+	Rules  []ruleListing `json:"rules"`
+}
+
+// The hits on each redirection.
+func (stats *Stats) hitCounts() map[string]int64 {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	hits := make(map[string]int64, len(stats.rules))
+	for source, counters := range stats.rules {
+		hits[source] = counters.Hits
+	}
+	return hits
+}
+
+// The hits on the redirection from source.
+func (stats *Stats) ruleHits(source string) int64 {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if counters := stats.rules[source]; counters != nil {
+		return counters.Hits
+	}
+	return 0
+}
+
+// The RulesHandler lists the redirections a page at a time, for
+// configurations too large to fetch whole from /_config:
+//
+//	GET /_config/rules?limit=100&offset=200&prefix=/blog/&tag=spring&sort=hits&order=desc
+//
+// source and to keep the redirections whose source or destination contain
+// them, prefix and to_prefix those whose source or destination start with
+// them, tag, which may be repeated, those with all of the tags, and owner
+// those with that owner. sort is by "source" (the default), "hits", or
+// "modified", in ascending order unless order is "desc". limit is 100 by
+// default, and at most maxRulesPage. Clients with a token limited to some
+// prefixes only see the redirections under them.
+func (redir *Redirector) RulesHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyScoped(w, req, func(token *apiToken) {
+			query := req.URL.Query()
+			limit, offset := 100, 0
+			var err error
+			if value := query.Get("limit"); value != "" {
+				if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+					writeError(w, http.StatusBadRequest, "Bad limit", paramError("limit", "must be a positive integer"))
+					return
+				}
+				limit = min(limit, maxRulesPage)
+			}
+			if value := query.Get("offset"); value != "" {
+				if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+					writeError(w, http.StatusBadRequest, "Bad offset", paramError("offset", "must be an integer of at least 0"))
+					return
+				}
+			}
+			order := query.Get("sort")
+			switch order {
+			case "":
+				order = "source"
+			case "source", "hits", "modified":
+			default:
+				writeError(w, http.StatusBadRequest, "Unknown sort "+strconv.Quote(order), paramError("sort", `must be "source", "hits", or "modified"`))
+				return
+			}
+			desc := query.Get("order") == "desc"
+
+			hits := redir.stats.hitCounts()
+			var listings []ruleListing
+			redir.mu.RLock()
+			tags, owner := query["tag"], query["owner"]
+			redir.Redirections.Each(func(source string, rule *Rule) {
+				if !strings.Contains(source, query.Get("source")) || !strings.HasPrefix(source, query.Get("prefix")) ||
+					!strings.Contains(rule.To, query.Get("to")) || !strings.HasPrefix(rule.To, query.Get("to_prefix")) ||
+					!rule.hasTags(tags) || owner != nil && rule.Owner != owner[0] || !token.covers(source) {
+					return
+				}
+				listings = append(listings, ruleListing{source, rule, hits[source], redir.ruleModified[source]})
+			})
+			redir.mu.RUnlock()
+
+			sort.Slice(listings, func(i, j int) bool {
+				a, b := listings[i], listings[j]
+				if desc {
+					a, b = b, a
+				}
+				switch {
+				case order == "hits" && a.Hits != b.Hits:
+					return a.Hits < b.Hits
+				case order == "modified" && !a.Modified.Equal(b.Modified):
+					return a.Modified.Before(b.Modified)
+				}
+				return a.Source < b.Source
+			})
+			page := &rulePage{Total: len(listings), Offset: offset, Rules: []ruleListing{}}
+			if offset < len(listings) {
+				end := min(offset+limit, len(listings))
+				page.Rules = listings[offset:end]
+				if end < len(listings) {
+					page.Next = end
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetEscapeHTML(false)
+			enc.Encode(page)
+		})
+	}
+}
+
+// The RuleHandler inspects (GET), sets (PUT), and removes (DELETE) the
+// redirection from the source after /_config/rules, as PUT and DELETE on the
+// source itself do, without a GET being redirected
+// (GET /_config/rules/blog/launch for /blog/launch).
+func (redir *Redirector) RuleHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		source := strings.TrimPrefix(req.URL.Path, "/_config/rules")
+		redir.onlyRule(w, req, source, func() {
+			switch req.Method {
+			case "GET", "HEAD":
+				redir.writeRule(w, pathKey(source), http.StatusOK)
+			case "PUT":
+				redir.idempotency.serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+					redir.putRule(w, req, source)
+				})
+			case "DELETE":
+				redir.idempotency.serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+					redir.deleteRule(w, req, source)
+				})
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRulesListing(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {
+		"/a": "/1",
+		"/b": {"to": "https://example.com/2", "tags": ["spring"], "owner": "marketing"},
+		"/c": {"to": "https://example.com/3", "tags": ["spring", "print"]},
+		"/blog/d": "/4"
+	}}`)
+	tr.do("GET", "/c", "", "User-Agent", "Mozilla/5.0")
+	tr.do("GET", "/c", "", "User-Agent", "Mozilla/5.0")
+	tr.do("GET", "/a", "", "User-Agent", "Mozilla/5.0")
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+		next  int
+	}{
+		{"", []string{"/a", "/b", "/blog/d", "/c"}, 0},
+		{"?limit=2", []string{"/a", "/b"}, 2},
+		{"?limit=2&offset=2", []string{"/blog/d", "/c"}, 0},
+		{"?prefix=/b", []string{"/b", "/blog/d"}, 0},
+		{"?to_prefix=https://", []string{"/b", "/c"}, 0},
+		{"?tag=spring&tag=print", []string{"/c"}, 0},
+		{"?owner=marketing", []string{"/b"}, 0},
+		{"?sort=hits&order=desc&limit=2", []string{"/c", "/a"}, 2},
+	} {
+		w := tr.do("GET", "/_config/rules"+tt.query, "")
+		tr.expectStatus(w, http.StatusOK)
+		var page rulePage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, listing := range page.Rules {
+			got = append(got, listing.Source)
+		}
+		if len(got) != len(tt.want) || page.Next != tt.next {
+			t.Errorf("%s: got %v, next %d, want %v, next %d", tt.query, got, page.Next, tt.want, tt.next)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+	tr.expectStatus(tr.do("GET", "/_config/rules?sort=size", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.do("GET", "/_config/rules?limit=0", ""), http.StatusBadRequest)
+}
+
+func TestRuleHandler(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/blog/launch": "/news/launch"}}`)
+	w := tr.do("GET", "/_config/rules/blog/launch", "")
+	tr.expectStatus(w, http.StatusOK)
+	var listing ruleListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if listing.Source != "/blog/launch" || listing.Rule.To != "/news/launch" {
+		t.Errorf("got %+v", listing)
+	}
+	tr.expectStatus(tr.do("PUT", "/_config/rules/blog/launch", "/news/2012/launch"), http.StatusOK)
+	tr.expectRedirect("/blog/launch", http.StatusFound, "/news/2012/launch")
+	tr.expectStatus(tr.do("DELETE", "/_config/rules/blog/launch", ""), http.StatusNoContent)
+	tr.expectStatus(tr.do("GET", "/_config/rules/blog/launch", ""), http.StatusNotFound)
+}
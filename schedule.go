@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// Time zones are looked up by name, and not every system, such as a
+	// minimal container, has a zoneinfo database.
+	_ "time/tzdata"
+)
+
+// A rule with a start or an end is only active from its start until just
+// before its end, such as for a campaign; outside of that, requests are
+// served as if it didn't exist:
+//
+//	"/sale": {"to": "/black-friday", "group": "us", "start": "2026-11-27", "end": "2026-12-01"}
+//
+// Times with an offset (RFC 3339) are exact. Times without one are wall
+// clock times in the time zone of the rule's group, or the server's local
+// time if it has none, so that a campaign ending at midnight ends at the
+// advertiser's midnight:
+//
+//	"groups": {"us": {"time_zone": "America/New_York"}}
+type schedule struct {
+	start, end scheduleTime
+}
+
+// A time a rule starts or ends. Wall clock times are kept as if in UTC until
+// they are placed in a time zone.
+type scheduleTime struct {
+	time.Time
+	wall bool
+}
+
+// The layouts of wall clock times.
+var wallLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func parseScheduleTime(value string) (scheduleTime, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return scheduleTime{Time: t}, nil
+	}
+	for _, layout := range wallLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return scheduleTime{Time: t, wall: true}, nil
+		}
+	}
+	return scheduleTime{}, fmt.Errorf("bad time %q", value)
+}
+
+// The time in loc.
+func (t scheduleTime) in(loc *time.Location) time.Time {
+	if !t.wall {
+		return t.Time
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+}
+
+// Parse a rule's start and end.
+func compileSchedule(start, end string) (*schedule, error) {
+	s := new(schedule)
+	var err error
+	if start != "" {
+		if s.start, err = parseScheduleTime(start); err != nil {
+			return nil, fmt.Errorf("start: %v", err)
+		}
+	}
+	if end != "" {
+		if s.end, err = parseScheduleTime(end); err != nil {
+			return nil, fmt.Errorf("end: %v", err)
+		}
+	}
+	if start != "" && end != "" && s.start.wall == s.end.wall && !s.start.Before(s.end.Time) {
+		return nil, fmt.Errorf("end %s is not after start %s", end, start)
+	}
+	return s, nil
+}
+
+// The time zone for a rule: its group's, or the server's local time.
+func (config *Config) location(rule *Rule) *time.Location {
+	if group := config.Groups[rule.Group]; group != nil && group.location != nil {
+		return group.location
+	}
+	return time.Local
+}
+
+// Whether a rule is active at now.
+func (config *Config) active(rule *Rule, now time.Time) bool {
+	if rule.schedule == nil {
+		return true
+	}
+	loc := config.location(rule)
+	if !rule.schedule.start.IsZero() && now.Before(rule.schedule.start.in(loc)) {
+		return false
+	}
+	return rule.schedule.end.IsZero() || now.Before(rule.schedule.end.in(loc))
+}
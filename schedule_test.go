@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestScheduledRule(t *testing.T) {
+	t.Parallel()
+	manual := NewManualClock(time.Date(2026, 11, 26, 12, 0, 0, 0, time.UTC))
+	tr := newTestRedirectorWithClock(t, manual, `{
+		"redirections": {"/sale": {"to": "/black-friday", "group": "us", "start": "2026-11-27", "end": "2026-12-01"}},
+		"groups": {"us": {"time_zone": "America/New_York"}}
+	}`)
+	tr.expectNotFound("/sale")
+
+	// Midnight in New York is 5:00 in UTC.
+	manual.Set(time.Date(2026, 11, 27, 4, 59, 0, 0, time.UTC))
+	tr.expectNotFound("/sale")
+	manual.Advance(time.Minute)
+	tr.expectRedirect("/sale", http.StatusFound, "/black-friday")
+
+	manual.Set(time.Date(2026, 12, 1, 4, 59, 59, 0, time.UTC))
+	tr.expectRedirect("/sale", http.StatusFound, "/black-friday")
+	manual.Advance(time.Second)
+	tr.expectNotFound("/sale")
+}
+
+func TestScheduleTimes(t *testing.T) {
+	for _, tt := range []struct {
+		start, end string
+		ok         bool
+	}{
+		{"2026-11-27", "2026-12-01", true},
+		{"2026-11-27T09:00", "", true},
+		{"", "2026-12-01T00:00:00-05:00", true},
+		{"2026-12-01", "2026-11-27", false},
+		{"2026-12-01", "2026-12-01", false},
+		{"next week", "", false},
+	} {
+		_, err := compileSchedule(tt.start, tt.end)
+		if (err == nil) != tt.ok {
+			t.Errorf("start %q, end %q: got %v", tt.start, tt.end, err)
+		}
+	}
+}
@@ -0,0 +1,905 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A rule with a script computes its destination from the request, for the
+// odd cases static rules can't express. Scripts are expressions in the style
+// of CEL over the request's path, host, method, ip, query, headers, and geo:
+//
+//	"/app": {"script": "headers['user-agent'].contains('iPhone') ? 'https://apps.apple.com/app/id1' : 'https://play.google.com/store/apps/details?id=app'"}
+//	"/docs": {"script": "'/docs/' + (geo.country == 'DE' ? 'de' : 'en') + '/' + query.page", "to": "/docs/en/"}
+//
+// Query parameters, headers (by lowercase name), and geo (country, region,
+// and city, from the headers trusted proxies and CDNs set) that are missing
+// are empty strings. Strings have startsWith, endsWith, contains, matches (a
+// regular expression), lowerAscii, upperAscii, replace, trim, split, and
+// size; size, int, and string are also functions. There are no loops, and
+// each run is cut off after maxScriptSteps steps, -script-timeout, or
+// -script-memory bytes of strings and lists made.
+//
+// A script that returns an empty string or fails sends the request to the
+// rule's destination, if it has one, and otherwise to the 404 page.
+var scriptTimeout *time.Duration = flag.Duration("script-timeout", 10*time.Millisecond, "longest a rule's script may run")
+var scriptMemory *int = flag.Int("script-memory", 64<<10, "most bytes of strings and lists a rule's script may make")
+
+// Limits on scripts beyond those set by flags: how long their source may be,
+// how many steps a run may take, and how long a regular expression may be.
+const (
+	maxScriptLength  = 4096
+	maxScriptSteps   = 10000
+	maxScriptPattern = 1024
+)
+
+// The variables scripts can use.
+var scriptVars = map[string]bool{"path": true, "host": true, "method": true, "ip": true, "query": true, "headers": true, "geo": true}
+
+// The headers the geo variable is filled from, most preferred first, as set
+// by Cloudflare, CloudFront, and proxies that look addresses up themselves.
+var geoHeaders = map[string][]string{
+	"country": {"CF-IPCountry", "CloudFront-Viewer-Country", "X-Country-Code"},
+	"region":  {"CloudFront-Viewer-Country-Region", "X-Region-Code"},
+	"city":    {"CloudFront-Viewer-City", "X-City"},
+}
+
+// The methods of strings and the functions scripts can call, with how many
+// arguments each takes, not counting the string a method is called on.
+var (
+	scriptMethods = map[string]int{"startsWith": 1, "endsWith": 1, "contains": 1, "matches": 1,
+		"lowerAscii": 0, "upperAscii": 0, "replace": 2, "trim": 0, "split": 1, "size": 0}
+	scriptFuncs = map[string]int{"size": 1, "int": 1, "string": 1}
+)
+
+var (
+	errScriptSteps   = errors.New("script took too many steps")
+	errScriptTimeout = errors.New("script took too long")
+	errScriptMemory  = errors.New("script used too much memory")
+)
+
+// A compiled script.
+type script struct {
+	root scriptNode
+}
+
+func compileScript(source string) (*script, error) {
+	if len(source) > maxScriptLength {
+		return nil, fmt.Errorf("script is longer than %d bytes", maxScriptLength)
+	}
+	tokens, err := lexScript(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &scriptParser{tokens: tokens}
+	root, err := p.expr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("script: %v", err)
+	}
+	return &script{root: root}, nil
+}
+
+// Run the script for req, returning the destination it computes.
+func (s *script) run(req *http.Request) (string, error) {
+	env := &scriptEnv{vars: scriptRequestVars(req), deadline: time.Now().Add(*scriptTimeout)}
+	value, err := s.root.eval(env)
+	if err != nil {
+		return "", err
+	}
+	destination, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("script returned %s, not a string", typeName(value))
+	}
+	return destination, nil
+}
+
+// The destination rule's script computes for req, checked like any other
+// and with named destinations expanded, or fallback if it computes none.
+func (config *Config) scriptDestination(source string, rule *Rule, req *http.Request, fallback string) string {
+	destination, err := rule.script.run(req)
+	if err == nil && destination != "" {
+		if destination, _ = config.expand(destination); checkDestination(destination) == nil {
+			return destination
+		}
+		err = fmt.Errorf("bad destination %q", destination)
+	}
+	if err != nil {
+		log.Println("script for", source, "failed:", err)
+	}
+	return fallback
+}
+
+// The variables for a request.
+func scriptRequestVars(req *http.Request) map[string]any {
+	query := make(map[string]string)
+	for name, values := range req.URL.Query() {
+		query[name] = values[0]
+	}
+	headers := make(map[string]string, len(req.Header))
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+	headers["host"] = req.Host
+	geo := map[string]string{"country": "", "region": "", "city": ""}
+	if peer, err := peerAddr(req); err == nil && containsAddr(trustedProxies, peer) {
+		for field, names := range geoHeaders {
+			for _, name := range names {
+				if value := req.Header.Get(name); value != "" {
+					geo[field] = value
+					break
+				}
+			}
+		}
+	}
+	return map[string]any{
+		"path":    req.URL.Path,
+		"host":    requestHost(req),
+		"method":  req.Method,
+		"ip":      realAddr(req),
+		"query":   query,
+		"headers": headers,
+		"geo":     geo,
+	}
+}
+
+// The state of a run: its variables, and what it has used of its budget.
+type scriptEnv struct {
+	vars     map[string]any
+	steps    int
+	memory   int
+	deadline time.Time
+}
+
+func (env *scriptEnv) step() error {
+	env.steps++
+	if env.steps > maxScriptSteps {
+		return errScriptSteps
+	}
+	if env.steps%16 == 0 && time.Now().After(env.deadline) {
+		return errScriptTimeout
+	}
+	return nil
+}
+
+// Count n bytes made by the script.
+func (env *scriptEnv) alloc(n int) error {
+	if env.memory += n; env.memory > *scriptMemory {
+		return errScriptMemory
+	}
+	return nil
+}
+
+// Tokens.
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenString
+	tokenInt
+	tokenOp
+)
+
+type scriptToken struct {
+	kind  int
+	text  string
+	value any
+	pos   int
+}
+
+func (t scriptToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of script"
+	}
+	return fmt.Sprintf("%q at %d", t.text, t.pos)
+}
+
+// Operators, longest first so that <= isn't read as <.
+var scriptOps = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "?", ":", ".", ",", "(", ")", "[", "]"}
+
+func lexScript(s string) ([]scriptToken, error) {
+	var tokens []scriptToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			value, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, i)
+			}
+			tokens = append(tokens, scriptToken{tokenString, s[i : i+n], value, i})
+			i += n
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			n, err := strconv.ParseInt(s[i:j], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad number %s at %d", s[i:j], i)
+			}
+			tokens = append(tokens, scriptToken{tokenInt, s[i:j], n, i})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, scriptToken{tokenIdent, s[i:j], nil, i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range scriptOps {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, scriptToken{tokenOp, op, nil, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, scriptToken{kind: tokenEOF, pos: len(s)}), nil
+}
+
+// Read a quoted string at the start of s, returning its value and length.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case '\\', '\'', '"':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return "", 0, fmt.Errorf("unknown escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// A parser for scripts, with the precedence of CEL's operators:
+//
+//	expr   = or ["?" expr ":" expr]
+//	or     = and {"||" and}
+//	and    = rel {"&&" rel}
+//	rel    = add [("==" | "!=" | "<" | "<=" | ">" | ">=" | "in") add]
+//	add    = mul {("+" | "-") mul}
+//	mul    = unary {("*" | "/" | "%") unary}
+//	unary  = ("!" | "-") unary | member
+//	member = primary {"." ident ["(" args ")"] | "[" expr "]"}
+type scriptParser struct {
+	tokens []scriptToken
+	pos    int
+}
+
+func (p *scriptParser) peek() scriptToken {
+	return p.tokens[p.pos]
+}
+
+func (p *scriptParser) next() scriptToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// Whether the next token is the operator or keyword op, taking it if so.
+func (p *scriptParser) accept(op string) bool {
+	if t := p.peek(); (t.kind == tokenOp || t.kind == tokenIdent) && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *scriptParser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("expected %q, found %s", op, p.peek())
+	}
+	return nil
+}
+
+func (p *scriptParser) expr() (scriptNode, error) {
+	cond, err := p.binary(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	then, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{cond, then, els}, nil
+}
+
+// The binary operators by precedence, loosest first. Relations don't chain.
+var scriptLevels = [][]string{{"||"}, {"&&"}, {"==", "!=", "<", "<=", ">", ">=", "in"}, {"+", "-"}, {"*", "/", "%"}}
+
+func (p *scriptParser) binary(level int) (scriptNode, error) {
+	if level == len(scriptLevels) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, candidate := range scriptLevels[level] {
+			if p.accept(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op, x, y}
+		if level == 2 {
+			return x, nil
+		}
+	}
+}
+
+func (p *scriptParser) unary() (scriptNode, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			x, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryNode{op, x}, nil
+		}
+	}
+	return p.member()
+}
+
+func (p *scriptParser) member() (scriptNode, error) {
+	x, err := p.primary()
+	for err == nil {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokenIdent {
+				return nil, fmt.Errorf("expected a name, found %s", name)
+			}
+			if !p.accept("(") {
+				x = &indexNode{x, &literalNode{name.text}}
+				continue
+			}
+			arity, ok := scriptMethods[name.text]
+			if !ok {
+				return nil, fmt.Errorf("unknown method %s", name.text)
+			}
+			var args []scriptNode
+			if args, err = p.args(name.text, arity); err == nil {
+				x, err = newCall(name.text, append([]scriptNode{x}, args...))
+			}
+		case p.accept("["):
+			var index scriptNode
+			if index, err = p.expr(); err == nil {
+				err = p.expect("]")
+			}
+			x = &indexNode{x, index}
+		default:
+			return x, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *scriptParser) primary() (scriptNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString, tokenInt:
+		return &literalNode{t.value}, nil
+	case tokenIdent:
+		switch {
+		case t.text == "true" || t.text == "false":
+			return &literalNode{t.text == "true"}, nil
+		case p.accept("("):
+			arity, ok := scriptFuncs[t.text]
+			if !ok {
+				return nil, fmt.Errorf("unknown function %s", t.text)
+			}
+			args, err := p.args(t.text, arity)
+			if err != nil {
+				return nil, err
+			}
+			return newCall(t.text, args)
+		case scriptVars[t.text]:
+			return &varNode{t.text}, nil
+		}
+		return nil, fmt.Errorf("unknown variable %s", t.text)
+	case tokenOp:
+		switch t.text {
+		case "(":
+			x, err := p.expr()
+			if err == nil {
+				err = p.expect(")")
+			}
+			return x, err
+		case "[":
+			list := &listNode{}
+			for !p.accept("]") {
+				if len(list.items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+			}
+			return list, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+// The arguments of a call, after its "(".
+func (p *scriptParser) args(name string, arity int) ([]scriptNode, error) {
+	var args []scriptNode
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("%s takes %d arguments, not %d", name, arity, len(args))
+	}
+	return args, nil
+}
+
+// A call, with the regular expression of matches compiled now if it is
+// given literally.
+func newCall(name string, args []scriptNode) (scriptNode, error) {
+	call := &callNode{name: name, args: args}
+	if name == "matches" {
+		if pattern, ok := args[1].(*literalNode); ok {
+			s, ok := pattern.value.(string)
+			if !ok {
+				return nil, errors.New("matches takes a string")
+			}
+			re, err := compilePattern(s)
+			if err != nil {
+				return nil, err
+			}
+			call.re = re
+		}
+	}
+	return call, nil
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxScriptPattern {
+		return nil, fmt.Errorf("pattern is longer than %d bytes", maxScriptPattern)
+	}
+	return regexp.Compile(pattern)
+}
+
+// Nodes of a parsed script. Values are strings, int64s, bools, []any lists,
+// and map[string]string maps.
+type scriptNode interface {
+	eval(env *scriptEnv) (any, error)
+}
+
+type literalNode struct{ value any }
+
+func (n *literalNode) eval(env *scriptEnv) (any, error) {
+	return n.value, env.step()
+}
+
+type varNode struct{ name string }
+
+func (n *varNode) eval(env *scriptEnv) (any, error) {
+	return env.vars[n.name], env.step()
+}
+
+type listNode struct{ items []scriptNode }
+
+func (n *listNode) eval(env *scriptEnv) (any, error) {
+	if err := env.step(); err != nil {
+		return nil, err
+	}
+	if err := env.alloc(16 * len(n.items)); err != nil {
+		return nil, err
+	}
+	list := make([]any, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = value
+	}
+	return list, nil
+}
+
+// A field or index of a map, where missing keys are empty strings, or an
+// index of a list.
+type indexNode struct{ x, index scriptNode }
+
+func (n *indexNode) eval(env *scriptEnv) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case map[string]string:
+		if key, ok := index.(string); ok {
+			return x[key], nil
+		}
+	case []any:
+		if i, ok := index.(int64); ok {
+			if i < 0 || i >= int64(len(x)) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			return x[i], nil
+		}
+	}
+	return nil, fmt.Errorf("cannot index %s with %s", typeName(x), typeName(index))
+}
+
+type unaryNode struct {
+	op string
+	x  scriptNode
+}
+
+func (n *unaryNode) eval(env *scriptEnv) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case bool:
+		if n.op == "!" {
+			return !x, nil
+		}
+	case int64:
+		if n.op == "-" {
+			return -x, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s for %s", n.op, typeName(x))
+}
+
+type condNode struct{ cond, then, els scriptNode }
+
+func (n *condNode) eval(env *scriptEnv) (any, error) {
+	cond, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := cond.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition is %s, not a bool", typeName(cond))
+	}
+	if b {
+		return n.then.eval(env)
+	}
+	return n.els.eval(env)
+}
+
+type binaryNode struct {
+	op   string
+	x, y scriptNode
+}
+
+func (n *binaryNode) eval(env *scriptEnv) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("no %s for %s", n.op, typeName(x))
+		}
+		if b == (n.op == "||") {
+			return b, nil
+		}
+		y, err := n.y.eval(env)
+		if _, ok := y.(bool); err == nil && !ok {
+			return nil, fmt.Errorf("no %s for %s", n.op, typeName(y))
+		}
+		return y, err
+	}
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "in" {
+		return scriptIn(x, y)
+	}
+	switch x := x.(type) {
+	case string:
+		if y, ok := y.(string); ok {
+			return stringOp(env, n.op, x, y)
+		}
+	case int64:
+		if y, ok := y.(int64); ok {
+			return intOp(n.op, x, y)
+		}
+	case bool:
+		if y, ok := y.(bool); ok {
+			switch n.op {
+			case "==":
+				return x == y, nil
+			case "!=":
+				return x != y, nil
+			}
+		}
+	case []any:
+		if y, ok := y.([]any); ok && n.op == "+" {
+			if err := env.alloc(16 * (len(x) + len(y))); err != nil {
+				return nil, err
+			}
+			return append(append(make([]any, 0, len(x)+len(y)), x...), y...), nil
+		}
+	}
+	return nil, fmt.Errorf("no %s for %s and %s", n.op, typeName(x), typeName(y))
+}
+
+func stringOp(env *scriptEnv, op, x, y string) (any, error) {
+	switch op {
+	case "+":
+		if err := env.alloc(len(x) + len(y)); err != nil {
+			return nil, err
+		}
+		return x + y, nil
+	case "==":
+		return x == y, nil
+	case "!=":
+		return x != y, nil
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	}
+	return nil, fmt.Errorf("no %s for strings", op)
+}
+
+func intOp(op string, x, y int64) (any, error) {
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "==":
+		return x == y, nil
+	case "!=":
+		return x != y, nil
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	}
+	return nil, fmt.Errorf("no %s for ints", op)
+}
+
+// Whether x is in the list, or is a key of the map, y.
+func scriptIn(x, y any) (any, error) {
+	switch y := y.(type) {
+	case []any:
+		for _, item := range y {
+			if item == x {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]string:
+		if key, ok := x.(string); ok {
+			_, found := y[key]
+			return found, nil
+		}
+	}
+	return nil, fmt.Errorf("no in for %s and %s", typeName(x), typeName(y))
+}
+
+// A call of a function, or of a method with the string it is called on as
+// its first argument.
+type callNode struct {
+	name string
+	args []scriptNode
+	re   *regexp.Regexp
+}
+
+func (n *callNode) eval(env *scriptEnv) (any, error) {
+	if err := env.step(); err != nil {
+		return nil, err
+	}
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	switch n.name {
+	case "size":
+		switch x := args[0].(type) {
+		case string:
+			return int64(len(x)), nil
+		case []any:
+			return int64(len(x)), nil
+		case map[string]string:
+			return int64(len(x)), nil
+		}
+	case "int":
+		switch x := args[0].(type) {
+		case int64:
+			return x, nil
+		case string:
+			i, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("int(%q): not a number", x)
+			}
+			return i, nil
+		}
+	case "string":
+		switch x := args[0].(type) {
+		case string:
+			return x, nil
+		case int64:
+			return strconv.FormatInt(x, 10), nil
+		case bool:
+			return strconv.FormatBool(x), nil
+		}
+	default:
+		return n.method(env, args)
+	}
+	return nil, fmt.Errorf("no %s for %s", n.name, typeName(args[0]))
+}
+
+// Call a method of a string.
+func (n *callNode) method(env *scriptEnv, args []any) (any, error) {
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("no %s for %s", n.name, typeName(args[0]))
+	}
+	strs := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		if strs[i], ok = arg.(string); !ok {
+			return nil, fmt.Errorf("%s takes strings, not %s", n.name, typeName(arg))
+		}
+	}
+	switch n.name {
+	case "startsWith":
+		return strings.HasPrefix(s, strs[0]), nil
+	case "endsWith":
+		return strings.HasSuffix(s, strs[0]), nil
+	case "contains":
+		return strings.Contains(s, strs[0]), nil
+	case "matches":
+		re := n.re
+		if re == nil {
+			if err := env.alloc(len(strs[0]) * 16); err != nil {
+				return nil, err
+			}
+			var err error
+			if re, err = compilePattern(strs[0]); err != nil {
+				return nil, err
+			}
+		}
+		return re.MatchString(s), nil
+	case "size":
+		return int64(len(s)), nil
+	case "trim":
+		return strings.TrimSpace(s), nil
+	}
+	// The rest make new strings, as long as the one they are called on at
+	// most, or, for replace, as long as if every byte were replaced.
+	size := len(s)
+	if n.name == "replace" {
+		size = len(s) * (len(strs[1]) + 1)
+	}
+	if err := env.alloc(size); err != nil {
+		return nil, err
+	}
+	switch n.name {
+	case "lowerAscii":
+		return strings.ToLower(s), nil
+	case "upperAscii":
+		return strings.ToUpper(s), nil
+	case "replace":
+		return strings.ReplaceAll(s, strs[0], strs[1]), nil
+	case "split":
+		parts := strings.Split(s, strs[0])
+		if err := env.alloc(16 * len(parts)); err != nil {
+			return nil, err
+		}
+		list := make([]any, len(parts))
+		for i, part := range parts {
+			list[i] = part
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unknown method %s", n.name)
+}
+
+// The name of a script value's type, for errors.
+func typeName(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "int"
+	case bool:
+		return "bool"
+	case []any:
+		return "list"
+	case map[string]string:
+		return "map"
+	}
+	return "null"
+}
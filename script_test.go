@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A request for scripts to run on.
+func scriptRequest() *http.Request {
+	req := httptest.NewRequest("GET", "https://go.example.com/docs/intro?page=setup&lang=de", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone)")
+	req.Header.Set("Accept-Language", "de-DE")
+	return req
+}
+
+func TestScript(t *testing.T) {
+	for _, tt := range []struct{ script, want string }{
+		{`'/docs/' + query.page`, "/docs/setup"},
+		{`path + '/' + host + '/' + method`, "/docs/intro/go.example.com/GET"},
+		{`headers['user-agent'].contains('iPhone') ? 'ios' : 'android'`, "ios"},
+		{`headers['x-missing'] == '' ? 'empty' : 'set'`, "empty"},
+		{`query.missing + geo.country`, ""},
+		{`query.lang == 'de' && path.startsWith('/docs') ? 'de' : 'en'`, "de"},
+		{`query.lang == 'fr' || path.endsWith('intro') ? 'yes' : 'no'`, "yes"},
+		{`!(1 < 2) ? 'no' : 'yes'`, "yes"},
+		{`string(1 + 2 * 3 - 10 / 3 % 2)`, "6"},
+		{`string(-int('5') + size('abc') + 'abcd'.size() + size(path.split('/')))`, "5"},
+		{`path.split('/')[2]`, "intro"},
+		{`string('de' in ['en', 'de'])`, "true"},
+		{`string('lang' in query) + string('page' in headers)`, "truefalse"},
+		{`string(['a'] + ['b'] == ['a', 'b'])`, ""},
+		{`'  Mixed  '.trim().lowerAscii() + 'x'.upperAscii()`, "mixedX"},
+		{`path.replace('/docs', '/manual')`, "/manual/intro"},
+		{`path.matches('^/docs/[a-z]+$') ? 'match' : 'miss'`, "match"},
+		{`query.page.matches(query.lang) ? 'match' : 'miss'`, "miss"},
+		{`string(true) + string(1 == 1) + string('a' < 'b') + string(2 >= 3)`, "truetruetruefalse"},
+		{`"double \"quoted\"" + ' and \'single\''`, `double "quoted" and 'single'`},
+	} {
+		s, err := compileScript(tt.script)
+		if err != nil {
+			t.Errorf("%s: %v", tt.script, err)
+			continue
+		}
+		got, err := s.run(scriptRequest())
+		if tt.want == "" && err == nil && got != "" {
+			t.Errorf("%s: got %q, want nothing", tt.script, got)
+		} else if tt.want != "" && (err != nil || got != tt.want) {
+			t.Errorf("%s: got %q, %v, want %q", tt.script, got, err, tt.want)
+		}
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	for _, script := range []string{
+		``,
+		`'unterminated`,
+		`path +`,
+		`path == 'a' == 'b'`,
+		`(path`,
+		`cookies.session`,
+		`path.shout()`,
+		`path.contains()`,
+		`size(path, host)`,
+		`path.matches('(')`,
+		`path #`,
+		`'` + strings.Repeat("a", maxScriptLength) + `'`,
+	} {
+		if _, err := compileScript(script); err == nil {
+			t.Errorf("%s: compiled", script)
+		}
+	}
+
+	// Scripts that compile but fail when run, with any error unless one is
+	// given.
+	for _, tt := range []struct {
+		script string
+		err    error
+	}{
+		{`1 / 0`, nil},
+		{`int('five')`, nil},
+		{`path.split('/')[9]`, nil},
+		{`path ? 'a' : 'b'`, nil},
+		{`path + 1`, nil},
+		{`size(1)`, nil},
+		{`1 + 2`, nil},
+		{`'x'.replace('x', 'xxxxxxxxxxxxxxxx').replace('x', 'xxxxxxxxxxxxxxxx').replace('x', 'xxxxxxxxxxxxxxxx').replace('x', 'xxxxxxxxxxxxxxxx')`, errScriptMemory},
+	} {
+		s, err := compileScript(tt.script)
+		if err != nil {
+			t.Errorf("%s: %v", tt.script, err)
+			continue
+		}
+		if _, err = s.run(scriptRequest()); err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.script, err, tt.err)
+		}
+	}
+}
+
+func TestScriptedRule(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {
+		"/app": {"script": "headers['user-agent'].contains('iPhone') ? 'https://apps.example.com/ios' : 'https://apps.example.com/android'"},
+		"/docs": {"script": "query.page == '' ? '' : '/docs/' + query.page", "to": "/docs/start"},
+		"/go": {"script": "query.to", "to": "/home"}
+	}}`)
+	w := tr.do("GET", "/app", "", "User-Agent", "Mozilla/5.0 (iPhone)")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://apps.example.com/ios" {
+		t.Errorf("iPhone: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	tr.expectRedirect("/app", http.StatusFound, "https://apps.example.com/android")
+	tr.expectRedirect("/docs?page=setup", http.StatusFound, "/docs/setup")
+	tr.expectRedirect("/docs", http.StatusFound, "/docs/start")
+
+	// Destinations computed from the request are checked like any other.
+	tr.expectRedirect("/go?to=/pricing", http.StatusFound, "/pricing")
+	tr.expectRedirect(`/go?to=/%5Cevil.example.com`, http.StatusFound, "/home")
+	tr.expectRedirect("/go?to=//evil.example.com", http.StatusFound, "/home")
+	tr.expectRedirect("/go?to=javascript:alert(1)", http.StatusFound, "/home")
+
+	tr.expectStatus(tr.do("PUT", "/bad", `{"script": "path +"}`, "Content-Type", "application/json"), http.StatusBadRequest)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// How short links are made. Slugs are drawn at random from the alphabet,
+// which by default leaves out characters that are easily confused, like 0
+// and O, or 1 and l.
+var slugLength *int = flag.Int("slug-length", 6, "length of the slugs made by /_shorten")
+var slugAlphabet *string = flag.String("slug-alphabet", "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ", "characters the slugs made by /_shorten are drawn from")
+
+// The URL the redirections are served under, such as https://go.example.com,
+// for the links made by /_shorten and /_qr. By default, the scheme and host
+// the request for the link was made to.
+var publicBase *string = flag.String("public-url", "", "base URL of the redirections, for the links made by /_shorten and /_qr")
+
+// How many random slugs are tried before giving up on finding a free one.
+const slugAttempts = 10
+
+var errNoSlug = errors.New("no free slug; try a longer -slug-length")
+
+// Check -slug-length and -slug-alphabet. Slugs are single path segments of
+// letters, digits, and dashes, which can't collide with the /_ endpoints.
+func checkSlugs(length int, alphabet string) error {
+	if length < 1 {
+		return fmt.Errorf("-slug-length must be at least 1")
+	}
+	if len(alphabet) < 2 {
+		return fmt.Errorf("-slug-alphabet needs at least two characters")
+	}
+	for _, c := range alphabet {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return fmt.Errorf("-slug-alphabet: %q is not a letter, digit, or dash", c)
+		}
+	}
+	return nil
+}
+
+// A random slug.
+func newSlug() (string, error) {
+	slug := make([]byte, *slugLength)
+	for i := range slug {
+		n, err := randomIntn(len(*slugAlphabet))
+		if err != nil {
+			return "", err
+		}
+		slug[i] = (*slugAlphabet)[n]
+	}
+	return string(slug), nil
+}
+
+// Shorten adds a redirection by rule from a new, random path, which it
+// returns.
+func (redir *Redirector) Shorten(rule *Rule) (string, error) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+
+	for i := 0; i < slugAttempts; i++ {
+		slug, err := newSlug()
+		if err != nil {
+			return "", err
+		}
+		source := pathKey("/" + slug)
+		if _, taken := redir.Redirections.Get(source); taken {
+			continue
+		}
+		redir.Redirections.Set(source, rule)
+		redir.changed("shorten " + source)
+		redir.peers.replicate(source, rule)
+		return source, nil
+	}
+	return "", errNoSlug
+}
+
+// The ShortenHandler makes a short link to the destination in the body
+// (POST /_shorten), and returns it.
+func (redir *Redirector) ShortenHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "POST" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			redir.idempotency.serve(w, req, redir.shorten)
+		})
+	}
+}
+
+func (redir *Redirector) shorten(w http.ResponseWriter, req *http.Request) {
+	body, ok := readBody(w, req)
+	if !ok {
+		return
+	}
+	destination := strings.TrimSpace(string(body))
+	redir.mu.RLock()
+	err := redir.checkTo(destination)
+	redir.mu.RUnlock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad destination: "+err.Error())
+		return
+	}
+
+	rule := &Rule{To: destination}
+	source, err := redir.Shorten(rule)
+	if err != nil {
+		log.Println("Shorten:", err)
+		writeError(w, http.StatusServiceUnavailable, "Error making short link")
+		return
+	}
+	log.Println(realAddr(req), "shortened", destination, "to", source)
+	redir.notify(redir.ruleEvent(req, source, nil, rule))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"path": source, "to": destination, "url": publicURL(req, source)})
+}
+
+// The full public URL of a path.
+func publicURL(req *http.Request, path string) string {
+	if *publicBase != "" {
+		return strings.TrimSuffix(*publicBase, "/") + path
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + path
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestShorten(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {}}`)
+	const destination = "https://example.com/spring-sale?utm_campaign=spring"
+	w := tr.do("POST", "/_shorten", destination)
+	tr.expectStatus(w, http.StatusCreated)
+	var link struct{ Path, To, URL string }
+	tr.decode(w, &link)
+	if len(link.Path) != 1+*slugLength || link.To != destination || link.URL != "http://example.com"+link.Path {
+		t.Fatalf("got %+v", link)
+	}
+	tr.expectRedirect(link.Path, http.StatusFound, destination)
+
+	// Each link gets a path of its own.
+	w = tr.do("POST", "/_shorten", destination)
+	tr.expectStatus(w, http.StatusCreated)
+	var again struct{ Path string }
+	tr.decode(w, &again)
+	if again.Path == link.Path {
+		t.Errorf("both links are %s", link.Path)
+	}
+
+	tr.expectStatus(tr.do("POST", "/_shorten", "javascript:alert(1)"), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_shorten", `/\evil.example.com`), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_shorten", ""), http.StatusBadRequest)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "POST", "/_shorten", destination), http.StatusUnauthorized)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// How long shutting down waits for requests in progress.
+var shutdownTimeout *time.Duration = flag.Duration("shutdown-timeout", 10*time.Second, "how long shutting down waits for requests in progress")
+
+// How long to keep serving after a signal, with /_ready failing, so that load
+// balancers and Kubernetes stop sending requests before the listeners close.
+var drainDelay *time.Duration = flag.Duration("drain-delay", 0, "how long to keep serving, but not ready, after SIGINT or SIGTERM")
+
+// How long shutting down waits for webhooks and traces to be sent.
+const drainTimeout = 5 * time.Second
+
+// Whether the server is draining before it shuts down, and so not ready.
+var draining atomic.Bool
+
+// How many of the busiest redirections the shutdown report lists.
+const shutdownTopRules = 10
+
+// A ShutdownReport sums up a run of the server when it stops, so restarts
+// can be audited and lost events noticed. Unsaved changes are versions of
+// the configuration made through the API since it was last loaded from the
+// file, which the next run won't have. Webhook deliveries still pending
+// after draining are abandoned.
+type ShutdownReport struct {
+	Reason         string           `json:"reason"`
+	PID            int              `json:"pid"`
+	Version        string           `json:"version"`
+	Started        time.Time        `json:"started"`
+	Uptime         string           `json:"uptime"`
+	Hits           int64            `json:"hits"`
+	Misses         int64            `json:"misses"`
+	TopRules       []RuleCount      `json:"top_rules"`
+	UnsavedChanges int              `json:"unsaved_changes"`
+	Filtered       map[string]int64 `json:"filtered"`
+	Webhooks       struct {
+		Sent      int64 `json:"sent"`
+		Dropped   int64 `json:"dropped"`
+		Failed    int64 `json:"failed"`
+		Abandoned int64 `json:"abandoned"`
+	} `json:"webhooks"`
+	TracesDropped int64 `json:"traces_dropped"`
+}
+
+// On SIGINT or SIGTERM, fail readiness for the drain delay, then shut the
+// servers down once their requests are finished, or after the shutdown
+// timeout. The returned channel then receives the signal's name. A second
+// signal stops the process at once.
+func stopOnSignal(servers []*http.Server) <-chan string {
+	stopped := make(chan string, 1)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		log.Printf("%s; shutting down %d", sig, os.Getpid())
+		if *drainDelay > 0 {
+			draining.Store(true)
+			log.Printf("draining for %s before closing the listeners", *drainDelay)
+			time.Sleep(*drainDelay)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		for _, server := range servers {
+			server.Shutdown(ctx)
+		}
+		stopped <- sig.String()
+	}()
+	return stopped
+}
+
+// Finish the work left once the servers have stopped: deliver the queued
+// webhooks, including the server.shutdown event, export the traces, and
+// flush the logs. Then log the report.
+func (redir *Redirector) shutdown(reason string) *ShutdownReport {
+	redir.sink.drain(drainTimeout)
+	report := redir.shutdownReport(reason)
+	redir.mu.RLock()
+	redir.sink.Send(redir.Webhooks, &Event{Type: EventShutdown, Time: redir.Clock().Now(), Report: report})
+	redir.mu.RUnlock()
+	report.Webhooks.Abandoned = redir.sink.drain(drainTimeout)
+	if tracing != nil {
+		tracing.flush(drainTimeout)
+	}
+	redir.access.Sync()
+	redir.audit.Sync()
+
+	if report.UnsavedChanges > 0 {
+		log.Printf("%d changes made through the API since the configuration was loaded are lost", report.UnsavedChanges)
+	}
+	line, _ := json.Marshal(report)
+	log.Printf("shutdown report: %s", line)
+	return report
+}
+
+// The report on this run so far.
+func (redir *Redirector) shutdownReport(reason string) *ShutdownReport {
+	report := &ShutdownReport{
+		Reason:   reason,
+		PID:      os.Getpid(),
+		Version:  version,
+		Started:  started,
+		Uptime:   time.Since(started).Round(time.Second).String(),
+		Filtered: filteredReport(),
+	}
+	report.Hits, report.Misses, report.TopRules = redir.stats.Summary(shutdownTopRules)
+	redir.mu.RLock()
+	report.UnsavedChanges = redir.versions.latest() - redir.fileVersion
+	redir.mu.RUnlock()
+	redir.sink.mu.Lock()
+	report.Webhooks.Sent, report.Webhooks.Dropped, report.Webhooks.Failed = redir.sink.sent, redir.sink.dropped, redir.sink.failed
+	redir.sink.mu.Unlock()
+	if tracing != nil {
+		report.TracesDropped = tracing.dropped.Load()
+	}
+	return report
+}
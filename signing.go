@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+)
+
+// The keys configurations must be signed with, if any. Several may be given,
+// separated by commas, so that a key can be replaced without downtime.
+var configKeys *string = flag.String("config-key", "", "comma-separated base64 Ed25519 public keys that configurations must be signed with")
+
+// A configuration file's detached signature is in a file of the same name
+// with this suffix.
+const signatureSuffix = ".sig"
+
+// A configuration PUT to /_config carries its signature in this header.
+const signatureHeader = "X-Config-Signature"
+
+var errUnsigned = errors.New("configuration is not signed")
+var errBadSignature = errors.New("configuration has a bad signature")
+
+// Decode -config-key.
+func parseConfigKeys(keys string) ([]ed25519.PublicKey, error) {
+	var parsed []ed25519.PublicKey
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			return nil, errors.New("-config-key must be base64 Ed25519 public keys")
+		}
+		parsed = append(parsed, ed25519.PublicKey(decoded))
+	}
+	return parsed, nil
+}
+
+// The keys from -config-key, set by main. If there are none, configurations
+// need not be signed.
+var signingKeys []ed25519.PublicKey
+
+// Check that config, exactly as it was read, is signed by one of the signing
+// keys with the base64 Ed25519 signature.
+func verifyConfig(config []byte, signature string) error {
+	if len(signingKeys) == 0 {
+		return nil
+	}
+	signature = strings.TrimSpace(signature)
+	if signature == "" {
+		return errUnsigned
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errBadSignature
+	}
+	for _, key := range signingKeys {
+		if ed25519.Verify(key, config, decoded) {
+			return nil
+		}
+	}
+	return errBadSignature
+}
+
+// Read a configuration file, checking it against the signature beside it if
+// configurations must be signed.
+func readSigned(path string) ([]byte, error) {
+	config, err := ioutil.ReadFile(path)
+	if err != nil || len(signingKeys) == 0 {
+		return config, err
+	}
+	signature, err := ioutil.ReadFile(path + signatureSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errUnsigned
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config, verifyConfig(config, string(signature))
+}
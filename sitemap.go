@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The most URLs a sitemap may list.
+const maxSitemapURLs = 50000
+
+// A redirection as the sitemap lists it: the absolute URL of its source, the
+// canonical URL it ends up at, after any further redirections, its code, and
+// when it last changed, if known.
+type sitemapEntry struct {
+	Source      string
+	Destination string
+	Code        int
+	Modified    time.Time
+}
+
+// The redirections that send every client to the same place from a fixed
+// path on req's host, with code if it isn't 0, in order of source. Those
+// that crawlers aren't redirected by, proxied and scripted ones, wildcards,
+// and those outside of their schedule are left out.
+func (redir *Redirector) sitemapEntries(req *http.Request, code int) []sitemapEntry {
+	config := redir.live.Load()
+	host := requestHost(req)
+	origin := config.canonicalFor(nil).origin(req)
+	if origin == "" {
+		origin = requestScheme(req) + "://" + req.Host
+	}
+
+	// The same paths as lookups find, from the host's redirections, then the
+	// active profile's, then the others.
+	paths := make(map[string]bool)
+	add := func(source string, rule *Rule) {
+		if !isWildcard(source) {
+			paths[source] = true
+		}
+	}
+	if hr, _ := config.forHost(host); hr != nil {
+		hr.rules.Each(add)
+	}
+	if config.Profile != "" {
+		config.Profiles[config.Profile].Each(add)
+	}
+	config.Redirections.Each(add)
+
+	redir.mu.RLock()
+	modified := make(map[string]time.Time, len(paths))
+	for path := range paths {
+		modified[path] = redir.ruleModified[path]
+	}
+	redir.mu.RUnlock()
+
+	entries := []sitemapEntry{}
+	for path := range paths {
+		rule, source, destination, ok := config.lookup(host, path)
+		if !ok || rule.proxy != nil || rule.Respond != nil || rule.script != nil || destination == "" ||
+			rule.Crawlers != "" && rule.Crawlers != CrawlersRedirect || strings.ContainsAny(destination, "{*") {
+			continue
+		}
+		ruleCode := rule.Code
+		if ruleCode == 0 {
+			ruleCode = redir.code
+		}
+		if code != 0 && ruleCode != code {
+			continue
+		}
+		hops, loop := config.chain(path, destination)
+		if loop {
+			continue
+		}
+		destination = hops[len(hops)-1]
+		if strings.HasPrefix(destination, "/") && !strings.HasPrefix(destination, "//") {
+			destination = origin + destination
+		}
+		// Only the top-level redirections' changes are noted.
+		when := rule.CreatedAt
+		if source == path && !modified[path].IsZero() {
+			when = modified[path]
+		}
+		entries = append(entries, sitemapEntry{origin + (&url.URL{Path: path}).EscapedPath(), destination, ruleCode, when})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Source < entries[j].Source })
+	if len(entries) > maxSitemapURLs {
+		entries = entries[:maxSitemapURLs]
+	}
+	return entries
+}
+
+// The SitemapHandler lists the redirections on the request's host for search
+// engines, so that they recrawl moved content sooner: /_sitemap.xml as a
+// sitemap of their sources, with their canonical destinations as
+// rel="canonical" links, and /_sitemap.txt as lines of source, destination,
+// and code, separated by tabs. ?code=301 lists only the redirections with
+// that code.
+func (redir *Redirector) SitemapHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !allowRequest(w, req, redir.globalLimit, redir.lookupLimit) {
+			return
+		}
+		code := 0
+		if value := req.URL.Query().Get("code"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 300 || n > 399 {
+				http.Error(w, "Bad code", http.StatusBadRequest)
+				return
+			}
+			code = n
+		}
+		entries := redir.sitemapEntries(req, code)
+		out := bufio.NewWriter(w)
+		defer out.Flush()
+		if strings.HasSuffix(req.URL.Path, ".txt") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, entry := range entries {
+				fmt.Fprintf(out, "%s\t%s\t%d\n", entry.Source, entry.Destination, entry.Code)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		out.WriteString(xml.Header)
+		out.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:xhtml="http://www.w3.org/1999/xhtml">` + "\n")
+		for _, entry := range entries {
+			out.WriteString("<url><loc>")
+			xml.EscapeText(out, []byte(entry.Source))
+			out.WriteString("</loc>")
+			if !entry.Modified.IsZero() {
+				out.WriteString("<lastmod>" + entry.Modified.UTC().Format(time.RFC3339) + "</lastmod>")
+			}
+			out.WriteString(`<xhtml:link rel="canonical" href="`)
+			xml.EscapeText(out, []byte(entry.Destination))
+			out.WriteString(`"/></url>` + "\n")
+		}
+		out.WriteString("</urlset>\n")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const sitemapConfig = `{
+	"redirections": {
+		"/old": {"to": "/new", "code": 301},
+		"/new": {"to": "/newer", "code": 301},
+		"/temporary": "/x",
+		"/elsewhere": {"to": "https://example.org/a?b=1&c=2", "code": 301},
+		"/item/{id}": "/items/{id}",
+		"/loop1": "/loop2",
+		"/loop2": "/loop1",
+		"/hidden": {"to": "/z", "crawlers": "block"}
+	},
+	"hosts": {"go.example.com": {"/spring": "/sale"}}
+}`
+
+func TestSitemap(t *testing.T) {
+	tr := newTestRedirector(t, sitemapConfig)
+	w := tr.do("GET", "http://www.example.com/_sitemap.xml", "")
+	tr.expectStatus(w, http.StatusOK)
+	var sitemap struct {
+		URLs []struct {
+			Loc  string `xml:"loc"`
+			Link struct {
+				Rel  string `xml:"rel,attr"`
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &sitemap); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range sitemap.URLs {
+		got = append(got, u.Loc+" "+u.Link.Href)
+	}
+	want := []string{
+		"http://www.example.com/elsewhere https://example.org/a?b=1&c=2",
+		"http://www.example.com/new http://www.example.com/newer",
+		"http://www.example.com/old http://www.example.com/newer",
+		"http://www.example.com/temporary http://www.example.com/x",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSitemapIndex(t *testing.T) {
+	tr := newTestRedirector(t, sitemapConfig)
+	w := tr.do("GET", "http://go.example.com/_sitemap.txt?code=302", "")
+	tr.expectStatus(w, http.StatusOK)
+	want := "http://go.example.com/spring\thttp://go.example.com/sale\t302\n" +
+		"http://go.example.com/temporary\thttp://go.example.com/x\t302\n"
+	if w.Body.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", w.Body.String(), want)
+	}
+	tr.expectStatus(tr.do("GET", "/_sitemap.txt?code=200", ""), http.StatusBadRequest)
+}
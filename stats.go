@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats counts the requests served for each redirection and the approximate
+// number of response body bytes sent for them, including proxied content.
+// Requests that matched no redirection are counted as misses, and the most
+// recent of them are kept. Traffic is the number of requests in each of the
+// last trafficSeconds seconds. Hits on each redirection are also counted by
+// day, in the time zone of its group, for the last -stats-days days, and by
+// where they came from (see attribution).
+type Stats struct {
+	mu        sync.Mutex
+	since     time.Time
+	rules     map[string]*RuleStats
+	misses    RuleStats
+	totalSent int64
+
+	recent     []Miss
+	nextRecent int
+
+	// How often each path has missed, for at most maxMissPaths paths.
+	missPaths map[string]*MissCount
+
+	traffic   [trafficSeconds]int64
+	trafficAt int64
+
+	clock Clock
+}
+
+// How many recent misses are kept, and for how many seconds traffic is kept.
+const (
+	recentMisses   = 50
+	trafficSeconds = 120
+)
+
+// A request that matched no redirection.
+type Miss struct {
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+// How many times a path has missed, and when it last did.
+type MissCount struct {
+	Path  string    `json:"path"`
+	Count int64     `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// The most paths whose misses are counted. When there are more, the counts
+// decay to make room, so the paths that keep missing stay on top.
+const maxMissPaths = 10000
+
+// The counters kept for each redirection. Bots counts the hits from bots,
+// which are included in Hits. Days has the hits on each day, by date. The
+// hits on a cached proxy rule are also counted by how the cache served them.
+type RuleStats struct {
+	Hits        int64            `json:"hits"`
+	Bots        int64            `json:"bots"`
+	Bytes       int64            `json:"bytes"`
+	CacheHits   int64            `json:"cache_hits,omitempty"`
+	CacheMisses int64            `json:"cache_misses,omitempty"`
+	Coalesced   int64            `json:"coalesced,omitempty"`
+	Days        map[string]int64 `json:"days,omitempty"`
+	attribution *ruleAttribution
+}
+
+func NewStats(clock Clock) *Stats {
+	return &Stats{since: clock.Now(), clock: clock, rules: make(map[string]*RuleStats), missPaths: make(map[string]*MissCount)}
+}
+
+// Record a request for source, or a miss if source is empty, that sent bytes
+// in its response, and whether it came from a bot. Hits on source are
+// counted by day in loc.
+func (stats *Stats) Record(source string, req *http.Request, bytes int64, loc *time.Location, bot bool) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	now := stats.clock.Now()
+	stats.advanceTraffic(now.Unix())
+	stats.traffic[now.Unix()%trafficSeconds]++
+
+	counters, path := &stats.misses, req.URL.Path
+	if source == "" {
+		miss := Miss{Path: path, Time: now}
+		if len(stats.recent) < recentMisses {
+			stats.recent = append(stats.recent, miss)
+		} else {
+			stats.recent[stats.nextRecent] = miss
+		}
+		stats.nextRecent = (stats.nextRecent + 1) % recentMisses
+		stats.countMiss(path, now)
+	} else {
+		if counters = stats.rules[source]; counters == nil {
+			counters = &RuleStats{Days: make(map[string]int64), attribution: newRuleAttribution()}
+			stats.rules[source] = counters
+		}
+		local := now.In(loc)
+		day := local.Format(dayLayout)
+		if counters.Days[day] == 0 {
+			forgetBefore(counters.Days, local.AddDate(0, 0, -*statsDays).Format(dayLayout))
+		}
+		counters.Days[day]++
+		counters.attribution.count(local, referrerHost(req), req.URL.Query().Get(campaignParam), bot)
+	}
+	counters.Hits++
+	if bot {
+		counters.Bots++
+	}
+	counters.Bytes += bytes
+	stats.totalSent += bytes
+}
+
+// Count how the cache of the rule for source served a request.
+func (stats *Stats) countCache(source, outcome string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	counters := stats.rules[source]
+	if counters == nil {
+		return
+	}
+	switch outcome {
+	case CacheHit:
+		counters.CacheHits++
+	case CacheMiss:
+		counters.CacheMisses++
+	case CacheCoalesced:
+		counters.Coalesced++
+	}
+}
+
+func (stats *Stats) countMiss(path string, now time.Time) {
+	count := stats.missPaths[path]
+	if count == nil {
+		for len(stats.missPaths) >= maxMissPaths {
+			for p, c := range stats.missPaths {
+				if c.Count /= 2; c.Count == 0 {
+					delete(stats.missPaths, p)
+				}
+			}
+		}
+		count = &MissCount{Path: path}
+		stats.missPaths[path] = count
+	}
+	count.Count++
+	count.Last = now
+}
+
+// TopMisses returns up to n of the paths that have missed most often, most
+// often first.
+func (stats *Stats) TopMisses(n int) []MissCount {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	top := make([]MissCount, 0, len(stats.missPaths))
+	for _, count := range stats.missPaths {
+		top = append(top, *count)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Path < top[j].Path
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// A redirection's hits.
+type RuleCount struct {
+	Source string `json:"source"`
+	Hits   int64  `json:"hits"`
+}
+
+// The hits on all redirections, the misses, and up to n of the redirections
+// with the most hits, most first.
+func (stats *Stats) Summary(n int) (hits, misses int64, top []RuleCount) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	top = make([]RuleCount, 0, len(stats.rules))
+	for source, counters := range stats.rules {
+		hits += counters.Hits
+		top = append(top, RuleCount{source, counters.Hits})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Hits != top[j].Hits {
+			return top[i].Hits > top[j].Hits
+		}
+		return top[i].Source < top[j].Source
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return hits, stats.misses.Hits, top
+}
+
+// Forget the misses for path, or every path matching it if it is a wildcard,
+// once it has a redirection.
+func (stats *Stats) ForgetMisses(path string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	if !isWildcard(path) {
+		delete(stats.missPaths, path)
+		return
+	}
+	w := &wildcard{segments: strings.Split(path, "/"), rule: &Rule{}}
+	for missed := range stats.missPaths {
+		if _, ok := w.match(missed); ok {
+			delete(stats.missPaths, missed)
+		}
+	}
+}
+
+// Clear the traffic counts for the seconds that passed without requests, up
+// to the second now.
+func (stats *Stats) advanceTraffic(now int64) {
+	for t := stats.trafficAt + 1; t <= now && t <= stats.trafficAt+trafficSeconds; t++ {
+		stats.traffic[t%trafficSeconds] = 0
+	}
+	if now > stats.trafficAt {
+		stats.trafficAt = now
+	}
+}
+
+// The JSON form of Stats. Recent misses are newest first, and traffic oldest
+// first, ending with the current second.
+type statsReport struct {
+	Since        time.Time             `json:"since"`
+	TotalBytes   int64                 `json:"total_bytes"`
+	Misses       RuleStats             `json:"misses"`
+	Rules        map[string]*RuleStats `json:"rules"`
+	RecentMisses []Miss                `json:"recent_misses"`
+	Traffic      []int64               `json:"traffic"`
+}
+
+func (stats *Stats) MarshalJSON() ([]byte, error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	report := &statsReport{Since: stats.since, TotalBytes: stats.totalSent, Misses: stats.misses, Rules: stats.rules}
+	report.RecentMisses = make([]Miss, 0, len(stats.recent))
+	for i := 1; i <= len(stats.recent); i++ {
+		report.RecentMisses = append(report.RecentMisses, stats.recent[(stats.nextRecent-i+recentMisses)%recentMisses])
+	}
+	now := stats.clock.Now().Unix()
+	stats.advanceTraffic(now)
+	for t := now - trafficSeconds + 1; t <= now; t++ {
+		report.Traffic = append(report.Traffic, stats.traffic[t%trafficSeconds])
+	}
+	return json.Marshal(report)
+}
+
+// A countingWriter counts the bytes written to the response body, and keeps
+// the status code sent.
+type countingWriter struct {
+	http.ResponseWriter
+	bytes  int64
+	status int
+}
+
+func (cw *countingWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err = cw.ResponseWriter.Write(p)
+	cw.bytes += int64(n)
+	return
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so proxied
+// responses can still be flushed.
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// The StatsHandler supplies the statistics as JSON.
+func (redir *Redirector) StatsHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			jsonStats, err := json.MarshalIndent(redir.stats, "", "  ")
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "Error encoding JSON stats")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(jsonStats)
+		})
+	}
+}
+
+// The MissesHandler lists the paths that most often had no redirection
+// (GET, with an optional limit, 20 by default, grouped into patterns with
+// by=pattern), and turns one into a redirection (POST, with the path and its
+// destination in "path" and "to").
+func (redir *Redirector) MissesHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			switch req.Method {
+			case "GET":
+				limit := 20
+				if value := req.URL.Query().Get("limit"); value != "" {
+					n, err := strconv.Atoi(value)
+					if err != nil || n < 1 {
+						writeError(w, http.StatusBadRequest, "Bad limit", paramError("limit", "must be a positive integer"))
+						return
+					}
+					limit = n
+				}
+				w.Header().Set("Content-Type", "application/json")
+				switch req.URL.Query().Get("by") {
+				case "":
+					json.NewEncoder(w).Encode(redir.stats.TopMisses(limit))
+				case "pattern":
+					json.NewEncoder(w).Encode(redir.stats.MissPatterns(limit))
+				default:
+					writeError(w, http.StatusBadRequest, "Bad grouping", paramError("by", `must be "pattern"`))
+				}
+			case "POST":
+				if !allowRequest(w, req, nil, redir.adminLimit) {
+					return
+				}
+				redir.idempotency.serve(w, req, redir.promoteMiss)
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			}
+		})
+	}
+}
+
+// The ProposalsHandler proposes wildcard redirections that would cover the
+// paths that most often had no redirection (GET, with an optional limit, 20
+// by default). A proposal is approved by POSTing its source and a destination
+// to the MissesHandler.
+func (redir *Redirector) ProposalsHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			limit := 20
+			if value := req.URL.Query().Get("limit"); value != "" {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					writeError(w, http.StatusBadRequest, "Bad limit", paramError("limit", "must be a positive integer"))
+					return
+				}
+				limit = n
+			}
+			proposals := redir.stats.ProposeRules(limit, func(source string) bool {
+				redir.mu.RLock()
+				defer redir.mu.RUnlock()
+				_, ok := redir.Redirections.Get(source)
+				return ok
+			})
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(proposals)
+		})
+	}
+}
+
+// Add a redirection for a path, or a wildcard, that had none, stamped and
+// checked as a PUT of it would be, answering 201, or 200 if it replaced one.
+func (redir *Redirector) promoteMiss(w http.ResponseWriter, req *http.Request) {
+	path, destination := req.FormValue("path"), req.FormValue("to")
+	if path == "" || destination == "" {
+		writeError(w, http.StatusBadRequest, "path and to are required", paramError("path", "is required"), paramError("to", "is required"))
+		return
+	}
+	if path[0] != '/' {
+		writeError(w, http.StatusBadRequest, "Bad path", paramError("path", "must start with /"))
+		return
+	}
+	rule := &Rule{To: destination}
+	token, _ := redir.tokenFor(req)
+	old, err := redir.SetRule(path, rule, token.client(req))
+	if err != nil {
+		(&APIError{http.StatusBadRequest, CodeInvalidRule, "Bad rule: " + err.Error(), configErrorDetails(err)}).write(w)
+		return
+	}
+	redir.stats.ForgetMisses(path)
+	path = pathKey(path)
+	log.Println(realAddr(req), "added redirection from missed", path, "to", destination)
+	redir.notify(redir.ruleEvent(req, path, old, rule))
+
+	status := http.StatusOK
+	if old == nil {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"path": path, "to": destination})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestMisses(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {}}`)
+	for i := 0; i < 3; i++ {
+		tr.expectNotFound("/spring-sale")
+	}
+	for i := 0; i < 5; i++ {
+		tr.expectNotFound(fmt.Sprintf("/product/%d", 100+i))
+	}
+
+	w := tr.do("GET", "/_stats/404s", "")
+	tr.expectStatus(w, http.StatusOK)
+	var misses []MissCount
+	if err := json.Unmarshal(w.Body.Bytes(), &misses); err != nil {
+		t.Fatal(err)
+	}
+	if len(misses) != 6 || misses[0].Path != "/spring-sale" || misses[0].Count != 3 {
+		t.Errorf("got %+v", misses)
+	}
+
+	w = tr.do("GET", "/_stats/404s/proposals", "")
+	var proposals []RuleProposal
+	if err := json.Unmarshal(w.Body.Bytes(), &proposals); err != nil {
+		t.Fatal(err)
+	}
+	if len(proposals) != 1 || proposals[0].Source != "/product/{id}" || proposals[0].Paths != 5 {
+		t.Errorf("got %+v", proposals)
+	}
+
+	form := []string{"Content-Type", "application/x-www-form-urlencoded"}
+	w = tr.do("POST", "/_stats/404s", "path=/spring-sale&to=/sales/spring", form...)
+	tr.expectStatus(w, http.StatusCreated)
+	tr.expectRedirect("/spring-sale", http.StatusFound, "/sales/spring")
+	rule, _ := tr.Redirections.Get("/spring-sale")
+	if rule.CreatedBy != "127.0.0.1" || rule.CreatedAt.IsZero() {
+		t.Errorf("promoted rule stamped %q at %v", rule.CreatedBy, rule.CreatedAt)
+	}
+
+	// Promoting a path again replaces its redirection, keeping its stamp.
+	tr.expectStatus(tr.do("POST", "/_stats/404s", "path=/spring-sale&to=/sales/spring-2026", form...), http.StatusOK)
+	tr.expectRedirect("/spring-sale", http.StatusFound, "/sales/spring-2026")
+	if again, _ := tr.Redirections.Get("/spring-sale"); again.CreatedAt != rule.CreatedAt {
+		t.Errorf("replaced rule stamped at %v, want %v", again.CreatedAt, rule.CreatedAt)
+	}
+
+	tr.expectStatus(tr.do("POST", "/_stats/404s", "path=spring-sale&to=/sales/spring", form...), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_stats/404s", "path=/x&to=javascript:alert(1)", form...), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_stats/404s", "path=/x", form...), http.StatusBadRequest)
+}
+
+func TestRuleStats(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/spring": "/sale"}}`)
+	tr.do("GET", "/spring?src=billboard", "", "User-Agent", "Mozilla/5.0", "Referer", "https://news.example.com/story")
+	tr.do("GET", "/spring", "", "User-Agent", "Mozilla/5.0")
+	tr.do("GET", "/spring", "", "User-Agent", "Googlebot/2.1")
+
+	w := tr.do("GET", "/_stats/spring", "")
+	tr.expectStatus(w, http.StatusOK)
+	var stats struct {
+		Hits      int64            `json:"hits"`
+		Bots      int64            `json:"bots"`
+		Referrers map[string]int64 `json:"referrers"`
+		Campaigns map[string]int64 `json:"campaigns"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 3 || stats.Bots != 1 || stats.Referrers["news.example.com"] != 1 || stats.Campaigns["billboard"] != 1 {
+		t.Errorf("got %+v", stats)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An exported row of statistics: the hits on a redirection in an hour or a
+// day with a campaign tag. Hits without one are under noCampaign, and hits
+// from bots under botCampaign, so the rows for a bucket add up to its hits.
+type ExportRow struct {
+	Time     string `json:"time"`
+	Source   string `json:"source"`
+	Group    string `json:"group,omitempty"`
+	Campaign string `json:"campaign"`
+	Hits     int64  `json:"hits"`
+}
+
+// The campaigns of hits without a campaign tag, and from bots.
+const (
+	noCampaign  = "(none)"
+	botCampaign = "(bots)"
+)
+
+// Export the hits in hourly or daily buckets from from to to, inclusive,
+// either of which may be empty, sorted by time, source, and campaign.
+func (stats *Stats) Export(bucket, from, to string) []ExportRow {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	rows := []ExportRow{}
+	now := stats.clock.Now()
+	for source, counters := range stats.rules {
+		counters.attribution.forget(now)
+		buckets := counters.attribution.days
+		if bucket == BucketHour {
+			buckets = counters.attribution.hours
+		}
+		for key, a := range buckets {
+			if key < from || to != "" && key[:min(len(key), len(to))] > to {
+				continue
+			}
+			tagged := int64(0)
+			for campaign, hits := range a.Campaigns {
+				rows = append(rows, ExportRow{Time: key, Source: source, Campaign: campaign, Hits: hits})
+				tagged += hits
+			}
+			if untagged := a.Hits - a.Bots - tagged; untagged > 0 {
+				rows = append(rows, ExportRow{Time: key, Source: source, Campaign: noCampaign, Hits: untagged})
+			}
+			if a.Bots > 0 {
+				rows = append(rows, ExportRow{Time: key, Source: source, Campaign: botCampaign, Hits: a.Bots})
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Time != b.Time {
+			return a.Time < b.Time
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Campaign < b.Campaign
+	})
+	return rows
+}
+
+// Whether s is a day or an hour, as buckets are keyed.
+func validBucketTime(s string) bool {
+	for _, layout := range []string{dayLayout, hourLayout} {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Keep a cell from being taken for a formula by spreadsheets, as a campaign
+// tag from a link someone made up could be.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// The StatsExportHandler exports the hits on each redirection by campaign,
+// in daily buckets or, with by=hour, hourly ones, from and to the days or
+// hours in from= and to=, as CSV or, with format=json, JSON
+// (GET /_stats/export?from=2024-03-01&to=2024-03-31&format=csv).
+func (redir *Redirector) StatsExportHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			query := req.URL.Query()
+			bucket := query.Get("by")
+			switch bucket {
+			case "":
+				bucket = BucketDay
+			case BucketDay, BucketHour:
+			default:
+				writeError(w, http.StatusBadRequest, "Bad bucket")
+				return
+			}
+			from, to := query.Get("from"), query.Get("to")
+			if from != "" && !validBucketTime(from) || to != "" && !validBucketTime(to) {
+				writeError(w, http.StatusBadRequest, "from and to must be days (2006-01-02) or hours (2006-01-02T15)",
+					paramError("from", "must be a day or an hour"), paramError("to", "must be a day or an hour"))
+				return
+			}
+			format := query.Get("format")
+			if format != "" && format != "csv" && format != "json" {
+				writeError(w, http.StatusBadRequest, "Unknown format "+strconv.Quote(format))
+				return
+			}
+
+			rows := redir.stats.Export(bucket, from, to)
+			config := redir.live.Load()
+			for i := range rows {
+				if rule, ok := config.Redirections.Get(rows[i].Source); ok {
+					rows[i].Group = rule.Group
+				}
+			}
+			if format == "json" {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(rows)
+				return
+			}
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="stats.csv"`)
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"time", "source", "group", "campaign", "hits"})
+			for _, row := range rows {
+				cw.Write([]string{row.Time, csvCell(row.Source), csvCell(row.Group), csvCell(row.Campaign), strconv.FormatInt(row.Hits, 10)})
+			}
+			cw.Flush()
+		})
+	}
+}
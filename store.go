@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// How redirections are kept in memory. A Go map is fastest to change; sorted
+// arrays take much less memory for millions of redirections that seldom
+// change.
+var storeFlag *string = flag.String("store", "map", "how redirections are kept in memory: map, or sorted for large, read-mostly sets")
+
+// Redirection stores.
+const (
+	StoreMap    = "map"
+	StoreSorted = "sorted"
+)
+
+// Rules are redirections by source, kept in the store chosen with -store.
+// Rules configured the same way are stored once, however many sources share
+// them (see interner). The zero Rules are empty and may be read, but not
+// changed.
+type Rules struct {
+	store  ruleStore
+	intern *interner
+}
+
+// A ruleStore keeps redirections by source. Merge sets many at once, with
+// later ones replacing earlier ones for the same source.
+type ruleStore interface {
+	get(source string) (*Rule, bool)
+	set(source string, rule *Rule)
+	remove(source string)
+	merge(sources []string, rules []*Rule)
+	len() int
+	each(fn func(source string, rule *Rule))
+	clone() ruleStore
+}
+
+// Empty Rules in the store chosen with -store, with room for n.
+func newRules(n int) Rules {
+	if *storeFlag == StoreSorted {
+		return Rules{newSortedStore(n), newInterner()}
+	}
+	return Rules{make(mapStore, n), newInterner()}
+}
+
+// Check -store.
+func checkStore(store string) error {
+	switch store {
+	case StoreMap, StoreSorted:
+		return nil
+	}
+	return fmt.Errorf("unknown -store %q", store)
+}
+
+// Get the rule for source.
+func (rules Rules) Get(source string) (*Rule, bool) {
+	if rules.store == nil {
+		return nil, false
+	}
+	return rules.store.get(source)
+}
+
+// Set the rule for source.
+func (rules *Rules) Set(source string, rule *Rule) {
+	if rules.store == nil {
+		*rules = newRules(0)
+	}
+	rules.store.set(source, rules.intern.rule(rule))
+}
+
+// Delete the rule for source, if there is one.
+func (rules Rules) Delete(source string) {
+	if rules.store != nil {
+		rules.store.remove(source)
+	}
+}
+
+// The number of redirections.
+func (rules Rules) Len() int {
+	if rules.store == nil {
+		return 0
+	}
+	return rules.store.len()
+}
+
+// Call fn with each redirection. The sorted store goes in order of source;
+// the map store, in no particular order.
+func (rules Rules) Each(fn func(source string, rule *Rule)) {
+	if rules.store != nil {
+		rules.store.each(fn)
+	}
+}
+
+// A copy of the rules that can be changed without changing these. The rules
+// themselves are shared.
+func (rules Rules) clone() Rules {
+	if rules.store == nil {
+		return newRules(0)
+	}
+	return Rules{rules.store.clone(), rules.intern.clone()}
+}
+
+// Rules are written as a JSON object, with sources in order.
+func (rules Rules) MarshalJSON() ([]byte, error) {
+	if rules.store == nil {
+		return []byte("null"), nil
+	}
+	sources := make([]string, 0, rules.Len())
+	rules.Each(func(source string, rule *Rule) { sources = append(sources, source) })
+	if _, ok := rules.store.(mapStore); ok {
+		sort.Strings(sources)
+	}
+
+	// Leave escaping HTML to the encoder these rules are a part of.
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, source := range sources {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		rule, _ := rules.Get(source)
+		if err := enc.Encode(source); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := enc.Encode(rule); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Decoding a JSON object adds its redirections to any already there, as it
+// would for a map.
+func (rules *Rules) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("redirections must be an object, not %s", data)
+	}
+	var sources []string
+	var list []*Rule
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		rule := new(Rule)
+		if err = dec.Decode(rule); err != nil {
+			return err
+		}
+		sources = append(sources, token.(string))
+		list = append(list, rule)
+	}
+	if rules.store == nil {
+		*rules = newRules(len(sources))
+	}
+	for i, rule := range list {
+		rule.To = rules.intern.destination(rule.To)
+		list[i] = rules.intern.rule(rule)
+	}
+	rules.store.merge(sources, list)
+	return nil
+}
+
+// The map store is a Go map.
+type mapStore map[string]*Rule
+
+func (m mapStore) get(source string) (*Rule, bool) {
+	rule, ok := m[source]
+	return rule, ok
+}
+
+func (m mapStore) set(source string, rule *Rule) { m[source] = rule }
+func (m mapStore) remove(source string)          { delete(m, source) }
+func (m mapStore) len() int                      { return len(m) }
+
+func (m mapStore) merge(sources []string, rules []*Rule) {
+	for i, source := range sources {
+		m[source] = rules[i]
+	}
+}
+
+func (m mapStore) each(fn func(source string, rule *Rule)) {
+	for source, rule := range m {
+		fn(source, rule)
+	}
+}
+
+func (m mapStore) clone() ruleStore {
+	clone := make(mapStore, len(m))
+	for source, rule := range m {
+		clone[source] = rule
+	}
+	return clone
+}
+
+// The sorted store keeps sources in a sorted slice, searched by binary
+// search, with their rules in a parallel slice.
+type sortedStore struct {
+	sources []string
+	rules   []*Rule
+}
+
+func newSortedStore(n int) *sortedStore {
+	return &sortedStore{
+		sources: make([]string, 0, n),
+		rules:   make([]*Rule, 0, n),
+	}
+}
+
+func (s *sortedStore) find(source string) (int, bool) {
+	i := sort.SearchStrings(s.sources, source)
+	return i, i < len(s.sources) && s.sources[i] == source
+}
+
+func (s *sortedStore) get(source string) (*Rule, bool) {
+	if i, ok := s.find(source); ok {
+		return s.rules[i], true
+	}
+	return nil, false
+}
+
+func (s *sortedStore) set(source string, rule *Rule) {
+	i, ok := s.find(source)
+	if ok {
+		s.rules[i] = rule
+		return
+	}
+	s.sources = append(s.sources, "")
+	copy(s.sources[i+1:], s.sources[i:])
+	s.sources[i] = source
+	s.rules = append(s.rules, nil)
+	copy(s.rules[i+1:], s.rules[i:])
+	s.rules[i] = rule
+}
+
+func (s *sortedStore) remove(source string) {
+	if i, ok := s.find(source); ok {
+		s.sources = append(s.sources[:i], s.sources[i+1:]...)
+		s.rules = append(s.rules[:i], s.rules[i+1:]...)
+	}
+}
+
+func (s *sortedStore) len() int { return len(s.sources) }
+
+func (s *sortedStore) each(fn func(source string, rule *Rule)) {
+	for i, source := range s.sources {
+		fn(source, s.rules[i])
+	}
+}
+
+func (s *sortedStore) clone() ruleStore {
+	return &sortedStore{
+		sources: append([]string(nil), s.sources...),
+		rules:   append([]*Rule(nil), s.rules...),
+	}
+}
+
+// Sort the new redirections, keeping the last of any with the same source,
+// and merge them with the stored ones in a single pass.
+func (s *sortedStore) merge(sources []string, rules []*Rule) {
+	order := make([]int, len(sources))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return sources[order[a]] < sources[order[b]] })
+
+	merged := newSortedStore(len(s.sources) + len(sources))
+	i := 0
+	for n, j := range order {
+		if n+1 < len(order) && sources[order[n+1]] == sources[j] {
+			continue
+		}
+		for i < len(s.sources) && s.sources[i] < sources[j] {
+			merged.sources = append(merged.sources, s.sources[i])
+			merged.rules = append(merged.rules, s.rules[i])
+			i++
+		}
+		if i < len(s.sources) && s.sources[i] == sources[j] {
+			i++
+		}
+		merged.sources = append(merged.sources, sources[j])
+		merged.rules = append(merged.rules, rules[j])
+	}
+	merged.sources = append(merged.sources, s.sources[i:]...)
+	merged.rules = append(merged.rules, s.rules[i:]...)
+	*s = *merged
+}
+
+// An interner hands out one shared copy of each distinct rule and
+// destination, since many sources usually go to the same place. Rules are
+// never changed once stored, so they can be shared. It only grows, so each
+// Rules has its own, which starts afresh when a configuration replaces the
+// redirections.
+type interner struct {
+	mu    sync.Mutex
+	rules map[string]*Rule
+	dests map[string]string
+}
+
+func newInterner() *interner {
+	return &interner{rules: make(map[string]*Rule), dests: make(map[string]string)}
+}
+
+// The shared rule configured the same way as rule, which becomes the shared
+// one if there was none.
+func (in *interner) rule(rule *Rule) *Rule {
+	// Rules with nothing but a destination are most common, and cheapest to
+	// tell apart.
+	key := "\x00" + rule.To
+	if !rule.simple() {
+		data, err := json.Marshal(rule)
+		if err != nil {
+			return rule
+		}
+		key = string(data)
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if shared, ok := in.rules[key]; ok {
+		return shared
+	}
+	in.rules[key] = rule
+	return rule
+}
+
+// The shared copy of a destination.
+func (in *interner) destination(to string) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if shared, ok := in.dests[to]; ok {
+		return shared
+	}
+	in.dests[to] = to
+	return to
+}
+
+func (in *interner) clone() *interner {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	clone := &interner{
+		rules: make(map[string]*Rule, len(in.rules)),
+		dests: make(map[string]string, len(in.dests)),
+	}
+	for key, rule := range in.rules {
+		clone.rules[key] = rule
+	}
+	for to := range in.dests {
+		clone.dests[to] = to
+	}
+	return clone
+}
+
+// How much interning saves, in the redirections as they are now.
+type InternStats struct {
+	Redirections int   `json:"redirections"`
+	Rules        int   `json:"rules"`
+	Destinations int   `json:"destinations"`
+	SavedBytes   int64 `json:"saved_bytes"`
+}
+
+// Count the distinct rules and destinations the redirections share, and
+// estimate the memory that would take without sharing them: a Rule and a
+// copy of its destination for each redirection beyond the first to use it.
+func (rules Rules) internStats() InternStats {
+	stats := InternStats{Redirections: rules.Len()}
+	seen := make(map[*Rule]bool)
+	dests := make(map[string]bool)
+	rules.Each(func(source string, rule *Rule) {
+		if seen[rule] {
+			stats.SavedBytes += int64(unsafe.Sizeof(*rule)) + int64(len(rule.To))
+			return
+		}
+		seen[rule] = true
+		if dests[rule.To] {
+			stats.SavedBytes += int64(len(rule.To))
+		}
+		dests[rule.To] = true
+	})
+	stats.Rules, stats.Destinations = len(seen), len(dests)
+	return stats
+}
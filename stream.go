@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits on the live stream of hits. Each client has a buffer of
+// streamBuffer events; a client that falls further behind than that misses
+// events, and is told how many when it catches up, rather than holding up
+// redirects or using up memory.
+const (
+	maxStreamClients = 20
+	streamBuffer     = 256
+
+	// How often a quiet stream sends a comment, so proxies and browsers
+	// don't time it out.
+	streamHeartbeat = 15 * time.Second
+)
+
+// A hitStream sends every redirect and 404, as a JSON accessEntry, to the
+// clients of /_stats/stream.
+type hitStream struct {
+	mu      sync.Mutex
+	clients map[*streamClient]bool
+	count   atomic.Int32
+}
+
+// A streamClient is a client of the stream, and the events waiting for it.
+type streamClient struct {
+	events  chan []byte
+	dropped atomic.Int64
+}
+
+func newHitStream() *hitStream {
+	return &hitStream{clients: make(map[*streamClient]bool)}
+}
+
+// Add a client, unless there are already maxStreamClients.
+func (stream *hitStream) subscribe() *streamClient {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if len(stream.clients) >= maxStreamClients {
+		return nil
+	}
+	client := &streamClient{events: make(chan []byte, streamBuffer)}
+	stream.clients[client] = true
+	stream.count.Store(int32(len(stream.clients)))
+	return client
+}
+
+func (stream *hitStream) unsubscribe(client *streamClient) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	delete(stream.clients, client)
+	stream.count.Store(int32(len(stream.clients)))
+}
+
+// Send a hit to the clients, dropping it for any whose buffer is full. It is
+// only encoded if someone is listening.
+func (stream *hitStream) publish(entry *accessEntry) {
+	if stream.count.Load() == 0 {
+		return
+	}
+	event, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("stream:", err)
+		return
+	}
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	for client := range stream.clients {
+		select {
+		case client.events <- event:
+		default:
+			client.dropped.Add(1)
+		}
+	}
+}
+
+// The StreamHandler streams hits as server-sent events
+// (GET /_stats/stream): a "hit" event with a JSON access log entry for every
+// redirect and 404, and a "dropped" event with the number of hits missed
+// when the client has fallen behind.
+func (redir *Redirector) StreamHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			client := redir.hits.subscribe()
+			if client == nil {
+				w.Header().Set("Retry-After", "60")
+				writeError(w, http.StatusServiceUnavailable, "Too many streams")
+				return
+			}
+			defer redir.hits.unsubscribe(client)
+
+			// The stream outlasts -write-timeout.
+			rc := http.NewResponseController(w)
+			rc.SetWriteDeadline(time.Time{})
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+			if rc.Flush() != nil {
+				return
+			}
+
+			heartbeat := time.NewTicker(streamHeartbeat)
+			defer heartbeat.Stop()
+			for {
+				var err error
+				select {
+				case event := <-client.events:
+					if dropped := client.dropped.Swap(0); dropped > 0 {
+						_, err = w.Write([]byte("event: dropped\ndata: " + strconv.FormatInt(dropped, 10) + "\n\n"))
+					}
+					if err == nil {
+						_, err = w.Write(append(append([]byte("event: hit\ndata: "), event...), "\n\n"...))
+					}
+				case <-heartbeat.C:
+					_, err = w.Write([]byte(": heartbeat\n\n"))
+				case <-req.Context().Done():
+					return
+				}
+				if err == nil {
+					err = rc.Flush()
+				}
+				if err != nil {
+					return
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The sitemap whose pages suggest matches 404s against, as a file or URL,
+// besides the destinations of the live redirections.
+var sitemapSource *string = flag.String("sitemap", "", "sitemap file or URL of the pages suggest may redirect to")
+
+// How similar the slugs of a dead path and a page must be, from 0 to 1, for
+// the page to be suggested, and how many sitemaps a sitemap index may list.
+const (
+	minSimilarity   = 0.6
+	maxSitemaps     = 50
+	sitemapTimeout  = 30 * time.Second
+	maxSitemapBytes = 64 << 20
+)
+
+// A Suggestion is a redirection suggested for a path that was not found, with
+// how many times the log has it and how similar the destination's slug is to
+// its own. Paths that nothing matched have no destination.
+type Suggestion struct {
+	Path  string  `json:"path"`
+	To    string  `json:"to,omitempty"`
+	Hits  int64   `json:"hits"`
+	Score float64 `json:"score,omitempty"`
+}
+
+// Suggestions are a configuration fragment of the suggested redirections,
+// which can be reviewed and then PUT to /_config or dropped into a
+// configuration directory, and the details behind it, most hit first.
+type Suggestions struct {
+	Redirections map[string]string `json:"redirections"`
+	Suggestions  []Suggestion      `json:"suggestions"`
+	Unmatched    []Suggestion      `json:"unmatched"`
+}
+
+// The request and status of a line in the common or combined log format, as
+// nginx and Apache write it.
+var logRequest = regexp.MustCompile(`"(?:GET|HEAD) (\S+)(?: [^"]*)?" (\d{3}) `)
+
+// Count the paths that were not found in an access log in the common or
+// combined format, or in a list of paths, one per line. Query strings are
+// left out.
+func readMisses(r io.Reader) (map[string]int64, error) {
+	misses := make(map[string]int64)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		path := line
+		if m := logRequest.FindStringSubmatch(line); m != nil {
+			if m[2] != "404" {
+				continue
+			}
+			path = m[1]
+			if u, err := url.Parse(path); err == nil && u.IsAbs() {
+				path = u.EscapedPath()
+			}
+		} else if !strings.HasPrefix(line, "/") || strings.ContainsAny(line, " \t") {
+			continue
+		}
+		if i := strings.IndexAny(path, "?#"); i >= 0 {
+			path = path[:i]
+		}
+		if path != "" {
+			misses[sourceKey(path)]++
+		}
+	}
+	return misses, scanner.Err()
+}
+
+// A sitemap or sitemap index.
+type sitemap struct {
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// Read the page URLs in the sitemap in a file or at a URL, following a
+// sitemap index to the sitemaps it lists. Sitemaps ending in .gz are
+// decompressed.
+func readSitemap(source string) ([]string, error) {
+	client := &http.Client{Timeout: sitemapTimeout}
+	read := func(source string) (*sitemap, error) {
+		var r io.ReadCloser
+		var err error
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			var resp *http.Response
+			if resp, err = client.Get(source); err != nil {
+				return nil, err
+			}
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return nil, fmt.Errorf("%s: %s", source, resp.Status)
+			}
+			r = resp.Body
+		} else if r, err = os.Open(source); err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		var body io.Reader = io.LimitReader(r, maxSitemapBytes)
+		if strings.HasSuffix(source, ".gz") {
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", source, err)
+			}
+			body = gz
+		}
+		var s sitemap
+		if err := xml.NewDecoder(body).Decode(&s); err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		return &s, nil
+	}
+	s, err := read(source)
+	if err != nil {
+		return nil, err
+	}
+	urls := s.URLs
+	if len(s.Sitemaps) > maxSitemaps {
+		return nil, fmt.Errorf("%s: more than %d sitemaps", source, maxSitemaps)
+	}
+	for _, child := range s.Sitemaps {
+		c, err := read(strings.TrimSpace(child))
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, c.URLs...)
+	}
+	for i := range urls {
+		urls[i] = strings.TrimSpace(urls[i])
+	}
+	return urls, nil
+}
+
+// The destinations of the redirections that could be suggested for others:
+// those that always send clients to the same place.
+func (config *Config) suggestTargets() []string {
+	var targets []string
+	config.Redirections.Each(func(source string, rule *Rule) {
+		if rule.Respond != nil || rule.proxy != nil || rule.Script != "" {
+			return
+		}
+		if to, ok := config.expand(rule.To); ok && to != "" && !strings.ContainsAny(to, "{*") {
+			targets = append(targets, to)
+		}
+	})
+	return targets
+}
+
+// Suggest a redirection to one of targets for each of the paths missed that
+// no redirection matches, by the similarity of their slugs.
+func (config *Config) Suggest(misses map[string]int64, targets []string) *Suggestions {
+	type page struct{ to, slug, path string }
+	pages := make([]page, 0, len(targets))
+	seen := make(map[string]bool)
+	for _, to := range targets {
+		if seen[to] {
+			continue
+		}
+		seen[to] = true
+		path := to
+		if u, err := url.Parse(to); err == nil {
+			path = u.Path
+		}
+		if s := slug(path); s != "" {
+			pages = append(pages, page{to, s, strings.ToLower(path)})
+		}
+	}
+
+	suggestions := &Suggestions{
+		Redirections: make(map[string]string),
+		Suggestions:  []Suggestion{},
+		Unmatched:    []Suggestion{},
+	}
+	for path, hits := range misses {
+		if _, _, _, ok := config.lookup("", path); ok {
+			continue
+		}
+		best := Suggestion{Path: path, Hits: hits}
+		var bestPath float64
+		if s := slug(path); s != "" {
+			for _, p := range pages {
+				score := similarity(s, p.slug)
+				if score < minSimilarity || score < best.Score {
+					continue
+				}
+				// Between pages with slugs as similar, the one whose whole
+				// path is more similar wins.
+				pathScore := similarity(strings.ToLower(path), p.path)
+				if score > best.Score || pathScore > bestPath || pathScore == bestPath && p.to < best.To {
+					best.To, best.Score, bestPath = p.to, score, pathScore
+				}
+			}
+		}
+		if best.To == "" || best.To == path {
+			best.To, best.Score = "", 0
+			suggestions.Unmatched = append(suggestions.Unmatched, best)
+			continue
+		}
+		best.Score = float64(int(best.Score*100+0.5)) / 100
+		suggestions.Suggestions = append(suggestions.Suggestions, best)
+		suggestions.Redirections[path] = best.To
+	}
+	for _, list := range [][]Suggestion{suggestions.Suggestions, suggestions.Unmatched} {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Hits != list[j].Hits {
+				return list[i].Hits > list[j].Hits
+			}
+			return list[i].Path < list[j].Path
+		})
+	}
+	return suggestions
+}
+
+// File extensions that don't tell pages apart.
+var pageExtension = regexp.MustCompile(`\.(html?|php|aspx?|jsp|cfm)$`)
+
+// The slug of a path, the words of its last segment, lowercased and joined
+// with hyphens, or of its whole path if the last segment is only a number or
+// an index page, as in /2012/11/spring-sale.html or /products/blue_widget.
+func slug(path string) string {
+	path = strings.ToLower(strings.TrimSuffix(path, "/"))
+	words := func(s string) string {
+		return strings.Trim(nonWord.ReplaceAllString(pageExtension.ReplaceAllString(s, ""), "-"), "-")
+	}
+	last := words(path[strings.LastIndex(path, "/")+1:])
+	if last == "" || last == "index" || strings.Trim(last, "0123456789") == "" {
+		return words(path)
+	}
+	return last
+}
+
+var nonWord = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// How similar a and b are, from 0 to 1, by the edit distance between them.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	if float64(longest-min(len(ra), len(rb)))/float64(longest) > 1-minSimilarity {
+		// Too far apart to be suggested.
+		return 0
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// The Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			diagonal, row[j] = row[j], min(row[j]+1, row[j-1]+1, diagonal+cost)
+		}
+	}
+	return row[len(b)]
+}
+
+// Suggest redirections for the 404s in an access log, or - for standard
+// input, writing the configuration fragment to standard output and what was
+// and wasn't matched to standard error for review.
+func suggestCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: suggest LOG")
+		return 2
+	}
+	redir := NewRedirector()
+	if err := redir.LoadConfigFile(*configFile); err != nil {
+		fmt.Fprintln(os.Stderr, "suggest:", err)
+		return 1
+	}
+	in := os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "suggest:", err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+	misses, err := readMisses(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "suggest:", args[0]+":", err)
+		return 1
+	}
+	config := redir.live.Load()
+	targets := config.suggestTargets()
+	if *sitemapSource != "" {
+		urls, err := readSitemap(*sitemapSource)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "suggest:", err)
+			return 1
+		}
+		targets = append(targets, urls...)
+	}
+	suggestions := config.Suggest(misses, targets)
+	for _, s := range suggestions.Suggestions {
+		fmt.Fprintf(os.Stderr, "%s -> %s (%d hits, %.2f similar)\n", s.Path, s.To, s.Hits, s.Score)
+	}
+	for _, s := range suggestions.Unmatched {
+		fmt.Fprintf(os.Stderr, "%s: no match (%d hits)\n", s.Path, s.Hits)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]any{"redirections": suggestions.Redirections})
+	return 0
+}
+
+// The SuggestHandler suggests redirections for the 404s in the access log or
+// list of paths POSTed to /_suggest, matching them against the destinations
+// of the live redirections and the pages of the sitemap at ?sitemap=, if any.
+// Nothing is changed: the suggestions are for review.
+func (redir *Redirector) SuggestHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "POST" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			misses, err := readMisses(req.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Bad log: "+err.Error())
+				return
+			}
+			config := redir.live.Load()
+			targets := config.suggestTargets()
+			if source := req.URL.Query().Get("sitemap"); source != "" {
+				if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+					writeError(w, http.StatusBadRequest, "sitemap must be an http or https URL")
+					return
+				}
+				urls, err := readSitemap(source)
+				if err != nil {
+					writeError(w, http.StatusBadGateway, "Bad sitemap: "+err.Error())
+					return
+				}
+				targets = append(targets, urls...)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetEscapeHTML(false)
+			enc.Encode(config.Suggest(misses, targets))
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const suggestLog = `203.0.113.7 - - [03/Nov/2012:10:02:11 -0400] "GET /blog/2012/11/spring-sale-2012.html?utm=x HTTP/1.1" 404 162 "-" "Mozilla/5.0"
+203.0.113.7 - - [03/Nov/2012:10:02:12 -0400] "GET /blog/2012/11/spring-sale-2012.html HTTP/1.1" 404 162 "-" "Mozilla/5.0"
+203.0.113.7 - - [03/Nov/2012:10:02:13 -0400] "GET /products/blue_widgets HTTP/1.1" 404 162
+203.0.113.7 - - [03/Nov/2012:10:02:14 -0400] "GET /found HTTP/1.1" 200 162
+203.0.113.7 - - [03/Nov/2012:10:02:15 -0400] "POST /contact-us HTTP/1.1" 404 162
+203.0.113.7 - - [03/Nov/2012:10:02:16 -0400] "GET /wp-login.php HTTP/1.1" 404 162
+/old-about
+/caf%C3%A9-menu
+not a path
+`
+
+func TestReadMisses(t *testing.T) {
+	misses, err := readMisses(strings.NewReader(suggestLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{
+		"/blog/2012/11/spring-sale-2012.html": 2,
+		"/products/blue_widgets":              1,
+		"/wp-login.php":                       1,
+		"/old-about":                          1,
+		"/café-menu":                          1,
+	}
+	if fmt.Sprint(misses) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", misses, want)
+	}
+}
+
+func TestSlug(t *testing.T) {
+	for _, tt := range []struct{ path, want string }{
+		{"/blog/2012/11/spring-sale.html", "spring-sale"},
+		{"/products/Blue_Widget/", "blue-widget"},
+		{"/news/2012/42", "news-2012-42"},
+		{"/about/index.php", "about-index"},
+		{"/", ""},
+	} {
+		if got := slug(tt.path); got != tt.want {
+			t.Errorf("slug(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	sitemap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/sitemap.xml" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>https://shop.example.com/sales/spring-sale</loc></url>
+<url><loc> https://shop.example.com/contact </loc></url>
+</urlset>`)
+	}))
+	defer sitemap.Close()
+	tr := newTestRedirector(t, `{"redirections": {
+		"/old-about": "/about-us",
+		"/widget": "https://shop.example.com/products/blue-widget"
+	}}`)
+
+	w := tr.do("POST", "/_suggest?sitemap="+url.QueryEscape(sitemap.URL+"/sitemap.xml"), suggestLog)
+	tr.expectStatus(w, http.StatusOK)
+	var suggestions Suggestions
+	if err := json.Unmarshal(w.Body.Bytes(), &suggestions); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/blog/2012/11/spring-sale-2012.html": "https://shop.example.com/sales/spring-sale",
+		"/products/blue_widgets":              "https://shop.example.com/products/blue-widget",
+	}
+	if fmt.Sprint(suggestions.Redirections) != fmt.Sprint(want) {
+		t.Errorf("suggested %v, want %v", suggestions.Redirections, want)
+	}
+	// /old-about already has a redirection.
+	if len(suggestions.Unmatched) != 2 || suggestions.Unmatched[0].Path != "/café-menu" {
+		t.Errorf("unmatched %+v", suggestions.Unmatched)
+	}
+	if s := suggestions.Suggestions[0]; s.Hits != 2 || s.Score != 0.69 {
+		t.Errorf("got %+v", s)
+	}
+
+	tr.expectStatus(tr.do("POST", "/_suggest?sitemap=/etc/passwd", suggestLog), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_suggest?sitemap="+url.QueryEscape(sitemap.URL+"/missing.xml"), suggestLog), http.StatusBadGateway)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A debug tap captures the next requests for a path, with their headers and
+// a trace of how they were matched, for diagnosing why one URL goes to the
+// wrong place in production. A tap runs for a number of requests or minutes,
+// whichever comes first, and its captures are kept until it is removed or
+// replaced.
+type tap struct {
+	Path      string        `json:"path"`
+	Requests  int           `json:"requests"`
+	Expires   time.Time     `json:"expires"`
+	Captures  []*tapCapture `json:"captures"`
+	remaining int
+}
+
+// A request captured by a tap.
+type tapCapture struct {
+	Time        time.Time   `json:"time"`
+	Client      string      `json:"client"`
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	Header      http.Header `json:"header"`
+	Trace       []string    `json:"trace"`
+	Status      int         `json:"status"`
+	Action      string      `json:"action"`
+	Destination string      `json:"destination,omitempty"`
+}
+
+// Limits on taps, which are for a few requests, not for logging traffic.
+const (
+	maxTaps        = 20
+	maxTapRequests = 1000
+	maxTapMinutes  = 24 * 60
+)
+
+// Headers whose values are not captured.
+var tapRedacted = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// A tapSet holds the taps by path. Requests only look for a tap while some
+// are running.
+type tapSet struct {
+	mu      sync.Mutex
+	taps    map[string]*tap
+	running atomic.Int32
+	clock   Clock
+}
+
+func newTapSet(clock Clock) *tapSet {
+	return &tapSet{taps: make(map[string]*tap), clock: clock}
+}
+
+// Tap path for the next requests, or minutes, replacing any tap it had.
+func (taps *tapSet) add(path string, requests int, minutes int) (*tap, error) {
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+
+	if _, ok := taps.taps[path]; !ok && len(taps.taps) >= maxTaps {
+		return nil, fmt.Errorf("too many taps; remove one first")
+	}
+	t := &tap{
+		Path:      path,
+		Requests:  requests,
+		Expires:   taps.clock.Now().Add(time.Duration(minutes) * time.Minute),
+		Captures:  []*tapCapture{},
+		remaining: requests,
+	}
+	taps.taps[path] = t
+	taps.count()
+	return t.snapshot(), nil
+}
+
+// Remove the tap on path, reporting whether there was one.
+func (taps *tapSet) remove(path string) bool {
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+
+	_, ok := taps.taps[path]
+	delete(taps.taps, path)
+	taps.count()
+	return ok
+}
+
+// Count the running taps. The caller must hold the lock.
+func (taps *tapSet) count() {
+	now, running := taps.clock.Now(), 0
+	for _, t := range taps.taps {
+		if t.remaining > 0 && now.Before(t.Expires) {
+			running++
+		}
+	}
+	taps.running.Store(int32(running))
+}
+
+// Start capturing a request for path, if a running tap is on it, tracing it
+// with trace. It returns nil otherwise.
+func (taps *tapSet) capture(req *http.Request, path string, trace func() []string) *tapCapture {
+	if taps.running.Load() == 0 {
+		return nil
+	}
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+
+	t := taps.taps[pathKey(cleanPath(path))]
+	now := taps.clock.Now()
+	if t == nil || t.remaining == 0 || !now.Before(t.Expires) {
+		taps.count()
+		return nil
+	}
+	header := req.Header.Clone()
+	for _, name := range tapRedacted {
+		if header.Get(name) != "" {
+			header.Set(name, "(redacted)")
+		}
+	}
+	c := &tapCapture{
+		Time:   now,
+		Client: realAddr(req),
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: header,
+		Trace:  trace(),
+	}
+	t.Captures = append(t.Captures, c)
+	if t.remaining--; t.remaining == 0 {
+		taps.count()
+	}
+	return c
+}
+
+// Finish a capture with the response.
+func (taps *tapSet) finish(c *tapCapture, status int, entry *accessEntry) {
+	if c == nil {
+		return
+	}
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+	c.Status, c.Action, c.Destination = status, entry.Action, entry.Destination
+}
+
+// The taps, in order of path, or just the one on path.
+func (taps *tapSet) list(path string) []*tap {
+	taps.mu.Lock()
+	defer taps.mu.Unlock()
+
+	list := []*tap{}
+	for p, t := range taps.taps {
+		if path == "" || p == path {
+			list = append(list, t.snapshot())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// A copy of the tap that stays the same while it goes on capturing. The
+// caller must hold the lock.
+func (t *tap) snapshot() *tap {
+	c := *t
+	c.Captures = make([]*tapCapture, len(t.Captures))
+	for i, capture := range t.Captures {
+		copied := *capture
+		c.Captures[i] = &copied
+	}
+	return &c
+}
+
+// Trace how a request for path on host from a client with the user agent ua
+// is matched, step by step, as lookup does it. The caller must hold one of
+// the Redirector's locks.
+func (config *Config) trace(host, path, ua string) []string {
+	var steps []string
+	step := func(format string, args ...any) { steps = append(steps, fmt.Sprintf(format, args...)) }
+	cleaned := cleanPath(path)
+	if cleaned != path {
+		step("cleaned the path to %s", cleaned)
+	}
+	now := config.Clock().Now()
+	try := func(name string, redirections Rules, wildcards *wildcardSet) (*Rule, bool) {
+		key := pathKey(cleaned)
+		if rule, ok := redirections.Get(key); ok {
+			step("%s: exact match %s", name, key)
+			return rule, config.traceActive(rule, key, now, step)
+		}
+		step("%s: no exact match for %s", name, key)
+		for _, w := range wildcards.all() {
+			if _, ok := w.match(cleaned); ok {
+				step("%s: wildcard %s matched", name, w.source)
+				return w.rule, config.traceActive(w.rule, w.source, now, step)
+			}
+			step("%s: wildcard %s did not match", name, w.source)
+		}
+		return nil, false
+	}
+
+	rule, ok := (*Rule)(nil), false
+	if hr, subdomain := config.forHost(host); hr != nil {
+		if subdomain != "" {
+			step("host %s matched %s with subdomain %s", host, hr.host, subdomain)
+		}
+		rule, ok = try("host "+hr.host, hr.rules, hr.wildcards)
+	}
+	if !ok && config.Profile != "" {
+		rule, ok = try("profile "+config.Profile, config.Profiles[config.Profile], config.profileWildcards)
+	}
+	if !ok {
+		rule, ok = try("redirections", config.Redirections, config.wildcards)
+	}
+	if !ok {
+		if config.DefaultDestination != "" {
+			step("no redirection; default destination %s", config.DefaultDestination)
+		} else {
+			step("no redirection; not found")
+		}
+		return steps
+	}
+	if to, known := config.expand(rule.To); to != rule.To {
+		step("named destination %s is %s", rule.To, to)
+	} else if !known {
+		step("named destination %s is unknown", rule.To)
+	}
+	if policy := rule.agentPolicy(ua); policy != CrawlersRedirect {
+		step("user agent %q gets %s", ua, policy)
+	}
+	return steps
+}
+
+// Trace whether a matched rule is active.
+func (config *Config) traceActive(rule *Rule, source string, now time.Time, step func(string, ...any)) bool {
+	if config.active(rule, now) {
+		return true
+	}
+	step("%s is outside of its schedule (start %q, end %q, in %s)", source, rule.Start, rule.End, config.location(rule))
+	return false
+}
+
+// The TapHandler manages debug taps. POST /_tap starts one on "path" for the
+// next "requests" requests (10 by default) or "minutes" minutes (10 by
+// default), GET /_tap lists the taps and their captures (or, with ?path=,
+// one tap's), and DELETE /_tap?path= removes one.
+func (redir *Redirector) TapHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			path := req.FormValue("path")
+			if path != "" {
+				path = pathKey(cleanPath(path))
+			}
+			switch req.Method {
+			case "GET":
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(redir.taps.list(path))
+			case "POST":
+				if path == "" || path[0] != '/' {
+					writeError(w, http.StatusBadRequest, "Missing path")
+					return
+				}
+				requests, ok := formInt(req, "requests", 10, maxTapRequests)
+				if !ok {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("requests must be from 1 to %d", maxTapRequests))
+					return
+				}
+				minutes, ok := formInt(req, "minutes", 10, maxTapMinutes)
+				if !ok {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("minutes must be from 1 to %d", maxTapMinutes))
+					return
+				}
+				t, err := redir.taps.add(path, requests, minutes)
+				if err != nil {
+					writeError(w, http.StatusConflict, err.Error())
+					return
+				}
+				log.Println(realAddr(req), "tapped", path, "for", requests, "requests or", minutes, "minutes")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(t)
+			case "DELETE":
+				if !redir.taps.remove(path) {
+					writeError(w, http.StatusNotFound, "No tap on "+path)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			}
+		})
+	}
+}
+
+// A whole number from 1 to max in a form field, or def if it is missing.
+func formInt(req *http.Request, name string, def, max int) (int, bool) {
+	value := req.FormValue(name)
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(value)
+	return n, err == nil && n >= 1 && n <= max
+}
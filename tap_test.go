@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTap(t *testing.T) {
+	t.Parallel()
+	manual := NewManualClock(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC))
+	tr := newTestRedirectorWithClock(t, manual, `{"redirections": {"/spring": "/sale", "/summer": "/sale"}}`)
+	form := []string{"Content-Type", "application/x-www-form-urlencoded"}
+	captures := func(path string) []*tapCapture {
+		t.Helper()
+		w := tr.do("GET", "/_tap?path="+path, "")
+		tr.expectStatus(w, http.StatusOK)
+		var taps []*tap
+		tr.decode(w, &taps)
+		if len(taps) != 1 {
+			t.Fatalf("got %d taps on %s, want 1", len(taps), path)
+		}
+		return taps[0].Captures
+	}
+
+	tr.expectStatus(tr.do("POST", "/_tap", "path=/spring&requests=2", form...), http.StatusCreated)
+	tr.do("GET", "/spring", "", "Authorization", "Bearer secret")
+	tr.expectRedirect("/summer", http.StatusFound, "/sale")
+	tr.expectRedirect("/spring", http.StatusFound, "/sale")
+	tr.expectRedirect("/spring", http.StatusFound, "/sale")
+	got := captures("/spring")
+	if len(got) != 2 {
+		t.Fatalf("got %d captures, want the first 2", len(got))
+	}
+	if c := got[0]; c.URL != "/spring" || c.Status != http.StatusFound || c.Destination != "/sale" || len(c.Trace) == 0 {
+		t.Errorf("got %+v", c)
+	}
+	if auth := got[0].Header.Get("Authorization"); auth != "(redacted)" {
+		t.Errorf("captured Authorization %q", auth)
+	}
+
+	// A tap runs out after its minutes, however few requests it has had.
+	tr.expectStatus(tr.do("POST", "/_tap", "path=/summer&minutes=1", form...), http.StatusCreated)
+	manual.Advance(2 * time.Minute)
+	tr.expectRedirect("/summer", http.StatusFound, "/sale")
+	if got := captures("/summer"); len(got) != 0 {
+		t.Errorf("got %d captures after the tap expired", len(got))
+	}
+
+	tr.expectStatus(tr.do("POST", "/_tap", "requests=2", form...), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_tap", "path=/spring&requests=0", form...), http.StatusBadRequest)
+	tr.expectStatus(tr.do("POST", "/_tap", "path=/spring&minutes=99999", form...), http.StatusBadRequest)
+
+	tr.expectStatus(tr.do("DELETE", "/_tap?path=/spring", ""), http.StatusNoContent)
+	tr.expectStatus(tr.do("DELETE", "/_tap?path=/spring", ""), http.StatusNotFound)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "GET", "/_tap", ""), http.StatusUnauthorized)
+}
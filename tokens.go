@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// An APIToken lets a client use the admin API from any address, by sending
+// it as a bearer token. The configuration only has its SHA-256, in hex, so
+// that it doesn't give the token away:
+//
+//	"admin": {"tokens": {
+//	  "marketing": {"sha256": "5e884898da...", "scope": "write", "prefixes": ["/promo/"], "hosts": ["shop.example.com"]}
+//	}}
+//
+// A token with the "admin" scope may do anything a client allowed by address
+// may. Other tokens may only use the redirections' own endpoints: "read" to
+// inspect and list redirections, and "write" to change them too. Prefixes
+// limit them to the redirections whose sources start with one of them, and
+// Hosts limit the destinations of the redirections they set to those hosts,
+// or their subdomains with *., such as *.example.com. Destinations on this
+// server are always allowed.
+type APIToken struct {
+	SHA256   string   `json:"sha256"`
+	Scope    string   `json:"scope"`
+	Prefixes []string `json:"prefixes,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+}
+
+// Token scopes.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// A token as the configuration names it.
+type apiToken struct {
+	name string
+	*APIToken
+}
+
+var errUnknownToken = errors.New("unknown token")
+
+// Check the tokens and index them by their hashes.
+func compileTokens(tokens map[string]*APIToken) (map[[sha256.Size]byte]apiToken, error) {
+	compiled := make(map[[sha256.Size]byte]apiToken, len(tokens))
+	for name, token := range tokens {
+		var sum [sha256.Size]byte
+		if n, err := hex.Decode(sum[:], []byte(token.SHA256)); err != nil || n != sha256.Size {
+			return nil, fmt.Errorf("token %s: sha256 is not a SHA-256 in hex", name)
+		}
+		if _, ok := compiled[sum]; ok {
+			return nil, fmt.Errorf("token %s: another token has the same sha256", name)
+		}
+		switch token.Scope {
+		case ScopeRead, ScopeWrite:
+		case ScopeAdmin:
+			if token.Prefixes != nil || token.Hosts != nil {
+				return nil, fmt.Errorf("token %s: admin tokens cannot be limited to prefixes or hosts", name)
+			}
+		default:
+			return nil, fmt.Errorf("token %s: scope must be %q, %q, or %q, not %q", name, ScopeRead, ScopeWrite, ScopeAdmin, token.Scope)
+		}
+		for _, prefix := range token.Prefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				return nil, fmt.Errorf("token %s: prefix %q does not start with /", name, prefix)
+			}
+		}
+		for _, host := range token.Hosts {
+			if bare := strings.TrimPrefix(host, "*."); bare == "" || host != strings.ToLower(host) || strings.ContainsAny(bare, "*:/") {
+				return nil, fmt.Errorf("token %s: host %q is not a lowercase host name or *. followed by one", name, host)
+			}
+		}
+		compiled[sum] = apiToken{name, token}
+	}
+	return compiled, nil
+}
+
+// The token of a client of the admin API, which is nil if the client has all
+// the access there is, as clients allowed by address and with admin tokens
+// do. It is an error if the client has no access.
+func (redir *Redirector) tokenFor(req *http.Request) (*apiToken, error) {
+	config := redir.live.Load()
+	if bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		token, ok := config.tokens[sha256.Sum256([]byte(strings.TrimSpace(bearer)))]
+		if !ok {
+			return nil, errUnknownToken
+		}
+		if token.Scope == ScopeAdmin {
+			return nil, nil
+		}
+		return &token, nil
+	}
+	addr, err := clientAddr(req)
+	if err == nil && !containsAddr(adminAllow, addr) && !containsAddr(config.adminAllow, addr) {
+		err = errors.New("address not allowed")
+	}
+	return nil, err
+}
+
+// Run fn for clients allowed to use the admin API, passing the token of a
+// client whose access is limited, or nil. Tokens that may only read are
+// refused other methods.
+func (redir *Redirector) onlyScoped(w http.ResponseWriter, req *http.Request, fn func(token *apiToken)) {
+	token, err := redir.tokenFor(req)
+	switch {
+	case err != nil:
+		log.Println(realAddr(req), "denied", req.Method, req.URL.Path+":", err)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+	case !token.allows(req.Method, ""):
+		log.Println(realAddr(req), "denied", req.Method, req.URL.Path, "to token", token.name)
+		writeError(w, http.StatusForbidden, "Forbidden")
+	default:
+		fn(token)
+	}
+}
+
+// Run fn for clients that may use req's method on the redirection from
+// source.
+func (redir *Redirector) onlyRule(w http.ResponseWriter, req *http.Request, source string, fn func()) {
+	redir.onlyScoped(w, req, func(token *apiToken) {
+		if !token.allows(req.Method, pathKey(source)) {
+			log.Println(realAddr(req), "denied", req.Method, source, "to token", token.name)
+			writeError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		fn()
+	})
+}
+
+// Whether the token allows method on the redirection from source, or on any
+// of them if source is empty. A nil token allows everything.
+func (token *apiToken) allows(method, source string) bool {
+	if token == nil {
+		return true
+	}
+	if method != "GET" && method != "HEAD" && token.Scope != ScopeWrite {
+		return false
+	}
+	return source == "" || token.covers(source)
+}
+
+// Whether the redirection from source is among the token's.
+func (token *apiToken) covers(source string) bool {
+	if token == nil || token.Prefixes == nil {
+		return true
+	}
+	for _, prefix := range token.Prefixes {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the token may set rule, by where it sends clients. Tokens limited
+// to hosts can't set responses of their own, which would be served from
+// this server, alongside the admin dashboard.
+func (token *apiToken) allowsRule(rule *Rule) error {
+	if token == nil || token.Hosts == nil {
+		return nil
+	}
+	if rule.Script != "" || strings.HasPrefix(rule.To, "@") {
+		return fmt.Errorf("token %s may not set scripts or named destinations", token.name)
+	}
+	if rule.Respond != nil {
+		return fmt.Errorf("token %s may not set responses", token.name)
+	}
+	if rule.Canonical != nil && rule.Canonical.Host != "" && !token.allowsHost(rule.Canonical.Host) {
+		return fmt.Errorf("token %s may not redirect to %s", token.name, rule.Canonical.Host)
+	}
+	if rule.To == "" {
+		return nil
+	}
+	u, err := url.Parse(rule.To)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		// On this server, since checkDestination refuses paths that browsers
+		// would take to another.
+		return nil
+	}
+	if host := u.Hostname(); !token.allowsHost(host) {
+		return fmt.Errorf("token %s may not redirect to %s", token.name, host)
+	}
+	return nil
+}
+
+// Whether the token may send clients to host.
+func (token *apiToken) allowsHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range token.Hosts {
+		if host == allowed || strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Who a client is, for the record: its token's name, or its address.
+func (token *apiToken) client(req *http.Request) string {
+	if token == nil {
+		return realAddr(req)
+	}
+	return "token " + token.name
+}
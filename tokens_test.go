@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// The tokens "secret", which may write redirections under /promo/ to
+// example.com, and "reader", which may read them all.
+const tokensConfig = `{
+	"redirections": {"/promo/spring": "/spring", "/other": "/elsewhere"},
+	"admin": {"tokens": {
+		"marketing": {"sha256": "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", "scope": "write", "prefixes": ["/promo/"], "hosts": ["*.example.com"]},
+		"dashboard": {"sha256": "3d0941964aa3ebdcb00ccef58b1bb399f9f898465e9886d5aec7f31090a0fb30", "scope": "read"}
+	}}
+}`
+
+func TestTokens(t *testing.T) {
+	tr := newTestRedirector(t, tokensConfig)
+	const client = "192.0.2.1"
+	writer := []string{"Authorization", "Bearer secret"}
+	reader := []string{"Authorization", "Bearer reader"}
+
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/summer", "/summer", "Authorization", "Bearer wrong"), http.StatusUnauthorized)
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/summer", "/summer", writer...), http.StatusCreated)
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/fall", "https://shop.example.com/fall", writer...), http.StatusCreated)
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/fall", "https://example.org/fall", writer...), http.StatusForbidden)
+	tr.expectStatus(tr.doFrom(client, "PUT", "/other", "/summer", writer...), http.StatusForbidden)
+
+	// Nor can it send clients elsewhere by other means.
+	writerJSON := append([]string{"Content-Type", "application/json"}, writer...)
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/a", `{"to": "/a", "canonical": {"host": "evil.com"}}`, writerJSON...), http.StatusForbidden)
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/a", `{"to": "/a", "canonical": {"host": "shop.example.com"}}`, writerJSON...), http.StatusCreated)
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/b", `/\evil.com`, writer...), http.StatusBadRequest)
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/c", `{"respond": {"status": 200, "content_type": "text/html", "body": "<script>alert(1)</script>"}}`, writerJSON...), http.StatusForbidden)
+	tr.expectNotFound("/promo/b")
+	tr.expectNotFound("/promo/c")
+	tr.expectStatus(tr.doFrom(client, "PUT", "/promo/summer", "/x", reader...), http.StatusForbidden)
+	tr.expectStatus(tr.doFrom(client, "GET", "/_config", "", writer...), http.StatusForbidden)
+
+	w := tr.doFrom(client, "PUT", "/promo/winter", "/winter", writer...)
+	var listing ruleListing
+	json.Unmarshal(w.Body.Bytes(), &listing)
+	if listing.Rule.CreatedBy != "token marketing" {
+		t.Errorf("created by %q, want token marketing", listing.Rule.CreatedBy)
+	}
+
+	// Listings are limited to the token's prefixes.
+	for _, tt := range []struct {
+		headers []string
+		total   int
+	}{{writer, 5}, {reader, 6}} {
+		w := tr.doFrom(client, "GET", "/_config/rules", "", tt.headers...)
+		tr.expectStatus(w, http.StatusOK)
+		var page rulePage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != tt.total {
+			t.Errorf("%s: listed %d redirections, want %d", tt.headers[1], page.Total, tt.total)
+		}
+	}
+}
+
+func TestCompileTokens(t *testing.T) {
+	const sum = "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	for _, tt := range []struct {
+		token APIToken
+		ok    bool
+	}{
+		{APIToken{SHA256: sum, Scope: ScopeRead}, true},
+		{APIToken{SHA256: sum, Scope: ScopeWrite, Prefixes: []string{"/promo/"}, Hosts: []string{"*.example.com"}}, true},
+		{APIToken{SHA256: "secret", Scope: ScopeRead}, false},
+		{APIToken{SHA256: sum, Scope: "owner"}, false},
+		{APIToken{SHA256: sum, Scope: ScopeAdmin, Prefixes: []string{"/promo/"}}, false},
+		{APIToken{SHA256: sum, Scope: ScopeWrite, Prefixes: []string{"promo"}}, false},
+		{APIToken{SHA256: sum, Scope: ScopeWrite, Hosts: []string{"Example.com"}}, false},
+	} {
+		token := tt.token
+		if _, err := compileTokens(map[string]*APIToken{"test": &token}); (err == nil) != tt.ok {
+			t.Errorf("%+v: got %v", tt.token, err)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The TOML understood here is TOML 1.0 apart from hexadecimal, octal, and
+// binary integers, infinities and NaN. Dates and times are read as strings.
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func parseTOML(data []byte) (map[string]any, error) {
+	p := &tomlParser{s: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1}
+	root := make(map[string]any)
+	table := root
+	for {
+		p.skipBlank()
+		if p.pos == len(p.s) {
+			return root, nil
+		}
+		var err error
+		if p.s[p.pos] == '[' {
+			table, err = p.header(root)
+		} else {
+			err = p.keyValue(table)
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", p.line, err)
+		}
+	}
+}
+
+// Skip whitespace, comments, and line breaks.
+func (p *tomlParser) skipBlank() {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t':
+		case '\n':
+			p.line++
+		case '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// Expect only a comment before the end of the line.
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == '#' {
+		for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+	if p.pos < len(p.s) && p.s[p.pos] != '\n' {
+		return fmt.Errorf("unexpected %q", p.rest())
+	}
+	return nil
+}
+
+// The rest of the current line, for error messages.
+func (p *tomlParser) rest() string {
+	rest, _, _ := strings.Cut(p.s[p.pos:], "\n")
+	return rest
+}
+
+func (p *tomlParser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(p.s[p.pos:], prefix)
+}
+
+// Read a [table] or [[array of tables]] header, returning the table that
+// the following keys go in.
+func (p *tomlParser) header(root map[string]any) (map[string]any, error) {
+	array := p.hasPrefix("[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !p.hasPrefix(closing) {
+		return nil, fmt.Errorf("expected %s", closing)
+	}
+	p.pos += len(closing)
+
+	parent, err := tomlTable(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	if array {
+		list, ok := parent[last].([]any)
+		if !ok && parent[last] != nil {
+			return nil, fmt.Errorf("%s is not an array of tables", strings.Join(keys, "."))
+		}
+		table := make(map[string]any)
+		parent[last] = append(list, table)
+		return table, nil
+	}
+	return tomlTable(parent, []string{last})
+}
+
+// Find or create the table at keys below table. An array of tables stands for
+// its last table.
+func tomlTable(table map[string]any, keys []string) (map[string]any, error) {
+	for _, key := range keys {
+		switch next := table[key].(type) {
+		case nil:
+			created := make(map[string]any)
+			table[key] = created
+			table = created
+		case map[string]any:
+			table = next
+		case []any:
+			last, ok := next[len(next)-1].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%s is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+// Read key = value into table.
+func (p *tomlParser) keyValue(table map[string]any) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.pos == len(p.s) || p.s[p.pos] != '=' {
+		return fmt.Errorf("expected = after %s", strings.Join(keys, "."))
+	}
+	p.pos++
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	if table, err = tomlTable(table, keys[:len(keys)-1]); err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, dup := table[last]; dup {
+		return fmt.Errorf("duplicate key %s", strings.Join(keys, "."))
+	}
+	table[last] = value
+	return nil
+}
+
+// Read a dotted key of bare and quoted parts.
+func (p *tomlParser) key() (keys []string, err error) {
+	for {
+		p.skipSpace()
+		var key string
+		switch {
+		case p.hasPrefix(`"`):
+			key, err = p.basicString()
+		case p.hasPrefix("'"):
+			key, err = p.literalString()
+		default:
+			start := p.pos
+			for p.pos < len(p.s) && isBareKeyChar(p.s[p.pos]) {
+				p.pos++
+			}
+			if key = p.s[start:p.pos]; key == "" {
+				err = fmt.Errorf("expected a key: %q", p.rest())
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		p.skipSpace()
+		if !p.hasPrefix(".") {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) value() (any, error) {
+	p.skipSpace()
+	switch {
+	case p.pos == len(p.s):
+		return nil, fmt.Errorf("expected a value")
+	case p.hasPrefix(`"""`):
+		return p.multilineString(`"""`)
+	case p.hasPrefix(`'''`):
+		return p.multilineString(`'''`)
+	case p.hasPrefix(`"`):
+		return p.basicString()
+	case p.hasPrefix("'"):
+		return p.literalString()
+	case p.hasPrefix("["):
+		return p.array()
+	case p.hasPrefix("{"):
+		return p.inlineTable()
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\n#,]}", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	// Dates may have a space between the date and the time.
+	if len(token) == 10 && p.pos+1 < len(p.s) && p.s[p.pos] == ' ' && p.s[p.pos+1] >= '0' && p.s[p.pos+1] <= '9' {
+		p.pos++
+		for p.pos < len(p.s) && strings.IndexByte(" \t\n#,]}", p.s[p.pos]) < 0 {
+			p.pos++
+		}
+		token = p.s[start:p.pos]
+	}
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.TrimPrefix(strings.ReplaceAll(token, "_", ""), "+")
+	if json.Valid([]byte(number)) {
+		if _, err := strconv.ParseFloat(number, 64); err == nil {
+			return json.Number(number), nil
+		}
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "15:04:05"} {
+		if _, err := time.Parse(layout, token); err == nil {
+			return token, nil
+		}
+	}
+	return nil, fmt.Errorf("bad value %q", token)
+}
+
+// Read a "basic string" with escapes.
+func (p *tomlParser) basicString() (string, error) {
+	for end := p.pos + 1; end < len(p.s) && p.s[end] != '\n'; end++ {
+		switch p.s[end] {
+		case '\\':
+			end++
+		case '"':
+			s, err := strconv.Unquote(p.s[p.pos : end+1])
+			p.pos = end + 1
+			return s, err
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// Read a 'literal string' without escapes.
+func (p *tomlParser) literalString() (string, error) {
+	end := strings.IndexAny(p.s[p.pos+1:], "'\n")
+	if end < 0 || p.s[p.pos+1+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.s[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return s, nil
+}
+
+// Read a multi-line basic or literal string, in three double or single
+// quotes. A line break right after the opening quotes is dropped, as is a
+// backslash at the end of a line in a basic string along with the whitespace
+// after it.
+func (p *tomlParser) multilineString(quotes string) (string, error) {
+	p.pos += 3
+	end := strings.Index(p.s[p.pos:], quotes)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string")
+	}
+	// Up to two more quotes may end the string.
+	for extra := 0; extra < 2 && p.pos+end+3 < len(p.s) && p.s[p.pos+end+3] == quotes[0]; extra++ {
+		end++
+	}
+	raw := strings.TrimPrefix(p.s[p.pos:p.pos+end], "\n")
+	p.line += strings.Count(p.s[p.pos:p.pos+end], "\n")
+	p.pos += end + 3
+	if quotes == "'''" {
+		return raw, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			b.WriteByte(raw[i])
+			continue
+		}
+		if i+1 == len(raw) {
+			return "", fmt.Errorf("bad escape")
+		}
+		if rest := strings.TrimLeft(raw[i+1:], " \t"); strings.HasPrefix(rest, "\n") {
+			i = len(raw) - len(strings.TrimLeft(rest, " \t\n")) - 1
+			continue
+		}
+		escape := `\` + raw[i+1:i+2]
+		if raw[i+1] == 'u' || raw[i+1] == 'U' {
+			n := 4
+			if raw[i+1] == 'U' {
+				n = 8
+			}
+			if i+2+n > len(raw) {
+				return "", fmt.Errorf("bad escape")
+			}
+			escape = raw[i : i+2+n]
+		}
+		s, err := strconv.Unquote(`"` + escape + `"`)
+		if err != nil {
+			return "", fmt.Errorf("bad escape %s", escape)
+		}
+		b.WriteString(s)
+		i += len(escape) - 1
+	}
+	return b.String(), nil
+}
+
+// Read an [array], which may span lines.
+func (p *tomlParser) array() (any, error) {
+	p.pos++
+	list := []any{}
+	for {
+		p.skipBlank()
+		if p.hasPrefix("]") {
+			p.pos++
+			return list, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+		p.skipBlank()
+		switch {
+		case p.hasPrefix(","):
+			p.pos++
+		case !p.hasPrefix("]"):
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+// Read an {inline = "table"} on one line.
+func (p *tomlParser) inlineTable() (any, error) {
+	p.pos++
+	table := make(map[string]any)
+	p.skipSpace()
+	if p.hasPrefix("}") {
+		p.pos++
+		return table, nil
+	}
+	for {
+		if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		switch {
+		case p.hasPrefix(","):
+			p.pos++
+		case p.hasPrefix("}"):
+			p.pos++
+			return table, nil
+		default:
+			return nil, fmt.Errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// Write a table decoded by decodeOrdered as TOML, under the header for path.
+// Values come first, then tables and arrays of tables. Nulls are left out, as
+// TOML has none.
+func encodeTOML(buf *bytes.Buffer, path []string, table *orderedMap, arrayTable bool) {
+	var values, tables []string
+	for _, key := range table.keys {
+		switch value := table.values[key].(type) {
+		case nil:
+		case *orderedMap:
+			tables = append(tables, key)
+		case []any:
+			if isTableArray(value) {
+				tables = append(tables, key)
+			} else {
+				values = append(values, key)
+			}
+		default:
+			values = append(values, key)
+		}
+	}
+
+	if len(path) > 0 && (arrayTable || len(values) > 0 || len(tables) == 0) {
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		if arrayTable {
+			fmt.Fprintf(buf, "[[%s]]\n", tomlKey(path...))
+		} else {
+			fmt.Fprintf(buf, "[%s]\n", tomlKey(path...))
+		}
+	}
+	for _, key := range values {
+		fmt.Fprintf(buf, "%s = %s\n", tomlKey(key), tomlValue(table.values[key]))
+	}
+	for _, key := range tables {
+		sub := append(append([]string(nil), path...), key)
+		switch value := table.values[key].(type) {
+		case *orderedMap:
+			encodeTOML(buf, sub, value, false)
+		case []any:
+			for _, item := range value {
+				encodeTOML(buf, sub, item.(*orderedMap), true)
+			}
+		}
+	}
+}
+
+// Arrays of objects are written as arrays of tables.
+func isTableArray(list []any) bool {
+	for _, item := range list {
+		if _, ok := item.(*orderedMap); !ok {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+// Write a dotted key, quoting the parts that aren't bare.
+func tomlKey(keys ...string) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key
+		if key == "" || strings.IndexFunc(key, func(r rune) bool { return r > 0x7f || !isBareKeyChar(byte(r)) }) >= 0 {
+			parts[i] = quoteString(key)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Write a value inline.
+func tomlValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return quoteString(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			if item != nil {
+				items = append(items, tomlValue(item))
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *orderedMap:
+		items := make([]string, 0, len(value.keys))
+		for _, key := range value.keys {
+			if value.values[key] != nil {
+				items = append(items, tomlKey(key)+" = "+tomlValue(value.values[key]))
+			}
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return quoteString(fmt.Sprint(value))
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Where to export traces, as OpenTelemetry's OTLP over HTTP with JSON. The
+// standard OTEL_EXPORTER_OTLP_ENDPOINT and OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+// environment variables are used if this isn't set.
+var otlpEndpoint *string = flag.String("otlp-endpoint", "", "OTLP/HTTP collector to export traces to, such as http://localhost:4318")
+
+// The share of requests traced, unless the caller's traceparent says whether
+// to trace them.
+var traceSample *float64 = flag.Float64("trace-sample", 1, "fraction of requests to trace, from 0 to 1")
+
+// Go's profiles, for diagnosing slowdowns, served to admins.
+var pprofEnabled *bool = flag.Bool("pprof", false, "serve Go's runtime profiles to admins at /debug/pprof/")
+
+// Span kinds, as numbered by OTLP.
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+// How many finished spans may wait to be exported, and how many are sent
+// at once, at least every traceFlush.
+const (
+	traceQueue = 4096
+	traceBatch = 512
+	traceFlush = 5 * time.Second
+)
+
+// A span is a timed operation in a trace, such as handling a request, a
+// lookup, or proxying. Spans of traces that aren't sampled are kept in the
+// context, so the trace stays unsampled, but not exported. A nil span does
+// nothing.
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	sampled  bool
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	err      string
+}
+
+type spanAttr struct {
+	key   string
+	value any
+}
+
+// A tracer exports finished spans in the background. Spans that don't fit
+// in the queue are dropped and counted.
+type tracer struct {
+	url     string
+	client  *http.Client
+	queue   chan *span
+	flushed chan chan struct{}
+	dropped atomic.Int64
+}
+
+// The tracer in use, if traces are exported.
+var tracing *tracer
+
+type spanKey struct{}
+
+// Start exporting traces, if an endpoint is configured.
+func startTracing() {
+	url := *otlpEndpoint
+	if url == "" {
+		if url = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); url != "" {
+			tracing = newTracer(url)
+			return
+		}
+		url = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if url != "" {
+		tracing = newTracer(strings.TrimSuffix(url, "/") + "/v1/traces")
+	}
+}
+
+func newTracer(url string) *tracer {
+	t := &tracer{url: url, client: &http.Client{Timeout: 10 * time.Second}, queue: make(chan *span, traceQueue), flushed: make(chan chan struct{})}
+	go t.run()
+	log.Println("exporting traces to", url)
+	return t
+}
+
+// Start a span as a child of the one in ctx, or else in a new trace.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracing == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now()}
+	rand.Read(s.spanID[:])
+	if parent := spanFrom(ctx); parent != nil {
+		s.traceID, s.parentID, s.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else {
+		rand.Read(s.traceID[:])
+		s.sampled = randomFloat() < *traceSample
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// The span in ctx, if any.
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// Trace requests to handler, continuing the caller's trace if the request
+// has a W3C traceparent header.
+func traceRequests(handler http.Handler) http.Handler {
+	if tracing == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if parent, ok := parseTraceparent(req.Header.Get("Traceparent")); ok {
+			ctx = context.WithValue(ctx, spanKey{}, parent)
+		}
+		ctx, s := startSpan(ctx, req.Method, spanServer)
+		s.set("http.request.method", req.Method)
+		s.set("url.path", req.URL.Path)
+		s.set("client.address", realAddr(req))
+		s.set("user_agent.original", req.UserAgent())
+		cw := &countingWriter{ResponseWriter: w}
+		defer func() {
+			status := cw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			s.set("http.response.status_code", status)
+			if status >= 500 {
+				s.fail(http.StatusText(status))
+			}
+			s.finish()
+		}()
+		handler.ServeHTTP(cw, req.WithContext(ctx))
+	})
+}
+
+// The trace and parent span in a traceparent header, as a span standing in
+// for the caller's.
+func parseTraceparent(header string) (*span, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil, false
+	}
+	s := new(span)
+	flags, err := hex.DecodeString(parts[3])
+	if _, err1 := hex.Decode(s.traceID[:], []byte(parts[1])); err1 != nil || err != nil {
+		return nil, false
+	}
+	if _, err = hex.Decode(s.spanID[:], []byte(parts[2])); err != nil {
+		return nil, false
+	}
+	if s.traceID == [16]byte{} || s.spanID == [8]byte{} {
+		return nil, false
+	}
+	s.sampled = flags[0]&1 == 1
+	return s, true
+}
+
+// The traceparent header that makes a request part of the span's trace.
+func (s *span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-" + flags
+}
+
+// Set an attribute on the span.
+func (s *span) set(key string, value any) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.attrs = append(s.attrs, spanAttr{key, value})
+}
+
+// Mark the span as failed.
+func (s *span) fail(message string) {
+	if s == nil {
+		return
+	}
+	s.err = message
+}
+
+// Finish the span, queueing it for export.
+func (s *span) finish() {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.end = time.Now()
+	select {
+	case tracing.queue <- s:
+	default:
+		tracing.dropped.Add(1)
+	}
+}
+
+// Export spans in batches.
+func (t *tracer) run() {
+	ticker := time.NewTicker(traceFlush)
+	defer ticker.Stop()
+	var batch []*span
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) < traceBatch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case done := <-t.flushed:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			if len(batch) > 0 {
+				if err := t.export(batch); err != nil {
+					log.Println("traces:", err)
+				}
+				batch = nil
+			}
+			close(done)
+			continue
+		}
+		if err := t.export(batch); err != nil {
+			log.Println("traces:", err)
+		}
+		batch = nil
+	}
+}
+
+// Export the spans waiting to be, giving up after timeout.
+func (t *tracer) flush(timeout time.Duration) {
+	done := make(chan struct{})
+	select {
+	case t.flushed <- done:
+		select {
+		case <-done:
+		case <-time.After(timeout):
+		}
+	case <-time.After(timeout):
+	}
+}
+
+// The OTLP JSON encoding of spans, with IDs in hex and times as strings of
+// nanoseconds.
+type otlpValue map[string]any
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+func otlpAttribute(key string, value any) otlpAttr {
+	switch v := value.(type) {
+	case bool:
+		return otlpAttr{key, otlpValue{"boolValue": v}}
+	case int:
+		return otlpAttr{key, otlpValue{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttr{key, otlpValue{"intValue": strconv.FormatInt(v, 10)}}
+	case float64:
+		return otlpAttr{key, otlpValue{"doubleValue": v}}
+	}
+	return otlpAttr{key, otlpValue{"stringValue": fmt.Sprint(value)}}
+}
+
+// Send a batch of spans to the collector.
+func (t *tracer) export(batch []*span) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		o := otlpSpan{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttribute(attr.key, attr.value))
+		}
+		if s.err != "" {
+			o.Status = &struct {
+				Code    int    `json:"code"`
+				Message string `json:"message,omitempty"`
+			}{2, s.err}
+		}
+		spans[i] = o
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []otlpAttr{
+				otlpAttribute("service.name", "fourohfourfound"),
+				otlpAttribute("service.version", version),
+			}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "fourohfourfound", "version": version},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s from %s", resp.Status, t.url)
+	}
+	return nil
+}
+
+// Serve Go's profiles at /debug/pprof/ on mux, for admins only. Profiles
+// that run for a while, such as a 30 second CPU profile, may outlast
+// -write-timeout.
+func (redir *Redirector) handlePprof(mux *http.ServeMux) {
+	for path, handler := range map[string]http.HandlerFunc{
+		"/debug/pprof/":        pprof.Index,
+		"/debug/pprof/cmdline": pprof.Cmdline,
+		"/debug/pprof/profile": pprof.Profile,
+		"/debug/pprof/symbol":  pprof.Symbol,
+		"/debug/pprof/trace":   pprof.Trace,
+	} {
+		mux.HandleFunc(path, allowMethods(func(w http.ResponseWriter, req *http.Request) {
+			log.Println(realAddr(req), req.Method, req.URL.Path)
+			redir.onlyAdmin(w, req, func() {
+				http.NewResponseController(w).SetWriteDeadline(time.Time{})
+				handler(w, req)
+			})
+		}, "GET", "POST"))
+	}
+}
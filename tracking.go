@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"text/template"
+)
+
+// Tracking parameters are added to the query of redirect destinations, so
+// that visits from each ad or campaign can be measured. They are set for all
+// rules with "tracking" at the top level of the configuration, and for one
+// rule with its own, which takes precedence:
+//
+//	"tracking": {"utm_source": "fourohfourfound", "utm_medium": "redirect"},
+//	"redirections": {
+//	  "/billboard": {"to": "/sale", "group": "spring", "tracking": {"utm_campaign": "{{.Campaign}}", "ad": "{{.Path}}"}}
+//	}
+//
+// Values are templates executed with trackingData. Parameters already in the
+// destination are left alone, as are parameters whose value is empty.
+type trackingParams []trackingParam
+
+type trackingParam struct {
+	key   string
+	value *template.Template
+}
+
+// The data available to tracking parameter templates.
+type trackingData struct {
+	Path        string
+	Source      string
+	Destination string
+	Group       string
+	Campaign    string
+}
+
+// Parse tracking parameter templates, in order of key.
+func compileTracking(params map[string]string) (trackingParams, error) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	compiled := make(trackingParams, 0, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("tracking parameter without a name")
+		}
+		value, err := template.New(key).Parse(params[key])
+		if err != nil {
+			return nil, fmt.Errorf("tracking parameter %s: %v", key, err)
+		}
+		compiled = append(compiled, trackingParam{key, value})
+	}
+	return compiled, nil
+}
+
+// Add the tracking parameters for a redirect from path by rule, matched as
+// source, to destination.
+func (config *Config) addTracking(destination, path, source string, rule *Rule) string {
+	if config.tracking == nil && rule.tracking == nil {
+		return destination
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	data := trackingData{Path: path, Source: source, Destination: destination, Group: rule.Group}
+	if group := config.Groups[rule.Group]; group != nil {
+		data.Campaign = group.Campaign
+	}
+
+	existing, values := u.Query(), url.Values{}
+	buf := new(bytes.Buffer)
+	for _, params := range []trackingParams{rule.tracking, config.tracking} {
+		for _, param := range params {
+			if existing.Has(param.key) || values.Has(param.key) {
+				continue
+			}
+			buf.Reset()
+			if err := param.value.Execute(buf, data); err != nil {
+				log.Println("Tracking template:", err)
+				continue
+			}
+			if buf.Len() > 0 {
+				values.Set(param.key, buf.String())
+			}
+		}
+	}
+	if len(values) == 0 {
+		return destination
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += values.Encode()
+	return u.String()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long deleted redirections are kept in the trash, where they can be
+// restored from. 0 deletes them outright.
+var trashRetention *time.Duration = flag.Duration("trash-retention", 7*24*time.Hour, "how long deleted redirections can be restored from the trash (0 to delete them outright)")
+
+// The trash keeps redirections deleted one at a time, the latest for each
+// source, until they are restored or expire. Like the statistics, it isn't
+// kept across restarts or shared with peers.
+type trash struct {
+	mu    sync.Mutex
+	rules map[string]*trashedRule
+	clock Clock
+}
+
+// A deleted redirection, by whom and when it was deleted, and when it will
+// be purged.
+type trashedRule struct {
+	Source    string    `json:"source"`
+	Rule      *Rule     `json:"rule"`
+	Deleted   time.Time `json:"deleted"`
+	DeletedBy string    `json:"deleted_by"`
+	Expires   time.Time `json:"expires"`
+}
+
+func newTrash(clock Clock) *trash {
+	return &trash{rules: make(map[string]*trashedRule), clock: clock}
+}
+
+// Put the rule deleted from source in the trash.
+func (t *trash) add(source string, rule *Rule, client string) {
+	if *trashRetention <= 0 {
+		return
+	}
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.purge(now)
+	t.rules[source] = &trashedRule{source, rule, now, client, now.Add(*trashRetention)}
+}
+
+// Purge the rules that have expired. The caller must hold the lock.
+func (t *trash) purge(now time.Time) {
+	for source, trashed := range t.rules {
+		if !now.Before(trashed.Expires) {
+			delete(t.rules, source)
+		}
+	}
+}
+
+// The rules in the trash that match, most recently deleted first.
+func (t *trash) list(match func(source string) bool) []*trashedRule {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.purge(t.clock.Now())
+	rules := []*trashedRule{}
+	for source, trashed := range t.rules {
+		if match(source) {
+			rules = append(rules, trashed)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].Deleted.Equal(rules[j].Deleted) {
+			return rules[i].Deleted.After(rules[j].Deleted)
+		}
+		return rules[i].Source < rules[j].Source
+	})
+	return rules
+}
+
+// The rule in the trash for source, if it hasn't expired.
+func (t *trash) get(source string) (*trashedRule, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trashed, ok := t.rules[source]
+	if ok && !t.clock.Now().Before(trashed.Expires) {
+		delete(t.rules, source)
+		return nil, false
+	}
+	return trashed, ok
+}
+
+// Take the rule for source out of the trash, if it is still the one given,
+// or any rule if trashed is nil, and say whether there was one.
+func (t *trash) remove(source string, trashed *trashedRule) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	current, ok := t.rules[source]
+	if ok && (trashed == nil || current == trashed) {
+		delete(t.rules, source)
+	}
+	return ok
+}
+
+// Empty the trash.
+func (t *trash) empty() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.rules)
+}
+
+// The TrashHandler lists the deleted redirections in the trash
+// (GET /_config/trash), restores one (POST /_config/trash/promo/spring for
+// /promo/spring), purges one (DELETE /_config/trash/promo/spring), and
+// empties the trash (DELETE /_config/trash). A redirection isn't restored
+// over one that has since been set from the same source unless forced with
+// ?force=true.
+func (redir *Redirector) TrashHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		source := strings.TrimPrefix(req.URL.Path, "/_config/trash")
+		switch {
+		case source == "" && req.Method == "GET":
+			redir.onlyScoped(w, req, func(token *apiToken) {
+				w.Header().Set("Content-Type", "application/json")
+				enc := json.NewEncoder(w)
+				enc.SetEscapeHTML(false)
+				enc.Encode(redir.trash.list(token.covers))
+			})
+		case source == "" && req.Method == "DELETE":
+			redir.onlyAdmin(w, req, func() {
+				redir.trash.empty()
+				log.Println(realAddr(req), "emptied the trash")
+				w.WriteHeader(http.StatusNoContent)
+			})
+		case source != "" && req.Method == "POST":
+			redir.onlyRule(w, req, source, func() {
+				redir.idempotency.serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+					redir.restoreRule(w, req, pathKey(source))
+				})
+			})
+		case source != "" && req.Method == "DELETE":
+			redir.onlyRule(w, req, source, func() {
+				if !redir.trash.remove(pathKey(source), nil) {
+					writeError(w, http.StatusNotFound, "No redirection for "+pathKey(source)+" in the trash")
+					return
+				}
+				log.Println(realAddr(req), "purged", pathKey(source), "from the trash")
+				w.WriteHeader(http.StatusNoContent)
+			})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// Restore the redirection from source from the trash, answering with it as
+// /_config/rules lists it.
+func (redir *Redirector) restoreRule(w http.ResponseWriter, req *http.Request, source string) {
+	trashed, ok := redir.trash.get(source)
+	if !ok {
+		writeError(w, http.StatusNotFound, "No redirection for "+source+" in the trash")
+		return
+	}
+	if _, exists := redir.live.Load().Redirections.Get(source); exists && !forced(req) {
+		writeError(w, http.StatusConflict, "A redirection has since been set for "+source+"; add ?force=true to replace it")
+		return
+	}
+	token, _ := redir.tokenFor(req)
+	if err := token.allowsRule(trashed.Rule); err != nil {
+		writeError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+		return
+	}
+	// The trashed rule may still be in older versions of the configuration,
+	// so it is restored as a copy.
+	rule := *trashed.Rule
+	old, err := redir.SetRule(source, &rule, token.client(req))
+	if err != nil {
+		(&APIError{http.StatusBadRequest, CodeInvalidRule, "Bad rule: " + err.Error(), configErrorDetails(err)}).write(w)
+		return
+	}
+	redir.trash.remove(source, trashed)
+	log.Println(realAddr(req), "restored redirection from", source, "to", rule.To)
+	redir.notify(redir.ruleEvent(req, source, old, &rule))
+
+	status := http.StatusOK
+	if old == nil {
+		status = http.StatusCreated
+	}
+	redir.writeRule(w, source, status)
+}
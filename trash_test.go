@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	t.Parallel()
+	manual := NewManualClock(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	tr := newTestRedirectorWithClock(t, manual, `{"redirections": {"/promo/spring": {"to": "/spring", "code": 301}, "/old": "/new"}}`)
+
+	tr.expectStatus(tr.do("DELETE", "/promo/spring", ""), http.StatusNoContent)
+	tr.expectNotFound("/promo/spring")
+	w := tr.do("GET", "/_config/trash", "")
+	var trashed []trashedRule
+	if err := json.Unmarshal(w.Body.Bytes(), &trashed); err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 1 || trashed[0].Source != "/promo/spring" || trashed[0].DeletedBy != "127.0.0.1" {
+		t.Fatalf("trash has %+v", trashed)
+	}
+
+	tr.expectStatus(tr.do("POST", "/_config/trash/promo/spring", ""), http.StatusCreated)
+	tr.expectRedirect("/promo/spring", http.StatusMovedPermanently, "/spring")
+	tr.expectStatus(tr.do("POST", "/_config/trash/promo/spring", ""), http.StatusNotFound)
+
+	// A redirection set since isn't replaced unless forced.
+	tr.expectStatus(tr.do("DELETE", "/old", ""), http.StatusNoContent)
+	tr.expectStatus(tr.do("PUT", "/old", "/newer"), http.StatusCreated)
+	tr.expectStatus(tr.do("POST", "/_config/trash/old", ""), http.StatusConflict)
+	tr.expectStatus(tr.do("POST", "/_config/trash/old?force=true", ""), http.StatusOK)
+	tr.expectRedirect("/old", http.StatusFound, "/new")
+
+	// Deleted redirections expire.
+	tr.expectStatus(tr.do("DELETE", "/old", ""), http.StatusNoContent)
+	manual.Advance(*trashRetention)
+	tr.expectStatus(tr.do("POST", "/_config/trash/old", ""), http.StatusNotFound)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The version of this build, set with -ldflags "-X main.version=...".
+var version = "dev"
+
+// Where releases are published, and the key they are signed with.
+var updateURL *string = flag.String("update-url", "", "URL of the release manifest for selfupdate")
+var updateKey *string = flag.String("update-key", "", "base64 Ed25519 public key that release binaries are signed with")
+
+// Whether to serve /_update, which checks for a newer release.
+var updateCheck *bool = flag.Bool("update-check", false, "serve /_update to check for a newer release")
+
+// The running server writes its process ID here, so selfupdate can tell it to
+// restart into the new binary.
+var pidFile *string = flag.String("pidfile", "", "file to write the server's process ID to")
+
+// The largest binary selfupdate will download.
+const maxReleaseSize = 256 << 20
+
+// A release manifest lists the binary for each platform, keyed by GOOS/GOARCH
+// (e.g., "linux/arm64"), with a base64 Ed25519 signature of the release (see
+// releasePayload):
+//
+//	{
+//	  "version": "1.4.0",
+//	  "binaries": {
+//	    "linux/amd64": {"url": "https://.../fourohfourfound-linux-amd64", "signature": "..."}
+//	  }
+//	}
+type releaseManifest struct {
+	Version  string                   `json:"version"`
+	Binaries map[string]releaseBinary `json:"binaries"`
+}
+
+type releaseBinary struct {
+	URL       string `json:"url"`
+	Signature string `json:"signature"`
+}
+
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+// What a release binary's signature covers: a line naming the version and
+// platform, and then the binary, so that a tampered manifest can't offer an
+// older release or another platform's binary under a valid signature.
+func releasePayload(version, platform string, binary []byte) []byte {
+	return append([]byte("fourohfourfound "+version+" "+platform+"\n"), binary...)
+}
+
+// Check a release binary's signature for version on platform.
+func verifyRelease(key ed25519.PublicKey, version, platform string, binary, signature []byte) error {
+	if !ed25519.Verify(key, releasePayload(version, platform, binary), signature) {
+		return fmt.Errorf("release binary has a bad signature for %s on %s", version, platform)
+	}
+	return nil
+}
+
+// Whether release version a is newer than b. Versions are numbers separated
+// by dots, optionally after a "v"; a build without one, such as "dev", is
+// older than any release, and a release without one is never newer.
+func newerVersion(a, b string) bool {
+	parse := func(v string) ([]int, bool) {
+		var numbers []int
+		for _, part := range strings.Split(strings.TrimPrefix(v, "v"), ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			numbers = append(numbers, n)
+		}
+		return numbers, true
+	}
+	release, ok := parse(a)
+	if !ok {
+		return false
+	}
+	current, ok := parse(b)
+	if !ok {
+		return true
+	}
+	for i := 0; i < max(len(release), len(current)); i++ {
+		var x, y int
+		if i < len(release) {
+			x = release[i]
+		}
+		if i < len(current) {
+			y = current[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+func fetchManifest() (*releaseManifest, error) {
+	if *updateURL == "" {
+		return nil, errors.New("no -update-url")
+	}
+	resp, err := updateClient.Get(*updateURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching manifest: %s", resp.Status)
+	}
+	manifest := new(releaseManifest)
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %v", err)
+	}
+	return manifest, nil
+}
+
+// Download the binary for this platform from the release manifest, verify its
+// signature, and replace the running executable with it. If the server wrote
+// a -pidfile, it is told to restart into the new binary without dropping
+// connections.
+func selfUpdate() error {
+	key, err := base64.StdEncoding.DecodeString(*updateKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("-update-key must be a base64 Ed25519 public key")
+	}
+	manifest, err := fetchManifest()
+	if err != nil {
+		return err
+	}
+	if manifest.Version == version {
+		log.Println("already running", version)
+		return nil
+	}
+	if !newerVersion(manifest.Version, version) {
+		return fmt.Errorf("release %s is not newer than %s", manifest.Version, version)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	release, ok := manifest.Binaries[platform]
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s", manifest.Version, platform)
+	}
+	signature, err := base64.StdEncoding.DecodeString(release.Signature)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %v", err)
+	}
+
+	resp, err := updateClient.Get(release.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %s", release.URL, resp.Status)
+	}
+	binary, err := io.ReadAll(io.LimitReader(resp.Body, maxReleaseSize+1))
+	if err != nil {
+		return err
+	}
+	if len(binary) > maxReleaseSize {
+		return errors.New("release binary is too large")
+	}
+	if err = verifyRelease(ed25519.PublicKey(key), manifest.Version, platform, binary, signature); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	// Write next to the executable so the rename is atomic.
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(binary); err == nil {
+		err = tmp.Chmod(0755)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), exe); err != nil {
+		return err
+	}
+	log.Println("updated", exe, "from", version, "to", manifest.Version)
+
+	if *pidFile == "" {
+		log.Println("restart the server to use the new version")
+		return nil
+	}
+	contents, err := os.ReadFile(*pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return fmt.Errorf("%s: %v", *pidFile, err)
+	}
+	return signalRestart(pid)
+}
+
+// Write this process's ID to the -pidfile, if there is one.
+func writePidFile() error {
+	if *pidFile == "" {
+		return nil
+	}
+	return os.WriteFile(*pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// The UpdateHandler reports whether a newer release is available.
+func (redir *Redirector) UpdateHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redir.onlyAdmin(w, req, func() {
+			if req.Method != "GET" {
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			manifest, err := fetchManifest()
+			if err != nil {
+				log.Println("update check:", err)
+				writeError(w, http.StatusBadGateway, "Error checking for updates")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"current":          version,
+				"latest":           manifest.Version,
+				"update_available": newerVersion(manifest.Version, version),
+			})
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestNewerVersion(t *testing.T) {
+	for _, tt := range []struct {
+		a, b  string
+		newer bool
+	}{
+		{"1.4.0", "1.3.9", true},
+		{"1.10.0", "1.9.0", true},
+		{"v2", "1.9.9", true},
+		{"1.4.1", "1.4", true},
+		{"1.4.0", "1.4", false},
+		{"1.3.0", "1.4.0", false},
+		{"1.4.0", "1.4.0", false},
+		{"1.4.0", "dev", true},
+		{"latest", "1.4.0", false},
+		{"1.4.0-rc1", "1.3.0", false},
+	} {
+		if got := newerVersion(tt.a, tt.b); got != tt.newer {
+			t.Errorf("newerVersion(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+}
+
+// A signature only holds for the version and platform it was made for.
+func TestVerifyRelease(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte("\x7fELF...")
+	signature := ed25519.Sign(private, releasePayload("1.4.0", "linux/amd64", binary))
+	if err := verifyRelease(public, "1.4.0", "linux/amd64", binary, signature); err != nil {
+		t.Error(err)
+	}
+	for _, tt := range []struct{ version, platform string }{
+		{"1.5.0", "linux/amd64"},
+		{"1.4.0", "linux/arm64"},
+	} {
+		if verifyRelease(public, tt.version, tt.platform, binary, signature) == nil {
+			t.Errorf("signature for 1.4.0 on linux/amd64 verified for %s on %s", tt.version, tt.platform)
+		}
+	}
+	if verifyRelease(public, "1.4.0", "linux/amd64", binary, ed25519.Sign(private, binary)) == nil {
+		t.Error("signature of the binary alone verified")
+	}
+}
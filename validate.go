@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Check the configuration and exit, instead of serving it.
+var validateOnly *bool = flag.Bool("validate", false, "check the configuration and exit, with a nonzero status if it has problems")
+
+// A problem found in a configuration file, at a line if it is known.
+type configProblem struct {
+	file string
+	line int
+	err  error
+}
+
+func (p configProblem) String() string {
+	if p.line > 0 {
+		return fmt.Sprintf("%s:%d: %v", p.file, p.line, p.err)
+	}
+	return fmt.Sprintf("%s: %v", p.file, p.err)
+}
+
+// Check the configuration file or directory at path, returning its problems
+// in order. Paths handled by mux rather than by the redirections are
+// reported as unreachable.
+func validateConfig(path string, mux *http.ServeMux) []configProblem {
+	info, err := os.Stat(path)
+	if err != nil {
+		return []configProblem{{path, 0, err}}
+	}
+	if !info.IsDir() {
+		return validateFile(path, fileFormat(path), mux)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return []configProblem{{path, 0, err}}
+	}
+	var problems []configProblem
+	for _, entry := range entries {
+		format, ok := extensionFormat(entry.Name())
+		if ok && !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			problems = append(problems, validateFile(filepath.Join(path, entry.Name()), format, mux)...)
+		}
+	}
+	if problems != nil {
+		return problems
+	}
+	// The files may be fine on their own but not together.
+	data, err := readConfigDir(path)
+	if err == nil {
+		err = NewRedirector().LoadConfig(data)
+	}
+	if err != nil {
+		problems = append(problems, configProblem{path, 0, err})
+	}
+	return problems
+}
+
+// Check one configuration file: its syntax, duplicate and unknown keys, each
+// of its rules, and whether any of them can never be reached.
+func validateFile(path, format string, mux *http.ServeMux) (problems []configProblem) {
+	report := func(line int, err error) {
+		problems = append(problems, configProblem{path, line, err})
+	}
+	data, err := readSigned(path)
+	if err != nil {
+		report(0, err)
+		return
+	}
+
+	// Find where each key is. JSON is checked for duplicate keys here; the
+	// YAML and TOML parsers reject them themselves.
+	text := string(data)
+	var lines map[string]int
+	if format == FormatJSON {
+		if lines, err = jsonKeyLines(data); err != nil {
+			report(jsonErrorLine(data, err), err)
+			return
+		}
+	} else if data, err = configToJSON(data, format); err != nil {
+		if m := parseErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			report(line, errors.New(m[2]))
+		} else {
+			report(0, err)
+		}
+		return
+	}
+	lineOf := func(keys ...string) int {
+		if lines != nil {
+			return lines[strings.Join(keys, "\x00")]
+		}
+		return keyLine(text, keys[len(keys)-1])
+	}
+
+	// An unknown key is likely a misspelling, but the rest of the
+	// configuration can still be checked.
+	config := new(Config)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(config); err != nil {
+		field, ok := unknownField(err)
+		if ok {
+			report(lineOf(field), fmt.Errorf("unknown key %q", field))
+			config = new(Config)
+			err = json.Unmarshal(data, config)
+		}
+		if err != nil {
+			line := 0
+			if format == FormatJSON {
+				line = jsonErrorLine(data, err)
+			}
+			report(line, err)
+			return
+		}
+	}
+
+	checkRules := func(rules Rules, keys ...string) {
+		rules.Each(func(source string, rule *Rule) {
+			if err := config.compileRule(source, rule); err != nil {
+				report(lineOf(append(keys, source)...), err)
+			}
+		})
+		wildcards, err := compileWildcards(rules)
+		if err != nil {
+			report(0, err)
+			return
+		}
+		unreachable(rules, wildcards, mux, func(source string, err error) {
+			report(lineOf(append(keys, source)...), err)
+		})
+	}
+	checkRules(config.Redirections, "redirections")
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checkRules(config.Profiles[name], "profiles", name)
+	}
+	hosts := make([]string, 0, len(config.Hosts))
+	for host := range config.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		checkRules(config.Hosts[host], "hosts", host)
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].line < problems[j].line })
+
+	if len(problems) == 0 {
+		if err = config.compile(); err != nil {
+			report(0, err)
+		}
+	}
+	return
+}
+
+// Report the rules that no request can reach: those at paths the server
+// handles itself, and wildcards that an earlier wildcard always matches
+// first.
+func unreachable(rules Rules, wildcards *wildcardSet, mux *http.ServeMux, report func(source string, err error)) {
+	var sources []string
+	rules.Each(func(source string, rule *Rule) { sources = append(sources, source) })
+	sort.Strings(sources)
+	for _, source := range sources {
+		req := &http.Request{Method: "GET", URL: &url.URL{Path: source}}
+		if _, pattern := mux.Handler(req); pattern != "/" {
+			report(source, fmt.Errorf("redirection %s is unreachable: the server handles %s itself", source, pattern))
+		}
+	}
+	for i, w := range wildcards.all() {
+		for _, earlier := range wildcards.all()[:i] {
+			if earlier.covers(w) {
+				report(w.source, fmt.Errorf("redirection %s is unreachable: %s matches the same paths first", w.source, earlier.source))
+				break
+			}
+		}
+	}
+}
+
+// Whether the wildcard matches every path that other does.
+func (w *wildcard) covers(other *wildcard) bool {
+	if len(w.segments) != len(other.segments) {
+		return false
+	}
+	for i, segment := range w.segments {
+		if segment != "*" && !isPlaceholder(segment) && segment != other.segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Walk a JSON document, returning the line of each object key, by the keys
+// leading to it joined with NUL, and failing on the first duplicate key.
+func jsonKeyLines(data []byte) (map[string]int, error) {
+	lines := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	lineAt := func(offset int64) int {
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'):
+			seen := make(map[string]bool)
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				line := lineAt(dec.InputOffset())
+				name := key.(string)
+				if seen[name] {
+					return &jsonKeyError{line, fmt.Sprintf("duplicate key %q", name)}
+				}
+				seen[name] = true
+				keys := append(path[:len(path):len(path)], name)
+				lines[strings.Join(keys, "\x00")] = line
+				if err = walk(keys); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		case json.Delim('['):
+			for dec.More() {
+				if err = walk(path); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		}
+		return nil
+	}
+	if err := walk(nil); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// An error at a line of a JSON document.
+type jsonKeyError struct {
+	line int
+	msg  string
+}
+
+func (err *jsonKeyError) Error() string { return err.msg }
+
+// The line of a JSON document that a decoding error is at, or 0.
+func jsonErrorLine(data []byte, err error) int {
+	var offset int64
+	var keyErr *jsonKeyError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &keyErr):
+		return keyErr.line
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// The line in an error from the YAML or TOML parser.
+var parseErrorLine = regexp.MustCompile(`^(?:yaml|toml): line (\d+): (.*)$`)
+
+var unknownFieldError = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+
+// The field that DisallowUnknownFields rejected, if that is the error.
+func unknownField(err error) (string, bool) {
+	m := unknownFieldError.FindStringSubmatch(err.Error())
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// The first line of a YAML or TOML document that defines key, or 0. Keys
+// may be bare or quoted, and in TOML, a table header.
+func keyLine(text, key string) int {
+	candidates := []string{key + ":", key + " =", key + "=", strconv.Quote(key), "'" + key + "'", "[" + key + "]"}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimLeft(line, " \t-")
+		for _, candidate := range candidates {
+			if strings.HasPrefix(line, candidate) {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many versions of the configuration are kept for rollback.
+var keepVersions *int = flag.Int("config-versions", 20, "how many versions of the configuration to keep for rollback")
+
+// A configVersion is a snapshot of the configuration taken after a change.
+type configVersion struct {
+	Version     int           `json:"version"`
+	Time        time.Time     `json:"time"`
+	Description string        `json:"description"`
+	Changes     ConfigChanges `json:"changes"`
+	config      *Config
+}
+
+// The most recent versions of the configuration, oldest first.
+type configVersions struct {
+	mu    sync.Mutex
+	next  int
+	list  []*configVersion
+	clock Clock
+}
+
+func newConfigVersions(clock Clock) *configVersions {
+	return &configVersions{next: 1, clock: clock}
+}
+
+// Keep a snapshot of the configuration, which must not be changed
+// afterwards. The caller must hold the Redirector's update lock, so that
+// versions are recorded in order.
+func (versions *configVersions) record(snapshot *Config, description string) {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+
+	var before Rules
+	if n := len(versions.list); n > 0 {
+		before = versions.list[n-1].config.Redirections
+	}
+	versions.list = append(versions.list, &configVersion{
+		Version:     versions.next,
+		Time:        versions.clock.Now(),
+		Description: description,
+		Changes:     diffRedirections(before, snapshot.Redirections),
+		config:      snapshot,
+	})
+	versions.next++
+	if keep := *keepVersions; keep > 0 && len(versions.list) > keep {
+		versions.list = append([]*configVersion(nil), versions.list[len(versions.list)-keep:]...)
+	}
+}
+
+// Note a change to the live configuration, and publish a snapshot of it for
+// lookups. The caller must hold both of the Redirector's locks.
+func (redir *Redirector) changed(description string) {
+	redir.modified = redir.Clock().Now()
+	redir.noteModified(redir.live.Load())
+	snapshot := redir.Config.clone()
+	redir.versions.record(snapshot, description)
+	redir.live.Store(snapshot)
+}
+
+// The number of the latest version, or 0 if there is none.
+func (versions *configVersions) latest() int {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+	return versions.next - 1
+}
+
+// Find a version, and the one before it if that is still kept.
+func (versions *configVersions) find(version int) (v, previous *configVersion) {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+
+	for i, candidate := range versions.list {
+		if candidate.Version == version {
+			if i > 0 {
+				previous = versions.list[i-1]
+			}
+			return candidate, previous
+		}
+	}
+	return nil, nil
+}
+
+// The list of versions, most recent first.
+func (versions *configVersions) summary() []*configVersion {
+	versions.mu.Lock()
+	defer versions.mu.Unlock()
+
+	list := make([]*configVersion, len(versions.list))
+	for i, v := range versions.list {
+		list[len(list)-1-i] = v
+	}
+	return list
+}
+
+var errNoVersion = errors.New("no such version")
+
+// Rollback restores the configuration saved as version, recording the result
+// as a new version. Unless forced, it is refused if it would update or remove
+// more of the redirections than -max-change allows.
+func (redir *Redirector) Rollback(version int, force bool) (changes ConfigChanges, err error) {
+	redir.update.Lock()
+	defer redir.update.Unlock()
+
+	v, _ := redir.versions.find(version)
+	if v == nil {
+		return changes, errNoVersion
+	}
+	candidate := v.config.clone()
+	if err = candidate.compile(); err != nil {
+		return
+	}
+
+	redir.mu.Lock()
+	defer redir.mu.Unlock()
+
+	changes = diffRedirections(redir.Redirections, candidate.Redirections)
+	changes.Mode = "rollback"
+	if err = checkChangeRate(changes, redir.Redirections.Len(), force); err != nil {
+		return
+	}
+	redir.Config = *candidate
+	redir.changed(fmt.Sprintf("rollback to version %d", version))
+	redir.replicateChanges(changes)
+	log.Printf("rolled back to version %d, %d redirections\n", version, redir.Redirections.Len())
+	return
+}
+
+// A change to one redirection, as shown in a version's diff.
+type ruleDiff struct {
+	Source string `json:"source"`
+	Old    *Rule  `json:"old,omitempty"`
+	New    *Rule  `json:"new,omitempty"`
+}
+
+// The VersionsHandler lists the kept versions of the configuration
+// (GET /_config/versions), shows how one changed the redirections
+// (GET /_config/versions/n), and rolls back to one
+// (POST /_config/rollback/n).
+func (redir *Redirector) VersionsHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		log.Println(realAddr(req), req.Method, req.URL.Path)
+		if !allowRequest(w, req, nil, redir.adminLimit) {
+			return
+		}
+		redir.onlyAdmin(w, req, func() {
+			path := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/_config/"), "/")
+			action, number, _ := strings.Cut(path, "/")
+			version, err := strconv.Atoi(number)
+			switch {
+			case action == "versions" && number == "" && req.Method == "GET":
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(redir.versions.summary())
+			case action == "versions" && err == nil && req.Method == "GET":
+				redir.versionDiff(w, version)
+			case action == "rollback" && err == nil && req.Method == "POST":
+				redir.idempotency.serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+					redir.rollback(w, req, version)
+				})
+			case action == "versions" && (number == "" || err == nil), action == "rollback" && err == nil:
+				writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			default:
+				http.NotFound(w, req)
+			}
+		})
+	}
+}
+
+func (redir *Redirector) versionDiff(w http.ResponseWriter, version int) {
+	v, previous := redir.versions.find(version)
+	if v == nil {
+		writeError(w, http.StatusNotFound, "No such version")
+		return
+	}
+	var before Rules
+	if previous != nil {
+		before = previous.config.Redirections
+	}
+	diff := []ruleDiff{}
+	for _, change := range diffRedirections(before, v.config.Redirections).changed {
+		diff = append(diff, ruleDiff{Source: change.source, Old: change.old, New: change.new})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*configVersion
+		Diff []ruleDiff `json:"diff"`
+	}{v, diff})
+}
+
+func (redir *Redirector) rollback(w http.ResponseWriter, req *http.Request, version int) {
+	changes, err := redir.Rollback(version, forced(req))
+	if err == errNoVersion {
+		writeError(w, http.StatusNotFound, "No such version")
+		return
+	}
+	if _, ok := err.(*tooManyChanges); ok {
+		refuseChange(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error restoring version: "+err.Error())
+		return
+	}
+	log.Println(realAddr(req), "rolled back to version", version)
+	redir.notify(&Event{Type: EventConfigRolledBack, Time: redir.Clock().Now(), Client: realAddr(req), Changes: &changes})
+	for _, change := range changes.changed {
+		redir.audit.Record(redir.ruleEvent(req, change.source, change.old, change.new))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&changes)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	tr := newTestRedirector(t, `{"redirections": {"/a": "/b"}}`)
+	tr.expectStatus(tr.do("PUT", "/c", "/d"), http.StatusCreated)
+	tr.expectStatus(tr.do("PUT", "/a", "/e"), http.StatusOK)
+
+	w := tr.do("GET", "/_config/versions", "")
+	tr.expectStatus(w, http.StatusOK)
+	var versions []configVersion
+	tr.decode(w, &versions)
+	if len(versions) < 3 {
+		t.Fatalf("got %d versions, want at least 3", len(versions))
+	}
+	// Most recent first.
+	latest := versions[0].Version
+	if versions[1].Version != latest-1 || versions[0].Changes.Updated != 1 || versions[1].Changes.Added != 1 {
+		t.Errorf("got %+v", versions[:2])
+	}
+
+	w = tr.do("GET", fmt.Sprintf("/_config/versions/%d", latest), "")
+	tr.expectStatus(w, http.StatusOK)
+	var diff struct{ Diff []ruleDiff }
+	tr.decode(w, &diff)
+	if len(diff.Diff) != 1 || diff.Diff[0].Source != "/a" || diff.Diff[0].Old.To != "/b" || diff.Diff[0].New.To != "/e" {
+		t.Errorf("got diff %+v", diff.Diff)
+	}
+
+	// Rolling back is a new version.
+	tr.expectStatus(tr.do("POST", fmt.Sprintf("/_config/rollback/%d", latest-2), ""), http.StatusOK)
+	tr.expectRedirect("/a", http.StatusFound, "/b")
+	tr.expectNotFound("/c")
+	if got := tr.versions.latest(); got != latest+1 {
+		t.Errorf("latest version %d after a rollback, want %d", got, latest+1)
+	}
+
+	tr.expectStatus(tr.do("GET", "/_config/versions/999", ""), http.StatusNotFound)
+	tr.expectStatus(tr.do("POST", "/_config/rollback/999", ""), http.StatusNotFound)
+	tr.expectStatus(tr.do("POST", "/_config/versions/1", ""), http.StatusMethodNotAllowed)
+	tr.expectStatus(tr.do("GET", "/_config/other", ""), http.StatusNotFound)
+	tr.expectStatus(tr.doFrom("192.0.2.1", "POST", "/_config/rollback/1", ""), http.StatusUnauthorized)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Warming up after a deploy keeps the first requests from paying for work
+// that is done lazily.
+var warmup *bool = flag.Bool("warmup", false, "warm up before reporting ready")
+var warmupPaths *string = flag.String("warmup-paths", "", "file of paths, one per line, to look up while warming up")
+
+// Whether the Redirector is still warming up, and so not ready.
+var warming atomic.Bool
+
+// Warm up: render each template once, since html/template escapes a template
+// the first time it is executed, and look up the paths, such as those that
+// were most popular before a restart.
+func (redir *Redirector) Warmup(paths []string) {
+	start := time.Now()
+	redir.mu.RLock()
+	defer redir.mu.RUnlock()
+
+	data := redirectData{Path: "/", Destination: "/", Code: redir.code}
+	if redir.body != nil {
+		redir.body.Execute(io.Discard, data)
+	}
+	for _, group := range redir.Groups {
+		if group.body != nil {
+			group.body.Execute(io.Discard, data)
+		}
+	}
+	if redir.notFound != nil {
+		redir.notFound.Execute(io.Discard, &url.URL{Path: "/"})
+	}
+	previewPage.Execute(io.Discard, struct {
+		*Preview
+		Destination string
+	}{&Preview{}, "/"})
+
+	found := 0
+	for _, path := range paths {
+		if _, _, _, ok := redir.lookup("", path); ok {
+			found++
+		}
+	}
+	log.Printf("warmed up in %v, %d of %d paths found\n", time.Since(start).Round(time.Microsecond), found, len(paths))
+}
+
+// Read the paths to warm up with, skipping blank lines and # comments.
+func readWarmupPaths(name string) ([]string, error) {
+	if name == "" {
+		return nil, nil
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Reload the configuration when it changes on disk, for deploys that copy
+// it into place with rsync or a Kubernetes ConfigMap instead of calling the
+// API. The file, or the files in the directory, are checked every
+// -watch-interval; fsnotify would need a dependency, and polling also sees
+// through the symlink swaps ConfigMaps make.
+var watchConfig *bool = flag.Bool("watch", false, "reload the configuration when it changes on disk")
+var watchInterval *time.Duration = flag.Duration("watch-interval", 2*time.Second, "how often -watch checks the configuration")
+
+// Reload the configuration from path, replacing the live one. As with any
+// configuration, it is checked and compiled before it replaces the live
+// one, and a reload that changes more than -max-change allows is refused.
+func (redir *Redirector) ReloadConfigFile(path string) (changes ConfigChanges, err error) {
+	data, err := readConfig(path)
+	if err != nil {
+		return
+	}
+	if changes, err = redir.ApplyConfig(data, true, "", false); err == nil {
+		redir.markLoaded()
+	}
+	return
+}
+
+// Reload the configuration, logging the outcome.
+func (redir *Redirector) reload(path, why string) {
+	changes, err := redir.ReloadConfigFile(path)
+	if err != nil {
+		log.Println("reload of", path, "after", why, "failed, keeping the live configuration:", err)
+		return
+	}
+	log.Printf("reloaded %s after %s: %d added, %d updated, %d removed\n", path, why, changes.Added, changes.Updated, changes.Removed)
+	redir.notify(&Event{Type: EventConfigApplied, Time: redir.Clock().Now(), Changes: &changes})
+}
+
+// Watch the configuration at path, reloading it once it has changed and
+// then stayed the same for an interval, so a copy in progress isn't loaded
+// half-written.
+func (redir *Redirector) watch(path string, interval time.Duration) {
+	last := configDigest(path)
+	pending := ""
+	for range time.Tick(interval) {
+		digest := configDigest(path)
+		switch {
+		case digest == last:
+			pending = ""
+		case digest != pending:
+			pending = digest
+		default:
+			last, pending = digest, ""
+			redir.reload(path, "a change")
+		}
+	}
+}
+
+// A digest of the configuration file at path, or of the configuration files
+// in the directory, and their signatures, which changes when they do, so a
+// signature copied in after its configuration still brings a reload. Files
+// that can't be read are left out, and show up as an error on reload.
+func configDigest(path string) string {
+	hash := sha256.New()
+	add := func(name string) {
+		f, err := os.Open(name)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		io.WriteString(hash, name+"\x00")
+		io.Copy(hash, f)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if !info.IsDir() {
+		add(path)
+		add(path + signatureSuffix)
+	} else if entries, err := os.ReadDir(path); err == nil {
+		for _, entry := range entries {
+			if _, ok := extensionFormat(entry.Name()); ok && !strings.HasPrefix(entry.Name(), ".") {
+				add(filepath.Join(path, entry.Name()))
+				add(filepath.Join(path, entry.Name()) + signatureSuffix)
+			}
+		}
+	}
+	return string(hash.Sum(nil))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The digest changes with the configuration and with its signature.
+func TestConfigDigest(t *testing.T) {
+	for _, dir := range []bool{false, true} {
+		root := t.TempDir()
+		config := filepath.Join(root, "config.json")
+		path := config
+		if dir {
+			path = root
+		}
+		write := func(name, data string) string {
+			t.Helper()
+			if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return configDigest(path)
+		}
+
+		before := write(config, `{"redirections": {"/a": "/b"}}`)
+		withSig := write(config+signatureSuffix, "first")
+		if withSig == before {
+			t.Errorf("directory %v: digest unchanged when the signature arrived", dir)
+		}
+		if write(config+signatureSuffix, "second") == withSig {
+			t.Errorf("directory %v: digest unchanged when the signature changed", dir)
+		}
+		if after := write(config, `{"redirections": {"/a": "/c"}}`); after == withSig || after == before {
+			t.Errorf("directory %v: digest unchanged when the configuration changed", dir)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Webhook is sent the events it lists as JSON POSTs, or every event if it
+// lists none. With a secret, each body is signed with HMAC-SHA256 in the
+// X-Foff-Signature header as "sha256=<hex>".
+type Webhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// Event types sent to webhooks.
+const (
+	EventRuleCreated        = "rule.created"
+	EventRuleUpdated        = "rule.updated"
+	EventRuleDeleted        = "rule.deleted"
+	EventRuleTransferred    = "rule.transferred"
+	EventConfigApplied      = "config.applied"
+	EventConfigCleared      = "config.cleared"
+	EventConfigRolledBack   = "config.rolled_back"
+	EventProfileActivated   = "profile.activated"
+	EventThreshold          = "threshold"
+	EventShutdown           = "server.shutdown"
+	EventMaintenanceStarted = "maintenance.started"
+	EventMaintenanceEnded   = "maintenance.ended"
+)
+
+// An Event is a change made through the API, such as starting maintenance, a
+// rule's traffic crossing its alert threshold, or the server shutting down.
+type Event struct {
+	Type    string          `json:"event"`
+	Time    time.Time       `json:"time"`
+	Client  string          `json:"client,omitempty"`
+	Token   string          `json:"token,omitempty"`
+	Source  string          `json:"source,omitempty"`
+	Rule    *Rule           `json:"rule,omitempty"`
+	Old     *Rule           `json:"old,omitempty"`
+	Changes *ConfigChanges  `json:"changes,omitempty"`
+	Hits    int64           `json:"hits,omitempty"`
+	Window  string          `json:"window,omitempty"`
+	Profile *string         `json:"profile,omitempty"`
+	Report  *ShutdownReport `json:"report,omitempty"`
+
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// Create the event for a rule changed by the request, noting the token it
+// was made with, if any. A nil old rule means it was created, and a nil new
+// one that it was deleted.
+func (redir *Redirector) ruleEvent(req *http.Request, source string, old, rule *Rule) *Event {
+	event := &Event{Type: EventRuleUpdated, Time: redir.Clock().Now(), Client: realAddr(req), Source: source, Rule: rule, Old: old}
+	if token, _ := redir.tokenFor(req); token != nil {
+		event.Token = token.name
+	}
+	switch {
+	case old == nil:
+		event.Type = EventRuleCreated
+	case rule == nil:
+		event.Type = EventRuleDeleted
+	}
+	return event
+}
+
+func (hook *Webhook) wants(event string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// How many deliveries may wait to be sent, and how many times each is tried.
+const (
+	webhookQueue    = 1000
+	webhookAttempts = 3
+)
+
+type delivery struct {
+	hook  *Webhook
+	event string
+	body  []byte
+}
+
+// A webhookSink delivers events in the background, so requests never wait on
+// webhooks. Deliveries that don't fit in the queue are dropped and counted.
+// Once the sink's context is done, deliveries in progress are abandoned.
+// Pending counts the deliveries queued or in progress.
+type webhookSink struct {
+	queue   chan delivery
+	client  *http.Client
+	ctx     context.Context
+	pending atomic.Int64
+	mu      sync.Mutex
+	sent    int64
+	dropped int64
+	failed  int64
+}
+
+func newWebhookSink(ctx context.Context) *webhookSink {
+	sink := &webhookSink{queue: make(chan delivery, webhookQueue), client: &http.Client{Timeout: 10 * time.Second}, ctx: ctx}
+	go sink.run()
+	return sink
+}
+
+// Send the event to each of the hooks that wants it.
+func (sink *webhookSink) Send(hooks []*Webhook, event *Event) {
+	var body []byte
+	for _, hook := range hooks {
+		if !hook.wants(event.Type) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(event); err != nil {
+				log.Println("webhook:", err)
+				return
+			}
+		}
+		sink.pending.Add(1)
+		select {
+		case sink.queue <- delivery{hook, event.Type, body}:
+		default:
+			sink.pending.Add(-1)
+			sink.mu.Lock()
+			sink.dropped++
+			sink.mu.Unlock()
+		}
+	}
+}
+
+// Deliveries are made concurrently up to -max-background; beyond that they
+// are made one at a time, and the queue backs up.
+func (sink *webhookSink) run() {
+	for d := range sink.queue {
+		background.goOrRun(func() { sink.attempt(d) })
+	}
+}
+
+func (sink *webhookSink) attempt(d delivery) {
+	defer sink.pending.Add(-1)
+	var err error
+	for attempt := 0; attempt < webhookAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(time.Duration(1<<attempt) * time.Second)
+			select {
+			case <-timer.C:
+			case <-sink.ctx.Done():
+				timer.Stop()
+			}
+		}
+		if err = sink.ctx.Err(); err != nil {
+			break
+		}
+		if err = sink.deliver(d); err == nil {
+			break
+		}
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if err != nil {
+		log.Println("webhook", d.hook.URL, "failed:", err)
+		sink.failed++
+	} else {
+		sink.sent++
+	}
+}
+
+// Wait up to timeout for the queued deliveries to be made, returning how
+// many are still pending.
+func (sink *webhookSink) drain(timeout time.Duration) int64 {
+	deadline := time.Now().Add(timeout)
+	for sink.pending.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return sink.pending.Load()
+}
+
+func (sink *webhookSink) deliver(d delivery) error {
+	if err := injectFault(sink.ctx, "webhook"); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(sink.ctx, "POST", d.hook.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Foff-Event", d.event)
+	if d.hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.hook.Secret))
+		mac.Write(d.body)
+		req.Header.Set("X-Foff-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// Record a change in the audit log and send it to the configured webhooks.
+// The caller must hold one of the Redirector's locks.
+func (redir *Redirector) emit(event *Event) {
+	redir.audit.Record(event)
+	redir.sink.Send(redir.Webhooks, event)
+}
+
+// Emit a change without holding the Redirector's locks.
+func (redir *Redirector) notify(event *Event) {
+	redir.mu.RLock()
+	defer redir.mu.RUnlock()
+
+	redir.emit(event)
+}
+
+// An Alert fires a threshold event the first time a rule gets Hits hits
+// within a Window (e.g., "1h"). Windows are fixed: counting starts over once
+// a window has passed.
+type Alert struct {
+	Hits   int64  `json:"hits"`
+	Window string `json:"window"`
+	window time.Duration
+}
+
+// The current window of each rule with an alert.
+type alertWindows struct {
+	mu       sync.Mutex
+	bySource map[string]*alertWindow
+	clock    Clock
+}
+
+type alertWindow struct {
+	start time.Time
+	hits  int64
+}
+
+func newAlertWindows(clock Clock) *alertWindows {
+	return &alertWindows{bySource: make(map[string]*alertWindow), clock: clock}
+}
+
+// Count a hit for source, reporting whether it crossed the alert's threshold.
+func (alerts *alertWindows) hit(source string, alert *Alert) bool {
+	alerts.mu.Lock()
+	defer alerts.mu.Unlock()
+
+	now := alerts.clock.Now()
+	window := alerts.bySource[source]
+	if window == nil || now.Sub(window.start) >= alert.window {
+		window = &alertWindow{start: now}
+		alerts.bySource[source] = window
+	}
+	window.hits++
+	return window.hits == alert.Hits
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// A source with a segment of * or {name} is a wildcard, matching any value
+// for that segment. Values matched by {name} replace {name} in the
+// destination:
+//
+//	"/product/{id}": "/shop/item/{id}"
+//	"/2019/*/old-post": "/blog/old-post"
+//
+// Exact sources are matched before wildcards, and wildcards with more fixed
+// segments before those with fewer.
+type wildcard struct {
+	source   string
+	segments []string
+	fixed    int
+	rule     *Rule
+}
+
+// Report whether a source is a wildcard.
+func isWildcard(source string) bool {
+	for _, segment := range strings.Split(source, "/") {
+		if segment == "*" || isPlaceholder(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPlaceholder(segment string) bool {
+	return len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// The wildcards of a set of redirections, most specific first, with a tree
+// of their segments, so a lookup only tries the wildcards a path could
+// match rather than each in turn. A nil set has no wildcards.
+type wildcardSet struct {
+	list []*wildcard
+	root *wildcardNode
+}
+
+// A node of the tree for the segment of a path at its depth. Fixed segments
+// lead to children, * and {name} to any, and ends has the wildcards with no
+// more segments, most specific first.
+type wildcardNode struct {
+	children map[string]*wildcardNode
+	any      *wildcardNode
+	ends     []*wildcard
+}
+
+// Collect the wildcard redirections, most specific first.
+func compileWildcards(redirections Rules) (*wildcardSet, error) {
+	var wildcards []*wildcard
+	var err error
+	redirections.Each(func(source string, rule *Rule) {
+		if err != nil || !isWildcard(source) {
+			return
+		}
+		if rule.Mode == ModeProxy && strings.Contains(rule.To, "{") {
+			err = fmt.Errorf("redirection %s: proxied destinations cannot use wildcards", source)
+			return
+		}
+		w := &wildcard{source: source, segments: strings.Split(source, "/"), rule: rule}
+		for _, segment := range w.segments {
+			if segment != "*" && !isPlaceholder(segment) {
+				w.fixed++
+			}
+		}
+		wildcards = append(wildcards, w)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if wildcards == nil {
+		return nil, nil
+	}
+	sort.Slice(wildcards, func(i, j int) bool { return wildcards[i].before(wildcards[j]) })
+
+	set := &wildcardSet{list: wildcards, root: new(wildcardNode)}
+	for _, w := range wildcards {
+		node := set.root
+		for _, segment := range w.segments {
+			node = node.child(segment)
+		}
+		node.ends = append(node.ends, w)
+	}
+	return set, nil
+}
+
+// Whether w is more specific than other, and so tried first: it has more
+// fixed segments, or as many and a lower source.
+func (w *wildcard) before(other *wildcard) bool {
+	if w.fixed != other.fixed {
+		return w.fixed > other.fixed
+	}
+	return w.source < other.source
+}
+
+// The child for a segment of a source, added if there is none.
+func (node *wildcardNode) child(segment string) *wildcardNode {
+	if segment == "*" || isPlaceholder(segment) {
+		if node.any == nil {
+			node.any = new(wildcardNode)
+		}
+		return node.any
+	}
+	key := segmentKey(segment)
+	if node.children == nil {
+		node.children = make(map[string]*wildcardNode)
+	}
+	next := node.children[key]
+	if next == nil {
+		next = new(wildcardNode)
+		node.children[key] = next
+	}
+	return next
+}
+
+// The key fixed segments are found by in the tree.
+func segmentKey(segment string) string {
+	if *caseInsensitive {
+		return strings.ToLower(segment)
+	}
+	return segment
+}
+
+// The wildcards, most specific first.
+func (set *wildcardSet) all() []*wildcard {
+	if set == nil {
+		return nil
+	}
+	return set.list
+}
+
+// Find the most specific wildcard matching path, returning its destination
+// with the placeholders filled in.
+func (set *wildcardSet) match(path string) (w *wildcard, destination string, ok bool) {
+	if set == nil {
+		return nil, "", false
+	}
+	segments := strings.Split(path, "/")
+	if w = set.root.find(segments, nil); w == nil {
+		return nil, "", false
+	}
+	destination, ok = w.matchSegments(segments)
+	return w, destination, ok
+}
+
+// Find the most specific wildcard under node matching the rest of a path's
+// segments, or best if none is more specific.
+func (node *wildcardNode) find(segments []string, best *wildcard) *wildcard {
+	if len(segments) == 0 {
+		if len(node.ends) > 0 && (best == nil || node.ends[0].before(best)) {
+			return node.ends[0]
+		}
+		return best
+	}
+	if next := node.children[segmentKey(segments[0])]; next != nil {
+		best = next.find(segments[1:], best)
+	}
+	if node.any != nil {
+		best = node.any.find(segments[1:], best)
+	}
+	return best
+}
+
+// Match path against the wildcard, returning the destination with its
+// placeholders filled in.
+func (w *wildcard) match(path string) (destination string, ok bool) {
+	return w.matchSegments(strings.Split(path, "/"))
+}
+
+func (w *wildcard) matchSegments(segments []string) (destination string, ok bool) {
+	if len(segments) != len(w.segments) {
+		return "", false
+	}
+	destination = w.rule.To
+	for i, segment := range w.segments {
+		switch {
+		case segment == "*":
+		case isPlaceholder(segment):
+			// Escaped values have no braces, so can't be mistaken for
+			// placeholders themselves.
+			if strings.Contains(destination, segment) {
+				destination = strings.ReplaceAll(destination, segment, url.PathEscape(segments[i]))
+			}
+		case !segmentMatches(segment, segments[i]):
+			return "", false
+		}
+	}
+	return destination, true
+}
+
+// Find the rule for a request for path on host, returning its source, which
+// may be a wildcard, and its destination for this path. The host's
+// redirections are tried first, with their sources prefixed by the host, then
+// the active profile's, then the others. Rules outside of their schedule are
+// skipped. The caller must hold one of the Redirector's locks.
+func (config *Config) lookup(host, path string) (rule *Rule, source, destination string, ok bool) {
+	now := config.Clock().Now()
+	if hr, subdomain := config.forHost(host); hr != nil {
+		rule, source, destination, ok = findRule(hr.rules, hr.wildcards, path)
+		if ok = ok && config.active(rule, now); ok {
+			source = hr.host + source
+			destination = strings.ReplaceAll(destination, subdomainPlaceholder, subdomain)
+		}
+	}
+	if !ok && config.Profile != "" {
+		rule, source, destination, ok = findRule(config.Profiles[config.Profile], config.profileWildcards, path)
+		ok = ok && config.active(rule, now)
+	}
+	if !ok {
+		rule, source, destination, ok = findRule(config.Redirections, config.wildcards, path)
+		if ok && !config.active(rule, now) {
+			return nil, "", "", false
+		}
+	}
+	if ok {
+		destination, _ = config.expand(destination)
+	}
+	return
+}
+
+func findRule(redirections Rules, wildcards *wildcardSet, path string) (rule *Rule, source, destination string, ok bool) {
+	path = cleanPath(path)
+	key := pathKey(path)
+	if rule, ok = redirections.Get(key); ok {
+		return rule, key, rule.To, true
+	}
+	if w, destination, ok := wildcards.match(path); ok {
+		return w.rule, w.source, destination, true
+	}
+	return nil, "", "", false
+}
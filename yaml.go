@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The YAML understood here is the subset that configurations need: block
+// mappings and sequences, flow sequences and mappings on a single line, plain
+// and quoted scalars, literal (|) and folded (>) block scalars, and comments.
+// Anchors, tags, and multiple documents are not supported.
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+func parseYAML(data []byte) (any, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	p := &yamlParser{lines: strings.Split(text, "\n")}
+	if _, content, ok := p.peek(); ok && content == "---" {
+		p.pos++
+	}
+	value, err := p.parseNode(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, content, ok := p.peek(); ok {
+		return nil, p.errorf("unexpected %q", content)
+	}
+	return value, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// Skip to the next line with content, returning its indentation and its
+// content without any comment.
+func (p *yamlParser) peek() (indent int, content string, ok bool) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		content = strings.TrimRight(stripYAMLComment(line), " \t")
+		trimmed := strings.TrimLeft(content, " ")
+		if trimmed == "" {
+			continue
+		}
+		return len(content) - len(trimmed), trimmed, true
+	}
+	return 0, "", false
+}
+
+// Remove a comment from a line: a # at the start or after whitespace, outside
+// of quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[{,:-", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Parse the node starting at the next line, if it is indented at least
+// minIndent. A missing node is null.
+func (p *yamlParser) parseNode(minIndent int) (any, error) {
+	indent, content, ok := p.peek()
+	if !ok || indent < minIndent {
+		return nil, nil
+	}
+	if strings.Contains(p.lines[p.pos][:indent], "\t") {
+		return nil, p.errorf("tabs cannot be used for indentation")
+	}
+	if isSequenceItem(content) {
+		return p.parseSequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(content); ok {
+		return p.parseMapping(indent)
+	}
+	p.pos++
+	return p.parseValue(content, indent-1)
+}
+
+func isSequenceItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+func (p *yamlParser) parseSequence(indent int) (any, error) {
+	list := []any{}
+	for {
+		i, content, ok := p.peek()
+		if !ok || i != indent || !isSequenceItem(content) {
+			return list, nil
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+		var value any
+		var err error
+		switch {
+		case rest == "":
+			p.pos++
+			value, err = p.parseNode(indent + 1)
+		case isSequenceItem(rest) || hasYAMLKey(rest):
+			// The item is itself a collection starting on this line: parse it
+			// as though the dash were a space.
+			line := p.lines[p.pos]
+			at := strings.IndexByte(line, '-')
+			p.lines[p.pos] = line[:at] + " " + line[at+1:]
+			value, err = p.parseNode(indent + 1)
+		default:
+			p.pos++
+			value, err = p.parseValue(rest, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+}
+
+func hasYAMLKey(content string) bool {
+	_, _, ok := splitYAMLKey(content)
+	return ok
+}
+
+func (p *yamlParser) parseMapping(indent int) (any, error) {
+	m := make(map[string]any)
+	for {
+		i, content, ok := p.peek()
+		if !ok || i < indent {
+			return m, nil
+		}
+		if i > indent {
+			return nil, p.errorf("bad indentation")
+		}
+		if isSequenceItem(content) {
+			return m, nil
+		}
+		key, rest, ok := splitYAMLKey(content)
+		if !ok {
+			return nil, p.errorf("expected a key: %q", content)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+		var value any
+		var err error
+		if rest == "" {
+			// A sequence may be indented as much as its key.
+			if i, content, ok := p.peek(); ok && i == indent && isSequenceItem(content) {
+				value, err = p.parseSequence(indent)
+			} else {
+				value, err = p.parseNode(indent + 1)
+			}
+		} else {
+			value, err = p.parseValue(rest, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+}
+
+// Split "key: value" into the key and the rest of the line.
+func splitYAMLKey(content string) (key, rest string, ok bool) {
+	if content[0] == '"' || content[0] == '\'' {
+		s := &flowScanner{s: content}
+		quoted, err := s.quoted()
+		if err != nil {
+			return "", "", false
+		}
+		after := strings.TrimLeft(content[s.pos:], " ")
+		if after == ":" || strings.HasPrefix(after, ": ") {
+			return quoted, strings.TrimSpace(after[1:]), true
+		}
+		return "", "", false
+	}
+	if strings.IndexByte("[{", content[0]) >= 0 {
+		return "", "", false
+	}
+	if strings.HasSuffix(content, ":") && !strings.Contains(content, ": ") {
+		return strings.TrimSpace(content[:len(content)-1]), "", true
+	}
+	if i := strings.Index(content, ": "); i > 0 {
+		return strings.TrimSpace(content[:i]), strings.TrimSpace(content[i+2:]), true
+	}
+	return "", "", false
+}
+
+// Parse the value after a key or dash, on a line indented indent.
+func (p *yamlParser) parseValue(rest string, indent int) (any, error) {
+	if rest[0] == '|' || rest[0] == '>' {
+		return p.parseBlockScalar(rest, indent)
+	}
+	s := &flowScanner{s: rest}
+	value, err := s.value(false)
+	if s.skipSpace(); err == nil && s.pos < len(s.s) {
+		err = fmt.Errorf("unexpected %q", s.s[s.pos:])
+	}
+	if err != nil {
+		p.pos--
+		return nil, p.errorf("%v", err)
+	}
+	return value, nil
+}
+
+// Parse a literal (|) or folded (>) block scalar from the lines indented
+// more than indent. A - after the indicator strips the final line break, and
+// a + keeps all trailing ones.
+func (p *yamlParser) parseBlockScalar(header string, indent int) (any, error) {
+	folded, chomp := header[0] == '>', header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, p.errorf("unsupported block scalar %q", header)
+	}
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := strings.TrimRight(p.lines[p.pos], " \t")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			lines = append(lines, "")
+			continue
+		}
+		i := len(line) - len(trimmed)
+		if blockIndent < 0 {
+			blockIndent = i
+		}
+		if i <= indent || i < blockIndent {
+			break
+		}
+		lines = append(lines, p.lines[p.pos][blockIndent:])
+	}
+	// Trailing blank lines only count toward chomping.
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		switch {
+		case i == 0:
+		case !folded || lines[i-1] == "" || line != "" && (line[0] == ' ' || lines[i-1][0] == ' '):
+			b.WriteByte('\n')
+		case line != "":
+			// Folded lines are joined with spaces, and a blank line between
+			// them stands for one line break.
+			b.WriteByte(' ')
+		}
+		b.WriteString(line)
+	}
+	switch {
+	case len(lines) == 0 || chomp == "-":
+	case chomp == "+":
+		b.WriteString(strings.Repeat("\n", trailing+1))
+	default:
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// A flowScanner reads scalars and single-line flow collections.
+type flowScanner struct {
+	s   string
+	pos int
+}
+
+func (s *flowScanner) skipSpace() {
+	for s.pos < len(s.s) && (s.s[s.pos] == ' ' || s.s[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+// Read a value. Inside a flow collection, plain scalars end at , ] or }.
+func (s *flowScanner) value(inFlow bool) (any, error) {
+	s.skipSpace()
+	if s.pos == len(s.s) {
+		return nil, nil
+	}
+	switch s.s[s.pos] {
+	case '[':
+		s.pos++
+		list := []any{}
+		for {
+			s.skipSpace()
+			if s.pos < len(s.s) && s.s[s.pos] == ']' {
+				s.pos++
+				return list, nil
+			}
+			value, err := s.value(true)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			if err := s.next(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		s.pos++
+		m := make(map[string]any)
+		for {
+			s.skipSpace()
+			if s.pos < len(s.s) && s.s[s.pos] == '}' {
+				s.pos++
+				return m, nil
+			}
+			key, err := s.value(true)
+			if err != nil {
+				return nil, err
+			}
+			s.skipSpace()
+			if s.pos == len(s.s) || s.s[s.pos] != ':' {
+				return nil, fmt.Errorf("expected : in flow mapping")
+			}
+			s.pos++
+			value, err := s.value(true)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = value
+			if err := s.next('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		return s.quoted()
+	}
+	start := s.pos
+	for s.pos < len(s.s) {
+		c := s.s[s.pos]
+		if inFlow && (c == ',' || c == ']' || c == '}' || c == ':' && (s.pos+1 == len(s.s) || s.s[s.pos+1] == ' ')) {
+			break
+		}
+		s.pos++
+	}
+	return yamlScalar(strings.TrimSpace(s.s[start:s.pos])), nil
+}
+
+// Move past the comma between flow items, or stop at the closing bracket.
+func (s *flowScanner) next(end byte) error {
+	s.skipSpace()
+	switch {
+	case s.pos < len(s.s) && s.s[s.pos] == ',':
+		s.pos++
+		return nil
+	case s.pos < len(s.s) && s.s[s.pos] == end:
+		return nil
+	}
+	return fmt.Errorf("unterminated flow collection")
+}
+
+// Read a single- or double-quoted string.
+func (s *flowScanner) quoted() (string, error) {
+	quote := s.s[s.pos]
+	for end := s.pos + 1; end < len(s.s); end++ {
+		switch {
+		case quote == '"' && s.s[end] == '\\':
+			end++
+		case s.s[end] == quote && quote == '\'' && end+1 < len(s.s) && s.s[end+1] == '\'':
+			end++
+		case s.s[end] == quote:
+			raw := s.s[s.pos : end+1]
+			s.pos = end + 1
+			if quote == '\'' {
+				return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+			}
+			return strconv.Unquote(raw)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// Resolve a plain scalar to null, a boolean, a number, or a string.
+func yamlScalar(plain string) any {
+	switch plain {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if _, err := strconv.ParseFloat(plain, 64); err == nil && json.Valid([]byte(plain)) {
+		return json.Number(plain)
+	}
+	return plain
+}
+
+// Write a value decoded by decodeOrdered as YAML, indented by indent spaces.
+func encodeYAML(buf *bytes.Buffer, value any, indent int) {
+	prefix := strings.Repeat(" ", indent)
+	switch value := value.(type) {
+	case *orderedMap:
+		if len(value.keys) == 0 {
+			buf.WriteString(prefix + "{}\n")
+		}
+		for _, key := range value.keys {
+			buf.WriteString(prefix + yamlString(key) + ":")
+			encodeYAMLChild(buf, value.values[key], indent)
+		}
+	case []any:
+		if len(value) == 0 {
+			buf.WriteString(prefix + "[]\n")
+		}
+		for _, item := range value {
+			if m, ok := item.(*orderedMap); ok && len(m.keys) > 0 {
+				// Start the mapping on the dash's line.
+				item := new(bytes.Buffer)
+				encodeYAML(item, m, indent+2)
+				buf.WriteString(prefix + "- ")
+				buf.Write(item.Bytes()[indent+2:])
+				continue
+			}
+			buf.WriteString(prefix + "-")
+			encodeYAMLChild(buf, item, indent)
+		}
+	default:
+		buf.WriteString(prefix + yamlScalarString(value) + "\n")
+	}
+}
+
+// Write the value after a key or dash at indent.
+func encodeYAMLChild(buf *bytes.Buffer, value any, indent int) {
+	switch v := value.(type) {
+	case *orderedMap:
+		if len(v.keys) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteString("\n")
+		encodeYAML(buf, v, indent+2)
+	case []any:
+		if len(v) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteString("\n")
+		encodeYAML(buf, v, indent+2)
+	case string:
+		if strings.Contains(v, "\n") && !strings.ContainsAny(v, "\r\t") && !strings.HasPrefix(v, " ") {
+			encodeYAMLBlock(buf, v, indent+2)
+			return
+		}
+		buf.WriteString(" " + yamlString(v) + "\n")
+	default:
+		buf.WriteString(" " + yamlScalarString(value) + "\n")
+	}
+}
+
+// Write a multi-line string as a literal block scalar.
+func encodeYAMLBlock(buf *bytes.Buffer, s string, indent int) {
+	body := strings.TrimRight(s, "\n")
+	switch trailing := len(s) - len(body); {
+	case trailing == 0:
+		buf.WriteString(" |-\n")
+	case trailing == 1:
+		buf.WriteString(" |\n")
+	default:
+		buf.WriteString(" |+\n")
+		body = s[:len(s)-1]
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" {
+			buf.WriteString(strings.Repeat(" ", indent) + line)
+		}
+		buf.WriteString("\n")
+	}
+}
+
+func yamlScalarString(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case string:
+		return yamlString(value)
+	}
+	return quoteString(fmt.Sprint(value))
+}
+
+// Write a string plainly if it would be read back as the same string, or
+// quoted otherwise.
+func yamlString(s string) string {
+	if s == "" || yamlScalar(s) != any(s) || strings.IndexByte("-?:,[]{}#&*!|>'\"%@` \t", s[0]) >= 0 ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") ||
+		strings.HasSuffix(s, " ") || strings.ContainsAny(s, "\n\r\t\\") || strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return quoteString(s)
+	}
+	return s
+}